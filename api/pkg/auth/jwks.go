@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySource finds the public key a token was signed with by its key ID.
+type KeySource interface {
+	PublicKey(kid string) (interface{}, error)
+}
+
+var keySource KeySource
+
+// UseKeys sets where the auth middlewares get verification keys: the
+// identity service's Signer there, a JWKSCache everywhere else.
+func UseKeys(src KeySource) {
+	keySource = src
+}
+
+// JWKSCache fetches the identity service's JWKS and keeps the keys for ttl.
+// A token naming a key it has not seen triggers an early refetch, at most
+// every few seconds, so newly rotated keys are picked up at once. Known keys
+// keep working while the identity service is unreachable.
+//
+// Fetches run outside the lock and at most one at a time: a caller with a
+// known key never waits for one, a caller with an unknown key waits for the
+// fetch in flight instead of starting another.
+type JWKSCache struct {
+	url         string
+	ttl         time.Duration
+	httpClient  *http.Client
+	mu          sync.RWMutex
+	keys        map[string]ed25519.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetching    chan struct{} // Closed when the fetch in flight is done
+	fetchErr    error         // Of the last fetch
+}
+
+const jwksMinRefetch = 5 * time.Second
+
+func NewJWKSCache(url string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		url:        url,
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		keys:       make(map[string]ed25519.PublicKey),
+	}
+}
+
+func (c *JWKSCache) PublicKey(kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := time.Since(c.fetchedAt) < c.ttl
+	c.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	c.mu.Lock()
+	done := c.refresh()
+	c.mu.Unlock()
+	if ok {
+		// A stale key is served while the refetch runs in the background
+		return key, nil
+	}
+	if done == nil {
+		return nil, ErrUnknownKey
+	}
+
+	<-done
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if c.fetchErr != nil {
+		return nil, fmt.Errorf("fetching JWKS: %v", c.fetchErr)
+	}
+	return nil, ErrUnknownKey
+}
+
+// refresh starts a fetch unless one is in flight, and returns the channel
+// closed when it is done. It returns nil if the last attempt was too recent.
+// c.mu must be held.
+func (c *JWKSCache) refresh() <-chan struct{} {
+	if c.fetching != nil {
+		return c.fetching
+	}
+	if time.Since(c.attemptedAt) < jwksMinRefetch {
+		return nil
+	}
+	c.attemptedAt = time.Now()
+	done := make(chan struct{})
+	c.fetching = done
+
+	go func() {
+		keys, err := c.fetch()
+		c.mu.Lock()
+		if err == nil {
+			c.keys = keys
+			c.fetchedAt = time.Now()
+		}
+		c.fetchErr = err
+		c.fetching = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (c *JWKSCache) fetch() (map[string]ed25519.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity service returned %d", resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" {
+			continue
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			continue
+		}
+		keys[jwk.Kid] = ed25519.PublicKey(x)
+	}
+	return keys, nil
+}
+
+// TokenSource supplies the bearer token a service sends when it calls
+// another service.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// RemoteTokenSource gets service tokens from the identity service with the
+// client credentials it was registered under, reusing each until shortly
+// before it expires.
+type RemoteTokenSource struct {
+	url          string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+	mu           sync.Mutex
+	token        string
+	expiresAt    time.Time
+}
+
+// NewRemoteTokenSource asks identityURL, e.g. http://identity:3000, for
+// tokens.
+func NewRemoteTokenSource(identityURL string, clientID string, clientSecret string) *RemoteTokenSource {
+	return &RemoteTokenSource{
+		url:          strings.TrimSuffix(identityURL, "/") + "/auth/service-token",
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		httpClient:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *RemoteTokenSource) Token(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.token != "" && time.Until(r.expiresAt) > 30*time.Second {
+		return r.token, nil
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"client_id":     r.clientID,
+		"client_secret": r.clientSecret,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, strings.NewReader(string(payload)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("identity service unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+		Data    struct {
+			Token     string `json:"token"`
+			ExpiresIn int    `json:"expires_in"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid response from identity service: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity service returned %d: %s", resp.StatusCode, body.Message)
+	}
+
+	r.token = body.Data.Token
+	r.expiresAt = time.Now().Add(time.Duration(body.Data.ExpiresIn) * time.Second)
+	return r.token, nil
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jwksServer(t *testing.T, signer *Signer, hits *atomic.Int32, gate <-chan struct{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			hits.Add(1)
+		}
+		if gate != nil {
+			<-gate
+		}
+		json.NewEncoder(w).Encode(signer.JWKS())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// rotate makes a new current key, keeping the old ones published.
+func rotate(t *testing.T, signer *Signer) {
+	t.Helper()
+	key, err := signer.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.mu.Lock()
+	signer.keys = append([]signingKey{key}, signer.keys...)
+	signer.mu.Unlock()
+}
+
+func TestJWKSCachePicksUpRotatedKeys(t *testing.T) {
+	signer, err := NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewJWKSCache(jwksServer(t, signer, nil, nil).URL, time.Hour)
+	UseKeys(cache)
+	t.Cleanup(func() { UseKeys(nil) })
+
+	oldToken, err := signer.GenerateToken(1, "a@example.com", RoleMember, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(oldToken); err != nil {
+		t.Fatalf("token from the first key: %v", err)
+	}
+
+	rotate(t, signer)
+	newToken, err := signer.GenerateToken(1, "a@example.com", RoleMember, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Right after a fetch an unknown key does not trigger another
+	if _, err := ParseToken(newToken); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("token from a new key within the refetch interval: got %v, want ErrUnknownKey", err)
+	}
+
+	cache.mu.Lock()
+	cache.attemptedAt = time.Time{}
+	cache.mu.Unlock()
+	if _, err := ParseToken(newToken); err != nil {
+		t.Fatalf("token from the rotated key: %v", err)
+	}
+	if _, err := ParseToken(oldToken); err != nil {
+		t.Fatalf("token from the replaced key while still published: %v", err)
+	}
+}
+
+func TestJWKSCacheDoesNotBlockOnFetch(t *testing.T) {
+	signer, err := NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := signer.JWKS().Keys[0].Kid
+
+	var hits atomic.Int32
+	gate := make(chan struct{}, 1)
+	cache := NewJWKSCache(jwksServer(t, signer, &hits, gate).URL, 0)
+
+	// Seed the cache, then make every key stale with the server stuck
+	gate <- struct{}{}
+	if _, err := cache.PublicKey(kid); err != nil {
+		t.Fatal(err)
+	}
+	cache.mu.Lock()
+	cache.attemptedAt = time.Time{}
+	cache.mu.Unlock()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := cache.PublicKey(kid); err != nil {
+			t.Fatalf("stale known key: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("known key waited %v for the fetch", elapsed)
+	}
+
+	// Callers with an unknown key share the fetch in flight
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.PublicKey("unknown"); !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("unknown key: got %v, want ErrUnknownKey", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
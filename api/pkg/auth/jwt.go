@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"strings"
 	"time"
 
@@ -8,50 +9,155 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateToken(userID uint, email string, role string, secret string, expiry time.Duration) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"email":   email,
-		"role":    role,
-		"exp":     time.Now().Add(expiry).Unix(),
-	}
+// Every token is issued by the identity service for the Pushtaka API; tokens
+// naming anyone else are rejected.
+const (
+	Issuer   = "pushtaka-identity"
+	Audience = "pushtaka-api"
+)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// leeway absorbs clock drift between the services.
+const leeway = 30 * time.Second
+
+// Claims is the payload of every access and service token.
+type Claims struct {
+	UserID      uint     `json:"user_id"`
+	Email       string   `json:"email"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	Version     int      `json:"ver"`
+	SessionID   uint     `json:"sid,omitempty"`
+	jwt.RegisteredClaims
 }
 
-func Middleware(secret string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing token"})
-		}
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID      uint
+	Email       string
+	Role        string
+	Permissions []string
+	// SessionID is the device session the token was issued for, 0 if none.
+	SessionID uint
+}
+
+// Can reports whether the caller holds permission.
+func (p *Principal) Can(permission string) bool {
+	return Grants(p.Permissions, permission)
+}
+
+// IsService reports whether the caller is another service rather than a
+// signed-in user.
+func (p *Principal) IsService() bool {
+	return p.Role == RoleService
+}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		})
+var (
+	ErrMissingToken = errors.New("missing authorization token")
+	ErrInvalidToken = errors.New("invalid token")
+	errMissingKeyID = errors.New("token has no key ID")
+)
 
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+// ParseToken verifies a token and returns its claims. Only EdDSA tokens
+// signed by a key from UseKeys, issued by Issuer for Audience and not yet
+// expired are accepted; anything else, including the old shared-secret HS256
+// tokens, is an error.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodEdDSA {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		if keySource == nil {
+			return nil, errors.New("no verification keys configured")
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errMissingKeyID
 		}
+		return keySource.PublicKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-		c.Locals("user", token)
-		return c.Next()
+// Principal builds the caller from verified claims. Tokens without a
+// permissions claim get their role's current permissions.
+func (c *Claims) Principal() *Principal {
+	permissions := c.Permissions
+	if permissions == nil {
+		permissions = PermissionsFor(c.Role)
+	}
+	return &Principal{
+		UserID:      c.UserID,
+		Email:       c.Email,
+		Role:        c.Role,
+		Permissions: permissions,
+		SessionID:   c.SessionID,
 	}
 }
 
-func GetUserID(c *fiber.Ctx) uint {
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	return uint(claims["user_id"].(float64))
+// principalKey keeps the principal out of reach of plain string Locals keys.
+type principalKey struct{}
+
+// Authenticate verifies the request's bearer token and stores the caller for
+// PrincipalFrom. It returns the error to report: ErrTokenRevoked for a
+// revoked token, any other error if the token is missing or invalid.
+func Authenticate(c *fiber.Ctx) (*Principal, error) {
+	if p, ok := PrincipalFrom(c); ok {
+		return p, nil
+	}
+
+	header := c.Get(fiber.HeaderAuthorization)
+	tokenString, found := strings.CutPrefix(header, "Bearer ")
+	if !found || tokenString == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := CheckTokenVersion(c.Context(), claims); err != nil {
+		return nil, err
+	}
+
+	p := claims.Principal()
+	c.Locals(principalKey{}, p)
+	return p, nil
 }
 
-func GetUserRole(c *fiber.Ctx) string {
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	if role, ok := claims["role"].(string); ok {
-		return role
+// PrincipalFrom returns the caller a middleware authenticated, if any.
+func PrincipalFrom(c *fiber.Ctx) (*Principal, bool) {
+	p, ok := c.Locals(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// CurrentUser returns the signed-in user making the request. Service tokens
+// act for no user and are not one.
+func CurrentUser(c *fiber.Ctx) (*Principal, bool) {
+	p, ok := PrincipalFrom(c)
+	return p, ok && p.UserID != 0
+}
+
+// GetUserID returns the authenticated user's ID, or 0 for unauthenticated
+// requests and service tokens.
+func GetUserID(c *fiber.Ctx) uint {
+	if p, ok := PrincipalFrom(c); ok {
+		return p.UserID
 	}
-	return ""
+	return 0
+}
+
+// HasPermission reports whether the authenticated caller holds permission.
+func HasPermission(c *fiber.Ctx, permission string) bool {
+	p, ok := PrincipalFrom(c)
+	return ok && p.Can(permission)
 }
@@ -0,0 +1,72 @@
+package auth
+
+// Roles. Members are stored as "user", the name existing clients know.
+const (
+	RoleMember     = "user"
+	RoleLibrarian  = "librarian"
+	RoleCataloguer = "cataloguer"
+	RoleAdmin      = "admin"
+
+	// RoleService is carried by tokens services use to call each other. It
+	// cannot be assigned to an account.
+	RoleService = "service"
+)
+
+// Permissions carried in the "permissions" claim of a token.
+const (
+	PermBooksWrite     = "books:write"     // Add, edit and remove books and their copies
+	PermLoansRead      = "loans:read"      // See every member's loans and history
+	PermLoansCirculate = "loans:circulate" // Check items out and in at the desk
+	PermLoansOverride  = "loans:override"  // Confirm disputed returns, write loans off as lost
+	PermFinesManage    = "fines:manage"    // Verify manual fine payments
+	PermFinesWaive     = "fines:waive"     // Clear a fine without payment
+	PermUsersManage    = "users:manage"    // Create, edit and delete accounts
+	PermSettingsManage = "settings:manage" // Change library settings
+	PermSystemManage   = "system:manage"   // Dead letters and other operations tooling
+)
+
+var rolePermissions = map[string][]string{
+	RoleMember: {},
+	RoleLibrarian: {
+		PermLoansRead, PermLoansCirculate, PermLoansOverride, PermFinesManage,
+	},
+	RoleCataloguer: {
+		PermBooksWrite,
+	},
+	RoleAdmin: {
+		PermBooksWrite, PermLoansRead, PermLoansCirculate, PermLoansOverride,
+		PermFinesManage, PermFinesWaive, PermUsersManage, PermSettingsManage,
+		PermSystemManage,
+	},
+	RoleService: {
+		PermLoansRead,
+	},
+}
+
+// Roles lists every role that can be assigned to an account.
+var Roles = []string{RoleMember, RoleLibrarian, RoleCataloguer, RoleAdmin}
+
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsFor returns the permissions granted to a role. Unknown roles get
+// none.
+func PermissionsFor(role string) []string {
+	return append([]string{}, rolePermissions[role]...)
+}
+
+// Grants reports whether permission is among permissions.
+func Grants(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPermissionsFor(t *testing.T) {
+	tests := []struct {
+		role    string
+		grants  []string
+		refuses []string
+	}{
+		{RoleMember, nil, []string{PermBooksWrite, PermLoansRead, PermSystemManage}},
+		{RoleLibrarian, []string{PermLoansRead, PermLoansCirculate, PermLoansOverride, PermFinesManage}, []string{PermFinesWaive, PermBooksWrite, PermUsersManage}},
+		{RoleCataloguer, []string{PermBooksWrite}, []string{PermLoansCirculate, PermFinesManage}},
+		{RoleAdmin, []string{PermBooksWrite, PermFinesWaive, PermUsersManage, PermSettingsManage, PermSystemManage}, nil},
+		{"superuser", nil, []string{PermSystemManage}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			permissions := PermissionsFor(tt.role)
+			for _, p := range tt.grants {
+				if !Grants(permissions, p) {
+					t.Errorf("%s lacks %s", tt.role, p)
+				}
+			}
+			for _, p := range tt.refuses {
+				if Grants(permissions, p) {
+					t.Errorf("%s is granted %s", tt.role, p)
+				}
+			}
+		})
+	}
+
+	// Callers get a copy they may change
+	PermissionsFor(RoleCataloguer)[0] = PermSystemManage
+	if Grants(PermissionsFor(RoleCataloguer), PermSystemManage) {
+		t.Fatal("changing the returned slice changed the role")
+	}
+}
+
+func TestClaimsPrincipal(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		want   []string
+	}{
+		{"embedded claim wins", Claims{Role: RoleAdmin, Permissions: []string{PermLoansRead}}, []string{PermLoansRead}},
+		{"token from before permissions", Claims{Role: RoleCataloguer}, []string{PermBooksWrite}},
+		{"empty claim grants nothing", Claims{Role: RoleAdmin, Permissions: []string{}}, []string{}},
+		{"no role", Claims{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.Principal().Permissions; !slices.Equal(got, tt.want) {
+				t.Fatalf("permissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Signer issues tokens signed with Ed25519 (EdDSA). It is only used by the
+// identity service, the one place private keys live; every other service
+// verifies against the public keys it publishes as a JWKS.
+//
+// Keys are PEM files in dir named after their key ID. The newest one signs;
+// a new key is generated once it is older than rotation, and a replaced key
+// stays published for another rotation period so tokens it signed can still
+// be verified. With no dir, keys are kept in memory and lost on restart.
+type Signer struct {
+	dir      string
+	rotation time.Duration
+	mu       sync.RWMutex
+	keys     []signingKey // Newest first
+}
+
+type signingKey struct {
+	kid       string
+	private   ed25519.PrivateKey
+	createdAt time.Time
+}
+
+func NewSigner(dir string, rotation time.Duration) (*Signer, error) {
+	s := &Signer{dir: dir, rotation: rotation}
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR not set, signing keys will not survive a restart")
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run reloads the key directory, picking up keys made by other replicas, and
+// rotates keys when due. It blocks until ctx is done.
+func (s *Signer) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.refresh(); err != nil {
+				log.Printf("Failed to rotate signing keys: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Signer) refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		keys, err := loadKeys(s.dir)
+		if err != nil {
+			return err
+		}
+		s.keys = keys
+	}
+
+	if len(s.keys) == 0 || time.Since(s.keys[0].createdAt) >= s.rotation {
+		key, err := s.generate()
+		if err != nil {
+			return err
+		}
+		s.keys = append([]signingKey{key}, s.keys...)
+	}
+
+	// Drop keys replaced more than a rotation period ago
+	for i := 1; i < len(s.keys); i++ {
+		if time.Since(s.keys[i-1].createdAt) < s.rotation {
+			continue
+		}
+		for _, old := range s.keys[i:] {
+			if s.dir != "" {
+				os.Remove(filepath.Join(s.dir, old.kid+".pem"))
+			}
+		}
+		s.keys = s.keys[:i]
+		break
+	}
+	return nil
+}
+
+func (s *Signer) generate() (signingKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sum := sha256.Sum256(public)
+	key := signingKey{kid: hex.EncodeToString(sum[:8]), private: private, createdAt: time.Now()}
+
+	if s.dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return signingKey{}, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(filepath.Join(s.dir, key.kid+".pem"), data, 0600); err != nil {
+			return signingKey{}, err
+		}
+	}
+	log.Printf("Generated signing key %s", key.kid)
+	return key, nil
+}
+
+func loadKeys(dir string) ([]signingKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []signingKey
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: not a PEM file", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signingKey{
+			kid:       strings.TrimSuffix(entry.Name(), ".pem"),
+			private:   private,
+			createdAt: info.ModTime(),
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.After(keys[j].createdAt) })
+	return keys, nil
+}
+
+// Sign signs claims with the current key and names it in the "kid" header.
+// The issuer, audience and issue time are filled in.
+func (s *Signer) Sign(claims *Claims) (string, error) {
+	s.mu.RLock()
+	key := s.keys[0]
+	s.mu.RUnlock()
+
+	claims.Issuer = Issuer
+	claims.Audience = jwt.ClaimStrings{Audience}
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+func (s *Signer) GenerateToken(userID uint, email string, role string, expiry time.Duration) (string, error) {
+	return s.GenerateSessionToken(userID, email, role, 0, 0, expiry)
+}
+
+// GenerateSessionToken issues an access token for a signed-in device. The
+// session ID travels in the "sid" claim; 0 leaves it out. version is the
+// user's token version, see CheckTokenVersion.
+func (s *Signer) GenerateSessionToken(userID uint, email string, role string, sessionID uint, version int, expiry time.Duration) (string, error) {
+	return s.Sign(&Claims{
+		UserID:      userID,
+		Email:       email,
+		Role:        role,
+		Permissions: PermissionsFor(role),
+		Version:     version,
+		SessionID:   sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+	})
+}
+
+// PublicKey lets the identity service verify its own tokens without a
+// round trip to its JWKS endpoint.
+func (s *Signer) PublicKey(kid string) (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.kid == kid {
+			return key.private.Public(), nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS lists the public half of every published key.
+func (s *Signer) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.private.Public().(ed25519.PublicKey)),
+			Kid: key.kid,
+			Alg: "EdDSA",
+			Use: "sig",
+		})
+	}
+	return set
+}
+
+// ServiceTokenSource mints short-lived tokens for this service's own calls
+// to other services.
+func (s *Signer) ServiceTokenSource(name string) TokenSource {
+	return &signerTokenSource{signer: s, name: name}
+}
+
+type signerTokenSource struct {
+	signer *Signer
+	name   string
+}
+
+func (t *signerTokenSource) Token(ctx context.Context) (string, error) {
+	return t.signer.GenerateToken(0, t.name, RoleService, time.Minute)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParseToken(t *testing.T) {
+	signer, err := NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(s *Signer) string {
+		token, err := s.GenerateSessionToken(1, "siswa@contoh.com", RoleMember, 7, 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 1, "role": RoleAdmin}).SignedString([]byte("shared-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := signer.GenerateToken(1, "siswa@contoh.com", RoleMember, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signedAs signs claims with the signer's key as is, without Sign filling
+	// in the issuer and audience.
+	signedAs := func(claims *Claims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		tokenString, err := token.SignedString(signer.keys[0].private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	registered := func(issuer, audience string) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}
+	}
+	kid := signer.keys[0].kid
+
+	tests := []struct {
+		name    string
+		keys    KeySource
+		token   string
+		wantErr bool
+	}{
+		{"signed by a known key", signer, signed(signer), false},
+		{"signed by another issuer", signer, signed(other), true},
+		{"old shared-secret token", signer, legacy, true},
+		{"expired", signer, expired, true},
+		{"another issuer", signer, signedAs(&Claims{UserID: 1, RegisteredClaims: registered("someone-else", Audience)}, kid), true},
+		{"another audience", signer, signedAs(&Claims{UserID: 1, RegisteredClaims: registered(Issuer, "someone-else")}, kid), true},
+		{"no expiry", signer, signedAs(&Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{Issuer: Issuer, Audience: jwt.ClaimStrings{Audience}}}, kid), true},
+		{"no key ID", signer, signedAs(&Claims{UserID: 1, RegisteredClaims: registered(Issuer, Audience)}, ""), true},
+		{"no keys configured", nil, signed(signer), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UseKeys(tt.keys)
+			t.Cleanup(func() { UseKeys(nil) })
+
+			claims, err := ParseToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if claims.SessionID != 7 || claims.Version != 2 || claims.Role != RoleMember {
+				t.Fatalf("claims = %v, want session 7 at version 2", claims)
+			}
+		})
+	}
+}
+
+func TestSignerSharesKeysThroughDir(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewSigner(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second replica picks up the key instead of making its own
+	second, err := NewSigner(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := first.JWKS().Keys, second.JWKS().Keys; len(a) != 1 || len(b) != 1 || a[0].Kid != b[0].Kid {
+		t.Fatalf("replicas publish %v and %v, want the same single key", a, b)
+	}
+
+	token, err := second.GenerateToken(1, "siswa@contoh.com", RoleMember, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseKeys(first)
+	t.Cleanup(func() { UseKeys(nil) })
+	if _, err := ParseToken(token); err != nil {
+		t.Fatalf("token from the other replica: %v", err)
+	}
+}
+
+func TestSignerRotation(t *testing.T) {
+	signer, err := NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := signer.JWKS().Keys[0].Kid
+
+	// Due for rotation: a new key signs, the old one stays published
+	signer.keys[0].createdAt = time.Now().Add(-time.Hour)
+	if err := signer.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	keys := signer.JWKS().Keys
+	if len(keys) != 2 || keys[0].Kid == first || keys[1].Kid != first {
+		t.Fatalf("keys after rotation = %v, want a new key ahead of %s", keys, first)
+	}
+	if _, err := signer.PublicKey(first); err != nil {
+		t.Fatalf("replaced key: %v", err)
+	}
+
+	// A rotation period later the replaced key is dropped
+	signer.keys[0].createdAt = time.Now().Add(-90 * time.Minute)
+	signer.keys[1].createdAt = time.Now().Add(-3 * time.Hour)
+	if err := signer.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.PublicKey(first); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("key replaced over a rotation ago = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B. The RFC lists 8 digits;
+// a 6 digit code is the last 6 of them.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+// rfc6238Secret is the RFC's ASCII key "12345678901234567890", base32 encoded.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		if got := totpCode([]byte("12345678901234567890"), v.unix/totpPeriod); got != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		at := time.Unix(v.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, v.code, at)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s at %d) = %d, %v; want %d, true", v.code, v.unix, step, ok, v.unix/totpPeriod)
+		}
+
+		// One period of skew either way, not two
+		for _, offset := range []int64{-totpPeriod, totpPeriod} {
+			if _, ok := ValidateTOTP(rfc6238Secret, v.code, at.Add(time.Duration(offset)*time.Second)); !ok {
+				t.Errorf("%s refused %ds from %d", v.code, offset, v.unix)
+			}
+		}
+		for _, offset := range []int64{-2 * totpPeriod, 2 * totpPeriod} {
+			if v.unix+offset < 0 {
+				continue // Before the epoch, where no clock is
+			}
+			if _, ok := ValidateTOTP(rfc6238Secret, v.code, at.Add(time.Duration(offset)*time.Second)); ok {
+				t.Errorf("%s accepted %ds from %d", v.code, offset, v.unix)
+			}
+		}
+	}
+
+	// Lowercase and padded secrets are what some users paste back
+	if _, ok := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", "287082", time.Unix(59, 0)); !ok {
+		t.Error("lowercase padded secret refused")
+	}
+
+	at := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "287083"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, at); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", at); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, totpCode(key, now.Unix()/totpPeriod), now); !ok {
+		t.Fatal("generated secret does not validate its own code")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TOTPProvisioningURI(rfc6238Secret, "Pushtaka", "siswa@contoh.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Pushtaka:siswa@contoh.com" {
+		t.Fatalf("uri = %s", uri)
+	}
+	query := uri.Query()
+	for key, want := range map[string]string{"secret": rfc6238Secret, "issuer": "Pushtaka", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
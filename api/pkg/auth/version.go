@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// TokenVersionStore returns a user's current token version. Tokens carry the
+// version they were issued with in the "ver" claim; bumping the stored
+// version revokes every token issued before. found is false once the user is
+// deleted.
+type TokenVersionStore interface {
+	TokenVersion(ctx context.Context, userID uint) (version int, found bool, err error)
+}
+
+var versionStore TokenVersionStore
+
+// UseTokenVersions makes the auth middlewares reject tokens older than the
+// user's current version. Without it only signature and expiry are checked.
+func UseTokenVersions(store TokenVersionStore) {
+	versionStore = store
+}
+
+// ForgetTokenVersion drops a cached version so a bump made by this process
+// takes effect at once rather than after the cache TTL.
+func ForgetTokenVersion(userID uint) {
+	if cache, ok := versionStore.(*TokenVersionCache); ok {
+		cache.Forget(userID)
+	}
+}
+
+// CheckTokenVersion returns ErrTokenRevoked if the token predates the user's
+// current version or the user no longer exists. Service tokens, which carry
+// no user, are not checked.
+func CheckTokenVersion(ctx context.Context, claims *Claims) error {
+	if versionStore == nil || claims.UserID == 0 {
+		return nil
+	}
+
+	current, found, err := versionStore.TokenVersion(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if !found || claims.Version < current {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// TokenVersionCache reads token versions from the shared users table and
+// keeps them for ttl, so a revocation reaches every service within ttl.
+type TokenVersionCache struct {
+	db      *gorm.DB
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[uint]versionEntry
+}
+
+type versionEntry struct {
+	version   int
+	found     bool
+	fetchedAt time.Time
+}
+
+func NewTokenVersionCache(db *gorm.DB, ttl time.Duration) *TokenVersionCache {
+	return &TokenVersionCache{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[uint]versionEntry),
+	}
+}
+
+func (c *TokenVersionCache) TokenVersion(ctx context.Context, userID uint) (int, bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry.version, entry.found, nil
+	}
+
+	var row struct{ TokenVersion int }
+	err := c.db.WithContext(ctx).Table("users").
+		Select("token_version").
+		Where("id = ? AND deleted_at IS NULL", userID).
+		Take(&row).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, err
+	}
+
+	entry = versionEntry{version: row.TokenVersion, found: err == nil, fetchedAt: time.Now()}
+	c.mu.Lock()
+	c.entries[userID] = entry
+	c.mu.Unlock()
+	return entry.version, entry.found, nil
+}
+
+func (c *TokenVersionCache) Forget(userID uint) {
+	c.mu.Lock()
+	delete(c.entries, userID)
+	c.mu.Unlock()
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeVersionStore struct {
+	versions map[uint]int
+	err      error
+}
+
+func (s fakeVersionStore) TokenVersion(ctx context.Context, userID uint) (int, bool, error) {
+	if s.err != nil {
+		return 0, false, s.err
+	}
+	version, found := s.versions[userID]
+	return version, found, nil
+}
+
+func TestCheckTokenVersion(t *testing.T) {
+	errDown := errors.New("database unavailable")
+
+	tests := []struct {
+		name    string
+		store   TokenVersionStore
+		claims  *Claims
+		wantErr error
+	}{
+		{"current version", fakeVersionStore{versions: map[uint]int{1: 2}}, &Claims{UserID: 1, Version: 2}, nil},
+		{"older version", fakeVersionStore{versions: map[uint]int{1: 2}}, &Claims{UserID: 1, Version: 1}, ErrTokenRevoked},
+		{"token from before versions", fakeVersionStore{versions: map[uint]int{1: 1}}, &Claims{UserID: 1}, ErrTokenRevoked},
+		{"user deleted", fakeVersionStore{versions: map[uint]int{}}, &Claims{UserID: 1}, ErrTokenRevoked},
+		{"service token", fakeVersionStore{err: errDown}, &Claims{Role: RoleService}, nil},
+		{"store unavailable", fakeVersionStore{err: errDown}, &Claims{UserID: 1}, errDown},
+		{"versions not in use", nil, &Claims{UserID: 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UseTokenVersions(tt.store)
+			t.Cleanup(func() { UseTokenVersions(nil) })
+
+			if err := CheckTokenVersion(context.Background(), tt.claims); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
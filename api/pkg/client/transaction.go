@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pushtaka/pkg/auth"
+	"time"
+)
+
+// Obligations mirrors the transaction service's answer to a pre-delete check
+// on a member or a book.
+type Obligations struct {
+	OpenLoans   int64 `json:"open_loans"`
+	UnpaidFines int   `json:"unpaid_fines"`
+	FineAmount  int   `json:"fine_amount"`
+}
+
+// TransactionClient calls the transaction service on behalf of another
+// service.
+type TransactionClient struct {
+	baseURL    string
+	tokens     auth.TokenSource
+	httpClient *http.Client
+}
+
+// NewTransactionClient calls the transaction service at baseURL, e.g.
+// http://transaction:3000. Requests carry a service token from tokens.
+func NewTransactionClient(baseURL string, tokens auth.TokenSource) *TransactionClient {
+	return &TransactionClient{
+		baseURL:    baseURL,
+		tokens:     tokens,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// UserObligations is what a member still owes.
+func (t *TransactionClient) UserObligations(ctx context.Context, userID uint) (*Obligations, error) {
+	return t.obligations(ctx, fmt.Sprintf("/transactions/admin/users/%d/obligations", userID))
+}
+
+// BookObligations is what is still owed on a book.
+func (t *TransactionClient) BookObligations(ctx context.Context, bookID uint) (*Obligations, error) {
+	return t.obligations(ctx, fmt.Sprintf("/transactions/admin/books/%d/obligations", bookID))
+}
+
+func (t *TransactionClient) obligations(ctx context.Context, path string) (*Obligations, error) {
+	token, err := t.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("transaction service unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string      `json:"message"`
+		Data    Obligations `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response from transaction service: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transaction service returned %d: %s", resp.StatusCode, body.Message)
+	}
+	return &body.Data, nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type staticToken struct {
+	token string
+	err   error
+}
+
+func (s staticToken) Token(ctx context.Context) (string, error) {
+	return s.token, s.err
+}
+
+func TestTransactionClientObligations(t *testing.T) {
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		switch {
+		case strings.Contains(r.URL.Path, "/users/404/"):
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"forbidden"}`))
+		case strings.Contains(r.URL.Path, "/users/500/"):
+			w.Write([]byte(`<html>`))
+		default:
+			w.Write([]byte(`{"message":"ok","data":{"open_loans":2,"unpaid_fines":1,"fine_amount":3000}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name     string
+		tokens   staticToken
+		call     func(c *TransactionClient) (*Obligations, error)
+		wantPath string
+		wantErr  string
+	}{
+		{"member", staticToken{token: "svc"}, func(c *TransactionClient) (*Obligations, error) {
+			return c.UserObligations(context.Background(), 7)
+		}, "/transactions/admin/users/7/obligations", ""},
+		{"book", staticToken{token: "svc"}, func(c *TransactionClient) (*Obligations, error) {
+			return c.BookObligations(context.Background(), 9)
+		}, "/transactions/admin/books/9/obligations", ""},
+		{"refused", staticToken{token: "svc"}, func(c *TransactionClient) (*Obligations, error) {
+			return c.UserObligations(context.Background(), 404)
+		}, "", "returned 403: forbidden"},
+		{"not json", staticToken{token: "svc"}, func(c *TransactionClient) (*Obligations, error) {
+			return c.UserObligations(context.Background(), 500)
+		}, "", "invalid response"},
+		{"no service token", staticToken{err: errors.New("identity down")}, func(c *TransactionClient) (*Obligations, error) {
+			return c.UserObligations(context.Background(), 7)
+		}, "", "identity down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.call(NewTransactionClient(srv.URL, tt.tokens))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotPath != tt.wantPath || gotAuth != "Bearer svc" {
+				t.Fatalf("requested %s with %q", gotPath, gotAuth)
+			}
+			if *got != (Obligations{OpenLoans: 2, UnpaidFines: 1, FineAmount: 3000}) {
+				t.Fatalf("obligations = %+v", *got)
+			}
+		})
+	}
+}
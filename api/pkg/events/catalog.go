@@ -0,0 +1,53 @@
+package events
+
+const (
+	TypeStockUpdated              = "stock.updated"
+	TypeBookDeleted               = "book.deleted"
+	TypeStockReservationRequested = "stock.reservation_requested"
+	TypeStockReservationCompleted = "stock.reservation_completed"
+)
+
+// StockUpdated changes a book's stock by Quantity (negative for borrow).
+// CopyID names the physical copy; it is zero for loans made before copies
+// were tracked.
+type StockUpdated struct {
+	BookID   uint   `json:"book_id"`
+	CopyID   uint   `json:"copy_id,omitempty"`
+	Action   string `json:"action"` // "borrow" or "return"
+	Quantity int    `json:"quantity"`
+}
+
+func (StockUpdated) EventType() string { return TypeStockUpdated }
+func (StockUpdated) EventVersion() int { return 1 }
+
+type BookDeleted struct {
+	BookID uint `json:"book_id"`
+}
+
+func (BookDeleted) EventType() string { return TypeBookDeleted }
+func (BookDeleted) EventVersion() int { return 1 }
+
+// StockReservationRequested asks the book service to take copies out of
+// stock. It is answered on the reply queue with a StockReservationCompleted.
+// A non-zero CopyID asks for that specific copy rather than any available one.
+type StockReservationRequested struct {
+	LoanID   uint `json:"loan_id"`
+	BookID   uint `json:"book_id"`
+	CopyID   uint `json:"copy_id,omitempty"`
+	Quantity int  `json:"quantity"`
+}
+
+func (StockReservationRequested) EventType() string { return TypeStockReservationRequested }
+func (StockReservationRequested) EventVersion() int { return 1 }
+
+type StockReservationCompleted struct {
+	LoanID   uint   `json:"loan_id"`
+	BookID   uint   `json:"book_id"`
+	CopyID   uint   `json:"copy_id,omitempty"` // The copy taken off the shelf when approved
+	Barcode  string `json:"barcode,omitempty"`
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason"`
+}
+
+func (StockReservationCompleted) EventType() string { return TypeStockReservationCompleted }
+func (StockReservationCompleted) EventVersion() int { return 1 }
@@ -0,0 +1,263 @@
+package events_test
+
+import (
+	"encoding/json"
+	"errors"
+	"pushtaka/pkg/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// contract pins one event as producers send it and consumers read it. A
+// renamed field or a bumped version fails here until the consumers and this
+// table are updated together.
+type contract struct {
+	producer string
+	payload  events.Payload // As the producer sends it, every field set
+	decode   func(body []byte) (events.Payload, *events.Envelope, error)
+	typ      string
+	version  int
+	wire     string // The payload consumers expect on the wire
+}
+
+func decodeAs[T events.Payload](body []byte) (events.Payload, *events.Envelope, error) {
+	return events.Decode[T](body)
+}
+
+var (
+	dueDate    = time.Date(2026, 3, 14, 17, 0, 0, 0, time.UTC)
+	returnedAt = time.Date(2026, 3, 20, 9, 30, 0, 0, time.UTC)
+)
+
+var contracts = []contract{
+	{
+		producer: "transaction",
+		payload:  events.StockUpdated{BookID: 7, CopyID: 21, Action: "return", Quantity: 1},
+		decode:   decodeAs[events.StockUpdated],
+		typ:      "stock.updated",
+		version:  1,
+		wire:     `{"book_id":7,"copy_id":21,"action":"return","quantity":1}`,
+	},
+	{
+		producer: "book",
+		payload:  events.BookDeleted{BookID: 7},
+		decode:   decodeAs[events.BookDeleted],
+		typ:      "book.deleted",
+		version:  1,
+		wire:     `{"book_id":7}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.StockReservationRequested{LoanID: 3, BookID: 7, CopyID: 21, Quantity: 1},
+		decode:   decodeAs[events.StockReservationRequested],
+		typ:      "stock.reservation_requested",
+		version:  1,
+		wire:     `{"loan_id":3,"book_id":7,"copy_id":21,"quantity":1}`,
+	},
+	{
+		producer: "book",
+		payload:  events.StockReservationCompleted{LoanID: 3, BookID: 7, CopyID: 21, Barcode: "B-000021", Approved: true, Reason: "reserved"},
+		decode:   decodeAs[events.StockReservationCompleted],
+		typ:      "stock.reservation_completed",
+		version:  1,
+		wire:     `{"loan_id":3,"book_id":7,"copy_id":21,"barcode":"B-000021","approved":true,"reason":"reserved"}`,
+	},
+	{
+		producer: "identity",
+		payload:  events.UserRegistered{UserID: 5, Email: "siswa@contoh.com", Name: "Siswa", Role: "user"},
+		decode:   decodeAs[events.UserRegistered],
+		typ:      "user.registered",
+		version:  1,
+		wire:     `{"user_id":5,"email":"siswa@contoh.com","name":"Siswa","role":"user"}`,
+	},
+	{
+		producer: "identity",
+		payload:  events.UserDeleted{UserID: 5, Permanent: true},
+		decode:   decodeAs[events.UserDeleted],
+		typ:      "user.deleted",
+		version:  1,
+		wire:     `{"user_id":5,"permanent":true}`,
+	},
+	{
+		producer: "book",
+		payload:  events.BookCreated{BookSnapshot: events.BookSnapshot{BookID: 7, Title: "Laskar Pelangi", Author: "Andrea Hirata", Code: "LP-01", ISBN: "9789793062792", Stock: 4}},
+		decode:   decodeAs[events.BookCreated],
+		typ:      "book.created",
+		version:  1,
+		wire:     `{"book_id":7,"title":"Laskar Pelangi","author":"Andrea Hirata","code":"LP-01","isbn":"9789793062792","stock":4}`,
+	},
+	{
+		producer: "book",
+		payload:  events.BookUpdated{BookSnapshot: events.BookSnapshot{BookID: 7, Title: "Laskar Pelangi", Author: "Andrea Hirata", Code: "LP-01", ISBN: "9789793062792", Stock: 3}},
+		decode:   decodeAs[events.BookUpdated],
+		typ:      "book.updated",
+		version:  1,
+		wire:     `{"book_id":7,"title":"Laskar Pelangi","author":"Andrea Hirata","code":"LP-01","isbn":"9789793062792","stock":3}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.LoanBorrowed{LoanID: 3, UserID: 5, BookID: 7, CopyID: 21, DueDate: &dueDate},
+		decode:   decodeAs[events.LoanBorrowed],
+		typ:      "loan.borrowed",
+		version:  1,
+		wire:     `{"loan_id":3,"user_id":5,"book_id":7,"copy_id":21,"due_date":"2026-03-14T17:00:00Z"}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.LoanReturned{LoanID: 3, UserID: 5, BookID: 7, CopyID: 21, ReturnedAt: returnedAt, Late: true},
+		decode:   decodeAs[events.LoanReturned],
+		typ:      "loan.returned",
+		version:  1,
+		wire:     `{"loan_id":3,"user_id":5,"book_id":7,"copy_id":21,"returned_at":"2026-03-20T09:30:00Z","late":true}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.LoanOverdue{LoanID: 3, UserID: 5, BookID: 7, DueDate: &dueDate},
+		decode:   decodeAs[events.LoanOverdue],
+		typ:      "loan.overdue",
+		version:  1,
+		wire:     `{"loan_id":3,"user_id":5,"book_id":7,"due_date":"2026-03-14T17:00:00Z"}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.FineAssessed{TransactionID: 11, LoanID: 3, UserID: 5, BookID: 7, Amount: 6000},
+		decode:   decodeAs[events.FineAssessed],
+		typ:      "fine.assessed",
+		version:  1,
+		wire:     `{"transaction_id":11,"loan_id":3,"user_id":5,"book_id":7,"amount":6000}`,
+	},
+	{
+		producer: "transaction",
+		payload:  events.FinePaid{TransactionID: 11, UserID: 5, Amount: 6000, Method: "cash"},
+		decode:   decodeAs[events.FinePaid],
+		typ:      "fine.paid",
+		version:  1,
+		wire:     `{"transaction_id":11,"user_id":5,"amount":6000,"method":"cash"}`,
+	},
+}
+
+func TestEventContracts(t *testing.T) {
+	for _, c := range contracts {
+		t.Run(c.typ, func(t *testing.T) {
+			assertAllFieldsSet(t, reflect.ValueOf(c.payload), reflect.TypeOf(c.payload).Name())
+
+			if c.payload.EventType() != c.typ {
+				t.Fatalf("type renamed to %q: update the consumers and this contract", c.payload.EventType())
+			}
+			if c.payload.EventVersion() != c.version {
+				t.Fatalf("version bumped to %d: update the consumers and this contract", c.payload.EventVersion())
+			}
+
+			// What the producer sends is what the consumer gets back
+			body, err := events.Marshal(c.producer, c.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, env, err := c.decode(body)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if env.Type != c.typ || env.Version != c.version || env.Producer != c.producer {
+				t.Fatalf("envelope = %s v%d from %s, want %s v%d from %s", env.Type, env.Version, env.Producer, c.typ, c.version, c.producer)
+			}
+			if !reflect.DeepEqual(got, c.payload) {
+				t.Fatalf("decoded %+v, sent %+v", got, c.payload)
+			}
+
+			// The field names on the wire are the contract
+			assertSameJSON(t, env.Payload, c.wire)
+			got, _, err = c.decode(envelope(t, c.typ, c.version, c.wire))
+			if err != nil {
+				t.Fatalf("decode pinned payload: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.payload) {
+				t.Fatalf("pinned payload decoded as %+v, want %+v", got, c.payload)
+			}
+
+			// A newer version than the consumer knows is refused, not misread
+			_, _, err = c.decode(envelope(t, c.typ, c.version+1, c.wire))
+			if !errors.Is(err, events.ErrUnsupportedVersion) {
+				t.Fatalf("v%d: got %v, want ErrUnsupportedVersion", c.version+1, err)
+			}
+
+			// So is another event
+			_, _, err = c.decode(envelope(t, "other.event", c.version, c.wire))
+			if !errors.Is(err, events.ErrTypeMismatch) {
+				t.Fatalf("other type: got %v, want ErrTypeMismatch", err)
+			}
+		})
+	}
+}
+
+func TestEventContractsCoverCatalogue(t *testing.T) {
+	types := []string{
+		events.TypeStockUpdated, events.TypeBookDeleted, events.TypeStockReservationRequested, events.TypeStockReservationCompleted,
+		events.TypeUserRegistered, events.TypeUserDeleted, events.TypeBookCreated, events.TypeBookUpdated,
+		events.TypeLoanBorrowed, events.TypeLoanReturned, events.TypeLoanOverdue, events.TypeFineAssessed,
+		events.TypeFinePaid,
+	}
+	covered := make(map[string]bool)
+	for _, c := range contracts {
+		covered[c.typ] = true
+	}
+	for _, typ := range types {
+		if !covered[typ] {
+			t.Errorf("no contract for %s", typ)
+		}
+	}
+}
+
+// Queues that predate envelopes may still hold bare payloads.
+func TestDecodeBarePayload(t *testing.T) {
+	got, env, err := events.Decode[events.StockUpdated]([]byte(`{"book_id":7,"action":"borrow","quantity":-1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != 0 || env.Type != events.TypeStockUpdated {
+		t.Fatalf("envelope = %s v%d, want %s v0", env.Type, env.Version, events.TypeStockUpdated)
+	}
+	if want := (events.StockUpdated{BookID: 7, Action: "borrow", Quantity: -1}); got != want {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func envelope(t *testing.T, typ string, version int, payload string) []byte {
+	t.Helper()
+	body, err := json.Marshal(events.Envelope{Type: typ, Version: version, Producer: "test", Payload: json.RawMessage(payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func assertSameJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("payload on the wire is %s, consumers expect %s", got, want)
+	}
+}
+
+// assertAllFieldsSet makes sure a contract exercises every field, so a field
+// dropped from the JSON cannot go unnoticed.
+func assertAllFieldsSet(t *testing.T, v reflect.Value, path string) {
+	t.Helper()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		if field.Anonymous && value.Kind() == reflect.Struct {
+			assertAllFieldsSet(t, value, path+"."+field.Name)
+			continue
+		}
+		if value.IsZero() {
+			t.Fatalf("%s.%s is not set in the contract", path, field.Name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package events
+
+import "time"
+
+// Exchange is the topic exchange domain events are broadcast on, routed by
+// their type. Consumers bind their own queue to the types they care about,
+// e.g. "loan.*", so producers never need to know who is listening.
+const Exchange = "pushtaka.events"
+
+const (
+	TypeUserRegistered = "user.registered"
+	TypeUserDeleted    = "user.deleted"
+	TypeBookCreated    = "book.created"
+	TypeBookUpdated    = "book.updated"
+	TypeLoanBorrowed   = "loan.borrowed"
+	TypeLoanReturned   = "loan.returned"
+	TypeLoanOverdue    = "loan.overdue"
+	TypeFineAssessed   = "fine.assessed"
+	TypeFinePaid       = "fine.paid"
+)
+
+// UserRegistered is published once an account is verified and usable.
+type UserRegistered struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+}
+
+func (UserRegistered) EventType() string { return TypeUserRegistered }
+func (UserRegistered) EventVersion() int { return 1 }
+
+type UserDeleted struct {
+	UserID    uint `json:"user_id"`
+	Permanent bool `json:"permanent"`
+}
+
+func (UserDeleted) EventType() string { return TypeUserDeleted }
+func (UserDeleted) EventVersion() int { return 1 }
+
+// BookSnapshot is the catalogue data carried by book events.
+type BookSnapshot struct {
+	BookID uint   `json:"book_id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Code   string `json:"code"`
+	ISBN   string `json:"isbn"`
+	Stock  int    `json:"stock"`
+}
+
+type BookCreated struct {
+	BookSnapshot
+}
+
+func (BookCreated) EventType() string { return TypeBookCreated }
+func (BookCreated) EventVersion() int { return 1 }
+
+type BookUpdated struct {
+	BookSnapshot
+}
+
+func (BookUpdated) EventType() string { return TypeBookUpdated }
+func (BookUpdated) EventVersion() int { return 1 }
+
+// LoanBorrowed is published when a loan becomes active, i.e. after the
+// book service has reserved a copy.
+type LoanBorrowed struct {
+	LoanID  uint       `json:"loan_id"`
+	UserID  uint       `json:"user_id"`
+	BookID  uint       `json:"book_id"`
+	CopyID  uint       `json:"copy_id,omitempty"`
+	DueDate *time.Time `json:"due_date"`
+}
+
+func (LoanBorrowed) EventType() string { return TypeLoanBorrowed }
+func (LoanBorrowed) EventVersion() int { return 1 }
+
+type LoanReturned struct {
+	LoanID     uint      `json:"loan_id"`
+	UserID     uint      `json:"user_id"`
+	BookID     uint      `json:"book_id"`
+	CopyID     uint      `json:"copy_id,omitempty"`
+	ReturnedAt time.Time `json:"returned_at"`
+	Late       bool      `json:"late"`
+}
+
+func (LoanReturned) EventType() string { return TypeLoanReturned }
+func (LoanReturned) EventVersion() int { return 1 }
+
+type LoanOverdue struct {
+	LoanID  uint       `json:"loan_id"`
+	UserID  uint       `json:"user_id"`
+	BookID  uint       `json:"book_id"`
+	DueDate *time.Time `json:"due_date"`
+}
+
+func (LoanOverdue) EventType() string { return TypeLoanOverdue }
+func (LoanOverdue) EventVersion() int { return 1 }
+
+// FineAssessed is published when a late return creates a fine transaction.
+type FineAssessed struct {
+	TransactionID uint `json:"transaction_id"`
+	LoanID        uint `json:"loan_id"`
+	UserID        uint `json:"user_id"`
+	BookID        uint `json:"book_id"`
+	Amount        int  `json:"amount"`
+}
+
+func (FineAssessed) EventType() string { return TypeFineAssessed }
+func (FineAssessed) EventVersion() int { return 1 }
+
+type FinePaid struct {
+	TransactionID uint   `json:"transaction_id"`
+	UserID        uint   `json:"user_id"`
+	Amount        int    `json:"amount"`
+	Method        string `json:"method"`
+}
+
+func (FinePaid) EventType() string { return TypeFinePaid }
+func (FinePaid) EventVersion() int { return 1 }
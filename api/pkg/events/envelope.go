@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTypeMismatch       = errors.New("event type mismatch")
+	ErrUnsupportedVersion = errors.New("unsupported event version")
+)
+
+// Envelope wraps every event exchanged between services.
+type Envelope struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Producer   string          `json:"producer"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Payload is implemented by every event body. Producers and consumers share
+// these types, so a schema change that one side does not follow breaks the
+// build instead of the message flow.
+type Payload interface {
+	EventType() string
+	EventVersion() int
+}
+
+// New wraps payload in an envelope stamped with the current time.
+func New(producer string, payload Payload) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Type:       payload.EventType(),
+		Version:    payload.EventVersion(),
+		OccurredAt: time.Now().UTC(),
+		Producer:   producer,
+		Payload:    body,
+	}, nil
+}
+
+// Marshal is New followed by JSON encoding of the envelope.
+func Marshal(producer string, payload Payload) ([]byte, error) {
+	env, err := New(producer, payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// Decode parses an envelope and its payload as T. It fails when the event is
+// of another type or of a newer version than this build understands.
+// Messages published before envelopes existed carry the bare payload and are
+// decoded as version 0.
+func Decode[T Payload](body []byte) (T, *Envelope, error) {
+	var payload T
+
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return payload, nil, err
+	}
+
+	if env.Type == "" && env.Payload == nil {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return payload, nil, err
+		}
+		return payload, &Envelope{Type: payload.EventType(), Payload: body}, nil
+	}
+
+	if env.Type != payload.EventType() {
+		return payload, &env, fmt.Errorf("%w: got %s, want %s", ErrTypeMismatch, env.Type, payload.EventType())
+	}
+	if env.Version > payload.EventVersion() {
+		return payload, &env, fmt.Errorf("%w: %s v%d, this build handles up to v%d", ErrUnsupportedVersion, env.Type, env.Version, payload.EventVersion())
+	}
+
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return payload, &env, err
+	}
+	return payload, &env, nil
+}
@@ -0,0 +1,227 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"pushtaka/pkg/events"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderRetryCount    = "x-retry-count"
+	HeaderLastError     = "x-last-error"
+	HeaderOriginalQueue = "x-original-queue"
+)
+
+// RetryPolicy bounds how often a failed delivery is retried. Attempt n waits
+// BaseDelay * 2^(n-1) in its own retry queue before going back to the main
+// queue; after MaxRetries the message is dead-lettered.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 3, BaseDelay: 2 * time.Second}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.BaseDelay * time.Duration(1<<uint(attempt-1))
+}
+
+// Handler processes one delivery. Returning an error retries it; wrap the
+// error with Permanent to dead-letter it straight away.
+type Handler func(ctx context.Context, d amqp.Delivery) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one retrying will not fix, e.g. a malformed message.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, was marked Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+func RetryQueue(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+func DeadLetterExchange(queue string) string {
+	return queue + ".dlx"
+}
+
+func DeadLetterQueue(queue string) string {
+	return queue + ".dlq"
+}
+
+// DeclareQueueWithRetry declares queue together with its retry queues and its
+// dead-letter exchange and queue.
+func DeclareQueueWithRetry(ch *amqp.Channel, queue string, policy RetryPolicy) error {
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", queue, err)
+	}
+
+	// Retry queues have no consumers: messages sit out their TTL and are
+	// dead-lettered back to the main queue
+	for attempt := 1; attempt <= policy.MaxRetries; attempt++ {
+		_, err := ch.QueueDeclare(RetryQueue(queue, attempt), true, false, false, false, amqp.Table{
+			"x-message-ttl":             policy.delay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue for %s: %v", queue, err)
+		}
+	}
+
+	return declareDeadLetter(ch, queue)
+}
+
+func declareDeadLetter(ch *amqp.Channel, queue string) error {
+	if err := ch.ExchangeDeclare(DeadLetterExchange(queue), "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange for %s: %v", queue, err)
+	}
+	if _, err := ch.QueueDeclare(DeadLetterQueue(queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue for %s: %v", queue, err)
+	}
+	if err := ch.QueueBind(DeadLetterQueue(queue), "", DeadLetterExchange(queue), false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue for %s: %v", queue, err)
+	}
+	return nil
+}
+
+// Consume delivers messages from queue to handler with manual acks. Failed
+// deliveries are retried according to policy, then dead-lettered; the
+// original is only acked once the broker has confirmed the reroute. It blocks
+// until the channel is closed.
+func Consume(ch *amqp.Channel, queue string, policy RetryPolicy, handler Handler) error {
+	if err := ch.Qos(10, 0, false); err != nil {
+		return fmt.Errorf("failed to set qos: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable confirm mode: %v", err)
+	}
+
+	msgs, err := ch.Consume(
+		queue, // queue
+		"",    // consumer
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %v", err)
+	}
+
+	reroute := func(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, msg)
+		if err != nil {
+			return err
+		}
+		acked, err := confirm.WaitContext(ctx)
+		if err == nil && !acked {
+			err = ErrNacked
+		}
+		return err
+	}
+	for d := range msgs {
+		handleDelivery(reroute, queue, policy, handler, d)
+	}
+	return nil
+}
+
+// rerouteFunc publishes a failed delivery to a retry queue or a dead-letter
+// exchange.
+type rerouteFunc func(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+
+func handleDelivery(reroute rerouteFunc, queue string, policy RetryPolicy, handler Handler, d amqp.Delivery) {
+	err := handler(context.Background(), d)
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+
+	attempt := RetryCount(d.Headers) + 1
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderRetryCount] = int32(attempt)
+	headers[HeaderLastError] = err.Error()
+
+	exchange, routingKey := "", RetryQueue(queue, attempt)
+	if IsPermanent(err) || attempt > policy.MaxRetries {
+		headers[HeaderOriginalQueue] = queue
+		exchange, routingKey = DeadLetterExchange(queue), ""
+		log.Printf("[pkg/messaging] Dead-lettering message from %s after %d attempt(s): %v", queue, attempt, err)
+	} else {
+		log.Printf("[pkg/messaging] Retrying message from %s in %s (attempt %d/%d): %v", queue, policy.delay(attempt), attempt, policy.MaxRetries, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubErr := reroute(ctx, exchange, routingKey, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		CorrelationId: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		MessageId:     d.MessageId,
+		Type:          d.Type,
+		DeliveryMode:  amqp.Persistent,
+		Body:          d.Body,
+	})
+	if pubErr != nil {
+		// Could not park it anywhere; let the broker redeliver
+		log.Printf("[pkg/messaging] Failed to reroute message from %s: %v", queue, pubErr)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+// RetryCount reads how many times a delivery has already been retried.
+func RetryCount(headers amqp.Table) int {
+	switch v := headers[HeaderRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// DeclareEventExchange declares the topic exchange domain events are
+// broadcast on. Every producer and subscriber declares it on connect.
+func DeclareEventExchange(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(events.Exchange, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", events.Exchange, err)
+	}
+	return nil
+}
+
+// BindEvents binds queue to the events exchange for each routing pattern,
+// e.g. "loan.*" or "#".
+func BindEvents(ch *amqp.Channel, queue string, patterns ...string) error {
+	for _, pattern := range patterns {
+		if err := ch.QueueBind(queue, pattern, events.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind %s to %s: %v", queue, pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcknowledger records how a delivery was settled.
+type fakeAcknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeued = true, requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type rerouted struct {
+	exchange, routingKey string
+	msg                  amqp.Publishing
+}
+
+func TestHandleDelivery(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second}
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name           string
+		retries        int
+		handlerErr     error
+		rerouteErr     error
+		wantExchange   string
+		wantRoutingKey string
+		wantAttempt    int32
+		wantAck        bool
+		wantRequeue    bool
+	}{
+		{"handled", 0, nil, nil, "", "", 0, true, false},
+		{"first failure", 0, errBoom, nil, "", "orders.retry.1", 1, true, false},
+		{"last retry", 2, errBoom, nil, "", "orders.retry.3", 3, true, false},
+		{"retries exhausted", 3, errBoom, nil, "orders.dlx", "", 4, true, false},
+		{"permanent failure", 0, Permanent(errBoom), nil, "orders.dlx", "", 1, true, false},
+		{"reroute failed", 0, errBoom, errors.New("channel closed"), "", "orders.retry.1", 1, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			d := amqp.Delivery{
+				Acknowledger:  ack,
+				Headers:       amqp.Table{HeaderRetryCount: int32(tt.retries)},
+				CorrelationId: "42",
+				Body:          []byte(`{"book_id":7}`),
+			}
+			var sent []rerouted
+			reroute := func(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+				sent = append(sent, rerouted{exchange, routingKey, msg})
+				return tt.rerouteErr
+			}
+			handler := func(ctx context.Context, d amqp.Delivery) error { return tt.handlerErr }
+
+			handleDelivery(reroute, "orders", policy, handler, d)
+
+			if ack.acked != tt.wantAck || ack.requeued != tt.wantRequeue {
+				t.Fatalf("acked %v requeued %v, want %v %v", ack.acked, ack.requeued, tt.wantAck, tt.wantRequeue)
+			}
+			if tt.handlerErr == nil {
+				if len(sent) != 0 {
+					t.Fatalf("rerouted a handled delivery: %+v", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].exchange != tt.wantExchange || sent[0].routingKey != tt.wantRoutingKey {
+				t.Fatalf("rerouted to %+v, want %q/%q", sent, tt.wantExchange, tt.wantRoutingKey)
+			}
+			msg := sent[0].msg
+			if RetryCount(msg.Headers) != int(tt.wantAttempt) || msg.Headers[HeaderLastError] != "boom" {
+				t.Fatalf("headers = %v, want attempt %d and the last error", msg.Headers, tt.wantAttempt)
+			}
+			if string(msg.Body) != string(d.Body) || msg.CorrelationId != d.CorrelationId {
+				t.Fatalf("rerouted message lost its body or correlation ID: %+v", msg)
+			}
+			// Only dead letters say where they came from
+			if origin, ok := msg.Headers[HeaderOriginalQueue]; ok != (tt.wantExchange != "") || (ok && origin != "orders") {
+				t.Fatalf("original queue header = %v", origin)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: 2 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second} {
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		headers amqp.Table
+		want    int
+	}{
+		{nil, 0},
+		{amqp.Table{HeaderRetryCount: int32(2)}, 2},
+		{amqp.Table{HeaderRetryCount: int64(3)}, 3},
+		{amqp.Table{HeaderRetryCount: "3"}, 0},
+	}
+	for _, tt := range tests {
+		if got := RetryCount(tt.headers); got != tt.want {
+			t.Errorf("RetryCount(%v) = %d, want %d", tt.headers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"pushtaka/pkg/database"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+)
+
+const (
+	DeadLetterPending  = "dead"
+	DeadLetterReplayed = "replayed"
+)
+
+var ErrDeadLetterReplayed = errors.New("dead letter already replayed")
+
+// DeadLetter is a message that exhausted its retries, archived from the
+// queue's dead-letter queue so admins can inspect and replay it.
+type DeadLetter struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Service       string     `gorm:"not null;index:idx_dead_letter_service_queue" json:"service"`
+	Queue         string     `gorm:"not null;index:idx_dead_letter_service_queue" json:"queue"`
+	MessageID     string     `json:"message_id"`
+	Type          string     `json:"type"`
+	ContentType   string     `json:"content_type"`
+	CorrelationID string     `json:"correlation_id"`
+	ReplyTo       string     `json:"reply_to"`
+	Headers       string     `gorm:"type:text" json:"headers"` // JSON encoded
+	Body          string     `gorm:"type:text" json:"body"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	Status        string     `gorm:"not null;default:'dead'" json:"status"`
+	ReplayedAt    *time.Time `json:"replayed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// DeadLetterStore archives and manages one service's dead-lettered messages.
+type DeadLetterStore struct {
+	db         *gorm.DB
+	service    string
+	outbox     *Outbox
+	transactor database.Transactor
+}
+
+func NewDeadLetterStore(db *gorm.DB, service string, outbox *Outbox) *DeadLetterStore {
+	return &DeadLetterStore{
+		db:         db,
+		service:    service,
+		outbox:     outbox,
+		transactor: database.NewTransactor(db),
+	}
+}
+
+// Archive moves messages from the dead-letter queues of the given queues into
+// the database. It blocks until ch is closed.
+func (s *DeadLetterStore) Archive(ch *amqp.Channel, queues ...string) error {
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		if err := declareDeadLetter(ch, queue); err != nil {
+			return err
+		}
+		msgs, err := ch.Consume(DeadLetterQueue(queue), "", false, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to consume dead-letter queue for %s: %v", queue, err)
+		}
+
+		wg.Add(1)
+		go func(queue string, msgs <-chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range msgs {
+				if err := s.store(queue, d); err != nil {
+					log.Printf("[pkg/messaging] Failed to archive dead letter from %s: %v", queue, err)
+					d.Nack(false, true)
+					time.Sleep(time.Second)
+					continue
+				}
+				d.Ack(false)
+			}
+		}(queue, msgs)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (s *DeadLetterStore) store(queue string, d amqp.Delivery) error {
+	return s.db.Create(newDeadLetter(s.service, queue, d)).Error
+}
+
+// newDeadLetter archives delivery d, dead-lettered from queue.
+func newDeadLetter(service, queue string, d amqp.Delivery) *DeadLetter {
+	headers, _ := json.Marshal(d.Headers)
+	lastError, _ := d.Headers[HeaderLastError].(string)
+
+	return &DeadLetter{
+		Service:       service,
+		Queue:         queue,
+		MessageID:     d.MessageId,
+		Type:          d.Type,
+		ContentType:   d.ContentType,
+		CorrelationID: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		Headers:       string(headers),
+		Body:          string(d.Body),
+		Attempts:      RetryCount(d.Headers),
+		LastError:     lastError,
+		Status:        DeadLetterPending,
+	}
+}
+
+// List returns dead letters newest first, optionally filtered by queue.
+func (s *DeadLetterStore) List(ctx context.Context, queue string, limit, offset int) ([]DeadLetter, int64, error) {
+	query := s.db.WithContext(ctx).Model(&DeadLetter{}).Where("service = ?", s.service)
+	if queue != "" {
+		query = query.Where("queue = ?", queue)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var letters []DeadLetter
+	err := query.Order("id desc").Limit(limit).Offset(offset).Find(&letters).Error
+	return letters, total, err
+}
+
+func (s *DeadLetterStore) Get(ctx context.Context, id uint) (*DeadLetter, error) {
+	var letter DeadLetter
+	err := s.db.WithContext(ctx).Where("service = ?", s.service).First(&letter, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// Replay sends a dead letter back to its original queue through the outbox
+// with a fresh retry budget.
+func (s *DeadLetterStore) Replay(ctx context.Context, id uint) error {
+	letter, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	msg, err := letter.replay(time.Now())
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.outbox.EnqueueRaw(ctx, msg); err != nil {
+			return err
+		}
+		return database.Conn(ctx, s.db).Save(letter).Error
+	})
+}
+
+// replay marks the letter replayed and returns the message to send to its
+// original queue. The message carries no retry headers, so it starts over.
+func (l *DeadLetter) replay(now time.Time) (OutboxMessage, error) {
+	if l.Status == DeadLetterReplayed {
+		return OutboxMessage{}, ErrDeadLetterReplayed
+	}
+	l.Status = DeadLetterReplayed
+	l.ReplayedAt = &now
+
+	// Keep the message ID: it never made it into the consumer's ledger
+	return OutboxMessage{
+		MessageID:     l.MessageID,
+		Type:          l.Type,
+		RoutingKey:    l.Queue,
+		ContentType:   l.ContentType,
+		CorrelationID: l.CorrelationID,
+		ReplyTo:       l.ReplyTo,
+		Body:          []byte(l.Body),
+	}, nil
+}
+
+func (s *DeadLetterStore) Delete(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Where("service = ?", s.service).Delete(&DeadLetter{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Purge deletes all dead letters, or only those of queue when it is set.
+func (s *DeadLetterStore) Purge(ctx context.Context, queue string) (int64, error) {
+	query := s.db.WithContext(ctx).Where("service = ?", s.service)
+	if queue != "" {
+		query = query.Where("queue = ?", queue)
+	}
+	result := query.Delete(&DeadLetter{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,70 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestNewDeadLetter(t *testing.T) {
+	d := amqp.Delivery{
+		Headers:       amqp.Table{HeaderRetryCount: int32(4), HeaderLastError: "book not found", HeaderOriginalQueue: "stock_updates"},
+		ContentType:   "application/json",
+		CorrelationId: "9",
+		ReplyTo:       "stock_reservation_results",
+		Body:          []byte(`{"book_id":7}`),
+	}
+
+	letter := newDeadLetter("book", "stock_updates", d)
+	if letter.Service != "book" || letter.Queue != "stock_updates" || letter.Status != DeadLetterPending {
+		t.Fatalf("letter = %+v, want a pending letter from book's stock_updates", letter)
+	}
+	if letter.Attempts != 4 || letter.LastError != "book not found" {
+		t.Fatalf("letter records %d attempts (%q), want 4 (book not found)", letter.Attempts, letter.LastError)
+	}
+	if letter.Body != `{"book_id":7}` || letter.CorrelationID != "9" || letter.ReplyTo != "stock_reservation_results" {
+		t.Fatalf("letter lost the message: %+v", letter)
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	letter := &DeadLetter{
+		Queue:         "stock_updates",
+		MessageID:     "3f2c9a",
+		Type:          "stock.updated",
+		ContentType:   "application/json",
+		CorrelationID: "9",
+		ReplyTo:       "stock_reservation_results",
+		Headers:       `{"x-retry-count":4}`,
+		Body:          `{"book_id":7}`,
+		Attempts:      4,
+		Status:        DeadLetterPending,
+	}
+	now := time.Now()
+
+	msg, err := letter.replay(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Back to the original queue, without the headers that used up its retries
+	if msg.RoutingKey != "stock_updates" || msg.Exchange != "" || string(msg.Body) != letter.Body {
+		t.Fatalf("replayed %+v, want the body sent to stock_updates", msg)
+	}
+	if msg.CorrelationID != "9" || msg.ReplyTo != "stock_reservation_results" {
+		t.Fatalf("replay dropped the reply address: %+v", msg)
+	}
+	// The consumer never recorded the ID, so the replay must not be dropped
+	// as a duplicate, nor get a new ID that defeats dedup on redelivery
+	if msg.MessageID != "3f2c9a" || msg.Type != "stock.updated" {
+		t.Fatalf("replayed as %q (%s), want the original ID and type", msg.MessageID, msg.Type)
+	}
+	if letter.Status != DeadLetterReplayed || letter.ReplayedAt == nil || !letter.ReplayedAt.Equal(now) {
+		t.Fatalf("letter is %s at %v, want replayed at %v", letter.Status, letter.ReplayedAt, now)
+	}
+
+	if _, err := letter.replay(now); !errors.Is(err, ErrDeadLetterReplayed) {
+		t.Fatalf("second replay = %v, want ErrDeadLetterReplayed", err)
+	}
+}
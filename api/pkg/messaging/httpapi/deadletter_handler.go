@@ -0,0 +1,109 @@
+// Package httpapi serves the HTTP endpoints of pkg/messaging, such as the
+// dead-letter admin routes, for the services that consume messages.
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/messaging"
+	"pushtaka/pkg/middleware"
+	"pushtaka/pkg/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeadLetterStore is the part of messaging.DeadLetterStore the handler uses.
+type DeadLetterStore interface {
+	List(ctx context.Context, queue string, limit, offset int) ([]messaging.DeadLetter, int64, error)
+	Get(ctx context.Context, id uint) (*messaging.DeadLetter, error)
+	Replay(ctx context.Context, id uint) error
+	Delete(ctx context.Context, id uint) error
+	Purge(ctx context.Context, queue string) (int64, error)
+}
+
+type DeadLetterHandler struct {
+	store DeadLetterStore
+}
+
+// NewDeadLetterHandler registers the admin routes for a service's dead
+// letters under prefix, such as /books.
+func NewDeadLetterHandler(app *fiber.App, prefix string, store DeadLetterStore) {
+	handler := &DeadLetterHandler{
+		store: store,
+	}
+
+	adminOnly := middleware.RequirePermission(auth.PermSystemManage)
+
+	app.Get(prefix+"/admin/dead-letters", adminOnly, handler.List)
+	app.Get(prefix+"/admin/dead-letters/:id", adminOnly, handler.Get)
+	app.Post(prefix+"/admin/dead-letters/:id/replay", adminOnly, handler.Replay)
+	app.Delete(prefix+"/admin/dead-letters/:id", adminOnly, handler.Delete)
+	app.Delete(prefix+"/admin/dead-letters", adminOnly, handler.Purge)
+}
+
+func (h *DeadLetterHandler) List(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	letters, total, err := h.store.List(c.Context(), c.Query("queue"), limit, offset)
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("dead letters retrieved", fiber.Map{
+		"items": letters,
+		"total": total,
+	}))
+}
+
+func (h *DeadLetterHandler) Get(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	letter, err := h.store.Get(c.Context(), uint(id))
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("dead letter retrieved", letter))
+}
+
+func (h *DeadLetterHandler) Replay(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	if err := h.store.Replay(c.Context(), uint(id)); err != nil {
+		if errors.Is(err, messaging.ErrDeadLetterReplayed) {
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("dead letter queued for replay", nil))
+}
+
+func (h *DeadLetterHandler) Delete(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	if err := h.store.Delete(c.Context(), uint(id)); err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("dead letter deleted", nil))
+}
+
+func (h *DeadLetterHandler) Purge(c *fiber.Ctx) error {
+	purged, err := h.store.Purge(c.Context(), c.Query("queue"))
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("dead letters purged", fiber.Map{"purged": purged}))
+}
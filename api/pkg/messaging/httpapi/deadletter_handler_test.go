@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/messaging"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// fakeDeadLetters holds letters by ID and records what was asked of it.
+type fakeDeadLetters struct {
+	letters map[uint]*messaging.DeadLetter
+	calls   []string
+}
+
+func (s *fakeDeadLetters) List(ctx context.Context, queue string, limit, offset int) ([]messaging.DeadLetter, int64, error) {
+	s.calls = append(s.calls, "list")
+	var letters []messaging.DeadLetter
+	for _, letter := range s.letters {
+		if queue == "" || letter.Queue == queue {
+			letters = append(letters, *letter)
+		}
+	}
+	return letters, int64(len(letters)), nil
+}
+
+func (s *fakeDeadLetters) Get(ctx context.Context, id uint) (*messaging.DeadLetter, error) {
+	letter, ok := s.letters[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return letter, nil
+}
+
+func (s *fakeDeadLetters) Replay(ctx context.Context, id uint) error {
+	letter, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if letter.Status == messaging.DeadLetterReplayed {
+		return messaging.ErrDeadLetterReplayed
+	}
+	letter.Status = messaging.DeadLetterReplayed
+	s.calls = append(s.calls, "replay")
+	return nil
+}
+
+func (s *fakeDeadLetters) Delete(ctx context.Context, id uint) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+func (s *fakeDeadLetters) Purge(ctx context.Context, queue string) (int64, error) {
+	s.calls = append(s.calls, "purge")
+	return 0, nil
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	signer, err := auth.NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.UseKeys(signer)
+	t.Cleanup(func() { auth.UseKeys(nil) })
+
+	token := func(role string) string {
+		tokenString, err := signer.GenerateSessionToken(1, "admin@contoh.com", role, 0, 0, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + tokenString
+	}
+	admin, librarian := token(auth.RoleAdmin), token(auth.RoleLibrarian)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{"list", "GET", "/books/admin/dead-letters?queue=book_deleted_queue", admin, fiber.StatusOK},
+		{"get", "GET", "/books/admin/dead-letters/1", admin, fiber.StatusOK},
+		{"get unknown", "GET", "/books/admin/dead-letters/9", admin, fiber.StatusNotFound},
+		{"get bad id", "GET", "/books/admin/dead-letters/abc", admin, fiber.StatusBadRequest},
+		{"replay", "POST", "/books/admin/dead-letters/1/replay", admin, fiber.StatusOK},
+		{"replay twice", "POST", "/books/admin/dead-letters/2/replay", admin, fiber.StatusConflict},
+		{"delete", "DELETE", "/books/admin/dead-letters/1", admin, fiber.StatusOK},
+		{"purge", "DELETE", "/books/admin/dead-letters", admin, fiber.StatusOK},
+		{"not an admin", "GET", "/books/admin/dead-letters", librarian, fiber.StatusForbidden},
+		{"signed out", "POST", "/books/admin/dead-letters/1/replay", "", fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeDeadLetters{letters: map[uint]*messaging.DeadLetter{
+				1: {ID: 1, Queue: "book_deleted_queue", Status: messaging.DeadLetterPending},
+				2: {ID: 2, Queue: "book_deleted_queue", Status: messaging.DeadLetterReplayed},
+			}}
+			app := fiber.New()
+			NewDeadLetterHandler(app, "/books", store)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == fiber.StatusForbidden || tt.want == fiber.StatusUnauthorized {
+				if len(store.calls) != 0 || store.letters[1].Status != messaging.DeadLetterPending {
+					t.Fatalf("store used without permission: %v", store.calls)
+				}
+			}
+		})
+	}
+}
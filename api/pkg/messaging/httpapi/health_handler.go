@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"pushtaka/pkg/messaging"
+	"pushtaka/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HealthSource reports the broker connection, as messaging.ConnectionManager
+// does.
+type HealthSource interface {
+	Health() messaging.Health
+}
+
+type HealthHandler struct {
+	mq HealthSource
+}
+
+// NewHealthHandler serves prefix+"/health", such as /books/health. It must be
+// registered before the service's routes so that the path is not taken for
+// an ID.
+func NewHealthHandler(app *fiber.App, prefix string, mq HealthSource) {
+	handler := &HealthHandler{
+		mq: mq,
+	}
+
+	app.Get(prefix+"/health", handler.Health)
+}
+
+func (h *HealthHandler) Health(c *fiber.Ctx) error {
+	health := h.mq.Health()
+	data := fiber.Map{"rabbitmq": health}
+	if !health.Connected {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(utils.Response{
+			Status:  "error",
+			Message: "rabbitmq unavailable",
+			Data:    data,
+		})
+	}
+	return c.JSON(utils.Success("service healthy", data))
+}
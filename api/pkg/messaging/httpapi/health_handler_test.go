@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net/http/httptest"
+	"pushtaka/pkg/messaging"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type fakeHealth messaging.Health
+
+func (h fakeHealth) Health() messaging.Health {
+	return messaging.Health(h)
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		health messaging.Health
+		want   int
+	}{
+		{"connected", messaging.Health{Connected: true}, fiber.StatusOK},
+		{"broker down", messaging.Health{Connected: false, LastError: "connection refused"}, fiber.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			NewHealthHandler(app, "/transactions", fakeHealth(tt.health))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/transactions/health", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"pushtaka/pkg/database"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProcessedMessage records that a consumer has handled a message ID.
+type ProcessedMessage struct {
+	Consumer    string    `gorm:"primaryKey" json:"consumer"`
+	MessageID   string    `gorm:"primaryKey" json:"message_id"`
+	Type        string    `json:"type"`
+	ProcessedAt time.Time `gorm:"index" json:"processed_at"`
+}
+
+// Ledger makes consumers idempotent by remembering which message IDs they
+// have already processed.
+type Ledger struct {
+	store      ledgerStore
+	transactor database.Transactor
+}
+
+func NewLedger(db *gorm.DB) *Ledger {
+	return &Ledger{store: gormLedgerStore{db: db}, transactor: database.NewTransactor(db)}
+}
+
+// ledgerStore records processed messages. claim reports false when the
+// message was already recorded for its consumer.
+type ledgerStore interface {
+	claim(ctx context.Context, msg *ProcessedMessage) (bool, error)
+}
+
+type gormLedgerStore struct {
+	db *gorm.DB
+}
+
+func (s gormLedgerStore) claim(ctx context.Context, msg *ProcessedMessage) (bool, error) {
+	result := database.Conn(ctx, s.db).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(msg)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Wrap runs handler at most once per message ID for consumer. The ledger
+// entry and the handler's database work share one transaction, so a failed
+// handler leaves no entry behind and the retry is processed normally.
+func (l *Ledger) Wrap(consumer string, handler Handler) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		if d.MessageId == "" {
+			log.Printf("[pkg/messaging] %s received a message without ID, processing without dedup", consumer)
+			return handler(ctx, d)
+		}
+
+		return l.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			claimed, err := l.store.claim(ctx, &ProcessedMessage{
+				Consumer:    consumer,
+				MessageID:   d.MessageId,
+				Type:        d.Type,
+				ProcessedAt: time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				log.Printf("[pkg/messaging] %s skipping duplicate message %s (%s)", consumer, d.MessageId, d.Type)
+				return nil
+			}
+			return handler(ctx, d)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeLedgerStore keeps claimed message IDs in memory.
+type fakeLedgerStore struct {
+	claimed map[[2]string]bool
+	pending [][2]string // Claimed in the current transaction
+}
+
+func (s *fakeLedgerStore) claim(ctx context.Context, msg *ProcessedMessage) (bool, error) {
+	key := [2]string{msg.Consumer, msg.MessageID}
+	if s.claimed[key] {
+		return false, nil
+	}
+	s.claimed[key] = true
+	s.pending = append(s.pending, key)
+	return true, nil
+}
+
+// WithinTransaction drops the claims made by fn when it fails, as a rollback
+// would.
+func (s *fakeLedgerStore) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.pending = nil
+	err := fn(ctx)
+	if err != nil {
+		for _, key := range s.pending {
+			delete(s.claimed, key)
+		}
+	}
+	return err
+}
+
+func TestLedgerWrap(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		consumer  string
+		messageID string
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{"first delivery", "book:stock_updates", "m1", nil, 1, nil},
+		{"redelivery", "book:stock_updates", "m1", nil, 1, nil},
+		{"same message, other consumer", "transaction:stock_updates", "m1", nil, 2, nil},
+		{"failed handler", "book:stock_updates", "m2", errBoom, 3, errBoom},
+		{"retry after a failure", "book:stock_updates", "m2", nil, 4, nil},
+		{"retry after success", "book:stock_updates", "m2", nil, 4, nil},
+		{"no message ID", "book:stock_updates", "", nil, 5, nil},
+		{"no message ID again", "book:stock_updates", "", nil, 6, nil},
+	}
+
+	// The cases run in order against one ledger
+	store := &fakeLedgerStore{claimed: make(map[[2]string]bool)}
+	ledger := &Ledger{store: store, transactor: store}
+	calls := 0
+	for _, tt := range tests {
+		handler := ledger.Wrap(tt.consumer, func(ctx context.Context, d amqp.Delivery) error {
+			calls++
+			return tt.err
+		})
+		err := handler(context.Background(), amqp.Delivery{MessageId: tt.messageID, Type: "stock.updated"})
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if calls != tt.wantCalls {
+			t.Fatalf("%s: handler ran %d times in total, want %d", tt.name, calls, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"pushtaka/pkg/database"
+	"pushtaka/pkg/events"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+)
+
+// OutboxMessage is a message waiting to be relayed to RabbitMQ. Rows are
+// written in the same database transaction as the change they announce.
+type OutboxMessage struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Producer      string     `gorm:"not null;index:idx_outbox_pending" json:"producer"` // Service that owns the row
+	MessageID     string     `gorm:"index" json:"message_id"`
+	Type          string     `json:"type"` // Event name, e.g. stock.updated
+	Exchange      string     `json:"exchange"`
+	RoutingKey    string     `gorm:"not null" json:"routing_key"`
+	ContentType   string     `json:"content_type"`
+	CorrelationID string     `json:"correlation_id"`
+	ReplyTo       string     `json:"reply_to"`
+	Broadcast     bool       `gorm:"default:false" json:"broadcast"` // Domain event; fine if nobody is subscribed
+	Body          []byte     `gorm:"not null" json:"body"`
+	Status        string     `gorm:"not null;default:'pending';index:idx_outbox_pending" json:"status"`
+	Attempts      int        `gorm:"default:0" json:"attempts"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `gorm:"index:idx_outbox_pending" json:"next_attempt_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// OutboxWriter queues messages for relaying. Usecases depend on it rather
+// than on Outbox so they can be tested without a database.
+type OutboxWriter interface {
+	Enqueue(ctx context.Context, routingKey string, payload events.Payload) error
+	EnqueueWithReply(ctx context.Context, routingKey, replyTo, correlationID string, payload events.Payload) error
+	Publish(ctx context.Context, payload events.Payload) error
+}
+
+// Outbox writes messages to the outbox table, joining the transaction carried
+// by the context when there is one.
+type Outbox struct {
+	db       *gorm.DB
+	producer string
+}
+
+func NewOutbox(db *gorm.DB, producer string) *Outbox {
+	return &Outbox{db: db, producer: producer}
+}
+
+// Enqueue wraps payload in an event envelope for publishing to routingKey on
+// the default exchange. Each message gets a unique ID so consumers can drop
+// duplicates.
+func (o *Outbox) Enqueue(ctx context.Context, routingKey string, payload events.Payload) error {
+	return o.EnqueueWithReply(ctx, routingKey, "", "", payload)
+}
+
+// EnqueueWithReply is Enqueue for request messages that expect an answer on replyTo.
+func (o *Outbox) EnqueueWithReply(ctx context.Context, routingKey, replyTo, correlationID string, payload events.Payload) error {
+	body, err := events.Marshal(o.producer, payload)
+	if err != nil {
+		return err
+	}
+	return o.EnqueueRaw(ctx, OutboxMessage{
+		Type:          payload.EventType(),
+		RoutingKey:    routingKey,
+		ContentType:   "application/json",
+		CorrelationID: correlationID,
+		ReplyTo:       replyTo,
+		Body:          body,
+	})
+}
+
+// Publish broadcasts a domain event on the events exchange, routed by its
+// type. Unlike Enqueue it is not an error if no queue is bound yet.
+func (o *Outbox) Publish(ctx context.Context, payload events.Payload) error {
+	body, err := events.Marshal(o.producer, payload)
+	if err != nil {
+		return err
+	}
+	return o.EnqueueRaw(ctx, OutboxMessage{
+		Type:        payload.EventType(),
+		Exchange:    events.Exchange,
+		RoutingKey:  payload.EventType(),
+		ContentType: "application/json",
+		Broadcast:   true,
+		Body:        body,
+	})
+}
+
+// EnqueueRaw stores an already encoded message, e.g. one being replayed. A
+// message ID is generated when msg has none.
+func (o *Outbox) EnqueueRaw(ctx context.Context, msg OutboxMessage) error {
+	msg.ID = 0
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.NewString()
+	}
+	msg.Producer = o.producer
+	msg.Status = OutboxPending
+	msg.NextAttemptAt = time.Now()
+	return database.Conn(ctx, o.db).Create(&msg).Error
+}
+
+// OutboxRelay publishes pending outbox rows and marks them sent once the
+// broker has confirmed them, retrying failures (including unroutable
+// returns) with exponential backoff. Delivery is at-least-once.
+type OutboxRelay struct {
+	db        *gorm.DB
+	mq        *ConnectionManager
+	publisher *Publisher
+	producer  string
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+}
+
+func NewOutboxRelay(db *gorm.DB, mq *ConnectionManager, producer string) *OutboxRelay {
+	return &OutboxRelay{
+		db:        db,
+		mq:        mq,
+		publisher: NewPublisher(mq, 5*time.Second),
+		producer:  producer,
+		interval:  time.Second,
+		batchSize: 100,
+		retention: 7 * 24 * time.Hour,
+	}
+}
+
+// Run relays pending messages until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Printf("[pkg/messaging] Outbox relay failed: %v", err)
+			}
+			if time.Since(lastPurge) > time.Hour {
+				r.purgeSent(ctx)
+				lastPurge = time.Now()
+			}
+		}
+	}
+}
+
+// Flush publishes one batch of due messages. Rows are locked with SKIP LOCKED
+// so several replicas can relay the same table. Nothing is attempted while
+// the broker is unreachable.
+func (r *OutboxRelay) Flush(ctx context.Context) error {
+	if !r.mq.IsConnected() {
+		return nil // Rows stay pending until the connection is back
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msgs []OutboxMessage
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("producer = ? AND status = ? AND next_attempt_at <= ?", r.producer, OutboxPending, time.Now()).
+			Order("id").
+			Limit(r.batchSize).
+			Find(&msgs).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range msgs {
+			msg := &msgs[i]
+			err := r.publish(ctx, msg)
+			msg.recordAttempt(err, time.Now())
+			if err != nil {
+				log.Printf("[pkg/messaging] Failed to relay outbox message %d (attempt %d): %v", msg.ID, msg.Attempts, err)
+			}
+			if err := tx.Save(msg).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, msg *OutboxMessage) error {
+	publish := r.publisher.Publish
+	if msg.Broadcast {
+		publish = r.publisher.Broadcast
+	}
+	return publish(ctx, msg.Exchange, msg.RoutingKey, amqp.Publishing{
+		MessageId:     msg.MessageID,
+		Type:          msg.Type,
+		Timestamp:     msg.CreatedAt,
+		ContentType:   msg.ContentType,
+		CorrelationId: msg.CorrelationID,
+		ReplyTo:       msg.ReplyTo,
+		DeliveryMode:  amqp.Persistent,
+		Body:          msg.Body,
+	})
+}
+
+func (r *OutboxRelay) purgeSent(ctx context.Context) {
+	err := r.db.WithContext(ctx).
+		Where("producer = ? AND status = ? AND sent_at < ?", r.producer, OutboxSent, time.Now().Add(-r.retention)).
+		Delete(&OutboxMessage{}).Error
+	if err != nil {
+		log.Printf("[pkg/messaging] Failed to purge sent outbox messages: %v", err)
+	}
+}
+
+// recordAttempt marks the message sent, or schedules its next attempt when
+// publishing failed with err.
+func (m *OutboxMessage) recordAttempt(err error, now time.Time) {
+	if err != nil {
+		m.Attempts++
+		m.LastError = err.Error()
+		m.NextAttemptAt = now.Add(outboxBackoff(m.Attempts))
+		return
+	}
+	m.Status = OutboxSent
+	m.SentAt = &now
+	m.LastError = ""
+}
+
+// outboxBackoff doubles from 1s per attempt, capped at 5 minutes.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 8 {
+		return 5 * time.Minute
+	}
+	d := time.Second << uint(attempts)
+	if d > 5*time.Minute {
+		return 5 * time.Minute
+	}
+	return d
+}
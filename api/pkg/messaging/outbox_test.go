@@ -0,0 +1,65 @@
+package messaging
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{8, 256 * time.Second},
+		{9, 5 * time.Minute},
+		{64, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxRecordAttempt(t *testing.T) {
+	now := time.Now()
+	unroutable := fmt.Errorf("%w: NO_ROUTE", ErrUnroutable)
+
+	tests := []struct {
+		name         string
+		attempts     int
+		err          error
+		wantStatus   string
+		wantAttempts int
+		wantNext     time.Time
+	}{
+		{"confirmed", 0, nil, OutboxSent, 0, time.Time{}},
+		{"nacked", 0, ErrNacked, OutboxPending, 1, now.Add(2 * time.Second)},
+		{"unroutable", 2, unroutable, OutboxPending, 3, now.Add(8 * time.Second)},
+		{"no confirm", 9, ErrConfirmTimeout, OutboxPending, 10, now.Add(5 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &OutboxMessage{Status: OutboxPending, Attempts: tt.attempts, LastError: "earlier failure"}
+			msg.recordAttempt(tt.err, now)
+
+			if msg.Status != tt.wantStatus || msg.Attempts != tt.wantAttempts || !msg.NextAttemptAt.Equal(tt.wantNext) {
+				t.Fatalf("message is %s after %d attempts, next at %v; want %s, %d, %v",
+					msg.Status, msg.Attempts, msg.NextAttemptAt, tt.wantStatus, tt.wantAttempts, tt.wantNext)
+			}
+			if tt.err == nil {
+				if msg.SentAt == nil || !msg.SentAt.Equal(now) || msg.LastError != "" {
+					t.Fatalf("sent message has sent_at %v and last error %q", msg.SentAt, msg.LastError)
+				}
+				return
+			}
+			if msg.SentAt != nil || msg.LastError != tt.err.Error() {
+				t.Fatalf("failed message has sent_at %v and last error %q", msg.SentAt, msg.LastError)
+			}
+		})
+	}
+}
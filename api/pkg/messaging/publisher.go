@@ -0,0 +1,107 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrUnroutable     = errors.New("message was returned as unroutable")
+	ErrNacked         = errors.New("message was nacked by the broker")
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
+)
+
+// Publisher publishes with mandatory routing on a channel in confirm mode and
+// waits for the broker to accept each message. A message that no queue is
+// bound to comes back as ErrUnroutable instead of being silently dropped.
+type Publisher struct {
+	mq      *ConnectionManager
+	timeout time.Duration
+
+	mu      sync.Mutex // Serialises publishes so returns match their message
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+func NewPublisher(mq *ConnectionManager, timeout time.Duration) *Publisher {
+	return &Publisher{mq: mq, timeout: timeout}
+}
+
+// Publish sends msg and blocks until the broker confirms it, returns it as
+// unroutable, nacks it or the timeout passes.
+func (p *Publisher) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	return p.publish(ctx, exchange, routingKey, true, msg)
+}
+
+// Broadcast is Publish without mandatory routing, for events that may have
+// no subscribers.
+func (p *Publisher) Broadcast(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	return p.publish(ctx, exchange, routingKey, false, msg)
+}
+
+func (p *Publisher) publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	// Drop returns left over from a publish that timed out
+	for len(p.returns) > 0 {
+		<-p.returns
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, mandatory, false, msg)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrConfirmTimeout
+		}
+		return err
+	}
+	if !acked {
+		return ErrNacked
+	}
+
+	// The broker sends basic.return before the ack of the same message
+	select {
+	case ret := <-p.returns:
+		return fmt.Errorf("%w: %s %s (exchange %q, routing key %q)", ErrUnroutable, ret.ReplyText, ret.MessageId, ret.Exchange, ret.RoutingKey)
+	default:
+		return nil
+	}
+}
+
+// channel returns the confirm-mode channel, opening a new one after a reconnect.
+func (p *Publisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	ch, err := p.mq.OpenChannel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable confirm mode: %v", err)
+	}
+
+	p.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	p.ch = ch
+	return ch, nil
+}
@@ -1,25 +1,151 @@
 package messaging
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func ConnectRabbitMQ(url string) (*amqp.Connection, *amqp.Channel, error) {
-	var conn *amqp.Connection
-	var err error
+var ErrNotConnected = errors.New("rabbitmq is not connected")
 
-	for i := 0; i < 10; i++ {
-		conn, err = amqp.Dial(url)
-		if err == nil {
-			break
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Health is a snapshot of the broker connection.
+type Health struct {
+	Connected  bool       `json:"connected"`
+	Since      *time.Time `json:"since"` // When the current state began
+	Reconnects int        `json:"reconnects"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+type consumerSpec struct {
+	name string
+	run  func(ch *amqp.Channel) error
+}
+
+// ConnectionManager keeps a RabbitMQ connection alive. When the broker goes
+// away it reconnects with backoff, re-declares topology and restarts every
+// registered consumer on a fresh channel.
+type ConnectionManager struct {
+	url string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	ch        *amqp.Channel // Topology channel, kept open to notice failures
+	health    Health
+	topology  []func(ch *amqp.Channel) error
+	consumers []consumerSpec
+}
+
+func NewConnectionManager(url string) *ConnectionManager {
+	now := time.Now()
+	return &ConnectionManager{
+		url:    url,
+		health: Health{Since: &now},
+	}
+}
+
+// DeclareTopology registers fn to run on every (re)connect, before consumers start.
+func (m *ConnectionManager) DeclareTopology(fn func(ch *amqp.Channel) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topology = append(m.topology, fn)
+}
+
+// Consume registers a consumer. run gets its own channel and should block
+// until that channel closes; it is restarted after every reconnect.
+func (m *ConnectionManager) Consume(name string, run func(ch *amqp.Channel) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consumers = append(m.consumers, consumerSpec{name: name, run: run})
+}
+
+// Start connects in the background and keeps reconnecting until ctx is cancelled.
+func (m *ConnectionManager) Start(ctx context.Context) {
+	go m.run(ctx)
+}
+
+// OpenChannel opens a new channel on the current connection. The caller owns
+// it and must open another one after a reconnect.
+func (m *ConnectionManager) OpenChannel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
+func (m *ConnectionManager) IsConnected() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health.Connected
+}
+
+func (m *ConnectionManager) Health() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health
+}
+
+func (m *ConnectionManager) run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		conn, ch, err := m.connect()
+		if err != nil {
+			m.setDown(err)
+			log.Printf("[pkg/messaging] Failed to connect, retrying in %s: %v", delay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = nextReconnectDelay(delay)
+			continue
+		}
+		delay = minReconnectDelay
+
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+		m.setUp(conn, ch)
+		log.Println("[pkg/messaging] Connected to RabbitMQ")
+
+		connCtx, cancel := context.WithCancel(ctx)
+		m.mu.RLock()
+		for _, spec := range m.consumers {
+			go m.runConsumer(connCtx, conn, spec)
 		}
-		log.Printf("[pkg/messaging] Failed to connect, retrying in 5s... (%d/10)", i+1)
-		time.Sleep(5 * time.Second)
+		m.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			conn.Close()
+			return
+		case amqpErr := <-connClosed:
+			m.setDown(closeError(amqpErr))
+			log.Printf("[pkg/messaging] Connection closed: %v", amqpErr)
+		case amqpErr := <-chClosed:
+			// Losing the topology channel: start over on a fresh connection
+			m.setDown(closeError(amqpErr))
+			log.Printf("[pkg/messaging] Topology channel closed: %v", amqpErr)
+			conn.Close()
+		}
+		cancel()
 	}
+}
 
+// connect dials the broker, opens the topology channel and declares topology.
+func (m *ConnectionManager) connect() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(m.url)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -30,5 +156,83 @@ func ConnectRabbitMQ(url string) (*amqp.Connection, *amqp.Channel, error) {
 		return nil, nil, err
 	}
 
+	m.mu.RLock()
+	topology := m.topology
+	m.mu.RUnlock()
+	for _, declare := range topology {
+		if err := declare(ch); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
 	return conn, ch, nil
 }
+
+func (m *ConnectionManager) runConsumer(ctx context.Context, conn *amqp.Connection, spec consumerSpec) {
+	for {
+		ch, err := conn.Channel()
+		if err != nil {
+			if !conn.IsClosed() {
+				log.Printf("[pkg/messaging] Consumer %s could not open a channel: %v", spec.name, err)
+			}
+			return
+		}
+
+		err = spec.run(ch)
+		ch.Close()
+		if ctx.Err() != nil || conn.IsClosed() {
+			return // The connection loop restarts us after reconnecting
+		}
+
+		// Channel-level failure on a live connection: restart just this consumer
+		log.Printf("[pkg/messaging] Consumer %s stopped, restarting: %v", spec.name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(minReconnectDelay):
+		}
+	}
+}
+
+func (m *ConnectionManager) setUp(conn *amqp.Connection, ch *amqp.Channel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.conn != nil {
+		m.health.Reconnects++
+	}
+	m.conn = conn
+	m.ch = ch
+	m.health.Connected = true
+	m.health.Since = &now
+	m.health.LastError = ""
+}
+
+func (m *ConnectionManager) setDown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.health.Connected {
+		now := time.Now()
+		m.health.Since = &now
+	}
+	m.health.Connected = false
+	if err != nil {
+		m.health.LastError = err.Error()
+	}
+}
+
+// nextReconnectDelay doubles delay up to maxReconnectDelay.
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+	return delay
+}
+
+func closeError(err *amqp.Error) error {
+	if err == nil {
+		return ErrNotConnected
+	}
+	return err
+}
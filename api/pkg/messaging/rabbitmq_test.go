@@ -0,0 +1,68 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestConnectionHealth(t *testing.T) {
+	m := NewConnectionManager("amqp://localhost")
+	if m.IsConnected() {
+		t.Fatal("a new manager reports connected")
+	}
+	if _, err := m.OpenChannel(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("OpenChannel before connecting = %v, want ErrNotConnected", err)
+	}
+
+	steps := []struct {
+		name           string
+		apply          func()
+		wantConnected  bool
+		wantReconnects int
+		wantLastError  string
+		wantNewSince   bool
+	}{
+		{"first connect", func() { m.setUp(&amqp.Connection{}, &amqp.Channel{}) }, true, 0, "", true},
+		{"connection lost", func() { m.setDown(errors.New("connection reset")) }, false, 0, "connection reset", true},
+		{"redial failed", func() { m.setDown(errors.New("connection refused")) }, false, 0, "connection refused", false},
+		{"reconnected", func() { m.setUp(&amqp.Connection{}, &amqp.Channel{}) }, true, 1, "", true},
+		{"reconnected again", func() {
+			m.setDown(nil)
+			m.setUp(&amqp.Connection{}, &amqp.Channel{})
+		}, true, 2, "", true},
+	}
+	for _, step := range steps {
+		before := m.Health().Since
+		time.Sleep(time.Millisecond)
+		step.apply()
+
+		h := m.Health()
+		if h.Connected != step.wantConnected || h.Reconnects != step.wantReconnects || h.LastError != step.wantLastError {
+			t.Fatalf("%s: health = %+v, want connected %v, %d reconnects, last error %q",
+				step.name, h, step.wantConnected, step.wantReconnects, step.wantLastError)
+		}
+		// Since marks when the current state began, not the latest event
+		if newSince := !h.Since.Equal(*before); newSince != step.wantNewSince {
+			t.Fatalf("%s: since moved %v, want %v", step.name, newSince, step.wantNewSince)
+		}
+	}
+}
+
+func TestNextReconnectDelay(t *testing.T) {
+	tests := []struct {
+		delay, want time.Duration
+	}{
+		{time.Second, 2 * time.Second},
+		{8 * time.Second, 16 * time.Second},
+		{16 * time.Second, maxReconnectDelay},
+		{maxReconnectDelay, maxReconnectDelay},
+	}
+	for _, tt := range tests {
+		if got := nextReconnectDelay(tt.delay); got != tt.want {
+			t.Errorf("nextReconnectDelay(%v) = %v, want %v", tt.delay, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAuth lets any caller with a valid token through. Handlers read the
+// caller with auth.PrincipalFrom.
+func RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := auth.Authenticate(c); err != nil {
+			return authError(c, err)
+		}
+		return c.Next()
+	}
+}
+
+// RequirePermission lets the request through only if the token grants
+// permission, either in its permissions claim or through its role. A route
+// behind RequireAuth reuses the caller it already authenticated.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := auth.Authenticate(c)
+		if err != nil {
+			return authError(c, err)
+		}
+		if !p.Can(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(utils.Error("Insufficient permissions"))
+		}
+		return c.Next()
+	}
+}
+
+// authError fails closed: a token whose version cannot be checked is not let
+// through either.
+func authError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrMissingToken):
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Missing authorization token"))
+	case errors.Is(err, auth.ErrInvalidToken):
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Invalid token"))
+	case errors.Is(err, auth.ErrTokenRevoked):
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Token revoked"))
+	default:
+		return c.Status(fiber.StatusServiceUnavailable).JSON(utils.Error("Could not verify token"))
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"pushtaka/pkg/auth"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type versionStore struct {
+	version int
+	err     error
+}
+
+func (s versionStore) TokenVersion(ctx context.Context, userID uint) (int, bool, error) {
+	return s.version, s.err == nil, s.err
+}
+
+func TestRequirePermission(t *testing.T) {
+	signer, err := auth.NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.UseKeys(signer)
+	t.Cleanup(func() { auth.UseKeys(nil) })
+
+	token := func(role string, version int) string {
+		tokenString, err := signer.GenerateSessionToken(1, "petugas@contoh.com", role, 3, version, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + tokenString
+	}
+	service, err := signer.ServiceTokenSource("book").Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/loans", RequirePermission(auth.PermLoansRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	// Behind RequireAuth the token is verified once
+	app.Get("/books", RequireAuth(), RequirePermission(auth.PermBooksWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		store  auth.TokenVersionStore
+		want   int
+	}{
+		{"no token", "/loans", "", nil, fiber.StatusUnauthorized},
+		{"not a bearer token", "/loans", "Basic dXNlcjpwYXNz", nil, fiber.StatusUnauthorized},
+		{"invalid token", "/loans", "Bearer not-a-token", nil, fiber.StatusUnauthorized},
+		{"permission granted", "/loans", token(auth.RoleAdmin, 0), nil, fiber.StatusOK},
+		{"service token", "/loans", "Bearer " + service, nil, fiber.StatusOK},
+		{"permission missing", "/loans", token(auth.RoleMember, 0), nil, fiber.StatusForbidden},
+		{"revoked token", "/loans", token(auth.RoleAdmin, 0), versionStore{version: 1}, fiber.StatusUnauthorized},
+		{"version unknown", "/loans", token(auth.RoleAdmin, 0), versionStore{err: errors.New("database unavailable")}, fiber.StatusServiceUnavailable},
+		{"after RequireAuth", "/books", token(auth.RoleCataloguer, 0), nil, fiber.StatusOK},
+		{"after RequireAuth, permission missing", "/books", token(auth.RoleMember, 0), nil, fiber.StatusForbidden},
+		{"after RequireAuth, no token", "/books", "", nil, fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth.UseTokenVersions(tt.store)
+			t.Cleanup(func() { auth.UseTokenVersions(nil) })
+
+			req := httptest.NewRequest(fiber.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
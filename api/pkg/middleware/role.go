@@ -1,83 +0,0 @@
-package middleware
-
-import (
-	"os"
-	"pushtaka/pkg/utils"
-	"strings"
-
-	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
-)
-
-type RoleMiddleware struct {
-	jwtSecret string
-}
-
-func NewRoleMiddleware() *RoleMiddleware {
-	return &RoleMiddleware{
-		jwtSecret: os.Getenv("JWT_SECRET"),
-	}
-}
-
-func (m *RoleMiddleware) RequireRole(requiredRole string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Missing authorization token"))
-		}
-
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(m.jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Invalid token"))
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Invalid token claims"))
-		}
-
-		role, ok := claims["role"].(string)
-		if !ok || role != requiredRole {
-			return c.Status(fiber.StatusForbidden).JSON(utils.Error("Insufficient permissions"))
-		}
-
-		// Store user info in locals if needed
-		c.Locals("user_id", claims["user_id"])
-		c.Locals("role", role)
-
-		return c.Next()
-	}
-}
-
-func (m *RoleMiddleware) RequireAuth() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Missing authorization token"))
-		}
-
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(m.jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Invalid token"))
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Invalid token claims"))
-		}
-
-		// Store user info in locals
-		c.Locals("user_id", claims["user_id"])
-		c.Locals("role", claims["role"])
-
-		return c.Next()
-	}
-}
@@ -1,6 +1,9 @@
 package utils
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 
 
@@ -68,6 +71,10 @@ func GetStatusCode(err error) int {
 	if err == nil {
 		return 200
 	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return 422 // Unprocessable Entity
+	}
 	msg := err.Error()
 	if strings.Contains(msg, "email already exists") || strings.Contains(msg, "23505") || strings.Contains(msg, "user already verified") {
 		return 409 // Conflict
@@ -0,0 +1,46 @@
+package utils
+
+import "strings"
+
+// FieldError is one problem with one field of a request. Code is stable for
+// clients to switch on, Message is for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects the field errors of a request. Handlers answer it
+// with 422 and the list, see Invalid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns e, or nil when nothing was added.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Invalid is the response body for a ValidationError.
+func Invalid(err *ValidationError) Response {
+	return Response{
+		Status:  "error",
+		Message: "validation failed",
+		Data:    map[string]interface{}{"errors": err.Fields},
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/client"
 	"pushtaka/pkg/database"
 	"pushtaka/pkg/messaging"
+	"pushtaka/pkg/messaging/httpapi"
 	"pushtaka/services/book/internal/domain"
 	"pushtaka/services/book/internal/handler"
 	msgConsumer "pushtaka/services/book/internal/messaging"
@@ -14,6 +18,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 func main() {
@@ -32,7 +37,13 @@ func main() {
 	}
 
 	// Auto Migrate
-	db.AutoMigrate(&domain.Book{}, &domain.Favorite{})
+	db.AutoMigrate(&domain.Book{}, &domain.BookCopy{}, &domain.Favorite{}, &messaging.OutboxMessage{}, &messaging.DeadLetter{}, &messaging.ProcessedMessage{})
+
+	// Reject tokens revoked by a password reset, role change or deletion
+	auth.UseTokenVersions(auth.NewTokenVersionCache(db, 10*time.Second))
+
+	// Verify tokens against the identity service's published keys
+	auth.UseKeys(auth.NewJWKSCache(os.Getenv("JWKS_URL"), 10*time.Minute))
 
 	// App
 	app := fiber.New()
@@ -41,30 +52,68 @@ func main() {
 	// Init Layers
 	timeoutContext := time.Duration(2) * time.Second
 	
-	// RabbitMQ Connection
-	conn, ch, err := messaging.ConnectRabbitMQ(os.Getenv("RABBITMQ_URL"))
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
-	}
-	defer conn.Close()
-	defer ch.Close()
+	// RabbitMQ Connection, re-established automatically if the broker restarts
+	mq := messaging.NewConnectionManager(os.Getenv("RABBITMQ_URL"))
+	mq.DeclareTopology(func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(
+			"book_deleted_queue", // name
+			true,                 // durable
+			false,                // delete when unused
+			false,                // exclusive
+			false,                // no-wait
+			nil,                  // arguments
+		)
+		return err
+	})
+	mq.DeclareTopology(messaging.DeclareEventExchange)
 
 	bookRepo := repository.NewPostgresBookRepo(db)
+	copyRepo := repository.NewPostgresCopyRepo(db)
+	if err := copyRepo.BackfillFromStock(context.Background()); err != nil {
+		log.Printf("Failed to backfill book copies: %v", err)
+	}
 	favoriteRepo := repository.NewPostgresFavoriteRepo(db)
 	
-	bookPublisher := msgConsumer.NewBookPublisher(ch)
-	bookUsecase := usecase.NewBookUsecase(bookRepo, bookPublisher, timeoutContext)
+	transactor := database.NewTransactor(db)
+	outbox := messaging.NewOutbox(db, "book")
+	bookPublisher := msgConsumer.NewBookPublisher(outbox)
+	transactionClient := client.NewTransactionClient(
+		os.Getenv("TRANSACTION_SERVICE_URL"),
+		auth.NewRemoteTokenSource(os.Getenv("IDENTITY_SERVICE_URL"), os.Getenv("SERVICE_CLIENT_ID"), os.Getenv("SERVICE_CLIENT_SECRET")),
+	)
+	bookUsecase := usecase.NewBookUsecase(bookRepo, copyRepo, bookPublisher, transactionClient, transactor, timeoutContext)
 	favoriteUsecase := usecase.NewFavoriteUsecase(favoriteRepo, timeoutContext)
+	copyUsecase := usecase.NewCopyUsecase(copyRepo, bookRepo, timeoutContext)
+	deadLetters := messaging.NewDeadLetterStore(db, "book", outbox)
+	ledger := messaging.NewLedger(db)
 
 	// Init Handler
+	httpapi.NewHealthHandler(app, "/books", mq)
 	handler.NewBookHandler(app, bookUsecase)
+	handler.NewCopyHandler(app, copyUsecase)
 	handler.NewFavoriteHandler(app, favoriteUsecase)
+	httpapi.NewDeadLetterHandler(app, "/books", deadLetters)
+
+	// RabbitMQ Consumers
+	mq.Consume("stock_updates", func(ch *amqp.Channel) error {
+		return msgConsumer.StartConsumer(ch, copyRepo, ledger)
+	})
+	mq.Consume("stock_reservations", func(ch *amqp.Channel) error {
+		return msgConsumer.StartReservationConsumer(ch, copyRepo, transactor, outbox, ledger)
+	})
+	mq.Consume("book.user_events", func(ch *amqp.Channel) error {
+		return msgConsumer.StartUserEventsConsumer(ch, favoriteRepo, ledger)
+	})
+
+	// Archive messages that exhausted their retries
+	mq.Consume("dead_letters", func(ch *amqp.Channel) error {
+		return deadLetters.Archive(ch, "stock_updates", "stock_reservations", "book.user_events")
+	})
+
+	mq.Start(context.Background())
 
-	// RabbitMQ Consumer
-	go func() {
-		log.Println("Connected to RabbitMQ, starting consumer...")
-		msgConsumer.StartConsumer(conn, bookRepo)
-	}()
+	// Relay outbox messages to RabbitMQ
+	go messaging.NewOutboxRelay(db, mq, "book").Run(context.Background())
 
 	// Start server
 	log.Fatal(app.Listen(":3000"))
@@ -2,6 +2,8 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"pushtaka/pkg/client"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,6 +26,16 @@ type Book struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+var (
+	ErrOutOfStock         = errors.New("book is out of stock")
+	ErrBookHasObligations = errors.New("book still has copies on loan or unpaid fines")
+)
+
+// ObligationChecker asks the transaction service what is still owed on a book.
+type ObligationChecker interface {
+	BookObligations(ctx context.Context, bookID uint) (*client.Obligations, error)
+}
+
 type BookRepository interface {
 	Fetch(ctx context.Context) ([]Book, error)
 	GetByID(ctx context.Context, id uint) (*Book, error)
@@ -32,7 +44,6 @@ type BookRepository interface {
 	Update(ctx context.Context, book *Book) error
 	Delete(ctx context.Context, id uint) error
 	DeleteBatch(ctx context.Context, ids []uint) error
-	UpdateStock(ctx context.Context, id uint, quantity int) error
 }
 
 type BookUsecase interface {
@@ -45,5 +56,7 @@ type BookUsecase interface {
 }
 
 type BookPublisher interface {
-	PublishBookDeleted(bookID uint) error
+	PublishBookCreated(ctx context.Context, book *Book) error
+	PublishBookUpdated(ctx context.Context, book *Book) error
+	PublishBookDeleted(ctx context.Context, bookID uint) error
 }
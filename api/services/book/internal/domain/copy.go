@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CopyStatus string
+
+const (
+	CopyAvailable CopyStatus = "available"
+	CopyOnLoan    CopyStatus = "on_loan"
+	CopyInRepair  CopyStatus = "in_repair"
+	CopyLost      CopyStatus = "lost"
+	CopyWithdrawn CopyStatus = "withdrawn"
+)
+
+const (
+	ConditionNew  = "new"
+	ConditionGood = "good"
+	ConditionFair = "fair"
+	ConditionPoor = "poor"
+)
+
+var (
+	ErrCopyUnavailable = errors.New("copy is not available")
+	ErrCopyOnLoan      = errors.New("copy is on loan")
+	ErrInvalidCopy     = errors.New("invalid copy status or condition")
+)
+
+// BookCopy is one physical item of a book. A book's Stock is the number of
+// its copies that are available.
+type BookCopy struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	BookID          uint           `gorm:"not null;index:idx_copy_book_status" json:"book_id"`
+	Barcode         string         `gorm:"not null;uniqueIndex" json:"barcode"`
+	AccessionNumber string         `gorm:"index" json:"accession_number"`
+	Branch          string         `json:"branch"`
+	ShelfLocation   string         `json:"shelf_location"`
+	Condition       string         `gorm:"not null;default:'good'" json:"condition"`
+	Status          CopyStatus     `gorm:"type:varchar(32);not null;default:'available';index:idx_copy_book_status" json:"status"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Staff may move a copy between these; on_loan is only set by circulation.
+var ShelfStatuses = []CopyStatus{CopyAvailable, CopyInRepair, CopyLost, CopyWithdrawn}
+
+var Conditions = []string{ConditionNew, ConditionGood, ConditionFair, ConditionPoor}
+
+type CopyRepository interface {
+	FetchByBookID(ctx context.Context, bookID uint) ([]BookCopy, error)
+	GetByID(ctx context.Context, id uint) (*BookCopy, error)
+	GetByBarcode(ctx context.Context, barcode string) (*BookCopy, error)
+	Store(ctx context.Context, bookCopy *BookCopy) error
+	Update(ctx context.Context, bookCopy *BookCopy) error
+	Delete(ctx context.Context, id uint) error
+
+	// Circulation. Each call keeps the book's Stock in step with its copies.
+	Checkout(ctx context.Context, bookID uint, copyID uint) (*BookCopy, error)
+	Checkin(ctx context.Context, bookID uint, copyID uint) (*BookCopy, error)
+	CreateForBook(ctx context.Context, book *Book, n int) error
+	SyncStock(ctx context.Context, bookID uint) error
+	BackfillFromStock(ctx context.Context) error
+}
+
+type CopyUsecase interface {
+	FetchByBookID(ctx context.Context, bookID uint) ([]BookCopy, error)
+	GetByBarcode(ctx context.Context, barcode string) (*BookCopy, error)
+	Store(ctx context.Context, bookCopy *BookCopy) error
+	Update(ctx context.Context, bookCopy *BookCopy) error
+	Delete(ctx context.Context, id uint) error
+}
@@ -21,6 +21,7 @@ type FavoriteRepository interface {
 	FetchByUserID(ctx context.Context, userID uint) ([]Favorite, error)
 	Store(ctx context.Context, favorite *Favorite) error
 	Delete(ctx context.Context, userID uint, bookID uint) error
+	DeleteByUserID(ctx context.Context, userID uint) error
 }
 
 type FavoriteUsecase interface {
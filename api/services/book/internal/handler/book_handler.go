@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+	"pushtaka/pkg/auth"
 	"pushtaka/pkg/middleware"
 	"pushtaka/pkg/utils"
 	"pushtaka/services/book/internal/domain"
@@ -18,15 +20,14 @@ func NewBookHandler(app *fiber.App, bookUsecase domain.BookUsecase) {
 		bookUsecase: bookUsecase,
 	}
 
-	mw := middleware.NewRoleMiddleware()
-	adminOnly := mw.RequireRole("admin")
+	canWrite := middleware.RequirePermission(auth.PermBooksWrite)
 
 	app.Get("/books", handler.Fetch)
 	app.Get("/books/:id", handler.GetByID)
-	app.Post("/books", adminOnly, handler.Store)
-	app.Put("/books/:id", adminOnly, handler.Update)
-	app.Delete("/books/:id", adminOnly, handler.Delete)
-	app.Delete("/books", adminOnly, handler.DeleteBatch)
+	app.Post("/books", canWrite, handler.Store)
+	app.Put("/books/:id", canWrite, handler.Update)
+	app.Delete("/books/:id", canWrite, handler.Delete)
+	app.Delete("/books", canWrite, handler.DeleteBatch)
 }
 
 func (h *BookHandler) Fetch(c *fiber.Ctx) error {
@@ -82,6 +83,9 @@ func (h *BookHandler) Delete(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
 	}
 	if err := h.bookUsecase.Delete(c.Context(), uint(id)); err != nil {
+		if errors.Is(err, domain.ErrBookHasObligations) {
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 	return c.Status(fiber.StatusOK).JSON(utils.Success("book deleted successfully", nil))
@@ -102,6 +106,9 @@ func (h *BookHandler) DeleteBatch(c *fiber.Ctx) error {
 	}
 
 	if err := h.bookUsecase.DeleteBatch(c.Context(), req.IDs); err != nil {
+		if errors.Is(err, domain.ErrBookHasObligations) {
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/middleware"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/book/internal/domain"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CopyHandler struct {
+	copyUsecase domain.CopyUsecase
+}
+
+func NewCopyHandler(app *fiber.App, copyUsecase domain.CopyUsecase) {
+	handler := &CopyHandler{
+		copyUsecase: copyUsecase,
+	}
+
+	canWrite := middleware.RequirePermission(auth.PermBooksWrite)
+
+	app.Get("/books/:id/copies", handler.FetchByBookID)
+	app.Get("/books/copies/barcode/:barcode", handler.GetByBarcode)
+	app.Post("/books/:id/copies", canWrite, handler.Store)
+	app.Put("/books/copies/:copyId", canWrite, handler.Update)
+	app.Delete("/books/copies/:copyId", canWrite, handler.Delete)
+}
+
+func (h *CopyHandler) FetchByBookID(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	copies, err := h.copyUsecase.FetchByBookID(c.Context(), uint(id))
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("copy list retrieved", copies))
+}
+
+func (h *CopyHandler) GetByBarcode(c *fiber.Ctx) error {
+	bookCopy, err := h.copyUsecase.GetByBarcode(c.Context(), c.Params("barcode"))
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+	return c.JSON(utils.Success("copy retrieved successfully", bookCopy))
+}
+
+func (h *CopyHandler) Store(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	var bookCopy domain.BookCopy
+	if err := c.BodyParser(&bookCopy); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(utils.ParseError(err)))
+	}
+	bookCopy.BookID = uint(id)
+	if err := h.copyUsecase.Store(c.Context(), &bookCopy); err != nil {
+		return copyError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(utils.Success("copy created successfully", bookCopy))
+}
+
+func (h *CopyHandler) Update(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("copyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	var bookCopy domain.BookCopy
+	if err := c.BodyParser(&bookCopy); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(utils.ParseError(err)))
+	}
+	bookCopy.ID = uint(id)
+	if err := h.copyUsecase.Update(c.Context(), &bookCopy); err != nil {
+		return copyError(c, err)
+	}
+	return c.JSON(utils.Success("copy updated successfully", bookCopy))
+}
+
+func (h *CopyHandler) Delete(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("copyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid id"))
+	}
+	if err := h.copyUsecase.Delete(c.Context(), uint(id)); err != nil {
+		return copyError(c, err)
+	}
+	return c.JSON(utils.Success("copy deleted successfully", nil))
+}
+
+func copyError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, domain.ErrCopyOnLoan) {
+		return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+	}
+	return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+}
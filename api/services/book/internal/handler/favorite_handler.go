@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"pushtaka/pkg/auth"
 	"pushtaka/pkg/middleware"
 	"pushtaka/pkg/utils"
 	"pushtaka/services/book/internal/domain"
@@ -18,21 +19,20 @@ func NewFavoriteHandler(app *fiber.App, favoriteUsecase domain.FavoriteUsecase)
 		favoriteUsecase: favoriteUsecase,
 	}
 
-	mw := middleware.NewRoleMiddleware()
-	auth := mw.RequireAuth()
+	requireAuth := middleware.RequireAuth()
 
-	app.Get("/favorites", auth, handler.FetchByUserID)
-	app.Post("/favorites/:book_id", auth, handler.Store)
-	app.Delete("/favorites/:book_id", auth, handler.Delete)
+	app.Get("/favorites", requireAuth, handler.FetchByUserID)
+	app.Post("/favorites/:book_id", requireAuth, handler.Store)
+	app.Delete("/favorites/:book_id", requireAuth, handler.Delete)
 }
 
 func (h *FavoriteHandler) FetchByUserID(c *fiber.Ctx) error {
-	userID, ok := c.Locals("user_id").(float64)
+	caller, ok := auth.CurrentUser(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("invalid user id"))
 	}
 
-	books, err := h.favoriteUsecase.FetchByUserID(c.Context(), uint(userID))
+	books, err := h.favoriteUsecase.FetchByUserID(c.Context(), caller.UserID)
 	if err != nil {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
@@ -49,12 +49,12 @@ func (h *FavoriteHandler) Store(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
 	}
 
-	userID, ok := c.Locals("user_id").(float64)
+	caller, ok := auth.CurrentUser(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("invalid user id"))
 	}
 
-	if err := h.favoriteUsecase.Store(c.Context(), uint(userID), uint(bookID)); err != nil {
+	if err := h.favoriteUsecase.Store(c.Context(), caller.UserID, uint(bookID)); err != nil {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 	return c.Status(fiber.StatusCreated).JSON(utils.Success("book added to favorites", nil))
@@ -66,12 +66,12 @@ func (h *FavoriteHandler) Delete(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
 	}
 
-	userID, ok := c.Locals("user_id").(float64)
+	caller, ok := auth.CurrentUser(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("invalid user id"))
 	}
 
-	if err := h.favoriteUsecase.Delete(c.Context(), uint(userID), uint(bookID)); err != nil {
+	if err := h.favoriteUsecase.Delete(c.Context(), caller.UserID, uint(bookID)); err != nil {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 	return c.Status(fiber.StatusOK).JSON(utils.Success("book removed from favorites", nil))
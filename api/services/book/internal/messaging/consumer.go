@@ -2,73 +2,140 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"pushtaka/pkg/database"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
 	"pushtaka/services/book/internal/domain"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
 )
 
-type StockUpdateMessage struct {
-	BookID   uint   `json:"book_id"`
-	Action   string `json:"action"` // "borrow" or "return"
-	Quantity int    `json:"quantity"`
+// StartConsumer applies stock updates from the transaction service. Each
+// message is applied once, even when it is delivered again. It blocks until
+// ch is closed.
+func StartConsumer(ch *amqp.Channel, copyRepo domain.CopyRepository, ledger *pkgMessaging.Ledger) error {
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, "stock_updates", pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
+	}
+
+	return pkgMessaging.Consume(ch, "stock_updates", pkgMessaging.DefaultRetryPolicy, ledger.Wrap("book:stock_updates", func(ctx context.Context, d amqp.Delivery) error {
+		msg, _, err := events.Decode[events.StockUpdated](d.Body)
+		if err != nil {
+			return pkgMessaging.Permanent(fmt.Errorf("error decoding message: %v", err))
+		}
+
+		log.Printf("Received stock update: %+v", msg)
+
+		return applyStockUpdate(ctx, copyRepo, msg)
+	}))
 }
 
-func StartConsumer(conn *amqp.Connection, bookRepo domain.BookRepository) {
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer ch.Close()
-
-	q, err := ch.QueueDeclare(
-		"stock_updates", // name
-		true,           // durable
-		false,           // delete when unused
-		false,           // exclusive
-		false,           // no-wait
-		nil,             // arguments
-	)
-	if err != nil {
-		log.Fatal(err)
+// StartReservationConsumer answers stock reservation requests from the
+// transaction service, refusing them when no copy is in stock. The reply goes
+// through the outbox in the same transaction as the stock change.
+func StartReservationConsumer(ch *amqp.Channel, copyRepo domain.CopyRepository, transactor database.Transactor, outbox *pkgMessaging.Outbox, ledger *pkgMessaging.Ledger) error {
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, "stock_reservations", pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
 	}
 
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return pkgMessaging.Consume(ch, "stock_reservations", pkgMessaging.DefaultRetryPolicy, ledger.Wrap("book:stock_reservations", func(ctx context.Context, d amqp.Delivery) error {
+		req, _, err := events.Decode[events.StockReservationRequested](d.Body)
+		if err != nil {
+			return pkgMessaging.Permanent(fmt.Errorf("error decoding message: %v", err))
+		}
 
-	forever := make(chan bool)
+		log.Printf("Received stock reservation: %+v", req)
 
-	go func() {
-		for d := range msgs {
-			var msg StockUpdateMessage
-			if err := json.Unmarshal(d.Body, &msg); err != nil {
-				log.Printf("Error decoding message: %v", err)
-				continue
+		return transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			result, err := reserveCopy(ctx, copyRepo, req)
+			if err != nil {
+				return err
 			}
 
-			log.Printf("Received stock update: %+v", msg)
-
-			// Update Stock
-			// If borrow, quantity is -1. If return, +1.
-			// Transaction service sends correct quantity (-1 or 1).
-			
-			err := bookRepo.UpdateStock(context.Background(), msg.BookID, msg.Quantity)
-			if err != nil {
-				log.Printf("Error updating stock: %v", err)
+			if d.ReplyTo == "" {
+				return nil
 			}
+			return outbox.EnqueueWithReply(ctx, d.ReplyTo, "", d.CorrelationId, result)
+		})
+	}))
+}
+
+// StartUserEventsConsumer removes a deleted member's favorites. It listens on
+// its own queue bound to user.deleted on the events exchange.
+func StartUserEventsConsumer(ch *amqp.Channel, favoriteRepo domain.FavoriteRepository, ledger *pkgMessaging.Ledger) error {
+	const queue = "book.user_events"
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, queue, pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
+	}
+	if err := pkgMessaging.BindEvents(ch, queue, events.TypeUserDeleted); err != nil {
+		return err
+	}
+
+	return pkgMessaging.Consume(ch, queue, pkgMessaging.DefaultRetryPolicy, ledger.Wrap("book:"+queue, func(ctx context.Context, d amqp.Delivery) error {
+		event, _, err := events.Decode[events.UserDeleted](d.Body)
+		if err != nil {
+			return pkgMessaging.Permanent(fmt.Errorf("error decoding message: %v", err))
 		}
-	}()
+		if event.UserID == 0 {
+			return pkgMessaging.Permanent(errors.New("invalid event format: missing user_id"))
+		}
+
+		log.Printf("Received user deleted: %+v", event)
+		return favoriteRepo.DeleteByUserID(ctx, event.UserID)
+	}))
+}
 
-	<-forever
+// applyStockUpdate moves copies on or off the shelf; stock follows the
+// copies. A borrow has a negative quantity, a return a positive one.
+func applyStockUpdate(ctx context.Context, copyRepo domain.CopyRepository, msg events.StockUpdated) error {
+	var err error
+	for i := 0; i < abs(msg.Quantity); i++ {
+		if msg.Quantity < 0 {
+			_, err = copyRepo.Checkout(ctx, msg.BookID, msg.CopyID)
+		} else {
+			_, err = copyRepo.Checkin(ctx, msg.BookID, msg.CopyID)
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, domain.ErrOutOfStock) || errors.Is(err, domain.ErrCopyUnavailable) {
+			return pkgMessaging.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveCopy checks out a copy for a loan and builds the answer. Running
+// out of copies is a refusal, not an error.
+func reserveCopy(ctx context.Context, copyRepo domain.CopyRepository, req events.StockReservationRequested) (events.StockReservationCompleted, error) {
+	result := events.StockReservationCompleted{LoanID: req.LoanID, BookID: req.BookID, Approved: true}
+	bookCopy, err := copyRepo.Checkout(ctx, req.BookID, req.CopyID)
+	if err == nil {
+		result.CopyID = bookCopy.ID
+		result.Barcode = bookCopy.Barcode
+		return result, nil
+	}
+
+	result.Approved = false
+	if errors.Is(err, domain.ErrOutOfStock) {
+		result.Reason = "book is out of stock"
+	} else if errors.Is(err, domain.ErrCopyUnavailable) {
+		result.Reason = "copy is not available"
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		result.Reason = "book not available"
+	} else {
+		return result, err
+	}
+	return result, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
@@ -0,0 +1,101 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
+	"pushtaka/services/book/internal/domain"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+const (
+	available = domain.CopyAvailable
+	onLoan    = domain.CopyOnLoan
+)
+
+// newTestCopies returns book 1 with a copy on the shelf, one on loan and
+// one in repair.
+func newTestCopies() *fakeCopyRepo {
+	return &fakeCopyRepo{bookID: 1, copies: []domain.BookCopy{
+		{ID: 1, BookID: 1, Barcode: "BK-1-001", Status: available},
+		{ID: 2, BookID: 1, Barcode: "BK-1-002", Status: onLoan},
+		{ID: 3, BookID: 1, Barcode: "BK-1-003", Status: domain.CopyInRepair},
+	}}
+}
+
+func TestApplyStockUpdate(t *testing.T) {
+	tests := []struct {
+		name          string
+		msg           events.StockUpdated
+		wantErr       error
+		wantPermanent bool
+		want          []domain.CopyStatus
+	}{
+		{"borrow any copy", events.StockUpdated{BookID: 1, Action: "borrow", Quantity: -1}, nil, false,
+			[]domain.CopyStatus{onLoan, onLoan, domain.CopyInRepair}},
+		{"return a named copy", events.StockUpdated{BookID: 1, CopyID: 2, Action: "return", Quantity: 1}, nil, false,
+			[]domain.CopyStatus{available, available, domain.CopyInRepair}},
+		{"borrow a copy in repair", events.StockUpdated{BookID: 1, CopyID: 3, Action: "borrow", Quantity: -1}, domain.ErrCopyUnavailable, true,
+			[]domain.CopyStatus{available, onLoan, domain.CopyInRepair}},
+		{"borrow past the last copy", events.StockUpdated{BookID: 1, Action: "borrow", Quantity: -2}, domain.ErrOutOfStock, true,
+			[]domain.CopyStatus{onLoan, onLoan, domain.CopyInRepair}},
+		{"unknown book", events.StockUpdated{BookID: 9, Action: "return", Quantity: 1}, gorm.ErrRecordNotFound, true,
+			[]domain.CopyStatus{available, onLoan, domain.CopyInRepair}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copies := newTestCopies()
+
+			err := applyStockUpdate(context.Background(), copies, tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if pkgMessaging.IsPermanent(err) != tt.wantPermanent {
+				t.Fatalf("permanent = %v, want %v", pkgMessaging.IsPermanent(err), tt.wantPermanent)
+			}
+			if got := copies.statuses(); !slices.Equal(got, tt.want) {
+				t.Fatalf("copies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		req  events.StockReservationRequested
+		want events.StockReservationCompleted
+	}{
+		{"any copy", events.StockReservationRequested{LoanID: 5, BookID: 1, Quantity: 1},
+			events.StockReservationCompleted{LoanID: 5, BookID: 1, CopyID: 1, Barcode: "BK-1-001", Approved: true}},
+		{"named copy", events.StockReservationRequested{LoanID: 5, BookID: 1, CopyID: 1, Quantity: 1},
+			events.StockReservationCompleted{LoanID: 5, BookID: 1, CopyID: 1, Barcode: "BK-1-001", Approved: true}},
+		{"named copy already lent", events.StockReservationRequested{LoanID: 5, BookID: 1, CopyID: 2, Quantity: 1},
+			events.StockReservationCompleted{LoanID: 5, BookID: 1, Reason: "copy is not available"}},
+		{"unknown book", events.StockReservationRequested{LoanID: 5, BookID: 9, Quantity: 1},
+			events.StockReservationCompleted{LoanID: 5, BookID: 9, Reason: "book not available"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reserveCopy(context.Background(), newTestCopies(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// With every copy gone the request is refused, not retried
+	copies := newTestCopies()
+	copies.copies[0].Status = onLoan
+	got, err := reserveCopy(context.Background(), copies, events.StockReservationRequested{LoanID: 5, BookID: 1, Quantity: 1})
+	if err != nil || got.Approved || got.Reason != "book is out of stock" {
+		t.Fatalf("result = %+v, %v; want refused as out of stock", got, err)
+	}
+}
@@ -0,0 +1,55 @@
+package messaging
+
+import (
+	"context"
+	"pushtaka/services/book/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+// fakeCopyRepo circulates copies the way the real repository does: checkout
+// takes the named copy or the first available one, checkin puts it back.
+type fakeCopyRepo struct {
+	domain.CopyRepository
+	bookID uint
+	copies []domain.BookCopy
+}
+
+func (r *fakeCopyRepo) Checkout(ctx context.Context, bookID uint, copyID uint) (*domain.BookCopy, error) {
+	if bookID != r.bookID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	for i := range r.copies {
+		c := &r.copies[i]
+		if c.Status == domain.CopyAvailable && (copyID == 0 || c.ID == copyID) {
+			c.Status = domain.CopyOnLoan
+			return c, nil
+		}
+	}
+	if copyID != 0 {
+		return nil, domain.ErrCopyUnavailable
+	}
+	return nil, domain.ErrOutOfStock
+}
+
+func (r *fakeCopyRepo) Checkin(ctx context.Context, bookID uint, copyID uint) (*domain.BookCopy, error) {
+	if bookID != r.bookID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	for i := range r.copies {
+		c := &r.copies[i]
+		if c.Status == domain.CopyOnLoan && (copyID == 0 || c.ID == copyID) {
+			c.Status = domain.CopyAvailable
+			return c, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeCopyRepo) statuses() []domain.CopyStatus {
+	var statuses []domain.CopyStatus
+	for _, c := range r.copies {
+		statuses = append(statuses, c.Status)
+	}
+	return statuses
+}
@@ -1,54 +1,57 @@
 package messaging
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-
-	amqp "github.com/rabbitmq/amqp091-go"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
+	"pushtaka/services/book/internal/domain"
 )
 
 type bookPublisher struct {
-	ch *amqp.Channel
+	outbox *pkgMessaging.Outbox
 }
 
-func NewBookPublisher(ch *amqp.Channel) *bookPublisher {
-	return &bookPublisher{ch: ch}
+func NewBookPublisher(outbox *pkgMessaging.Outbox) *bookPublisher {
+	return &bookPublisher{outbox: outbox}
 }
 
-func (p *bookPublisher) PublishBookDeleted(bookID uint) error {
-	q, err := p.ch.QueueDeclare(
-		"book_deleted_queue", // name
-		true,                 // durable
-		false,                // delete when unused
-		false,                // exclusive
-		false,                // no-wait
-		nil,                  // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare queue: %v", err)
+// PublishBookCreated broadcasts book.created on the events exchange once the
+// transaction on ctx commits.
+func (p *bookPublisher) PublishBookCreated(ctx context.Context, book *domain.Book) error {
+	if err := p.outbox.Publish(ctx, events.BookCreated{BookSnapshot: snapshot(book)}); err != nil {
+		return fmt.Errorf("failed to queue event: %v", err)
 	}
+	return nil
+}
 
-	body, err := json.Marshal(map[string]uint{
-		"book_id": bookID,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %v", err)
+func (p *bookPublisher) PublishBookUpdated(ctx context.Context, book *domain.Book) error {
+	if err := p.outbox.Publish(ctx, events.BookUpdated{BookSnapshot: snapshot(book)}); err != nil {
+		return fmt.Errorf("failed to queue event: %v", err)
 	}
+	return nil
+}
 
-	err = p.ch.Publish(
-		"",     // exchange
-		q.Name, // routing key
-		false,  // mandatory
-		false,  // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
+// PublishBookDeleted queues a BookDeleted event in the outbox. It joins the
+// transaction on ctx, so the event is only sent if the delete commits.
+func (p *bookPublisher) PublishBookDeleted(ctx context.Context, bookID uint) error {
+	err := p.outbox.Enqueue(ctx, "book_deleted_queue", events.BookDeleted{BookID: bookID})
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %v", err)
+		return fmt.Errorf("failed to queue event: %v", err)
 	}
 
-	log.Printf("Published BookDeleted event for book_id: %d", bookID)
+	log.Printf("Queued BookDeleted event for book_id: %d", bookID)
 	return nil
 }
+
+func snapshot(book *domain.Book) events.BookSnapshot {
+	return events.BookSnapshot{
+		BookID: book.ID,
+		Title:  book.Title,
+		Author: book.Author,
+		Code:   book.Code,
+		ISBN:   book.ISBN,
+		Stock:  book.Stock,
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"pushtaka/pkg/database"
 	"pushtaka/services/book/internal/domain"
 
 	"gorm.io/gorm"
@@ -17,13 +18,13 @@ func NewPostgresBookRepo(db *gorm.DB) domain.BookRepository {
 
 func (p *postgresBookRepo) Fetch(ctx context.Context) ([]domain.Book, error) {
 	var books []domain.Book
-	err := p.db.WithContext(ctx).Find(&books).Error
+	err := database.Conn(ctx, p.db).Find(&books).Error
 	return books, err
 }
 
 func (p *postgresBookRepo) GetByID(ctx context.Context, id uint) (*domain.Book, error) {
 	var book domain.Book
-	err := p.db.WithContext(ctx).First(&book, id).Error
+	err := database.Conn(ctx, p.db).First(&book, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +33,7 @@ func (p *postgresBookRepo) GetByID(ctx context.Context, id uint) (*domain.Book,
 
 func (p *postgresBookRepo) GetBySlug(ctx context.Context, slug string) (*domain.Book, error) {
 	var book domain.Book
-	err := p.db.WithContext(ctx).Unscoped().Where("slug = ?", slug).First(&book).Error
+	err := database.Conn(ctx, p.db).Unscoped().Where("slug = ?", slug).First(&book).Error
 	if err != nil {
 		return nil, err
 	}
@@ -40,11 +41,12 @@ func (p *postgresBookRepo) GetBySlug(ctx context.Context, slug string) (*domain.
 }
 
 func (p *postgresBookRepo) Store(ctx context.Context, book *domain.Book) error {
-	return p.db.WithContext(ctx).Create(book).Error
+	return database.Conn(ctx, p.db).Create(book).Error
 }
 
 func (p *postgresBookRepo) Update(ctx context.Context, book *domain.Book) error {
-	result := p.db.WithContext(ctx).Model(book).Updates(book)
+	// Stock is derived from the book's copies and never written directly
+	result := database.Conn(ctx, p.db).Model(book).Omit("stock").Updates(book)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -55,7 +57,7 @@ func (p *postgresBookRepo) Update(ctx context.Context, book *domain.Book) error
 }
 
 func (p *postgresBookRepo) Delete(ctx context.Context, id uint) error {
-	result := p.db.WithContext(ctx).Delete(&domain.Book{}, id)
+	result := database.Conn(ctx, p.db).Delete(&domain.Book{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -66,7 +68,7 @@ func (p *postgresBookRepo) Delete(ctx context.Context, id uint) error {
 }
 
 func (p *postgresBookRepo) DeleteBatch(ctx context.Context, ids []uint) error {
-	result := p.db.WithContext(ctx).Where("id IN ?", ids).Delete(&domain.Book{})
+	result := database.Conn(ctx, p.db).Where("id IN ?", ids).Delete(&domain.Book{})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -75,7 +77,3 @@ func (p *postgresBookRepo) DeleteBatch(ctx context.Context, ids []uint) error {
 	}
 	return nil
 }
-
-func (p *postgresBookRepo) UpdateStock(ctx context.Context, id uint, quantity int) error {
-	return p.db.WithContext(ctx).Model(&domain.Book{}).Where("id = ?", id).UpdateColumn("stock", gorm.Expr("stock + ?", quantity)).Error
-}
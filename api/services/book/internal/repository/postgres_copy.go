@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pushtaka/pkg/database"
+	"pushtaka/services/book/internal/domain"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresCopyRepo struct {
+	db *gorm.DB
+}
+
+func NewPostgresCopyRepo(db *gorm.DB) domain.CopyRepository {
+	return &postgresCopyRepo{db}
+}
+
+func (p *postgresCopyRepo) FetchByBookID(ctx context.Context, bookID uint) ([]domain.BookCopy, error) {
+	var copies []domain.BookCopy
+	err := database.Conn(ctx, p.db).Where("book_id = ?", bookID).Order("id").Find(&copies).Error
+	return copies, err
+}
+
+func (p *postgresCopyRepo) GetByID(ctx context.Context, id uint) (*domain.BookCopy, error) {
+	var bookCopy domain.BookCopy
+	if err := database.Conn(ctx, p.db).First(&bookCopy, id).Error; err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+func (p *postgresCopyRepo) GetByBarcode(ctx context.Context, barcode string) (*domain.BookCopy, error) {
+	var bookCopy domain.BookCopy
+	if err := database.Conn(ctx, p.db).Where("barcode = ?", barcode).First(&bookCopy).Error; err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// Store adds a copy. A missing barcode is generated from the book code and
+// also used as the accession number.
+func (p *postgresCopyRepo) Store(ctx context.Context, bookCopy *domain.BookCopy) error {
+	if bookCopy.Barcode == "" {
+		barcode, err := p.nextBarcode(ctx, bookCopy.BookID)
+		if err != nil {
+			return err
+		}
+		bookCopy.Barcode = barcode
+	}
+	if bookCopy.AccessionNumber == "" {
+		bookCopy.AccessionNumber = bookCopy.Barcode
+	}
+	if err := database.Conn(ctx, p.db).Create(bookCopy).Error; err != nil {
+		return err
+	}
+	return p.SyncStock(ctx, bookCopy.BookID)
+}
+
+func (p *postgresCopyRepo) Update(ctx context.Context, bookCopy *domain.BookCopy) error {
+	if err := database.Conn(ctx, p.db).Save(bookCopy).Error; err != nil {
+		return err
+	}
+	return p.SyncStock(ctx, bookCopy.BookID)
+}
+
+func (p *postgresCopyRepo) Delete(ctx context.Context, id uint) error {
+	bookCopy, err := p.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := database.Conn(ctx, p.db).Delete(bookCopy).Error; err != nil {
+		return err
+	}
+	return p.SyncStock(ctx, bookCopy.BookID)
+}
+
+// Checkout marks a copy of the book as on loan. With copyID 0 any available
+// copy is taken; rows are locked so concurrent checkouts never share a copy.
+func (p *postgresCopyRepo) Checkout(ctx context.Context, bookID uint, copyID uint) (*domain.BookCopy, error) {
+	if _, err := p.getBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	query := database.Conn(ctx, p.db).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("book_id = ? AND status = ?", bookID, domain.CopyAvailable)
+	if copyID != 0 {
+		query = query.Where("id = ?", copyID)
+	}
+
+	var bookCopy domain.BookCopy
+	err := query.Order("id").First(&bookCopy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if copyID != 0 {
+			return nil, domain.ErrCopyUnavailable
+		}
+		return nil, domain.ErrOutOfStock
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bookCopy.Status = domain.CopyOnLoan
+	if err := p.Update(ctx, &bookCopy); err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// Checkin puts a copy back on the shelf. Loans made before copies existed
+// carry no copy ID: one of the book's on-loan copies is used, or a new copy
+// is added when there is none, so stock grows back as it used to.
+func (p *postgresCopyRepo) Checkin(ctx context.Context, bookID uint, copyID uint) (*domain.BookCopy, error) {
+	if _, err := p.getBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	query := database.Conn(ctx, p.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("book_id = ? AND status = ?", bookID, domain.CopyOnLoan)
+	if copyID != 0 {
+		query = query.Where("id = ?", copyID)
+	}
+
+	var bookCopy domain.BookCopy
+	err := query.Order("id").First(&bookCopy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if copyID != 0 {
+			// Already back, e.g. a redelivered return
+			return p.GetByID(ctx, copyID)
+		}
+		return p.addCopy(ctx, bookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bookCopy.Status = domain.CopyAvailable
+	if err := p.Update(ctx, &bookCopy); err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// SyncStock recomputes the book's Stock from its available copies.
+func (p *postgresCopyRepo) SyncStock(ctx context.Context, bookID uint) error {
+	available := database.Conn(ctx, p.db).Model(&domain.BookCopy{}).
+		Select("count(*)").
+		Where("book_id = ? AND status = ?", bookID, domain.CopyAvailable)
+	return database.Conn(ctx, p.db).Model(&domain.Book{}).
+		Where("id = ?", bookID).
+		UpdateColumn("stock", available).Error
+}
+
+// BackfillFromStock gives books that predate copies one available copy per
+// unit of stock.
+func (p *postgresCopyRepo) BackfillFromStock(ctx context.Context) error {
+	var books []domain.Book
+	err := database.Conn(ctx, p.db).
+		Where("stock > 0 AND NOT EXISTS (?)", database.Conn(ctx, p.db).Model(&domain.BookCopy{}).Unscoped().Select("1").Where("book_copies.book_id = books.id")).
+		Find(&books).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range books {
+		if err := p.CreateForBook(ctx, &books[i], books[i].Stock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateForBook adds n available copies with generated barcodes.
+func (p *postgresCopyRepo) CreateForBook(ctx context.Context, book *domain.Book, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := p.addCopy(ctx, book.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *postgresCopyRepo) addCopy(ctx context.Context, bookID uint) (*domain.BookCopy, error) {
+	bookCopy := &domain.BookCopy{
+		BookID:    bookID,
+		Condition: domain.ConditionGood,
+		Status:    domain.CopyAvailable,
+	}
+	if err := p.Store(ctx, bookCopy); err != nil {
+		return nil, err
+	}
+	return bookCopy, nil
+}
+
+// nextBarcode numbers copies per book, e.g. BK-1700000000-003.
+func (p *postgresCopyRepo) nextBarcode(ctx context.Context, bookID uint) (string, error) {
+	book, err := p.getBook(ctx, bookID)
+	if err != nil {
+		return "", err
+	}
+
+	var count int64
+	if err := database.Conn(ctx, p.db).Model(&domain.BookCopy{}).Unscoped().Where("book_id = ?", bookID).Count(&count).Error; err != nil {
+		return "", err
+	}
+
+	prefix := book.Code
+	if prefix == "" {
+		prefix = fmt.Sprintf("BK%d", book.ID)
+	}
+	return fmt.Sprintf("%s-%03d", prefix, count+1), nil
+}
+
+func (p *postgresCopyRepo) getBook(ctx context.Context, id uint) (*domain.Book, error) {
+	var book domain.Book
+	if err := database.Conn(ctx, p.db).First(&book, id).Error; err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
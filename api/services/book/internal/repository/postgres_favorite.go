@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"pushtaka/pkg/database"
 	"pushtaka/services/book/internal/domain"
 
 	"gorm.io/gorm"
@@ -17,19 +18,19 @@ func NewPostgresFavoriteRepo(db *gorm.DB) domain.FavoriteRepository {
 
 func (p *postgresFavoriteRepo) FetchByUserID(ctx context.Context, userID uint) ([]domain.Favorite, error) {
 	var favorites []domain.Favorite
-	err := p.db.WithContext(ctx).Preload("Book").Where("user_id = ?", userID).Find(&favorites).Error
+	err := database.Conn(ctx, p.db).Preload("Book").Where("user_id = ?", userID).Find(&favorites).Error
 	return favorites, err
 }
 
 func (p *postgresFavoriteRepo) Store(ctx context.Context, favorite *domain.Favorite) error {
 	var existing domain.Favorite
-	err := p.db.WithContext(ctx).Unscoped().Where("user_id = ? AND book_id = ?", favorite.UserID, favorite.BookID).First(&existing).Error
+	err := database.Conn(ctx, p.db).Unscoped().Where("user_id = ? AND book_id = ?", favorite.UserID, favorite.BookID).First(&existing).Error
 	
 	if err == nil {
 		// Record exists (either active or soft-deleted)
 		if existing.DeletedAt.Valid {
 			// Restore soft-deleted record
-			return p.db.WithContext(ctx).Model(&existing).Unscoped().Update("deleted_at", nil).Error
+			return database.Conn(ctx, p.db).Model(&existing).Unscoped().Update("deleted_at", nil).Error
 		}
 		// Already active, nothing to do
 		return nil
@@ -40,11 +41,11 @@ func (p *postgresFavoriteRepo) Store(ctx context.Context, favorite *domain.Favor
 	}
 
 	// No record exists, create new one
-	return p.db.WithContext(ctx).Create(favorite).Error
+	return database.Conn(ctx, p.db).Create(favorite).Error
 }
 
 func (p *postgresFavoriteRepo) Delete(ctx context.Context, userID uint, bookID uint) error {
-	result := p.db.WithContext(ctx).Where("user_id = ? AND book_id = ?", userID, bookID).Delete(&domain.Favorite{})
+	result := database.Conn(ctx, p.db).Where("user_id = ? AND book_id = ?", userID, bookID).Delete(&domain.Favorite{})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -53,3 +54,8 @@ func (p *postgresFavoriteRepo) Delete(ctx context.Context, userID uint, bookID u
 	}
 	return nil
 }
+
+// DeleteByUserID soft-deletes all of a member's favorites.
+func (p *postgresFavoriteRepo) DeleteByUserID(ctx context.Context, userID uint) error {
+	return database.Conn(ctx, p.db).Where("user_id = ?", userID).Delete(&domain.Favorite{}).Error
+}
@@ -3,6 +3,7 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"pushtaka/pkg/database"
 	"pushtaka/services/book/internal/domain"
 	"regexp"
 	"strings"
@@ -11,14 +12,20 @@ import (
 
 type bookUsecase struct {
 	bookRepo       domain.BookRepository
+	copyRepo       domain.CopyRepository
 	publisher      domain.BookPublisher
+	obligations    domain.ObligationChecker
+	transactor     database.Transactor
 	contextTimeout time.Duration
 }
 
-func NewBookUsecase(bookRepo domain.BookRepository, publisher domain.BookPublisher, timeout time.Duration) domain.BookUsecase {
+func NewBookUsecase(bookRepo domain.BookRepository, copyRepo domain.CopyRepository, publisher domain.BookPublisher, obligations domain.ObligationChecker, transactor database.Transactor, timeout time.Duration) domain.BookUsecase {
 	return &bookUsecase{
 		bookRepo:       bookRepo,
+		copyRepo:       copyRepo,
 		publisher:      publisher,
+		obligations:    obligations,
+		transactor:     transactor,
 		contextTimeout: timeout,
 	}
 }
@@ -51,7 +58,20 @@ func (a *bookUsecase) Store(c context.Context, book *domain.Book) error {
 		book.Code = fmt.Sprintf("BK-%d", time.Now().UnixNano())
 	}
 
-	return a.bookRepo.Store(ctx, book)
+	// Stock on create is the number of copies to add; from then on it is
+	// derived from the copies
+	copies := book.Stock
+	book.Stock = 0
+	return a.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := a.bookRepo.Store(ctx, book); err != nil {
+			return err
+		}
+		if err := a.copyRepo.CreateForBook(ctx, book, copies); err != nil {
+			return err
+		}
+		book.Stock = copies
+		return a.publisher.PublishBookCreated(ctx, book)
+	})
 }
 
 func (a *bookUsecase) Update(c context.Context, book *domain.Book) error {
@@ -75,7 +95,17 @@ func (a *bookUsecase) Update(c context.Context, book *domain.Book) error {
 
 	book.Slug = a.ensureUniqueSlug(ctx, book.Slug, book.ID)
 
-	return a.bookRepo.Update(ctx, book)
+	return a.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := a.bookRepo.Update(ctx, book); err != nil {
+			return err
+		}
+		updated, err := a.bookRepo.GetByID(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		*book = *updated
+		return a.publisher.PublishBookUpdated(ctx, book)
+	})
 }
 
 func generateSlug(title string) string {
@@ -117,19 +147,53 @@ func (a *bookUsecase) ensureUniqueSlug(ctx context.Context, slug string, exclude
 func (a *bookUsecase) Delete(c context.Context, id uint) error {
 	ctx, cancel := context.WithTimeout(c, a.contextTimeout)
 	defer cancel()
-	err := a.bookRepo.Delete(ctx, id)
-	if err == nil {
-		// Publish event
-		if pubErr := a.publisher.PublishBookDeleted(id); pubErr != nil {
-			// Log error but don't fail the request as the action itself succeeded
-			fmt.Printf("Failed to publish book deleted event: %v\n", pubErr)
-		}
+
+	if err := a.ensureDeletable(ctx, id); err != nil {
+		return err
 	}
-	return err
+
+	// Delete and queue the event together so neither happens without the other
+	return a.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := a.bookRepo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return a.publisher.PublishBookDeleted(ctx, id)
+	})
 }
 
 func (a *bookUsecase) DeleteBatch(c context.Context, ids []uint) error {
 	ctx, cancel := context.WithTimeout(c, a.contextTimeout)
 	defer cancel()
-	return a.bookRepo.DeleteBatch(ctx, ids)
+
+	// All or nothing: refuse the batch if any book is still on loan or owed for
+	for _, id := range ids {
+		if err := a.ensureDeletable(ctx, id); err != nil {
+			return fmt.Errorf("book %d: %w", id, err)
+		}
+	}
+
+	return a.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := a.bookRepo.DeleteBatch(ctx, ids); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := a.publisher.PublishBookDeleted(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ensureDeletable asks the transaction service whether the book still has
+// copies on loan or unpaid fines. It fails closed when the service cannot answer.
+func (a *bookUsecase) ensureDeletable(ctx context.Context, id uint) error {
+	obligations, err := a.obligations.BookObligations(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot verify loans and fines: %v", err)
+	}
+	if obligations.OpenLoans > 0 || obligations.UnpaidFines > 0 {
+		return domain.ErrBookHasObligations
+	}
+	return nil
 }
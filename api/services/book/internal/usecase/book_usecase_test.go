@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/client"
+	"pushtaka/pkg/events"
+	"pushtaka/services/book/internal/domain"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Every catalogue change is announced from inside its own transaction, so
+// the event commits with the change or not at all.
+func TestBookChangesPublishEvents(t *testing.T) {
+	ctx := context.Background()
+	errOutbox := errors.New("outbox unavailable")
+
+	tests := []struct {
+		name       string
+		change     func(domain.BookUsecase) error
+		publishErr error
+		wantErr    error
+		want       []publishedEvent
+	}{
+		{"store", func(u domain.BookUsecase) error {
+			return u.Store(ctx, &domain.Book{Title: "Ronggeng Dukuh Paruk"})
+		}, nil, nil, []publishedEvent{{events.TypeBookCreated, 2, true}}},
+		{"update", func(u domain.BookUsecase) error {
+			return u.Update(ctx, &domain.Book{ID: 1, Title: "Bumi Manusia", Slug: "bumi-manusia"})
+		}, nil, nil, []publishedEvent{{events.TypeBookUpdated, 1, true}}},
+		{"update an unknown book", func(u domain.BookUsecase) error {
+			return u.Update(ctx, &domain.Book{ID: 2, Title: "Gadis Pantai"})
+		}, nil, gorm.ErrRecordNotFound, nil},
+		{"delete", func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 1)
+		}, nil, nil, []publishedEvent{{events.TypeBookDeleted, 1, true}}},
+		{"delete an unknown book", func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 2)
+		}, nil, gorm.ErrRecordNotFound, nil},
+		{"delete a batch", func(u domain.BookUsecase) error {
+			return u.DeleteBatch(ctx, []uint{1})
+		}, nil, nil, []publishedEvent{{events.TypeBookDeleted, 1, true}}},
+		{"outbox failure", func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 1)
+		}, errOutbox, errOutbox, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeBookRepo(domain.Book{ID: 1, Title: "Bumi Manusia", Slug: "bumi-manusia"})
+			publisher := &fakePublisher{err: tt.publishErr}
+			u := NewBookUsecase(repo, &fakeCopyRepo{books: repo}, publisher, &fakeObligations{}, fakeTransactor{}, time.Second)
+
+			if err := tt.change(u); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(publisher.events, tt.want) {
+				t.Fatalf("published %+v, want %+v", publisher.events, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsOwnSlug(t *testing.T) {
+	repo := newFakeBookRepo(domain.Book{ID: 1, Title: "Bumi Manusia", Slug: "bumi-manusia"}, domain.Book{ID: 2, Title: "Anak Semua Bangsa", Slug: "anak-semua-bangsa"})
+	u := NewBookUsecase(repo, &fakeCopyRepo{books: repo}, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, time.Second)
+
+	book := &domain.Book{ID: 1, Title: "Bumi Manusia", Slug: "bumi-manusia"}
+	if err := u.Update(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Slug != "bumi-manusia" {
+		t.Fatalf("slug = %q, want it unchanged", book.Slug)
+	}
+
+	// Taking another book's slug gets a suffix
+	book.Slug = "anak-semua-bangsa"
+	if err := u.Update(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Slug != "anak-semua-bangsa-1" {
+		t.Fatalf("slug = %q, want anak-semua-bangsa-1", book.Slug)
+	}
+}
+
+func TestDeleteIsGatedByObligations(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		obligations *fakeObligations
+		delete      func(domain.BookUsecase) error
+		wantErr     string
+	}{
+		{"copy on loan", &fakeObligations{owed: map[uint]client.Obligations{1: {OpenLoans: 1}}}, func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 1)
+		}, domain.ErrBookHasObligations.Error()},
+		{"unpaid fine", &fakeObligations{owed: map[uint]client.Obligations{1: {UnpaidFines: 1, FineAmount: 2000}}}, func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 1)
+		}, domain.ErrBookHasObligations.Error()},
+		{"transaction service unreachable", &fakeObligations{err: errors.New("connection refused")}, func(u domain.BookUsecase) error {
+			return u.Delete(ctx, 1)
+		}, "cannot verify loans and fines: connection refused"},
+		{"one book in the batch is on loan", &fakeObligations{owed: map[uint]client.Obligations{2: {OpenLoans: 3}}}, func(u domain.BookUsecase) error {
+			return u.DeleteBatch(ctx, []uint{1, 2})
+		}, "book 2: " + domain.ErrBookHasObligations.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeBookRepo(domain.Book{ID: 1, Title: "Bumi Manusia"}, domain.Book{ID: 2, Title: "Gadis Pantai"})
+			publisher := &fakePublisher{}
+			u := NewBookUsecase(repo, &fakeCopyRepo{books: repo}, publisher, tt.obligations, fakeTransactor{}, time.Second)
+
+			err := tt.delete(u)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if len(repo.books) != 2 {
+				t.Errorf("%d books left, want both kept", len(repo.books))
+			}
+			if len(publisher.events) != 0 {
+				t.Errorf("published %+v, want nothing", publisher.events)
+			}
+		})
+	}
+}
+
+func TestStoreAddsCopies(t *testing.T) {
+	repo := newFakeBookRepo()
+	copies := &fakeCopyRepo{books: repo}
+	u := NewBookUsecase(repo, copies, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, time.Second)
+
+	book := &domain.Book{Title: "Bumi Manusia", Stock: 3}
+	if err := u.Store(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+	want := []domain.CopyStatus{domain.CopyAvailable, domain.CopyAvailable, domain.CopyAvailable}
+	if got := copies.statuses(); !slices.Equal(got, want) {
+		t.Fatalf("copies = %v, want %v", got, want)
+	}
+	if book.Stock != 3 || repo.books[book.ID].Stock != 3 {
+		t.Fatalf("stock = %d (stored %d), want 3", book.Stock, repo.books[book.ID].Stock)
+	}
+}
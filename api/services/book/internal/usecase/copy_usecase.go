@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"context"
+	"pushtaka/services/book/internal/domain"
+	"strings"
+	"time"
+)
+
+type copyUsecase struct {
+	copyRepo       domain.CopyRepository
+	bookRepo       domain.BookRepository
+	contextTimeout time.Duration
+}
+
+func NewCopyUsecase(copyRepo domain.CopyRepository, bookRepo domain.BookRepository, timeout time.Duration) domain.CopyUsecase {
+	return &copyUsecase{
+		copyRepo:       copyRepo,
+		bookRepo:       bookRepo,
+		contextTimeout: timeout,
+	}
+}
+
+func (u *copyUsecase) FetchByBookID(c context.Context, bookID uint) ([]domain.BookCopy, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	if _, err := u.bookRepo.GetByID(ctx, bookID); err != nil {
+		return nil, err
+	}
+	return u.copyRepo.FetchByBookID(ctx, bookID)
+}
+
+func (u *copyUsecase) GetByBarcode(c context.Context, barcode string) (*domain.BookCopy, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+	return u.copyRepo.GetByBarcode(ctx, strings.TrimSpace(barcode))
+}
+
+func (u *copyUsecase) Store(c context.Context, bookCopy *domain.BookCopy) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	if _, err := u.bookRepo.GetByID(ctx, bookCopy.BookID); err != nil {
+		return err
+	}
+
+	// New copies go on the shelf
+	bookCopy.ID = 0
+	bookCopy.Status = domain.CopyAvailable
+	if bookCopy.Condition == "" {
+		bookCopy.Condition = domain.ConditionGood
+	}
+	if !validCondition(bookCopy.Condition) {
+		return domain.ErrInvalidCopy
+	}
+	bookCopy.Barcode = strings.TrimSpace(bookCopy.Barcode)
+	return u.copyRepo.Store(ctx, bookCopy)
+}
+
+// Update changes a copy's details. Staff can move a copy between the shelf
+// statuses, but not onto or off loan; that is left to circulation. A copy
+// on loan can only be written off as lost.
+func (u *copyUsecase) Update(c context.Context, bookCopy *domain.BookCopy) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	existing, err := u.copyRepo.GetByID(ctx, bookCopy.ID)
+	if err != nil {
+		return err
+	}
+
+	if bookCopy.Status != "" && bookCopy.Status != existing.Status {
+		if existing.Status == domain.CopyOnLoan && bookCopy.Status != domain.CopyLost {
+			return domain.ErrCopyOnLoan
+		}
+		if !validShelfStatus(bookCopy.Status) {
+			return domain.ErrInvalidCopy
+		}
+		existing.Status = bookCopy.Status
+	}
+	if bookCopy.Condition != "" {
+		if !validCondition(bookCopy.Condition) {
+			return domain.ErrInvalidCopy
+		}
+		existing.Condition = bookCopy.Condition
+	}
+	if bookCopy.Barcode != "" {
+		existing.Barcode = bookCopy.Barcode
+	}
+	if bookCopy.AccessionNumber != "" {
+		existing.AccessionNumber = bookCopy.AccessionNumber
+	}
+	if bookCopy.Branch != "" {
+		existing.Branch = bookCopy.Branch
+	}
+	if bookCopy.ShelfLocation != "" {
+		existing.ShelfLocation = bookCopy.ShelfLocation
+	}
+
+	if err := u.copyRepo.Update(ctx, existing); err != nil {
+		return err
+	}
+	*bookCopy = *existing
+	return nil
+}
+
+func (u *copyUsecase) Delete(c context.Context, id uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	bookCopy, err := u.copyRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if bookCopy.Status == domain.CopyOnLoan {
+		return domain.ErrCopyOnLoan
+	}
+	return u.copyRepo.Delete(ctx, id)
+}
+
+func validShelfStatus(status domain.CopyStatus) bool {
+	for _, s := range domain.ShelfStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func validCondition(condition string) bool {
+	for _, c := range domain.Conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
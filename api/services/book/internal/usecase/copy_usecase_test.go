@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/services/book/internal/domain"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newTestCopies returns a copy usecase over book 1 with an available copy
+// and a copy on loan.
+func newTestCopies() (domain.CopyUsecase, *fakeCopyRepo, *fakeBookRepo) {
+	ctx := context.Background()
+	books := newFakeBookRepo(domain.Book{ID: 1, Title: "Bumi Manusia", Code: "BK-1"})
+	copies := &fakeCopyRepo{books: books}
+	copies.Store(ctx, &domain.BookCopy{BookID: 1, Status: domain.CopyAvailable, Condition: domain.ConditionGood})
+	copies.Store(ctx, &domain.BookCopy{BookID: 1, Status: domain.CopyOnLoan, Condition: domain.ConditionGood})
+	return NewCopyUsecase(copies, books, time.Second), copies, books
+}
+
+func TestCopyStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		copy          domain.BookCopy
+		wantErr       error
+		wantCondition string
+	}{
+		{"defaults to good condition", domain.BookCopy{BookID: 1}, nil, domain.ConditionGood},
+		{"goes on the shelf whatever status is sent", domain.BookCopy{BookID: 1, Status: domain.CopyOnLoan, Condition: domain.ConditionNew}, nil, domain.ConditionNew},
+		{"unknown condition", domain.BookCopy{BookID: 1, Condition: "soggy"}, domain.ErrInvalidCopy, ""},
+		{"unknown book", domain.BookCopy{BookID: 9}, gorm.ErrRecordNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, copies, books := newTestCopies()
+
+			bookCopy := tt.copy
+			if err := u.Store(context.Background(), &bookCopy); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(copies.copies) != 2 {
+					t.Fatalf("%d copies, want none added", len(copies.copies))
+				}
+				return
+			}
+			if bookCopy.Status != domain.CopyAvailable || bookCopy.Condition != tt.wantCondition {
+				t.Errorf("stored %s/%s, want available/%s", bookCopy.Status, bookCopy.Condition, tt.wantCondition)
+			}
+			if stock := books.books[1].Stock; stock != 2 {
+				t.Errorf("stock = %d, want 2", stock)
+			}
+		})
+	}
+}
+
+func TestCopyUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		update     domain.BookCopy
+		wantErr    error
+		wantStatus []domain.CopyStatus
+		wantStock  int
+	}{
+		{"send to repair", domain.BookCopy{ID: 1, Status: domain.CopyInRepair}, nil,
+			[]domain.CopyStatus{domain.CopyInRepair, domain.CopyOnLoan}, 0},
+		{"details only", domain.BookCopy{ID: 1, ShelfLocation: "Rak B-2", Condition: domain.ConditionFair}, nil,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}, 1},
+		{"staff cannot lend a copy", domain.BookCopy{ID: 1, Status: domain.CopyOnLoan}, domain.ErrInvalidCopy,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}, 1},
+		{"copy on loan cannot be shelved", domain.BookCopy{ID: 2, Status: domain.CopyAvailable}, domain.ErrCopyOnLoan,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}, 1},
+		{"copy on loan can be written off", domain.BookCopy{ID: 2, Status: domain.CopyLost}, nil,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyLost}, 1},
+		{"unknown condition", domain.BookCopy{ID: 1, Condition: "soggy"}, domain.ErrInvalidCopy,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}, 1},
+		{"unknown copy", domain.BookCopy{ID: 9, Status: domain.CopyLost}, gorm.ErrRecordNotFound,
+			[]domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, copies, books := newTestCopies()
+
+			update := tt.update
+			if err := u.Update(context.Background(), &update); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got := copies.statuses(); !slices.Equal(got, tt.wantStatus) {
+				t.Errorf("copies = %v, want %v", got, tt.wantStatus)
+			}
+			if stock := books.books[1].Stock; stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", stock, tt.wantStock)
+			}
+		})
+	}
+}
+
+func TestCopyDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         uint
+		wantErr    error
+		wantStatus []domain.CopyStatus
+	}{
+		{"copy on the shelf", 1, nil, []domain.CopyStatus{domain.CopyWithdrawn, domain.CopyOnLoan}},
+		{"copy on loan", 2, domain.ErrCopyOnLoan, []domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}},
+		{"unknown copy", 9, gorm.ErrRecordNotFound, []domain.CopyStatus{domain.CopyAvailable, domain.CopyOnLoan}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, copies, _ := newTestCopies()
+
+			if err := u.Delete(context.Background(), tt.id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got := copies.statuses(); !slices.Equal(got, tt.wantStatus) {
+				t.Errorf("copies = %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"pushtaka/pkg/client"
+	"pushtaka/pkg/events"
+	"pushtaka/services/book/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+// In-memory stand-ins for the repositories, enough for the usecases under
+// test. Methods a test does not need are left to the embedded interface and
+// panic if called.
+
+type fakeBookRepo struct {
+	domain.BookRepository
+	books map[uint]domain.Book
+}
+
+func newFakeBookRepo(books ...domain.Book) *fakeBookRepo {
+	r := &fakeBookRepo{books: make(map[uint]domain.Book)}
+	for _, book := range books {
+		r.books[book.ID] = book
+	}
+	return r
+}
+
+func (r *fakeBookRepo) GetByID(ctx context.Context, id uint) (*domain.Book, error) {
+	book, ok := r.books[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &book, nil
+}
+
+func (r *fakeBookRepo) GetBySlug(ctx context.Context, slug string) (*domain.Book, error) {
+	for _, book := range r.books {
+		if book.Slug == slug {
+			return &book, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeBookRepo) Store(ctx context.Context, book *domain.Book) error {
+	book.ID = uint(len(r.books) + 1)
+	r.books[book.ID] = *book
+	return nil
+}
+
+func (r *fakeBookRepo) Update(ctx context.Context, book *domain.Book) error {
+	if _, ok := r.books[book.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	r.books[book.ID] = *book
+	return nil
+}
+
+func (r *fakeBookRepo) Delete(ctx context.Context, id uint) error {
+	if _, ok := r.books[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.books, id)
+	return nil
+}
+
+type inTxKey struct{}
+
+// fakeTransactor marks the context so fakes can tell whether they were
+// called inside a transaction. It does not roll anything back.
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, inTxKey{}, true))
+}
+
+type publishedEvent struct {
+	Type   string
+	BookID uint
+	InTx   bool
+}
+
+// fakePublisher records the events it was asked to queue. Setting err makes
+// every publish fail.
+type fakePublisher struct {
+	events []publishedEvent
+	err    error
+}
+
+func (p *fakePublisher) publish(ctx context.Context, typ string, bookID uint) error {
+	if p.err != nil {
+		return p.err
+	}
+	inTx, _ := ctx.Value(inTxKey{}).(bool)
+	p.events = append(p.events, publishedEvent{typ, bookID, inTx})
+	return nil
+}
+
+func (p *fakePublisher) PublishBookCreated(ctx context.Context, book *domain.Book) error {
+	return p.publish(ctx, events.TypeBookCreated, book.ID)
+}
+
+func (p *fakePublisher) PublishBookUpdated(ctx context.Context, book *domain.Book) error {
+	return p.publish(ctx, events.TypeBookUpdated, book.ID)
+}
+
+func (p *fakePublisher) PublishBookDeleted(ctx context.Context, bookID uint) error {
+	return p.publish(ctx, events.TypeBookDeleted, bookID)
+}
+
+func (r *fakeBookRepo) DeleteBatch(ctx context.Context, ids []uint) error {
+	for _, id := range ids {
+		delete(r.books, id)
+	}
+	return nil
+}
+
+// fakeObligations answers the pre-delete check from a map of what is owed
+// on each book. Setting err makes the transaction service unreachable.
+type fakeObligations struct {
+	owed map[uint]client.Obligations
+	err  error
+}
+
+func (f *fakeObligations) BookObligations(ctx context.Context, bookID uint) (*client.Obligations, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	o := f.owed[bookID]
+	return &o, nil
+}
+
+// fakeCopyRepo keeps copies in creation order. Like the real repository it
+// keeps each book's stock equal to its available copies.
+type fakeCopyRepo struct {
+	domain.CopyRepository
+	books  *fakeBookRepo
+	copies []domain.BookCopy
+}
+
+func (r *fakeCopyRepo) GetByID(ctx context.Context, id uint) (*domain.BookCopy, error) {
+	if id == 0 || int(id) > len(r.copies) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	bookCopy := r.copies[id-1]
+	return &bookCopy, nil
+}
+
+func (r *fakeCopyRepo) Store(ctx context.Context, bookCopy *domain.BookCopy) error {
+	bookCopy.ID = uint(len(r.copies) + 1)
+	if bookCopy.Barcode == "" {
+		bookCopy.Barcode = fmt.Sprintf("BK%d-%03d", bookCopy.BookID, bookCopy.ID)
+	}
+	r.copies = append(r.copies, *bookCopy)
+	return r.SyncStock(ctx, bookCopy.BookID)
+}
+
+func (r *fakeCopyRepo) Update(ctx context.Context, bookCopy *domain.BookCopy) error {
+	r.copies[bookCopy.ID-1] = *bookCopy
+	return r.SyncStock(ctx, bookCopy.BookID)
+}
+
+func (r *fakeCopyRepo) Delete(ctx context.Context, id uint) error {
+	bookCopy := r.copies[id-1]
+	r.copies[id-1].Status = domain.CopyWithdrawn // Stands in for the soft delete
+	return r.SyncStock(ctx, bookCopy.BookID)
+}
+
+func (r *fakeCopyRepo) CreateForBook(ctx context.Context, book *domain.Book, n int) error {
+	for i := 0; i < n; i++ {
+		if err := r.Store(ctx, &domain.BookCopy{BookID: book.ID, Condition: domain.ConditionGood, Status: domain.CopyAvailable}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeCopyRepo) SyncStock(ctx context.Context, bookID uint) error {
+	book, ok := r.books.books[bookID]
+	if !ok {
+		return nil
+	}
+	book.Stock = 0
+	for _, bookCopy := range r.copies {
+		if bookCopy.BookID == bookID && bookCopy.Status == domain.CopyAvailable {
+			book.Stock++
+		}
+	}
+	r.books.books[bookID] = book
+	return nil
+}
+
+// statuses lists the copy statuses in creation order.
+func (r *fakeCopyRepo) statuses() []domain.CopyStatus {
+	var statuses []domain.CopyStatus
+	for _, bookCopy := range r.copies {
+		statuses = append(statuses, bookCopy.Status)
+	}
+	return statuses
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"pushtaka/pkg/auth"
+	sharedClient "pushtaka/pkg/client"
 	"pushtaka/pkg/database"
 	"pushtaka/pkg/mail"
+	"pushtaka/pkg/messaging"
 	"pushtaka/pkg/middleware"
+	"pushtaka/services/identity/internal/client"
 	"pushtaka/services/identity/internal/domain"
 	"pushtaka/services/identity/internal/handler"
+	msgPublisher "pushtaka/services/identity/internal/messaging"
 	"pushtaka/services/identity/internal/repository"
 	"pushtaka/services/identity/internal/usecase"
 
@@ -35,7 +43,47 @@ func main() {
 	}
 
 	// Auto Migrate
-	db.AutoMigrate(&domain.User{}, &domain.Config{})
+	db.AutoMigrate(&domain.User{}, &domain.Config{}, &domain.Session{}, &domain.RetiredRefreshToken{}, &domain.LoginChallenge{}, &domain.RecoveryCode{}, &domain.IPThrottle{}, &domain.AuditLog{}, &domain.VerificationToken{}, &domain.PasswordHistory{}, &domain.ExternalIdentity{}, &domain.OIDCLogin{}, &messaging.OutboxMessage{})
+
+	// OTPs and reset tokens now live hashed in verification_tokens
+	verificationRepo := repository.NewVerificationRepository(db)
+	if err := verificationRepo.DropLegacyUserColumns(context.Background()); err != nil {
+		log.Printf("Failed to drop legacy OTP columns: %v", err)
+	}
+	// Rotated refresh tokens now live in retired_refresh_tokens
+	sessionRepo := repository.NewSessionRepository(db)
+	if err := sessionRepo.RetireLegacyPreviousHashes(context.Background()); err != nil {
+		log.Printf("Failed to move rotated refresh tokens: %v", err)
+	}
+	// Passwords set before password_changed_at existed get a full max age
+	db.Model(&domain.User{}).Where("password_changed_at IS NULL").UpdateColumn("password_changed_at", time.Now())
+
+	// Reject tokens revoked by a password reset, role change or deletion
+	auth.UseTokenVersions(auth.NewTokenVersionCache(db, 10*time.Second))
+
+	// Token signing keys, held by this service only
+	rotationDays, err := strconv.Atoi(os.Getenv("JWT_KEY_ROTATION_DAYS"))
+	if err != nil || rotationDays <= 0 {
+		rotationDays = 30
+	}
+	signer, err := auth.NewSigner(os.Getenv("JWT_KEYS_DIR"), time.Duration(rotationDays)*24*time.Hour)
+	if err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	go signer.Run(context.Background())
+	auth.UseKeys(signer)
+
+	// Key for the OTP hashes, so the stored codes cannot be guessed offline
+	otpKey := []byte(os.Getenv("OTP_HASH_KEY"))
+	if len(otpKey) == 0 {
+		log.Println("OTP_HASH_KEY not set, codes sent before a restart will not verify after it")
+		otpKey = make([]byte, 32)
+		if _, err := rand.Read(otpKey); err != nil {
+			log.Fatal("Failed to generate OTP key:", err)
+		}
+	} else if len(otpKey) < 32 {
+		log.Fatal("OTP_HASH_KEY must be at least 32 characters")
+	}
 
 	// Mail Config
 	mailPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
@@ -48,25 +96,79 @@ func main() {
 	mailSender := mail.NewMailSender(mailCfg)
 
 	// App
-	app := fiber.New()
+	app := fiber.New(appConfig(os.Getenv("PROXY_HEADER"), os.Getenv("TRUSTED_PROXIES")))
 	app.Use(logger.New())
 	app.Use(cors.New())
 
+	// RabbitMQ Connection, only used to relay domain events from the outbox
+	mq := messaging.NewConnectionManager(os.Getenv("RABBITMQ_URL"))
+	mq.DeclareTopology(messaging.DeclareEventExchange)
+	mq.Start(context.Background())
+	go messaging.NewOutboxRelay(db, mq, "identity").Run(context.Background())
+
 	// Init Layers
 	timeoutContext := time.Duration(2) * time.Second
 	userRepo := repository.NewUserRepository(db)
-	authUsecase := usecase.NewAuthUsecase(userRepo, mailSender, timeoutContext)
-	userUsecase := usecase.NewUserUsecase(userRepo, timeoutContext)
+	transactor := database.NewTransactor(db)
+	userPublisher := msgPublisher.NewUserPublisher(messaging.NewOutbox(db, "identity"))
+	sessionUsecase := usecase.NewSessionUsecase(sessionRepo, userRepo, signer, timeoutContext)
+	auditRepo := repository.NewAuditRepository(db)
+	loginGuard := usecase.NewLoginGuard(userRepo, repository.NewThrottleRepository(db), auditRepo)
+	var breached domain.BreachedPasswords
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		if breached, err = repository.NewBreachedPasswordFile(path); err != nil {
+			log.Fatal("Failed to open breached password list:", err)
+		}
+	}
+	passwordPolicy := usecase.NewPasswordPolicy(userRepo, repository.NewPasswordHistoryRepository(db), breached)
+	twoFactorRepo := repository.NewTwoFactorRepository(db)
+	twoFactorUsecase := usecase.NewTwoFactorUsecase(userRepo, twoFactorRepo, sessionUsecase, loginGuard, transactor, timeoutContext)
+	authUsecase := usecase.NewAuthUsecase(userRepo, sessionUsecase, twoFactorUsecase, loginGuard, verificationRepo, otpKey, passwordPolicy, mailSender, userPublisher, transactor, timeoutContext)
+	transactionClient := sharedClient.NewTransactionClient(os.Getenv("TRANSACTION_SERVICE_URL"), signer.ServiceTokenSource("identity-service"))
+	userUsecase := usecase.NewUserUsecase(userRepo, userPublisher, transactionClient, transactor, auditRepo, passwordPolicy, timeoutContext)
 	settingsUsecase := usecase.NewSettingsUsecase(userRepo, timeoutContext)
-
-	// Init Middleware
-	roleMiddleware := middleware.NewRoleMiddleware()
+	serviceTokenUsecase := usecase.NewServiceTokenUsecase(signer, os.Getenv("SERVICE_CLIENTS"))
+	oidcProviders, err := client.LoadOIDCProviders(os.Getenv("OIDC_PROVIDERS_FILE"))
+	if err != nil {
+		log.Fatal("Failed to load OIDC providers:", err)
+	}
+	oidcUsecase := usecase.NewOIDCUsecase(oidcProviders, userRepo, repository.NewExternalIdentityRepository(db), twoFactorUsecase, userPublisher, transactor, timeoutContext)
 
 	// Init Handler
 	handler.NewAuthHandler(app, authUsecase)
-	handler.NewUserHandler(app, userUsecase, roleMiddleware.RequireRole(domain.RoleAdmin), roleMiddleware.RequireAuth())
-	handler.NewSettingsHandler(app, settingsUsecase, roleMiddleware.RequireRole(domain.RoleAdmin))
+	handler.NewUserHandler(app, userUsecase, middleware.RequirePermission(auth.PermUsersManage), middleware.RequireAuth())
+	handler.NewSettingsHandler(app, settingsUsecase, middleware.RequirePermission(auth.PermSettingsManage))
+	handler.NewSessionHandler(app, sessionUsecase, middleware.RequireAuth())
+	handler.NewTwoFactorHandler(app, twoFactorUsecase, middleware.RequireAuth())
+	handler.NewTokenHandler(app, signer, serviceTokenUsecase)
+	handler.NewOIDCHandler(app, oidcUsecase, middleware.RequireAuth())
 
 	// Start server
 	log.Fatal(app.Listen(":3000"))
 }
+
+// appConfig reads the client's address from proxyHeader (X-Real-Ip for
+// Traefik) when the request comes from one of trustedProxies, a comma
+// separated list of IPs or CIDRs. Lockouts and sessions key on that address,
+// so without a trusted list the header is ignored rather than believed from
+// anyone.
+func appConfig(proxyHeader, trustedProxies string) fiber.Config {
+	var proxies []string
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if proxyHeader == "" {
+		return fiber.Config{}
+	}
+	if len(proxies) == 0 {
+		log.Println("PROXY_HEADER ignored, TRUSTED_PROXIES not set")
+		return fiber.Config{}
+	}
+	return fiber.Config{
+		ProxyHeader:             proxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          proxies,
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAppConfigTrustsProxyHeaderOnlyFromTrustedProxies(t *testing.T) {
+	// app.Test connects from 0.0.0.0
+	tests := []struct {
+		name           string
+		proxyHeader    string
+		trustedProxies string
+		want           string
+	}{
+		{"no proxy", "", "", "0.0.0.0"},
+		{"header without trusted proxies", "X-Real-Ip", "", "0.0.0.0"},
+		{"blank trusted proxies", "X-Real-Ip", " , ", "0.0.0.0"},
+		{"request from trusted proxy", "X-Real-Ip", "10.0.0.1, 0.0.0.0", "203.0.113.7"},
+		{"request from trusted range", "X-Real-Ip", "0.0.0.0/8", "203.0.113.7"},
+		{"request from elsewhere", "X-Real-Ip", "10.0.0.0/8", "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(appConfig(tt.proxyHeader, tt.trustedProxies))
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendString(c.IP()) })
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Real-Ip", "203.0.113.7")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Fatalf("IP = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
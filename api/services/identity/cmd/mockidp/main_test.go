@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/client"
+	"pushtaka/services/identity/internal/domain"
+	"pushtaka/services/identity/internal/usecase"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// These tests sign in through the OIDC usecase and client against the mock
+// provider, with the database replaced by maps.
+
+const redirectURI = "http://app.test/callback"
+
+type fakeUsers struct {
+	domain.UserRepository
+	mu     sync.Mutex
+	users  map[uint]domain.User
+	nextID uint
+}
+
+func (r *fakeUsers) Create(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	user.ID = r.nextID
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUsers) Update(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUsers) GetByID(ctx context.Context, id uint) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (r *fakeUsers) GetByEmailUnscoped(ctx context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUsers) get(t *testing.T, id uint) domain.User {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		t.Fatalf("user %d does not exist", id)
+	}
+	return user
+}
+
+type fakeIdentities struct {
+	mu         sync.Mutex
+	logins     map[string]*domain.OIDCLogin
+	identities []*domain.ExternalIdentity
+}
+
+func (r *fakeIdentities) CreateLogin(ctx context.Context, login *domain.OIDCLogin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logins[login.StateHash] = login
+	return nil
+}
+
+func (r *fakeIdentities) TakeLogin(ctx context.Context, stateHash string) (*domain.OIDCLogin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	login, ok := r.logins[stateHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.logins, stateHash)
+	return login, nil
+}
+
+func (r *fakeIdentities) Get(ctx context.Context, provider, subject string) (*domain.ExternalIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			found := *identity
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentities) Create(ctx context.Context, identity *domain.ExternalIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identity.ID = uint(len(r.identities) + 1)
+	stored := *identity
+	r.identities = append(r.identities, &stored)
+	return nil
+}
+
+func (r *fakeIdentities) Touch(ctx context.Context, id uint, email string) error {
+	return nil
+}
+
+// pending is the one sign-in waiting for its callback.
+func (r *fakeIdentities) pending(t *testing.T) *domain.OIDCLogin {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.logins) != 1 {
+		t.Fatalf("%d sign-ins pending, want 1", len(r.logins))
+	}
+	for _, login := range r.logins {
+		return login
+	}
+	return nil
+}
+
+type fakeTwoFactor struct {
+	domain.TwoFactorUsecase
+}
+
+func (fakeTwoFactor) SignIn(ctx context.Context, user *domain.User, client domain.ClientInfo) (*domain.AuthResponse, error) {
+	return &domain.AuthResponse{Token: "access-token", User: *user}, nil
+}
+
+type fakePublisher struct {
+	mu         sync.Mutex
+	registered []uint
+}
+
+func (p *fakePublisher) PublishUserRegistered(ctx context.Context, user *domain.User) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registered = append(p.registered, user.ID)
+	return nil
+}
+
+func (p *fakePublisher) PublishUserDeleted(ctx context.Context, userID uint, permanent bool) error {
+	return nil
+}
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type testEnv struct {
+	oidc       domain.OIDCUsecase
+	users      *fakeUsers
+	identities *fakeIdentities
+	publisher  *fakePublisher
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	p, err := newProvider("", "pushtaka", "rahasia-klien")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(p.routes())
+	p.issuer = "http://" + srv.Listener.Addr().String()
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	provider := client.NewOIDCProvider(domain.OIDCProviderConfig{
+		Name:         "school",
+		Issuer:       p.issuer,
+		ClientID:     "pushtaka",
+		ClientSecret: "rahasia-klien",
+		RedirectURIs: []string{redirectURI},
+		GroupsClaim:  "groups",
+		RoleMap:      map[string]string{"librarians": auth.RoleLibrarian, "admins": auth.RoleAdmin},
+	})
+
+	env := &testEnv{
+		users:      &fakeUsers{users: make(map[uint]domain.User)},
+		identities: &fakeIdentities{logins: make(map[string]*domain.OIDCLogin)},
+		publisher:  &fakePublisher{},
+	}
+	env.oidc = usecase.NewOIDCUsecase(map[string]domain.OIDCProvider{"school": provider}, env.users, env.identities, fakeTwoFactor{}, env.publisher, fakeTransactor{}, 2*time.Second)
+	return env
+}
+
+// authorize starts a sign-in, or a link for linkUserID when it is not 0, and
+// has the mock provider approve it as email in groups. It returns what the
+// app would post to the callback.
+func (e *testEnv) authorize(t *testing.T, email string, groups string, linkUserID uint) *domain.OIDCCallbackRequest {
+	t.Helper()
+	ctx := context.Background()
+	var start *domain.OIDCStartResponse
+	var err error
+	if linkUserID != 0 {
+		start, err = e.oidc.StartLink(ctx, linkUserID, "school", &domain.OIDCStartRequest{})
+	} else {
+		start, err = e.oidc.Start(ctx, "school", &domain.OIDCStartRequest{})
+	}
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	authURL, err := url.Parse(start.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization url asks for no S256 challenge: %s", authURL)
+	}
+	query.Set("login_hint", email)
+	query.Set("mock_groups", groups)
+	authURL.RawQuery = query.Encode()
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := browser.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d, want a redirect", resp.StatusCode)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Query().Get("state") != start.State {
+		t.Fatalf("provider returned state %q, want %q", back.Query().Get("state"), start.State)
+	}
+	return &domain.OIDCCallbackRequest{State: start.State, Code: back.Query().Get("code")}
+}
+
+func TestFirstSignInProvisionsAccount(t *testing.T) {
+	env := newTestEnv(t)
+
+	res, err := env.oidc.Callback(context.Background(), "school", env.authorize(t, "siswa@sekolah.sch.id", "", 0))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+
+	user := env.users.get(t, res.User.ID)
+	if user.Email != "siswa@sekolah.sch.id" || !user.IsVerified || user.Password != "" || user.Role != auth.RoleMember {
+		t.Fatalf("provisioned user = %+v", user)
+	}
+	identity, err := env.identities.Get(context.Background(), "school", "mock-siswa@sekolah.sch.id")
+	if err != nil {
+		t.Fatalf("no identity linked: %v", err)
+	}
+	if identity.UserID != user.ID || !identity.Provisioned {
+		t.Fatalf("identity = %+v, want provisioned for user %d", identity, user.ID)
+	}
+	if len(env.publisher.registered) != 1 || env.publisher.registered[0] != user.ID {
+		t.Fatalf("user.registered published for %v", env.publisher.registered)
+	}
+
+	// The next sign-in finds the same account
+	again, err := env.oidc.Callback(context.Background(), "school", env.authorize(t, "siswa@sekolah.sch.id", "", 0))
+	if err != nil {
+		t.Fatalf("second callback: %v", err)
+	}
+	if again.User.ID != user.ID || len(env.users.users) != 1 {
+		t.Fatalf("second sign-in gave user %d, want %d", again.User.ID, user.ID)
+	}
+}
+
+func TestGroupsMapToRole(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	res, err := env.oidc.Callback(ctx, "school", env.authorize(t, "pustakawan@sekolah.sch.id", "librarians", 0))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if res.User.Role != auth.RoleLibrarian {
+		t.Fatalf("role = %q, want %q", res.User.Role, auth.RoleLibrarian)
+	}
+
+	// The role with the most permissions wins
+	res, err = env.oidc.Callback(ctx, "school", env.authorize(t, "pustakawan@sekolah.sch.id", "librarians, admins", 0))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if res.User.Role != auth.RoleAdmin {
+		t.Fatalf("role = %q, want %q", res.User.Role, auth.RoleAdmin)
+	}
+
+	// Leaving every mapped group makes a member, revoking the old tokens
+	before := env.users.get(t, res.User.ID).TokenVersion
+	res, err = env.oidc.Callback(ctx, "school", env.authorize(t, "pustakawan@sekolah.sch.id", "students", 0))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	user := env.users.get(t, res.User.ID)
+	if user.Role != auth.RoleMember {
+		t.Fatalf("role = %q, want %q", user.Role, auth.RoleMember)
+	}
+	if user.TokenVersion != before+1 {
+		t.Fatalf("token version = %d, want %d", user.TokenVersion, before+1)
+	}
+}
+
+func TestExistingAccountIsLinkedOnlyByItsOwner(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	admin := &domain.User{Email: "kepala@sekolah.sch.id", Password: "hash", Role: auth.RoleAdmin, IsVerified: true}
+	env.users.Create(ctx, admin)
+
+	// Signing in with the same email does not take the account
+	_, err := env.oidc.Callback(ctx, "school", env.authorize(t, "kepala@sekolah.sch.id", "", 0))
+	if !errors.Is(err, domain.ErrExternalAccountExists) {
+		t.Fatalf("callback: got %v, want ErrExternalAccountExists", err)
+	}
+	if got := env.users.get(t, admin.ID); got.Role != auth.RoleAdmin || got.Password != "hash" {
+		t.Fatalf("local account changed: %+v", got)
+	}
+
+	// The owner links it from their session
+	identity, err := env.oidc.Link(ctx, admin.ID, "school", env.authorize(t, "kepala@sekolah.sch.id", "", admin.ID))
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if identity.UserID != admin.ID || identity.Provisioned {
+		t.Fatalf("identity = %+v, want linked, not provisioned", identity)
+	}
+
+	// Signing in now works, and the groups leave the role alone
+	res, err := env.oidc.Callback(ctx, "school", env.authorize(t, "kepala@sekolah.sch.id", "librarians", 0))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if res.User.ID != admin.ID || env.users.get(t, admin.ID).Role != auth.RoleAdmin {
+		t.Fatalf("signed in as %+v, want admin %d keeping the role", res.User, admin.ID)
+	}
+
+	// Nobody else can link the same provider account
+	other := &domain.User{Email: "guru@sekolah.sch.id", Role: auth.RoleMember, IsVerified: true}
+	env.users.Create(ctx, other)
+	_, err = env.oidc.Link(ctx, other.ID, "school", env.authorize(t, "kepala@sekolah.sch.id", "", other.ID))
+	if !errors.Is(err, domain.ErrExternalIdentityLinked) {
+		t.Fatalf("link by another user: got %v, want ErrExternalIdentityLinked", err)
+	}
+}
+
+func TestPKCEVerifierIsChecked(t *testing.T) {
+	env := newTestEnv(t)
+
+	req := env.authorize(t, "siswa@sekolah.sch.id", "", 0)
+	env.identities.pending(t).CodeVerifier = "not-the-verifier-the-challenge-was-made-from"
+
+	_, err := env.oidc.Callback(context.Background(), "school", req)
+	if !errors.Is(err, domain.ErrExternalLoginFailed) {
+		t.Fatalf("callback with the wrong verifier: got %v, want ErrExternalLoginFailed", err)
+	}
+	if len(env.users.users) != 0 {
+		t.Fatal("a user was created")
+	}
+}
+
+func TestBadStateOrNonceIsRejected(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	t.Run("unknown state", func(t *testing.T) {
+		req := env.authorize(t, "siswa@sekolah.sch.id", "", 0)
+		defer env.identities.TakeLogin(ctx, env.identities.pending(t).StateHash)
+		_, err := env.oidc.Callback(ctx, "school", &domain.OIDCCallbackRequest{State: "forged", Code: req.Code})
+		if !errors.Is(err, domain.ErrInvalidOIDCState) {
+			t.Fatalf("got %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("replayed state", func(t *testing.T) {
+		req := env.authorize(t, "siswa@sekolah.sch.id", "", 0)
+		if _, err := env.oidc.Callback(ctx, "school", req); err != nil {
+			t.Fatalf("first callback: %v", err)
+		}
+		_, err := env.oidc.Callback(ctx, "school", req)
+		if !errors.Is(err, domain.ErrInvalidOIDCState) {
+			t.Fatalf("got %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		req := env.authorize(t, "siswa@sekolah.sch.id", "", 0)
+		env.identities.pending(t).ExpiresAt = time.Now().Add(-time.Second)
+		_, err := env.oidc.Callback(ctx, "school", req)
+		if !errors.Is(err, domain.ErrInvalidOIDCState) {
+			t.Fatalf("got %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("link state used to sign in", func(t *testing.T) {
+		req := env.authorize(t, "siswa@sekolah.sch.id", "", 1)
+		_, err := env.oidc.Callback(ctx, "school", req)
+		if !errors.Is(err, domain.ErrInvalidOIDCState) {
+			t.Fatalf("got %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		req := env.authorize(t, "siswa@sekolah.sch.id", "", 0)
+		env.identities.pending(t).Nonce = "nonce-of-another-sign-in"
+		_, err := env.oidc.Callback(ctx, "school", req)
+		if !errors.Is(err, domain.ErrExternalLoginFailed) {
+			t.Fatalf("got %v, want ErrExternalLoginFailed", err)
+		}
+	})
+}
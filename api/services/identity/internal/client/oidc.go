@@ -0,0 +1,364 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcKeysMinRefetch = 5 * time.Second
+
+// LoadOIDCProviders reads the JSON list of providers at path, keyed by name.
+// No path means no providers.
+func LoadOIDCProviders(path string) (map[string]domain.OIDCProvider, error) {
+	providers := make(map[string]domain.OIDCProvider)
+	if path == "" {
+		return providers, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []domain.OIDCProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	for _, cfg := range configs {
+		if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || len(cfg.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("provider %q: name, issuer, client_id and redirect_uris are required", cfg.Name)
+		}
+		if _, dup := providers[cfg.Name]; dup {
+			return nil, fmt.Errorf("provider %q is listed twice", cfg.Name)
+		}
+		for group, role := range cfg.RoleMap {
+			if !auth.ValidRole(role) {
+				return nil, fmt.Errorf("provider %q: group %q maps to unknown role %q", cfg.Name, group, role)
+			}
+		}
+		if cfg.GroupsClaim == "" {
+			cfg.GroupsClaim = "groups"
+		}
+		providers[cfg.Name] = NewOIDCProvider(cfg)
+	}
+	return providers, nil
+}
+
+// oidcMetadata is the part of the provider's discovery document we use.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider is an OpenID Connect relying party for one provider. The
+// discovery document is fetched on first use, signing keys whenever an ID
+// token names one not seen yet.
+type oidcProvider struct {
+	cfg         domain.OIDCProviderConfig
+	httpClient  *http.Client
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]interface{}
+	attemptedAt time.Time
+}
+
+func NewOIDCProvider(cfg domain.OIDCProviderConfig) domain.OIDCProvider {
+	return &oidcProvider{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		keys:       make(map[string]interface{}),
+	}
+}
+
+func (p *oidcProvider) Config() *domain.OIDCProviderConfig {
+	return &p.cfg
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(append([]string{"openid", "email", "profile"}, p.cfg.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, redirectURI, verifier string) (*domain.ExternalClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s unreachable: %v", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid token response from %s: %v", p.cfg.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d: %s %s", p.cfg.Name, resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%s returned no id_token", p.cfg.Name)
+	}
+	return p.verify(ctx, meta, body.IDToken)
+}
+
+// verify checks the ID token's signature, issuer, audience and lifetime, and
+// reads the claims we use from it.
+func (p *oidcProvider) verify(ctx context.Context, meta *oidcMetadata, raw string) (*domain.ExternalClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token from %s: %v", p.cfg.Name, err)
+	}
+	// A token for several audiences must name us as the one it was issued to
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("invalid id_token from %s: issued to %q", p.cfg.Name, azp)
+	}
+
+	out := &domain.ExternalClaims{
+		Subject: stringClaim(claims, "sub"),
+		Email:   stringClaim(claims, "email"),
+		Name:    stringClaim(claims, "name"),
+		Nonce:   stringClaim(claims, "nonce"),
+	}
+	if out.Subject == "" {
+		return nil, fmt.Errorf("invalid id_token from %s: no subject", p.cfg.Name)
+	}
+	if out.Name == "" {
+		out.Name = stringClaim(claims, "preferred_username")
+	}
+	// Some providers send the flag as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		out.EmailVerified = v == "true"
+	}
+	switch v := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				out.Groups = append(out.Groups, s)
+			}
+		}
+	case string:
+		out.Groups = []string{v}
+	}
+	return out, nil
+}
+
+func (p *oidcProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta oidcMetadata
+	discovery := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discovery, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%s: discovery names issuer %q, expected %q", p.cfg.Name, meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: incomplete discovery document", p.cfg.Name)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// publicKey finds the signing key by ID, refetching the key set when the ID
+// is new to us. A token without a key ID is accepted only while the
+// provider publishes a single key.
+func (p *oidcProvider) publicKey(ctx context.Context, meta *oidcMetadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.lookupKey(kid)
+	if !ok && time.Since(p.attemptedAt) >= oidcKeysMinRefetch {
+		p.attemptedAt = time.Now()
+		if err := p.fetchKeys(ctx, meta.JWKSURI); err != nil {
+			return nil, err
+		}
+		key, ok = p.lookupKey(kid)
+	}
+	if !ok {
+		return nil, auth.ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (p *oidcProvider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *oidcProvider) fetchKeys(ctx context.Context, jwksURI string) error {
+	var set struct {
+		Keys []providerJWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s unreachable: %v", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d for %s", p.cfg.Name, resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// providerJWK is a public key as providers publish them: RSA, EC or Ed25519.
+type providerJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k providerJWK) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
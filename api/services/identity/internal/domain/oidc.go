@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnknownProvider     = errors.New("unknown identity provider")
+	ErrInvalidRedirectURI  = errors.New("invalid redirect_uri for this identity provider")
+	ErrInvalidOIDCState    = errors.New("invalid or expired sign-in state")
+	ErrExternalEmailNeeded = errors.New("the identity provider did not share a verified email address")
+	ErrExternalLoginFailed = errors.New("sign-in with the identity provider failed")
+	// ErrExternalAccountExists is returned on the first sign-in of a
+	// provider account whose email belongs to a local account. The owner
+	// links it from their profile instead.
+	ErrExternalAccountExists  = errors.New("an account with this email already exists, sign in and link the identity provider from your profile")
+	ErrExternalIdentityLinked = errors.New("this identity provider account is linked to another user")
+)
+
+// ExternalIdentity links an account at an identity provider, such as a
+// school's, to a user. The provider's subject never changes, unlike email.
+type ExternalIdentity struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	Provider    string    `gorm:"not null;uniqueIndex:idx_external_identity" json:"provider"`
+	Subject     string    `gorm:"not null;uniqueIndex:idx_external_identity" json:"subject"`
+	Email       string    `json:"email"`                                     // As the provider last reported it
+	Provisioned bool      `gorm:"not null;default:false" json:"provisioned"` // The sign-in created the account, see OIDCProviderConfig.RoleMap
+	LastLoginAt time.Time `json:"last_login_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OIDCLogin is a sign-in sent to a provider and not back yet. The client
+// holds the state; only its hash is stored, next to the PKCE verifier and
+// nonce the callback is checked against.
+type OIDCLogin struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateHash    string    `gorm:"not null;uniqueIndex"`
+	Provider     string    `gorm:"not null"`
+	RedirectURI  string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	LinkUserID   *uint     // The signed-in user linking the provider account, nil for a sign-in
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// OIDCProviderConfig is one entry of the OIDC_PROVIDERS_FILE list.
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Empty for a public client
+	RedirectURIs []string `json:"redirect_uris"` // Where the app receives the code, the first is the default
+	Scopes       []string `json:"scopes"`        // Added to "openid email profile"
+	GroupsClaim  string   `json:"groups_claim"`  // ID token claim listing groups, "groups" by default
+	// RoleMap maps provider groups to roles. When set, the role of an
+	// account the provider provisioned follows the groups at every sign-in;
+	// with several matches, the role with the most permissions wins and with
+	// none the user is a member. Local accounts linked to the provider keep
+	// their own role.
+	RoleMap map[string]string `json:"role_map"`
+}
+
+// ExternalClaims is what a verified ID token says about the user.
+type ExternalClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+	Nonce         string
+}
+
+type OIDCProvider interface {
+	Config() *OIDCProviderConfig
+	// AuthCodeURL is where to send the user, asking for an authorization
+	// code bound to the PKCE challenge.
+	AuthCodeURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error)
+	// Exchange redeems code with the PKCE verifier and verifies the ID
+	// token that comes back, except for the nonce.
+	Exchange(ctx context.Context, code, redirectURI, verifier string) (*ExternalClaims, error)
+}
+
+type OIDCStartRequest struct {
+	RedirectURI string `json:"redirect_uri"` // Optional, one of the provider's redirect_uris
+}
+
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+	ExpiresIn        int    `json:"expires_in"`
+}
+
+type OIDCCallbackRequest struct {
+	State  string     `json:"state"`
+	Code   string     `json:"code"`
+	Client ClientInfo `json:"-"`
+}
+
+type ExternalIdentityRepository interface {
+	CreateLogin(ctx context.Context, login *OIDCLogin) error
+	// TakeLogin removes and returns the login, so a state works once.
+	TakeLogin(ctx context.Context, stateHash string) (*OIDCLogin, error)
+	Get(ctx context.Context, provider, subject string) (*ExternalIdentity, error)
+	Create(ctx context.Context, identity *ExternalIdentity) error
+	Touch(ctx context.Context, id uint, email string) error
+}
+
+type OIDCUsecase interface {
+	Providers() []string
+	Start(ctx context.Context, provider string, req *OIDCStartRequest) (*OIDCStartResponse, error)
+	// Callback signs the user in with the code from the provider, creating
+	// their account on the first visit.
+	Callback(ctx context.Context, provider string, req *OIDCCallbackRequest) (*AuthResponse, error)
+	// StartLink and Link are Start and Callback for a signed-in user adding
+	// a provider account to their own.
+	StartLink(ctx context.Context, userID uint, provider string, req *OIDCStartRequest) (*OIDCStartResponse, error)
+	Link(ctx context.Context, userID uint, provider string, req *OIDCCallbackRequest) (*ExternalIdentity, error)
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrPasswordExpired = errors.New("password has expired, reset it through /auth/forgot-password")
+
+// PasswordHistory keeps the hashes of a user's earlier passwords so they
+// cannot be chosen again.
+type PasswordHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Hash      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// PasswordPolicy enforces the password_* settings. Failures come back as a
+// *utils.ValidationError on the field "password".
+type PasswordPolicy interface {
+	// Set checks password and hashes it onto user, moving the old hash into
+	// the history. The caller saves user.
+	Set(ctx context.Context, user *User, password string) error
+	// Expired reports whether the password is older than
+	// password_max_age_days.
+	Expired(ctx context.Context, user *User) bool
+}
+
+type PasswordHistoryRepository interface {
+	// Add records hash and forgets all but the newest keep entries.
+	Add(ctx context.Context, userID uint, hash string, keep int) error
+	Recent(ctx context.Context, userID uint, limit int) ([]string, error)
+}
+
+// BreachedPasswords answers k-anonymity range queries: given the first five
+// hex characters of a password's SHA-1, it returns the remaining 35 of every
+// breached hash sharing them. The password itself never leaves the caller.
+type BreachedPasswords interface {
+	Range(prefix string) ([]string, error)
+}
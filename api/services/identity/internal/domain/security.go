@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+
+// Audit events
+const (
+	AuditAccountLocked        = "account_locked"
+	AuditAccountUnlocked      = "account_unlocked"
+	AuditIPLocked             = "ip_locked"
+	AuditOTPInvalidated       = "otp_invalidated"
+	AuditChallengeInvalidated = "login_challenge_invalidated"
+)
+
+// AuditLog records security events: lockouts, unlocks and codes thrown away
+// after too many wrong guesses.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Event     string    `gorm:"not null;index" json:"event"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	ActorID   *uint     `json:"actor_id"` // Admin who acted, if any
+	IP        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// IPThrottle counts failed sign-in attempts from one address, across every
+// account tried from it.
+type IPThrottle struct {
+	IP            string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+type ThrottleRepository interface {
+	// RecordAccountFailure counts a failed attempt against the user and
+	// returns the new count, started over if the previous failure is older
+	// than window.
+	RecordAccountFailure(ctx context.Context, userID uint, window time.Duration) (int, error)
+	LockAccount(ctx context.Context, userID uint, until time.Time) error
+	ResetAccount(ctx context.Context, userID uint) error
+
+	GetIP(ctx context.Context, ip string) (*IPThrottle, error)
+	RecordIPFailure(ctx context.Context, ip string, window time.Duration) (int, error)
+	LockIP(ctx context.Context, ip string, until time.Time) error
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, entry *AuditLog) error
+}
+
+// LoginGuard slows down password and code guessing. Failed logins, OTPs and
+// two-factor codes all count against the account and the client's IP; past
+// a threshold each is locked out for a period that doubles with every
+// further failure.
+type LoginGuard interface {
+	// Check fails with ErrTooManyAttempts while the account or IP is locked.
+	// user may be nil when the email is unknown.
+	Check(ctx context.Context, user *User, ip string) error
+	Fail(ctx context.Context, user *User, ip string) error
+	// FailCode is Fail for a wrong OTP or two-factor code. attempts is how
+	// many wrong codes the OTP or challenge has now seen; spent reports it
+	// has had too many and must be thrown away, which is audited as event.
+	FailCode(ctx context.Context, user *User, ip string, attempts int, event string) (spent bool, err error)
+	// Succeed clears the account's failures once the user is fully signed in.
+	Succeed(ctx context.Context, user *User) error
+}
@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// Session is one signed-in device. It holds the hash of the device's current
+// refresh token, which is replaced on every refresh.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"index" json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Current    bool       `gorm:"-" json:"current"`
+}
+
+// RetiredRefreshToken is a refresh token a session has already rotated away
+// from. Every generation is kept for the life of the session, so a stolen
+// token is recognised however many refreshes ago it was replaced.
+type RetiredRefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	SessionID uint   `gorm:"not null;index"`
+	TokenHash string `gorm:"not null;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// ClientInfo describes the device a session is opened or refreshed from.
+type ClientInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	GetByID(ctx context.Context, id uint) (*Session, error)
+	GetByTokenHash(ctx context.Context, hash string) (*Session, error)
+	// GetByRetiredHash returns the session that once held the refresh token
+	// hash and has since rotated past it.
+	GetByRetiredHash(ctx context.Context, hash string) (*Session, error)
+	GetActiveByUserID(ctx context.Context, userID uint) ([]Session, error)
+	// Rotate swaps the session's refresh token hash, but only if oldHash is
+	// still current, so one token cannot be redeemed twice concurrently.
+	// oldHash is kept as retired.
+	Rotate(ctx context.Context, session *Session, oldHash string) error
+	Revoke(ctx context.Context, id uint) error
+	RevokeAllByUserID(ctx context.Context, userID uint, exceptID uint) error
+	// RetireLegacyPreviousHashes moves the one rotated hash sessions kept
+	// before RetiredRefreshToken into it and drops the column. It does
+	// nothing once the column is gone.
+	RetireLegacyPreviousHashes(ctx context.Context) error
+}
+
+type SessionUsecase interface {
+	// Open signs the user in on a new device.
+	Open(ctx context.Context, user *User, client ClientInfo) (*AuthResponse, error)
+	Refresh(ctx context.Context, refreshToken string, client ClientInfo) (*AuthResponse, error)
+	Logout(ctx context.Context, refreshToken string) error
+
+	// Profile
+	ListSessions(ctx context.Context, userID uint, currentID uint) ([]Session, error)
+	RevokeSession(ctx context.Context, userID uint, sessionID uint) error
+	RevokeOtherSessions(ctx context.Context, userID uint, currentID uint) error
+	RevokeAll(ctx context.Context, userID uint) error
+}
+
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+// ServiceTokenRequest is how another service, registered in SERVICE_CLIENTS,
+// asks for a token to call its peers with.
+type ServiceTokenRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+type ServiceTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+type ServiceTokenUsecase interface {
+	Issue(ctx context.Context, req *ServiceTokenRequest) (*ServiceTokenResponse, error)
+}
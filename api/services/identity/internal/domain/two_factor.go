@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidChallenge        = errors.New("invalid or expired login challenge")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorNotSetUp       = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for this account")
+)
+
+// LoginChallenge is a password login waiting for its second factor. The
+// client holds the token; only its hash is stored.
+type LoginChallenge struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"not null;index"`
+	TokenHash  string    `gorm:"not null;uniqueIndex"`
+	DeviceName string    // Carried over to the session opened once the challenge is met
+	Attempts   int       `gorm:"not null;default:0"` // Wrong codes tried
+	ExpiresAt  time.Time `gorm:"not null"`
+	CreatedAt  time.Time
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// authenticator is lost.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// TwoFactorChallenge is what Login returns instead of tokens when the
+// account needs a second factor. With SetupRequired the user has yet to
+// enroll, which the role makes mandatory.
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"`
+	SetupRequired  bool   `json:"setup_required"`
+}
+
+// TwoFactorSetup is a new TOTP secret, to be confirmed with a code before it
+// is enabled. ProvisioningURI is meant to be shown as a QR code.
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string     `json:"challenge_token"`
+	Code           string     `json:"code"` // TOTP code or recovery code
+	Client         ClientInfo `json:"-"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorRepository interface {
+	CreateChallenge(ctx context.Context, challenge *LoginChallenge) error
+	GetChallengeByTokenHash(ctx context.Context, hash string) (*LoginChallenge, error)
+	DeleteChallenge(ctx context.Context, id uint) error
+	// RecordChallengeFailure counts a wrong code and returns the new count.
+	RecordChallengeFailure(ctx context.Context, id uint) (int, error)
+
+	// ClaimTOTPStep records the time step of an accepted code, failing with
+	// gorm.ErrRecordNotFound if that step or a later one was already used.
+	ClaimTOTPStep(ctx context.Context, userID uint, step int64) error
+
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error
+	// UseRecoveryCode marks an unused code as used, failing with
+	// gorm.ErrRecordNotFound if there is none.
+	UseRecoveryCode(ctx context.Context, userID uint, hash string) error
+	DeleteRecoveryCodes(ctx context.Context, userID uint) error
+}
+
+type TwoFactorUsecase interface {
+	// SignIn finishes a password login: it opens a session, or returns a
+	// challenge if the account uses or must set up two-factor authentication.
+	SignIn(ctx context.Context, user *User, client ClientInfo) (*AuthResponse, error)
+	// SetupChallenge enrolls a user who must set up 2FA before signing in.
+	SetupChallenge(ctx context.Context, challengeToken string) (*TwoFactorSetup, error)
+	// CompleteLogin meets a challenge with a TOTP or recovery code.
+	CompleteLogin(ctx context.Context, req *TwoFactorLoginRequest) (*AuthResponse, error)
+
+	// Profile
+	Setup(ctx context.Context, userID uint) (*TwoFactorSetup, error)
+	Enable(ctx context.Context, userID uint, code string) ([]string, error)
+	Disable(ctx context.Context, userID uint, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error)
+}
@@ -2,6 +2,9 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/client"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,27 +14,37 @@ type User struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
 	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
 	Password  string         `gorm:"not null" json:"-"`
+	PasswordChangedAt *time.Time `json:"-"` // See PasswordPolicy.Expired
 	Name      string         `json:"name"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
-	ResetToken       string         `json:"-"`
-	ResetTokenExpiry time.Time      `json:"-"`
-	OTP              string         `json:"-"`
-	OTPPurpose       string         `json:"-"`
-	OTPExpiry        time.Time      `json:"-"`
 	IsVerified       bool           `gorm:"default:false" json:"is_verified"`
 	Role             string         `gorm:"default:'user'" json:"role"`
+	TokenVersion     int            `gorm:"not null;default:0" json:"-"` // Bumped to revoke every token issued so far
+	TOTPSecret       string         `json:"-"`                           // Set up but unconfirmed until TOTPEnabled
+	TOTPEnabled      bool           `gorm:"not null;default:false" json:"two_factor_enabled"`
+	TOTPLastStep     int64          `gorm:"not null;default:0" json:"-"` // Time step of the last accepted code, refused if replayed
+
+	// Lockout, see LoginGuard
+	FailedLogins      int        `gorm:"not null;default:0" json:"failed_logins"`
+	LastFailedLoginAt *time.Time `json:"-"`
+	LockedUntil       *time.Time `json:"locked_until,omitempty"`
 }
 
+// Roles, see auth.PermissionsFor for what each may do
 const (
-	RoleUser  = "user"
-	RoleAdmin = "admin"
+	RoleUser       = auth.RoleMember
+	RoleLibrarian  = auth.RoleLibrarian
+	RoleCataloguer = auth.RoleCataloguer
+	RoleAdmin      = auth.RoleAdmin
 )
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string     `json:"email"`
+	Password   string     `json:"password"`
+	DeviceName string     `json:"device_name"` // Optional label shown in the session list
+	Client     ClientInfo `json:"-"`
 }
 
 type RegisterRequest struct {
@@ -41,9 +54,10 @@ type RegisterRequest struct {
 }
 
 type VerifyOTPRequest struct {
-	Email   string `json:"email"`
-	OTP     string `json:"otp"`
-	Purpose string `json:"purpose"` // register, login, reset_password, change_password, change_email
+	Email   string     `json:"email"`
+	OTP     string     `json:"otp"`
+	Purpose string     `json:"purpose"` // register, reset_password, change_password, change_email
+	Client  ClientInfo `json:"-"`
 }
 
 type RequestOTPRequest struct {
@@ -56,6 +70,8 @@ type UpdateUserRequest struct {
 	Email      string `json:"email"`
 	Role       string `json:"role"`
 	IsVerified *bool  `json:"is_verified"` // Use pointer to distinguish between false and missing
+	Unlock     bool   `json:"unlock"`      // Lift a lockout after too many failed logins
+	ActorID    uint   `json:"-"`           // Admin making the change, for the audit log
 }
 
 type UpdateProfileRequest struct {
@@ -78,8 +94,15 @@ type ChangeEmailRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`                   // Access token
+	RefreshToken string `json:"refresh_token,omitempty"` // Exchanged at /auth/refresh for a new pair
+	ExpiresIn    int    `json:"expires_in,omitempty"`    // Access token lifetime in seconds
+	User         User   `json:"user"`
+
+	// Set instead of the tokens when the login still needs a second factor
+	TwoFactor *TwoFactorChallenge `json:"two_factor,omitempty"`
+	// Returned once, when two-factor authentication is set up during login
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 type UserResponse struct {
@@ -111,14 +134,12 @@ type UserRepository interface {
 	DeletePermanent(ctx context.Context, id uint) error
 	GetAll(ctx context.Context, limit, offset int) ([]User, int64, error)
 	DeleteBatch(ctx context.Context, ids []uint, permanent bool) error
+	BumpTokenVersion(ctx context.Context, ids []uint) error
 	
 	// Config (Legacy/Internal)
 	GetConfig(ctx context.Context, key string) (string, error)
 	SetConfig(ctx context.Context, key string, value string) error
 	
-	// Reset Token
-	GetByResetToken(ctx context.Context, token string) (*User, error)
-	
 	// Settings CRUD
 	GetAllConfigs(ctx context.Context) ([]Config, error)
 	GetConfigByKey(ctx context.Context, key string) (*Config, error)
@@ -134,6 +155,18 @@ type UserRepository interface {
 	DeleteConfigsByIDs(ctx context.Context, ids []uint) error
 }
 
+var ErrUserHasObligations = errors.New("user still has open loans or unpaid fines")
+
+// ObligationChecker asks the transaction service what a member still owes.
+type ObligationChecker interface {
+	UserObligations(ctx context.Context, userID uint) (*client.Obligations, error)
+}
+
+type UserPublisher interface {
+	PublishUserRegistered(ctx context.Context, user *User) error
+	PublishUserDeleted(ctx context.Context, userID uint, permanent bool) error
+}
+
 type SettingsUsecase interface {
 	GetAllSettings(ctx context.Context) ([]Config, error)
 	GetSetting(ctx context.Context, key string) (*Config, error)
@@ -155,6 +188,7 @@ type AuthUsecase interface {
 	VerifyOTP(ctx context.Context, req *VerifyOTPRequest) (*AuthResponse, error)
 	RequestOTP(ctx context.Context, email string, purpose string) error
 	ChangeEmail(ctx context.Context, req *ChangeEmailRequest) error
+	ConfirmEmail(ctx context.Context, req *ConfirmEmailRequest) error
 }
 
 type UserUsecase interface {
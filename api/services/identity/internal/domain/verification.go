@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired token")
+	ErrEmailChangeUnconfirmed   = errors.New("email changes must be confirmed at the new address, use /auth/change-email")
+)
+
+// Purposes of the emailed one-time codes, as sent to /auth/request-otp and
+// /auth/verify-otp
+const (
+	PurposeRegister       = "register"
+	PurposeResetPassword  = "reset_password"
+	PurposeChangePassword = "change_password"
+	PurposeChangeEmail    = "change_email"
+	// PurposeConfirmEmail is the code sent to the new address of an email
+	// change, checked at /auth/confirm-email
+	PurposeConfirmEmail = "confirm_email"
+)
+
+// Purposes of the tokens VerifyOTP hands out for the follow-up request
+const (
+	PurposePasswordToken = "password_token" // For /auth/reset-password
+	PurposeEmailToken    = "email_token"    // For /auth/change-email
+)
+
+// VerificationToken is an emailed one-time code, or a token proving one was
+// verified. Only a hash is stored. A token is bound to its user and purpose,
+// used once, and replaced when another is issued for the same purpose.
+type VerificationToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index:idx_verification_user_purpose"`
+	Purpose   string    `gorm:"not null;index:idx_verification_user_purpose"`
+	TokenHash string    `gorm:"not null;index"`
+	NewEmail  string    `gorm:"index"`              // Address being confirmed, for PurposeConfirmEmail
+	Attempts  int       `gorm:"not null;default:0"` // Wrong codes tried
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// ConfirmEmailRequest finishes an email change with the code sent to the new
+// address.
+type ConfirmEmailRequest struct {
+	Email  string     `json:"email"` // The new address
+	OTP    string     `json:"otp"`
+	Client ClientInfo `json:"-"`
+}
+
+type VerificationRepository interface {
+	// Issue stores token in place of any earlier one the user holds for the
+	// same purpose.
+	Issue(ctx context.Context, token *VerificationToken) error
+	// GetLatest returns the user's unused token for purpose.
+	GetLatest(ctx context.Context, userID uint, purpose string) (*VerificationToken, error)
+	GetByHash(ctx context.Context, purpose string, hash string) (*VerificationToken, error)
+	// GetLatestByNewEmail returns the newest unused token confirming email.
+	GetLatestByNewEmail(ctx context.Context, purpose string, email string) (*VerificationToken, error)
+	// RecordFailure counts a wrong code and returns the new count.
+	RecordFailure(ctx context.Context, id uint) (int, error)
+	// Consume marks the token used. It returns gorm.ErrRecordNotFound when
+	// the token was already used.
+	Consume(ctx context.Context, id uint) error
+	// DropLegacyUserColumns removes the code and reset token columns users
+	// had before this store. It does nothing once they are gone.
+	DropLegacyUserColumns(ctx context.Context) error
+}
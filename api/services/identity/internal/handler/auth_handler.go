@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"pushtaka/pkg/utils"
 	"pushtaka/services/identity/internal/domain"
 
@@ -25,6 +26,7 @@ func NewAuthHandler(app *fiber.App, authUsecase domain.AuthUsecase) {
 	authGroup.Post("/reset-password", handler.ResetPassword)
 	authGroup.Post("/request-otp", handler.RequestOTP)
 	authGroup.Post("/change-email", handler.ChangeEmail)
+	authGroup.Post("/confirm-email", handler.ConfirmEmail)
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
@@ -35,6 +37,10 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 
 	res, err := h.authUsecase.Register(c.Context(), &req)
 	if err != nil {
+		var invalid *utils.ValidationError
+		if errors.As(err, &invalid) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(utils.Invalid(invalid))
+		}
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 
@@ -47,7 +53,12 @@ func (h *AuthHandler) VerifyOTP(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
 	}
 
+	req.Client = clientInfo(c, "")
+
 	res, err := h.authUsecase.VerifyOTP(c.Context(), &req)
+	if errors.Is(err, domain.ErrTooManyAttempts) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(utils.Error(err.Error()))
+	}
 	if err != nil {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
@@ -61,11 +72,22 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
 	}
 
+	req.Client = clientInfo(c, req.DeviceName)
+
 	res, err := h.authUsecase.Login(c.Context(), &req)
+	if errors.Is(err, domain.ErrTooManyAttempts) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(utils.Error(err.Error()))
+	}
+	if errors.Is(err, domain.ErrPasswordExpired) {
+		return c.Status(fiber.StatusForbidden).JSON(utils.Error(err.Error()))
+	}
 	if err != nil {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error("Invalid email or password"))
 	}
 
+	if res.TwoFactor != nil {
+		return c.JSON(utils.Success("two-factor authentication required", res))
+	}
 	return c.JSON(utils.Success("login successful", res))
 }
 
@@ -91,6 +113,10 @@ func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
 
 	err := h.authUsecase.ResetPassword(c.Context(), &req)
 	if err != nil {
+		var invalid *utils.ValidationError
+		if errors.As(err, &invalid) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(utils.Invalid(invalid))
+		}
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 
@@ -121,6 +147,25 @@ func (h *AuthHandler) ChangeEmail(c *fiber.Ctx) error {
 		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
 	}
 
+	return c.JSON(utils.Success("otp sent to new email", nil))
+}
+
+func (h *AuthHandler) ConfirmEmail(c *fiber.Ctx) error {
+	var req domain.ConfirmEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
+	}
+
+	req.Client = clientInfo(c, "")
+
+	err := h.authUsecase.ConfirmEmail(c.Context(), &req)
+	if errors.Is(err, domain.ErrTooManyAttempts) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(utils.Error(err.Error()))
+	}
+	if err != nil {
+		return c.Status(utils.GetStatusCode(err)).JSON(utils.Error(utils.ParseError(err)))
+	}
+
 	return c.JSON(utils.Success("email changed successfully", nil))
 }
 
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type OIDCHandler struct {
+	oidcUsecase domain.OIDCUsecase
+}
+
+func NewOIDCHandler(app *fiber.App, oidcUsecase domain.OIDCUsecase, authMiddleware fiber.Handler) {
+	handler := &OIDCHandler{
+		oidcUsecase: oidcUsecase,
+	}
+
+	// Public Routes, sign-in through a school's identity provider
+	app.Get("/auth/oidc/providers", handler.Providers)
+	app.Post("/auth/oidc/:provider/start", handler.Start)
+	app.Post("/auth/oidc/:provider/callback", handler.Callback)
+
+	// Linking a provider account to the current user's
+	app.Post("/profile/oidc/:provider/start", authMiddleware, handler.StartLink)
+	app.Post("/profile/oidc/:provider/callback", authMiddleware, handler.Link)
+}
+
+func (h *OIDCHandler) Providers(c *fiber.Ctx) error {
+	return c.JSON(utils.Success("identity providers retrieved", h.oidcUsecase.Providers()))
+}
+
+func (h *OIDCHandler) Start(c *fiber.Ctx) error {
+	var req domain.OIDCStartRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
+		}
+	}
+
+	res, err := h.oidcUsecase.Start(c.Context(), c.Params("provider"), &req)
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	return c.JSON(utils.Success("continue at the authorization url", res))
+}
+
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	var req domain.OIDCCallbackRequest
+	if err := c.BodyParser(&req); err != nil || req.State == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("state and code are required"))
+	}
+
+	req.Client = clientInfo(c, "")
+
+	res, err := h.oidcUsecase.Callback(c.Context(), c.Params("provider"), &req)
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	if res.TwoFactor != nil {
+		return c.JSON(utils.Success("two-factor authentication required", res))
+	}
+	return c.JSON(utils.Success("login successful", res))
+}
+
+func (h *OIDCHandler) StartLink(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	var req domain.OIDCStartRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
+		}
+	}
+
+	res, err := h.oidcUsecase.StartLink(c.Context(), caller.UserID, c.Params("provider"), &req)
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	return c.JSON(utils.Success("continue at the authorization url", res))
+}
+
+func (h *OIDCHandler) Link(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	var req domain.OIDCCallbackRequest
+	if err := c.BodyParser(&req); err != nil || req.State == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("state and code are required"))
+	}
+
+	identity, err := h.oidcUsecase.Link(c.Context(), caller.UserID, c.Params("provider"), &req)
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	return c.JSON(utils.Success("identity provider linked", identity))
+}
+
+func oidcError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, domain.ErrUnknownProvider):
+		return c.Status(fiber.StatusNotFound).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrInvalidRedirectURI):
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrInvalidOIDCState), errors.Is(err, domain.ErrExternalLoginFailed):
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrExternalEmailNeeded):
+		return c.Status(fiber.StatusForbidden).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrExternalAccountExists), errors.Is(err, domain.ErrExternalIdentityLinked):
+		return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SessionHandler struct {
+	sessionUsecase domain.SessionUsecase
+}
+
+func NewSessionHandler(app *fiber.App, sessionUsecase domain.SessionUsecase, authMiddleware fiber.Handler) {
+	handler := &SessionHandler{
+		sessionUsecase: sessionUsecase,
+	}
+
+	// Public Routes, authenticated by the refresh token itself
+	app.Post("/auth/refresh", handler.Refresh)
+	app.Post("/auth/logout", handler.Logout)
+
+	// Signed-in devices of the current user
+	app.Get("/profile/sessions", authMiddleware, handler.ListSessions)
+	app.Delete("/profile/sessions/:id", authMiddleware, handler.RevokeSession)
+	app.Delete("/profile/sessions", authMiddleware, handler.RevokeOtherSessions)
+}
+
+func (h *SessionHandler) Refresh(c *fiber.Ctx) error {
+	var req domain.RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("refresh_token is required"))
+	}
+
+	res, err := h.sessionUsecase.Refresh(c.Context(), req.RefreshToken, clientInfo(c, ""))
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRefreshToken) || errors.Is(err, domain.ErrRefreshTokenReused) {
+			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("token refreshed", res))
+}
+
+func (h *SessionHandler) Logout(c *fiber.Ctx) error {
+	var req domain.RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("refresh_token is required"))
+	}
+
+	if err := h.sessionUsecase.Logout(c.Context(), req.RefreshToken); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("logged out successfully", nil))
+}
+
+func (h *SessionHandler) ListSessions(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	sessions, err := h.sessionUsecase.ListSessions(c.Context(), caller.UserID, currentSessionID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("sessions retrieved successfully", sessions))
+}
+
+func (h *SessionHandler) RevokeSession(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	sessionID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid session id"))
+	}
+
+	if err := h.sessionUsecase.RevokeSession(c.Context(), caller.UserID, uint(sessionID)); err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("session revoked successfully", nil))
+}
+
+// RevokeOtherSessions signs the user out of every device but this one.
+func (h *SessionHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	if err := h.sessionUsecase.RevokeOtherSessions(c.Context(), caller.UserID, currentSessionID(c)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("other sessions revoked successfully", nil))
+}
+
+// currentSessionID is the session the access token was issued for, or 0 for
+// tokens issued before sessions existed.
+func currentSessionID(c *fiber.Ctx) uint {
+	if p, ok := auth.PrincipalFrom(c); ok {
+		return p.SessionID
+	}
+	return 0
+}
+
+func clientInfo(c *fiber.Ctx, deviceName string) domain.ClientInfo {
+	return domain.ClientInfo{
+		DeviceName: deviceName,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TokenHandler struct {
+	signer              *auth.Signer
+	serviceTokenUsecase domain.ServiceTokenUsecase
+}
+
+func NewTokenHandler(app *fiber.App, signer *auth.Signer, serviceTokenUsecase domain.ServiceTokenUsecase) {
+	handler := &TokenHandler{
+		signer:              signer,
+		serviceTokenUsecase: serviceTokenUsecase,
+	}
+
+	// Public Routes
+	app.Get("/.well-known/jwks.json", handler.JWKS)
+	app.Post("/auth/service-token", handler.ServiceToken)
+}
+
+// JWKS publishes the keys other services verify tokens with. It is returned
+// bare, not wrapped in the usual response envelope, as JWKS clients expect.
+func (h *TokenHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.signer.JWKS())
+}
+
+func (h *TokenHandler) ServiceToken(c *fiber.Ctx) error {
+	var req domain.ServiceTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid request body"))
+	}
+
+	res, err := h.serviceTokenUsecase.Issue(c.Context(), &req)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidClient) {
+			return c.Status(fiber.StatusUnauthorized).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("service token issued", res))
+}
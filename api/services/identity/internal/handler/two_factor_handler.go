@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TwoFactorHandler struct {
+	twoFactorUsecase domain.TwoFactorUsecase
+}
+
+func NewTwoFactorHandler(app *fiber.App, twoFactorUsecase domain.TwoFactorUsecase, authMiddleware fiber.Handler) {
+	handler := &TwoFactorHandler{
+		twoFactorUsecase: twoFactorUsecase,
+	}
+
+	// Public Routes, authenticated by the challenge token from /auth/login
+	app.Post("/auth/2fa/setup", handler.SetupChallenge)
+	app.Post("/auth/2fa/verify", handler.CompleteLogin)
+
+	// Two-factor settings of the current user
+	app.Post("/profile/2fa/setup", authMiddleware, handler.Setup)
+	app.Post("/profile/2fa/enable", authMiddleware, handler.Enable)
+	app.Post("/profile/2fa/disable", authMiddleware, handler.Disable)
+	app.Post("/profile/2fa/recovery-codes", authMiddleware, handler.RegenerateRecoveryCodes)
+}
+
+func (h *TwoFactorHandler) SetupChallenge(c *fiber.Ctx) error {
+	var req domain.TwoFactorChallengeRequest
+	if err := c.BodyParser(&req); err != nil || req.ChallengeToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("challenge_token is required"))
+	}
+
+	res, err := h.twoFactorUsecase.SetupChallenge(c.Context(), req.ChallengeToken)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("scan the provisioning uri, then verify with a code", res))
+}
+
+func (h *TwoFactorHandler) CompleteLogin(c *fiber.Ctx) error {
+	var req domain.TwoFactorLoginRequest
+	if err := c.BodyParser(&req); err != nil || req.ChallengeToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("challenge_token is required"))
+	}
+
+	req.Client = clientInfo(c, "")
+
+	res, err := h.twoFactorUsecase.CompleteLogin(c.Context(), &req)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("login successful", res))
+}
+
+func (h *TwoFactorHandler) Setup(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	res, err := h.twoFactorUsecase.Setup(c.Context(), caller.UserID)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("scan the provisioning uri, then enable with a code", res))
+}
+
+func (h *TwoFactorHandler) Enable(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	var req domain.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
+	}
+
+	codes, err := h.twoFactorUsecase.Enable(c.Context(), caller.UserID, req.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("two-factor authentication enabled", domain.RecoveryCodesResponse{RecoveryCodes: codes}))
+}
+
+func (h *TwoFactorHandler) Disable(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	var req domain.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
+	}
+
+	if err := h.twoFactorUsecase.Disable(c.Context(), caller.UserID, req.Code); err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("two-factor authentication disabled", nil))
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
+	}
+
+	var req domain.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
+	}
+
+	codes, err := h.twoFactorUsecase.RegenerateRecoveryCodes(c.Context(), caller.UserID, req.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(utils.Success("recovery codes regenerated", domain.RecoveryCodesResponse{RecoveryCodes: codes}))
+}
+
+func twoFactorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, domain.ErrInvalidChallenge), errors.Is(err, domain.ErrInvalidTwoFactorCode):
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrTwoFactorNotSetUp), errors.Is(err, domain.ErrTwoFactorAlreadyEnabled):
+		return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrTwoFactorRequired):
+		return c.Status(fiber.StatusForbidden).JSON(utils.Error(err.Error()))
+	case errors.Is(err, domain.ErrTooManyAttempts):
+		return c.Status(fiber.StatusTooManyRequests).JSON(utils.Error(err.Error()))
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+}
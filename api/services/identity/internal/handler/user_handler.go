@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+	"pushtaka/pkg/auth"
 	"pushtaka/pkg/utils"
 	"pushtaka/services/identity/internal/domain"
 
@@ -35,14 +37,11 @@ func NewUserHandler(app *fiber.App, userUsecase domain.UserUsecase, adminMiddlew
 }
 
 func (h *UserHandler) GetProfile(c *fiber.Ctx) error {
-	// Got from RequireAuth/RequireRole locals
-	claimID := c.Locals("user_id")
-	if claimID == nil {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
 	}
-	
-	// Convert float64 (JWT default number type) to uint
-	id := uint(claimID.(float64))
+	id := caller.UserID
 
 	user, err := h.userUsecase.GetProfile(c.Context(), id)
 	if err != nil {
@@ -53,11 +52,11 @@ func (h *UserHandler) GetProfile(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) UpdateProfile(c *fiber.Ctx) error {
-	claimID := c.Locals("user_id")
-	if claimID == nil {
+	caller, ok := auth.CurrentUser(c)
+	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(utils.Error("Unauthorized"))
 	}
-	id := uint(claimID.(float64))
+	id := caller.UserID
 
 	var req domain.UpdateProfileRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -65,6 +64,9 @@ func (h *UserHandler) UpdateProfile(c *fiber.Ctx) error {
 	}
 
 	if err := h.userUsecase.UpdateProfile(c.Context(), id, &req); err != nil {
+		if errors.Is(err, domain.ErrEmailChangeUnconfirmed) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
 	}
 
@@ -81,6 +83,7 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("Invalid request payload"))
 	}
+	req.ActorID = auth.GetUserID(c)
 
 	if err := h.userUsecase.UpdateUser(c.Context(), uint(id), &req); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
@@ -126,6 +129,10 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 	}
 
 	if err := h.userUsecase.CreateUser(c.Context(), &req); err != nil {
+		var invalid *utils.ValidationError
+		if errors.As(err, &invalid) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(utils.Invalid(invalid))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
 	}
 
@@ -139,6 +146,9 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	}
 
 	if err := h.userUsecase.DeleteUser(c.Context(), uint(id), c.QueryBool("permanent")); err != nil {
+		if errors.Is(err, domain.ErrUserHasObligations) {
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
 	}
 
@@ -160,6 +170,9 @@ func (h *UserHandler) DeleteUsers(c *fiber.Ctx) error {
 	}
 
 	if err := h.userUsecase.DeleteUsers(c.Context(), req.IDs, c.QueryBool("permanent")); err != nil {
+		if errors.Is(err, domain.ErrUserHasObligations) {
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
 	}
 
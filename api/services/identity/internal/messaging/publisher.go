@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
+	"pushtaka/services/identity/internal/domain"
+)
+
+type userPublisher struct {
+	outbox *pkgMessaging.Outbox
+}
+
+func NewUserPublisher(outbox *pkgMessaging.Outbox) *userPublisher {
+	return &userPublisher{outbox: outbox}
+}
+
+// PublishUserRegistered broadcasts user.registered once the transaction on
+// ctx commits.
+func (p *userPublisher) PublishUserRegistered(ctx context.Context, user *domain.User) error {
+	err := p.outbox.Publish(ctx, events.UserRegistered{
+		UserID: user.ID,
+		Email:  user.Email,
+		Name:   user.Name,
+		Role:   user.Role,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue event: %v", err)
+	}
+	return nil
+}
+
+func (p *userPublisher) PublishUserDeleted(ctx context.Context, userID uint, permanent bool) error {
+	err := p.outbox.Publish(ctx, events.UserDeleted{UserID: userID, Permanent: permanent})
+	if err != nil {
+		return fmt.Errorf("failed to queue event: %v", err)
+	}
+
+	log.Printf("Queued UserDeleted event for user_id: %d", userID)
+	return nil
+}
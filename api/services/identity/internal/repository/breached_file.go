@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"pushtaka/services/identity/internal/domain"
+	"strings"
+)
+
+// breachedFile serves range queries from a local copy of a breached
+// password list, one upper-case SHA-1 per line, optionally followed by
+// ":count" as in the Have I Been Pwned downloads. Lines must be sorted, so a
+// range is found by binary search without loading the file.
+type breachedFile struct {
+	file *os.File
+	size int64
+}
+
+// NewBreachedPasswordFile opens the list at path. The file stays open for
+// the life of the service.
+func NewBreachedPasswordFile(path string) (domain.BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &breachedFile{file: file, size: info.Size()}, nil
+}
+
+func (f *breachedFile) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+
+	// Find the smallest offset whose next line is not below prefix
+	lo, hi := int64(0), f.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, ok, err := f.lineAfter(mid)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || hashOf(line) >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	r, err := f.readerAfter(lo)
+	if err != nil {
+		return nil, err
+	}
+	var suffixes []string
+	for {
+		line, err := r.ReadString('\n')
+		hash := hashOf(line)
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+		if err != nil {
+			break
+		}
+	}
+	return suffixes, nil
+}
+
+// lineAfter returns the first whole line starting at or after off.
+func (f *breachedFile) lineAfter(off int64) (string, bool, error) {
+	r, err := f.readerAfter(off)
+	if err != nil {
+		return "", false, err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	return line, line != "", nil
+}
+
+// readerAfter reads from the start of the first line at or after off.
+func (f *breachedFile) readerAfter(off int64) (*bufio.Reader, error) {
+	if off == 0 {
+		return bufio.NewReader(io.NewSectionReader(f.file, 0, f.size)), nil
+	}
+	// Skip the rest of the line holding off-1
+	r := bufio.NewReader(io.NewSectionReader(f.file, off-1, f.size-off+1))
+	if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return r, nil
+}
+
+func hashOf(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A sorted list in the Have I Been Pwned download format.
+var breachedLines = []string{
+	"0000A00000000000000000000000000000000001:12",
+	"0000AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA:3",
+	"0000ABBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB:1",
+	"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824",
+	"7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195",
+	"7C4A8D09CA3762AF61E59520943DC26494F8941C:1",
+	"7C4A900000000000000000000000000000000000:2",
+	"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:7",
+}
+
+func writeBreachedFile(t *testing.T, content string) *breachedFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := NewBreachedPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.(*breachedFile).file.Close() })
+	return list.(*breachedFile)
+}
+
+func TestBreachedFileRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"first line", "0000A", []string{"00000000000000000000000000000000001", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"}},
+		{"last line", "FFFFF", []string{"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"}},
+		{"middle", "5BAA6", []string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}},
+		{"neighbouring prefix left out", "7C4A8", []string{"D09CA3762AF61E59520943DC26494F8941B", "D09CA3762AF61E59520943DC26494F8941C"}},
+		{"lower case prefix", "7c4a9", []string{"00000000000000000000000000000000000"}},
+		{"missing between lines", "6ABCD", nil},
+		{"missing just before a match", "7C4A7", nil},
+		{"missing before first", "00000", nil},
+		{"missing just before last", "FFFFE", nil},
+	}
+
+	for _, ending := range []string{"\n", "\r\n"} {
+		content := strings.Join(breachedLines, ending)
+		for _, trailing := range []string{ending, ""} {
+			list := writeBreachedFile(t, content+trailing)
+			for _, tt := range tests {
+				got, err := list.Range(tt.prefix)
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s (ending %q, trailing %q): Range(%s) = %v, want %v", tt.name, ending, trailing, tt.prefix, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+// Every hash of a larger list is found by its prefix, checked against a
+// plain scan.
+func TestBreachedFileRangeMatchesScan(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 2000; i++ {
+		hashes = append(hashes, fmt.Sprintf("%X:%d", sha1.Sum([]byte(fmt.Sprint(i))), i))
+	}
+	slices.Sort(hashes)
+	list := writeBreachedFile(t, strings.Join(hashes, "\n")+"\n")
+
+	scan := func(prefix string) []string {
+		var suffixes []string
+		for _, line := range hashes {
+			if hash := hashOf(line); strings.HasPrefix(hash, prefix) {
+				suffixes = append(suffixes, hash[len(prefix):])
+			}
+		}
+		return suffixes
+	}
+	prefixes := []string{"00000", "FFFFF", hashes[0][:5], hashes[len(hashes)-1][:5]}
+	for _, line := range hashes {
+		prefixes = append(prefixes, line[:5])
+		// The prefix just after this one is most likely missing
+		prefixes = append(prefixes, fmt.Sprintf("%05X", mustParseHex(t, line[:5])+1))
+	}
+	for _, prefix := range prefixes {
+		got, err := list.Range(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := scan(prefix); !slices.Equal(got, want) {
+			t.Fatalf("Range(%s) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func mustParseHex(t *testing.T, s string) int {
+	t.Helper()
+	var n int
+	if _, err := fmt.Sscanf(s, "%X", &n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBreachedFileSingleLine(t *testing.T) {
+	list := writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n")
+	got, err := list.Range("5BAA6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}) {
+		t.Fatalf("Range = %v", got)
+	}
+	if got, _ := list.Range("5BAA5"); got != nil {
+		t.Fatalf("Range(5BAA5) = %v, want none", got)
+	}
+}
+
+func TestBreachedFileEmpty(t *testing.T) {
+	list := writeBreachedFile(t, "")
+	got, err := list.Range("5BAA6")
+	if err != nil || got != nil {
+		t.Fatalf("Range on an empty list = %v, %v", got, err)
+	}
+}
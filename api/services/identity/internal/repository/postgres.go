@@ -2,8 +2,8 @@ package repository
 
 import (
 	"context"
+	"pushtaka/pkg/database"
 	"pushtaka/services/identity/internal/domain"
-	"time"
 
 	"gorm.io/gorm"
 )
@@ -17,12 +17,12 @@ func NewUserRepository(db *gorm.DB) domain.UserRepository {
 }
 
 func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
-	return r.db.WithContext(ctx).Create(user).Error
+	return database.Conn(ctx, r.db).Create(user).Error
 }
 
 func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	err := database.Conn(ctx, r.db).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +31,7 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.
 
 func (r *userRepository) GetByEmailUnscoped(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Unscoped().Where("email = ?", email).First(&user).Error
+	err := database.Conn(ctx, r.db).Unscoped().Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +40,7 @@ func (r *userRepository) GetByEmailUnscoped(ctx context.Context, email string) (
 
 func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).First(&user, id).Error
+	err := database.Conn(ctx, r.db).First(&user, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -48,27 +48,18 @@ func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, er
 }
 
 func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
-	return r.db.WithContext(ctx).Save(user).Error
-}
-
-func (r *userRepository) GetByResetToken(ctx context.Context, token string) (*domain.User, error) {
-	var user domain.User
-	err := r.db.WithContext(ctx).Where("reset_token = ? AND reset_token_expiry > ?", token, time.Now()).First(&user).Error
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return database.Conn(ctx, r.db).Save(user).Error
 }
 
 func (r *userRepository) GetAll(ctx context.Context, limit, offset int) ([]domain.User, int64, error) {
 	var users []domain.User
 	var total int64
 
-	if err := r.db.WithContext(ctx).Model(&domain.User{}).Count(&total).Error; err != nil {
+	if err := database.Conn(ctx, r.db).Model(&domain.User{}).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.WithContext(ctx).Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+	if err := database.Conn(ctx, r.db).Limit(limit).Offset(offset).Find(&users).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -76,8 +67,8 @@ func (r *userRepository) GetAll(ctx context.Context, limit, offset int) ([]domai
 }
 
 func (r *userRepository) Delete(ctx context.Context, id uint) error {
-	// return r.db.WithContext(ctx).Delete(&domain.User{}, id).Error
-	result := r.db.WithContext(ctx).Delete(&domain.User{}, id)
+	// return database.Conn(ctx, r.db).Delete(&domain.User{}, id).Error
+	result := database.Conn(ctx, r.db).Delete(&domain.User{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -88,7 +79,7 @@ func (r *userRepository) Delete(ctx context.Context, id uint) error {
 }
 
 func (r *userRepository) DeletePermanent(ctx context.Context, id uint) error {
-	result := r.db.WithContext(ctx).Unscoped().Delete(&domain.User{}, id)
+	result := database.Conn(ctx, r.db).Unscoped().Delete(&domain.User{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -99,7 +90,7 @@ func (r *userRepository) DeletePermanent(ctx context.Context, id uint) error {
 }
 
 func (r *userRepository) DeleteBatch(ctx context.Context, ids []uint, permanent bool) error {
-	db := r.db.WithContext(ctx)
+	db := database.Conn(ctx, r.db)
 	if permanent {
 		db = db.Unscoped()
 	}
@@ -114,39 +105,45 @@ func (r *userRepository) DeleteBatch(ctx context.Context, ids []uint, permanent
 	return nil
 }
 
+func (r *userRepository) BumpTokenVersion(ctx context.Context, ids []uint) error {
+	return database.Conn(ctx, r.db).Model(&domain.User{}).
+		Where("id IN ?", ids).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
 func (r *userRepository) GetConfig(ctx context.Context, key string) (string, error) {
 	var config domain.Config
-	err := r.db.WithContext(ctx).Where("key = ?", key).First(&config).Error
+	err := database.Conn(ctx, r.db).Where("key = ?", key).First(&config).Error
 	return config.Value, err
 }
 
 func (r *userRepository) SetConfig(ctx context.Context, key string, value string) error {
 	config := domain.Config{Key: key, Value: value}
-	return r.db.WithContext(ctx).Save(&config).Error
+	return database.Conn(ctx, r.db).Save(&config).Error
 }
 
 func (r *userRepository) GetAllConfigs(ctx context.Context) ([]domain.Config, error) {
 	var configs []domain.Config
-	err := r.db.WithContext(ctx).Find(&configs).Error
+	err := database.Conn(ctx, r.db).Find(&configs).Error
 	return configs, err
 }
 
 func (r *userRepository) GetConfigByKey(ctx context.Context, key string) (*domain.Config, error) {
 	var config domain.Config
-	err := r.db.WithContext(ctx).Where("key = ?", key).First(&config).Error
+	err := database.Conn(ctx, r.db).Where("key = ?", key).First(&config).Error
 	return &config, err
 }
 
 func (r *userRepository) CreateConfig(ctx context.Context, config *domain.Config) error {
-	return r.db.WithContext(ctx).Create(config).Error
+	return database.Conn(ctx, r.db).Create(config).Error
 }
 
 func (r *userRepository) UpdateConfig(ctx context.Context, config *domain.Config) error {
-	return r.db.WithContext(ctx).Save(config).Error
+	return database.Conn(ctx, r.db).Save(config).Error
 }
 
 func (r *userRepository) DeleteConfig(ctx context.Context, key string) error {
-	result := r.db.WithContext(ctx).Delete(&domain.Config{}, "key = ?", key)
+	result := database.Conn(ctx, r.db).Delete(&domain.Config{}, "key = ?", key)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -157,7 +154,7 @@ func (r *userRepository) DeleteConfig(ctx context.Context, key string) error {
 }
 
 func (r *userRepository) DeleteConfigs(ctx context.Context, keys []string) error {
-	result := r.db.WithContext(ctx).Delete(&domain.Config{}, "key IN ?", keys)
+	result := database.Conn(ctx, r.db).Delete(&domain.Config{}, "key IN ?", keys)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -169,12 +166,12 @@ func (r *userRepository) DeleteConfigs(ctx context.Context, keys []string) error
 
 func (r *userRepository) GetConfigByID(ctx context.Context, id uint) (*domain.Config, error) {
 	var config domain.Config
-	err := r.db.WithContext(ctx).First(&config, id).Error
+	err := database.Conn(ctx, r.db).First(&config, id).Error
 	return &config, err
 }
 
 func (r *userRepository) DeleteConfigByID(ctx context.Context, id uint) error {
-	result := r.db.WithContext(ctx).Delete(&domain.Config{}, id)
+	result := database.Conn(ctx, r.db).Delete(&domain.Config{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -185,7 +182,7 @@ func (r *userRepository) DeleteConfigByID(ctx context.Context, id uint) error {
 }
 
 func (r *userRepository) DeleteConfigsByIDs(ctx context.Context, ids []uint) error {
-	result := r.db.WithContext(ctx).Delete(&domain.Config{}, ids)
+	result := database.Conn(ctx, r.db).Delete(&domain.Config{}, ids)
 	if result.Error != nil {
 		return result.Error
 	}
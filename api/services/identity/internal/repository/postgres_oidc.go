@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type externalIdentityRepository struct {
+	db *gorm.DB
+}
+
+func NewExternalIdentityRepository(db *gorm.DB) domain.ExternalIdentityRepository {
+	return &externalIdentityRepository{db}
+}
+
+func (r *externalIdentityRepository) CreateLogin(ctx context.Context, login *domain.OIDCLogin) error {
+	return database.Conn(ctx, r.db).Create(login).Error
+}
+
+func (r *externalIdentityRepository) TakeLogin(ctx context.Context, stateHash string) (*domain.OIDCLogin, error) {
+	var login domain.OIDCLogin
+	result := database.Conn(ctx, r.db).
+		Clauses(clause.Returning{}).
+		Where("state_hash = ?", stateHash).
+		Delete(&login)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Unknown, or taken by a concurrent request
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+func (r *externalIdentityRepository) Get(ctx context.Context, provider, subject string) (*domain.ExternalIdentity, error) {
+	var identity domain.ExternalIdentity
+	err := database.Conn(ctx, r.db).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *externalIdentityRepository) Create(ctx context.Context, identity *domain.ExternalIdentity) error {
+	return database.Conn(ctx, r.db).Create(identity).Error
+}
+
+func (r *externalIdentityRepository) Touch(ctx context.Context, id uint, email string) error {
+	return database.Conn(ctx, r.db).Model(&domain.ExternalIdentity{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"email":         email,
+			"last_login_at": time.Now(),
+		}).Error
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+type passwordHistoryRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordHistoryRepository(db *gorm.DB) domain.PasswordHistoryRepository {
+	return &passwordHistoryRepository{db}
+}
+
+func (r *passwordHistoryRepository) Add(ctx context.Context, userID uint, hash string, keep int) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&domain.PasswordHistory{UserID: userID, Hash: hash}).Error; err != nil {
+			return err
+		}
+		newest := tx.Model(&domain.PasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", userID).
+			Order("id DESC").
+			Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", userID, newest).Delete(&domain.PasswordHistory{}).Error
+	})
+}
+
+func (r *passwordHistoryRepository) Recent(ctx context.Context, userID uint, limit int) ([]string, error) {
+	var hashes []string
+	err := database.Conn(ctx, r.db).Model(&domain.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Pluck("hash", &hashes).Error
+	return hashes, err
+}
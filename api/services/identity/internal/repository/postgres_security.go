@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type throttleRepository struct {
+	db *gorm.DB
+}
+
+func NewThrottleRepository(db *gorm.DB) domain.ThrottleRepository {
+	return &throttleRepository{db}
+}
+
+func (r *throttleRepository) RecordAccountFailure(ctx context.Context, userID uint, window time.Duration) (int, error) {
+	now := time.Now()
+	var user domain.User
+	err := database.Conn(ctx, r.db).Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}}}).
+		Where("id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"failed_logins":        gorm.Expr("CASE WHEN last_failed_login_at IS NULL OR last_failed_login_at < ? THEN 1 ELSE failed_logins + 1 END", now.Add(-window)),
+			"last_failed_login_at": now,
+		}).Error
+	return user.FailedLogins, err
+}
+
+func (r *throttleRepository) LockAccount(ctx context.Context, userID uint, until time.Time) error {
+	return database.Conn(ctx, r.db).Model(&domain.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("locked_until", until).Error
+}
+
+func (r *throttleRepository) ResetAccount(ctx context.Context, userID uint) error {
+	return database.Conn(ctx, r.db).Model(&domain.User{}).
+		Where("id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"failed_logins":        0,
+			"last_failed_login_at": nil,
+			"locked_until":         nil,
+		}).Error
+}
+
+func (r *throttleRepository) GetIP(ctx context.Context, ip string) (*domain.IPThrottle, error) {
+	var throttle domain.IPThrottle
+	err := database.Conn(ctx, r.db).Where("ip = ?", ip).First(&throttle).Error
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (r *throttleRepository) RecordIPFailure(ctx context.Context, ip string, window time.Duration) (int, error) {
+	now := time.Now()
+	throttle := domain.IPThrottle{IP: ip, Failures: 1, LastFailureAt: now}
+	err := database.Conn(ctx, r.db).Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "ip"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("CASE WHEN ip_throttles.last_failure_at < ? THEN 1 ELSE ip_throttles.failures + 1 END", now.Add(-window)),
+				"last_failure_at": now,
+			}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "failures"}}},
+	).Create(&throttle).Error
+	return throttle.Failures, err
+}
+
+func (r *throttleRepository) LockIP(ctx context.Context, ip string, until time.Time) error {
+	return database.Conn(ctx, r.db).Model(&domain.IPThrottle{}).
+		Where("ip = ?", ip).
+		UpdateColumn("locked_until", until).Error
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) domain.AuditRepository {
+	return &auditRepository{db}
+}
+
+func (r *auditRepository) Record(ctx context.Context, entry *domain.AuditLog) error {
+	return database.Conn(ctx, r.db).Create(entry).Error
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) domain.SessionRepository {
+	return &sessionRepository{db}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *domain.Session) error {
+	return database.Conn(ctx, r.db).Create(session).Error
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, id uint) (*domain.Session, error) {
+	var session domain.Session
+	err := database.Conn(ctx, r.db).First(&session, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetByTokenHash(ctx context.Context, hash string) (*domain.Session, error) {
+	var session domain.Session
+	err := database.Conn(ctx, r.db).Where("token_hash = ?", hash).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetByRetiredHash(ctx context.Context, hash string) (*domain.Session, error) {
+	var session domain.Session
+	err := database.Conn(ctx, r.db).
+		Joins("JOIN retired_refresh_tokens ON retired_refresh_tokens.session_id = sessions.id").
+		Where("retired_refresh_tokens.token_hash = ?", hash).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetActiveByUserID(ctx context.Context, userID uint) ([]domain.Session, error) {
+	var sessions []domain.Session
+	err := database.Conn(ctx, r.db).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *sessionRepository) Rotate(ctx context.Context, session *domain.Session, oldHash string) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&domain.Session{}).
+			Where("id = ? AND token_hash = ? AND revoked_at IS NULL", session.ID, oldHash).
+			Updates(map[string]interface{}{
+				"token_hash":   session.TokenHash,
+				"user_agent":   session.UserAgent,
+				"ip":           session.IP,
+				"expires_at":   session.ExpiresAt,
+				"last_used_at": session.LastUsedAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(&domain.RetiredRefreshToken{SessionID: session.ID, TokenHash: oldHash}).Error
+	})
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, id uint) error {
+	return database.Conn(ctx, r.db).Model(&domain.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllByUserID signs the user out everywhere except exceptID, which may
+// be 0 to include every session.
+func (r *sessionRepository) RevokeAllByUserID(ctx context.Context, userID uint, exceptID uint) error {
+	return database.Conn(ctx, r.db).Model(&domain.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepository) RetireLegacyPreviousHashes(ctx context.Context) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if !tx.Migrator().HasColumn(&domain.Session{}, "previous_hash") {
+			return nil
+		}
+		err := tx.Exec(`INSERT INTO retired_refresh_tokens (session_id, token_hash, created_at)
+			SELECT id, previous_hash, ? FROM sessions WHERE previous_hash <> ''
+			ON CONFLICT (token_hash) DO NOTHING`, time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&domain.Session{}, "previous_hash")
+	})
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type twoFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) domain.TwoFactorRepository {
+	return &twoFactorRepository{db}
+}
+
+func (r *twoFactorRepository) CreateChallenge(ctx context.Context, challenge *domain.LoginChallenge) error {
+	return database.Conn(ctx, r.db).Create(challenge).Error
+}
+
+func (r *twoFactorRepository) GetChallengeByTokenHash(ctx context.Context, hash string) (*domain.LoginChallenge, error) {
+	var challenge domain.LoginChallenge
+	err := database.Conn(ctx, r.db).Where("token_hash = ?", hash).First(&challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (r *twoFactorRepository) DeleteChallenge(ctx context.Context, id uint) error {
+	result := database.Conn(ctx, r.db).Delete(&domain.LoginChallenge{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already met by a concurrent request
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) RecordChallengeFailure(ctx context.Context, id uint) (int, error) {
+	var challenge domain.LoginChallenge
+	err := database.Conn(ctx, r.db).Model(&challenge).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "attempts"}}}).
+		Where("id = ?", id).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+	return challenge.Attempts, err
+}
+
+func (r *twoFactorRepository) ClaimTOTPStep(ctx context.Context, userID uint, step int64) error {
+	result := database.Conn(ctx, r.db).Model(&domain.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]domain.RecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, domain.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, hash string) error {
+	result := database.Conn(ctx, r.db).Model(&domain.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) DeleteRecoveryCodes(ctx context.Context, userID uint) error {
+	return database.Conn(ctx, r.db).Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type verificationRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationRepository(db *gorm.DB) domain.VerificationRepository {
+	return &verificationRepository{db}
+}
+
+func (r *verificationRepository) Issue(ctx context.Context, token *domain.VerificationToken) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ?", token.UserID, token.Purpose).
+			Delete(&domain.VerificationToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *verificationRepository) GetLatest(ctx context.Context, userID uint, purpose string) (*domain.VerificationToken, error) {
+	var token domain.VerificationToken
+	err := database.Conn(ctx, r.db).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Order("id DESC").
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *verificationRepository) GetByHash(ctx context.Context, purpose string, hash string) (*domain.VerificationToken, error) {
+	var token domain.VerificationToken
+	err := database.Conn(ctx, r.db).
+		Where("purpose = ? AND token_hash = ? AND used_at IS NULL", purpose, hash).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *verificationRepository) GetLatestByNewEmail(ctx context.Context, purpose string, email string) (*domain.VerificationToken, error) {
+	var token domain.VerificationToken
+	err := database.Conn(ctx, r.db).
+		Where("purpose = ? AND new_email = ? AND used_at IS NULL", purpose, email).
+		Order("id DESC").
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *verificationRepository) RecordFailure(ctx context.Context, id uint) (int, error) {
+	var token domain.VerificationToken
+	err := database.Conn(ctx, r.db).Model(&token).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "attempts"}}}).
+		Where("id = ?", id).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+	return token.Attempts, err
+}
+
+func (r *verificationRepository) Consume(ctx context.Context, id uint) error {
+	result := database.Conn(ctx, r.db).Model(&domain.VerificationToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already used by a concurrent request
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// legacyUserColumns held a plain OTP and reset token on the user row.
+var legacyUserColumns = []string{"otp", "otp_purpose", "otp_expiry", "otp_attempts", "reset_token", "reset_token_expiry"}
+
+func (r *verificationRepository) DropLegacyUserColumns(ctx context.Context) error {
+	migrator := database.Conn(ctx, r.db).Migrator()
+	for _, column := range legacyUserColumns {
+		if !migrator.HasColumn(&domain.User{}, column) {
+			continue
+		}
+		// Codes still pending in them expire within minutes; users ask again
+		if err := migrator.DropColumn(&domain.User{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
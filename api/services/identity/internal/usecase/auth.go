@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
-	"os"
 	"pushtaka/pkg/auth"
+	"pushtaka/pkg/database"
 	"pushtaka/pkg/mail"
+	"pushtaka/pkg/utils"
 	"pushtaka/services/identity/internal/domain"
-	"strconv"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -17,25 +17,31 @@ import (
 
 type authUsecase struct {
 	userRepo       domain.UserRepository
+	sessions       domain.SessionUsecase
+	twoFactor      domain.TwoFactorUsecase
+	guard          domain.LoginGuard
+	verifications  domain.VerificationRepository
+	otpKey         []byte
+	passwords      domain.PasswordPolicy
 	mailSender     mail.Sender
+	publisher      domain.UserPublisher
+	transactor     database.Transactor
 	contextTimeout time.Duration
-	jwtExpiry      time.Duration
-	jwtSecret      string
 }
 
-func NewAuthUsecase(userRepo domain.UserRepository, mailSender mail.Sender, timeout time.Duration) domain.AuthUsecase {
-	expiryHoursStr := os.Getenv("JWT_EXPIRATION_HOURS")
-	expiryHours, err := strconv.Atoi(expiryHoursStr)
-	if err != nil || expiryHours <= 0 {
-		expiryHours = 24 // Default fallback
-	}
-
+func NewAuthUsecase(userRepo domain.UserRepository, sessions domain.SessionUsecase, twoFactor domain.TwoFactorUsecase, guard domain.LoginGuard, verifications domain.VerificationRepository, otpKey []byte, passwords domain.PasswordPolicy, mailSender mail.Sender, publisher domain.UserPublisher, transactor database.Transactor, timeout time.Duration) domain.AuthUsecase {
 	return &authUsecase{
 		userRepo:       userRepo,
+		sessions:       sessions,
+		twoFactor:      twoFactor,
+		guard:          guard,
+		verifications:  verifications,
+		otpKey:         otpKey,
+		passwords:      passwords,
 		mailSender:     mailSender,
+		publisher:      publisher,
+		transactor:     transactor,
 		contextTimeout: timeout,
-		jwtExpiry:      time.Duration(expiryHours) * time.Hour,
-		jwtSecret:      os.Getenv("JWT_SECRET"),
 	}
 }
 
@@ -43,19 +49,9 @@ func (u *authUsecase) Register(c context.Context, req *domain.RegisterRequest) (
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	// 1. Hash Password first
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, err
-	}
-
-	// 2. Generate 6-digit OTP
-	otp := auth.GenerateOTP()
-	otpExpiry := time.Now().Add(5 * time.Minute)
-
-	// 3. Check if user exists (including deleted)
+	// 1. Check if user exists (including deleted)
 	var userToProcess *domain.User
-	existingUser, err := u.userRepo.GetByEmailUnscoped(ctx, req.Email)
+	existingUser, _ := u.userRepo.GetByEmailUnscoped(ctx, req.Email)
 
 	if existingUser != nil {
 		// Check if active or deleted
@@ -63,11 +59,10 @@ func (u *authUsecase) Register(c context.Context, req *domain.RegisterRequest) (
 			// DELETED USER -> RESTORE SEQUENCE
 			existingUser.DeletedAt = gorm.DeletedAt{} // Clear delete flag (Restore)
 			existingUser.Name = req.Name
-			existingUser.Password = string(hashedPassword)
-			existingUser.OTP = otp
-			existingUser.OTPPurpose = "register"
-			existingUser.OTPExpiry = otpExpiry
 			existingUser.IsVerified = false // Require re-verification
+			if err := u.passwords.Set(ctx, existingUser, req.Password); err != nil {
+				return nil, err
+			}
 
 			if err := u.userRepo.Update(ctx, existingUser); err != nil {
 				return nil, err
@@ -80,10 +75,9 @@ func (u *authUsecase) Register(c context.Context, req *domain.RegisterRequest) (
 			}
 			// RE-REGISTER: Update existing unverified user
 			existingUser.Name = req.Name
-			existingUser.Password = string(hashedPassword)
-			existingUser.OTP = otp
-			existingUser.OTPPurpose = "register"
-			existingUser.OTPExpiry = otpExpiry
+			if err := u.passwords.Set(ctx, existingUser, req.Password); err != nil {
+				return nil, err
+			}
 
 			if err := u.userRepo.Update(ctx, existingUser); err != nil {
 				return nil, err
@@ -94,13 +88,12 @@ func (u *authUsecase) Register(c context.Context, req *domain.RegisterRequest) (
 		// NEW REGISTER: Create new user
 		newUser := &domain.User{
 			Email:      req.Email,
-			Password:   string(hashedPassword),
 			Name:       req.Name,
-			OTP:        otp,
-			OTPPurpose: "register",
-			OTPExpiry:  otpExpiry,
 			IsVerified: false,
 		}
+		if err := u.passwords.Set(ctx, newUser, req.Password); err != nil {
+			return nil, err
+		}
 
 		if err := u.userRepo.Create(ctx, newUser); err != nil {
 			return nil, err
@@ -108,8 +101,11 @@ func (u *authUsecase) Register(c context.Context, req *domain.RegisterRequest) (
 		userToProcess = newUser
 	}
 
-	// 4. Send OTP Email
-	log.Printf("DEBUG REGISTER OTP for %s: %s", userToProcess.Email, otp)
+	// 2. Issue a 6-digit OTP, replacing any sent before, and email it
+	otp, err := u.issueOTP(ctx, userToProcess, domain.PurposeRegister, "")
+	if err != nil {
+		return nil, err
+	}
 	if err := u.mailSender.SendOTP(userToProcess.Email, userToProcess.Name, otp); err != nil {
 		log.Printf("Failed to send OTP: %v", err)
 		// return nil, errors.New("failed to send verification email") // Suppress for testing
@@ -126,13 +122,22 @@ func (u *authUsecase) Login(c context.Context, req *domain.LoginRequest) (*domai
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	user, err := u.userRepo.GetByEmail(ctx, req.Email)
-	if err != nil {
+	ip := req.Client.IP
+	user, _ := u.userRepo.GetByEmail(ctx, req.Email)
+	if err := u.guard.Check(ctx, user, ip); err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if err := u.guard.Fail(ctx, nil, ip); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("invalid credentials")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-	if err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := u.guard.Fail(ctx, user, ip); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("invalid credentials")
 	}
 
@@ -140,22 +145,13 @@ func (u *authUsecase) Login(c context.Context, req *domain.LoginRequest) (*domai
 		return nil, errors.New("account not verified")
 	}
 
-	// Generate Token
-	// Get Expiry from Config
-	expiryStr, err := u.userRepo.GetConfig(ctx, "jwt_expiration_hours")
-	expiryDuration := u.jwtExpiry
-	if err == nil {
-		if hours, err := strconv.Atoi(expiryStr); err == nil && hours > 0 {
-			expiryDuration = time.Duration(hours) * time.Hour
-		}
-	}
-	
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, u.jwtSecret, expiryDuration)
-	if err != nil {
-		return nil, err
+	if u.passwords.Expired(ctx, user) {
+		return nil, domain.ErrPasswordExpired
 	}
 
-	return &domain.AuthResponse{Token: token, User: *user}, nil
+	// Sign in on a new device: short-lived access token plus refresh token,
+	// or a challenge first if the account uses two-factor authentication
+	return u.twoFactor.SignIn(ctx, user, req.Client)
 }
 
 func (u *authUsecase) RequestOTP(c context.Context, email string, purpose string) error {
@@ -168,19 +164,18 @@ func (u *authUsecase) RequestOTP(c context.Context, email string, purpose string
 	}
 
 	// Validate purpose
-	if purpose != "change_password" && purpose != "change_email" && purpose != "register" && purpose != "reset_password" {
+	if !isOTPPurpose(purpose) {
 		return errors.New("invalid otp purpose")
 	}
+	// A registration code signs the user in, so it is only for confirming
+	// the email of a new account
+	if purpose == domain.PurposeRegister && user.IsVerified {
+		return errors.New("user already verified")
+	}
 
-	// Generate 6-digit OTP
-	otp := auth.GenerateOTP()
-	expiry := time.Now().Add(5 * time.Minute)
-
-	user.OTP = otp
-	user.OTPPurpose = purpose
-	user.OTPExpiry = expiry
-
-	if err := u.userRepo.Update(ctx, user); err != nil {
+	// Generate 6-digit OTP, replacing any sent before for this purpose
+	otp, err := u.issueOTP(ctx, user, purpose, "")
+	if err != nil {
 		return err
 	}
 
@@ -201,110 +196,144 @@ func (u *authUsecase) ForgotPassword(c context.Context, req *domain.ForgotPasswo
 	return "otp sent", nil
 }
 
+func isOTPPurpose(purpose string) bool {
+	switch purpose {
+	case domain.PurposeRegister, domain.PurposeResetPassword, domain.PurposeChangePassword, domain.PurposeChangeEmail:
+		return true
+	}
+	return false
+}
+
 func (u *authUsecase) ResetPassword(c context.Context, req *domain.ResetPasswordRequest) error {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	// Find by Reset Token instead of Email+OTP (Restoring secure flow)
-	user, err := u.userRepo.GetByResetToken(ctx, req.Token)
+	// Find by the token VerifyOTP handed out instead of Email+OTP
+	token, err := u.findActionToken(ctx, domain.PurposePasswordToken, req.Token)
 	if err != nil {
-		return errors.New("invalid or expired token")
+		return err
+	}
+	user, err := u.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return domain.ErrInvalidVerificationToken
 	}
 
-	// SECURITY CHECK: Ensure token belongs to the requested email
-	// REMOVED: Email is no longer required in request, token is sufficient.
-	// if user.Email != req.Email {
-	// 	return errors.New("invalid token for this email")
-	// }
-
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err := u.passwords.Set(ctx, user, req.NewPassword); err != nil {
+		var invalid *utils.ValidationError
+		if errors.As(err, &invalid) {
+			// Report against the field of this request
+			for i := range invalid.Fields {
+				invalid.Fields[i].Field = "new_password"
+			}
+		}
+		return err
+	}
+	user.TokenVersion++
+	err = u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.consumeActionToken(ctx, token); err != nil {
+			return err
+		}
+		return u.userRepo.Update(ctx, user)
+	})
 	if err != nil {
 		return err
 	}
+	auth.ForgetTokenVersion(user.ID)
 
-	user.Password = string(hashedPassword)
-	user.ResetToken = ""
-	return u.userRepo.Update(ctx, user)
+	// Whoever knew the old password is signed out everywhere
+	return u.sessions.RevokeAll(ctx, user.ID)
 }
 
 func (u *authUsecase) VerifyOTP(c context.Context, req *domain.VerifyOTPRequest) (*domain.AuthResponse, error) {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	user, err := u.userRepo.GetByEmail(ctx, req.Email)
-	if err != nil {
-		return nil, errors.New("invalid email")
+	ip := req.Client.IP
+	user, _ := u.userRepo.GetByEmail(ctx, req.Email)
+	if err := u.guard.Check(ctx, user, ip); err != nil {
+		return nil, err
 	}
-
-	if user.OTP != req.OTP {
-		return nil, errors.New("invalid otp")
+	if user == nil {
+		if err := u.guard.Fail(ctx, nil, ip); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid email")
 	}
 
-	if user.OTPPurpose != req.Purpose {
+	if !isOTPPurpose(req.Purpose) {
 		return nil, errors.New("invalid otp purpose")
 	}
-
-	if time.Now().After(user.OTPExpiry) {
-		return nil, errors.New("otp expired")
+	if req.Purpose == domain.PurposeRegister && user.IsVerified {
+		return nil, errors.New("user already verified")
 	}
 
-	// Verify Success - Clear OTP
-	user.OTP = ""
-	user.OTPPurpose = ""
-	user.OTPExpiry = time.Time{}
+	token, err := u.verifications.GetLatest(ctx, user.ID, req.Purpose)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		// No code was sent for this purpose, still a wrong guess
+		return nil, u.otpFailed(ctx, user, nil, ip, errors.New("invalid otp"))
+	}
+	if err := u.checkOTP(ctx, user, token, req.OTP, ip); err != nil {
+		return nil, err
+	}
 
 	// Purpose Specific Logic
-	if req.Purpose == "register" {
+	switch req.Purpose {
+	case domain.PurposeRegister:
 		user.IsVerified = true
-		if err := u.userRepo.Update(ctx, user); err != nil {
+		err := u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			if err := u.userRepo.Update(ctx, user); err != nil {
+				return err
+			}
+			return u.publisher.PublishUserRegistered(ctx, user)
+		})
+		if err != nil {
 			return nil, err
 		}
 
-		// Generate Login Token
-		token, err := auth.GenerateToken(user.ID, user.Email, user.Role, os.Getenv("JWT_SECRET"), u.jwtExpiry)
+		// Sign the new member in, through the second factor if an admin
+		// role requires one
+		return u.twoFactor.SignIn(ctx, user, req.Client)
+
+	case domain.PurposeResetPassword, domain.PurposeChangePassword:
+		// Single-use token for /auth/reset-password, valid 15 minutes
+		resetToken, err := u.issueActionToken(ctx, user.ID, domain.PurposePasswordToken)
 		if err != nil {
 			return nil, err
 		}
-		return &domain.AuthResponse{Token: token, User: *user}, nil
-
-	} else if req.Purpose == "reset_password" || req.Purpose == "change_password" {
-		// Generate 15-min Reset Token
-		resetToken := "reset-" + auth.GenerateOTP() + "-" + time.Now().Format("20060102150405")
-		user.ResetToken = resetToken
-		user.ResetTokenExpiry = time.Now().Add(15 * time.Minute)
-		
-		if err := u.userRepo.Update(ctx, user); err != nil {
-			return nil, err
-		}
-
-		// Return the reset token in the response
 		return &domain.AuthResponse{Token: resetToken, User: *user}, nil
 
-	} else if req.Purpose == "change_email" {
-		// Generate 15-min Reset Token (reuse ResetToken field for simplicity or rename if needed)
-		// For now we reuse ResetToken field for any secure action token
-		changeToken := "change-email-" + auth.GenerateOTP() + "-" + time.Now().Format("20060102150405")
-		user.ResetToken = changeToken
-		user.ResetTokenExpiry = time.Now().Add(15 * time.Minute)
-
-		if err := u.userRepo.Update(ctx, user); err != nil {
+	case domain.PurposeChangeEmail:
+		// Single-use token for /auth/change-email, valid 15 minutes
+		changeToken, err := u.issueActionToken(ctx, user.ID, domain.PurposeEmailToken)
+		if err != nil {
 			return nil, err
 		}
-
 		return &domain.AuthResponse{Token: changeToken, User: *user}, nil
 	}
 
 	return nil, errors.New("invalid purpose")
 }
 
+// ChangeEmail does not change the email yet: it mails a code to the new
+// address, and ConfirmEmail makes the change once that code comes back.
 func (u *authUsecase) ChangeEmail(c context.Context, req *domain.ChangeEmailRequest) error {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	user, err := u.userRepo.GetByResetToken(ctx, req.Token)
+	if req.NewEmail == "" {
+		return errors.New("invalid email")
+	}
+
+	token, err := u.findActionToken(ctx, domain.PurposeEmailToken, req.Token)
 	if err != nil {
-		return errors.New("invalid or expired token")
+		return err
+	}
+	user, err := u.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return domain.ErrInvalidVerificationToken
 	}
 
 	// Check if new email is taken
@@ -313,9 +342,65 @@ func (u *authUsecase) ChangeEmail(c context.Context, req *domain.ChangeEmailRequ
 		return errors.New("email already taken")
 	}
 
-	user.Email = req.NewEmail
-	user.ResetToken = ""
-	user.ResetTokenExpiry = time.Time{}
+	if err := u.consumeActionToken(ctx, token); err != nil {
+		return err
+	}
+	otp, err := u.issueOTP(ctx, user, domain.PurposeConfirmEmail, req.NewEmail)
+	if err != nil {
+		return err
+	}
+
+	if err := u.mailSender.SendOTP(req.NewEmail, user.Name, otp); err != nil {
+		log.Printf("Failed to send OTP for %s: %v", domain.PurposeConfirmEmail, err)
+		return errors.New("failed to send OTP email")
+	}
+	return nil
+}
+
+func (u *authUsecase) ConfirmEmail(c context.Context, req *domain.ConfirmEmailRequest) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	ip := req.Client.IP
+	if err := u.guard.Check(ctx, nil, ip); err != nil {
+		return err
+	}
+
+	token, err := u.verifications.GetLatestByNewEmail(ctx, domain.PurposeConfirmEmail, req.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err := u.guard.Fail(ctx, nil, ip); err != nil {
+			return err
+		}
+		return errors.New("invalid otp")
+	}
+	user, err := u.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return errors.New("invalid otp")
+	}
+	if err := u.guard.Check(ctx, user, ip); err != nil {
+		return err
+	}
+	if err := u.checkOTP(ctx, user, token, req.OTP, ip); err != nil {
+		return err
+	}
+
+	// Someone may have registered the address in the meantime
+	existing, _ := u.userRepo.GetByEmail(ctx, token.NewEmail)
+	if existing != nil && existing.ID != user.ID {
+		return errors.New("email already taken")
+	}
+
+	user.Email = token.NewEmail
+	user.TokenVersion++
+	if err := u.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	auth.ForgetTokenVersion(user.ID)
 
-	return u.userRepo.Update(ctx, user)
+	// Tokens carry the email, and whoever held the old address may hold a
+	// session: sign out everywhere, as a password reset does
+	return u.sessions.RevokeAll(ctx, user.ID)
 }
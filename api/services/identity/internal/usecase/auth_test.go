@@ -0,0 +1,623 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pushtaka/pkg/events"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestAuth(t *testing.T) (*authUsecase, *fakeUserRepo, *fakeMailer, *fakePublisher, *fakeSessionRepo) {
+	repo := newFakeUserRepo()
+	repo.configs["password_hash_cost"] = "10" // The lowest allowed, to keep the tests quick
+	mailer := &fakeMailer{}
+	publisher := &fakePublisher{}
+	sessionRepo := newFakeSessionRepo()
+	sessions := NewSessionUsecase(sessionRepo, repo, newTestSigner(t), time.Second)
+	guard := NewLoginGuard(repo, newFakeThrottleRepo(repo), &fakeAuditRepo{})
+	twoFactor := NewTwoFactorUsecase(repo, newFakeTwoFactorRepo(repo), sessions, guard, fakeTransactor{}, time.Second)
+	u := NewAuthUsecase(repo, sessions, twoFactor, guard, &fakeVerificationRepo{}, []byte("0123456789abcdef0123456789abcdef"), NewPasswordPolicy(repo, newFakePasswordHistory(), nil), mailer, publisher, fakeTransactor{}, time.Second).(*authUsecase)
+	return u, repo, mailer, publisher, sessionRepo
+}
+
+func TestVerifyRegistrationAnnouncesMemberOnce(t *testing.T) {
+	u, _, mailer, publisher, _ := newTestAuth(t)
+	ctx := context.Background()
+	const email = "siswa@contoh.com"
+
+	if _, err := u.Register(ctx, &domain.RegisterRequest{Email: email, Password: "rahasia123", Name: "Siswa"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.events) != 0 {
+		t.Fatalf("announced %+v before the email was verified", publisher.events)
+	}
+
+	resp, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: mailer.last(email), Purpose: "register"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.User.IsVerified || resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("verified member = %+v, want verified and signed in", resp.User)
+	}
+	want := []publishedEvent{{Type: events.TypeUserRegistered, UserID: resp.User.ID, InTx: true}}
+	if !slices.Equal(publisher.events, want) {
+		t.Fatalf("published %+v, want %+v", publisher.events, want)
+	}
+
+	// A code used once is gone
+	if _, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: mailer.last(email), Purpose: "register"}); err == nil {
+		t.Fatal("the registration code was accepted twice")
+	}
+	if len(publisher.events) != 1 {
+		t.Fatalf("published %+v, want the member announced once", publisher.events)
+	}
+}
+
+func TestRegisterCodeOnlyForNewAccounts(t *testing.T) {
+	const email = "siswa@contoh.com"
+	tests := []struct {
+		name string
+		// setup returns the registration code to verify
+		setup        func(t *testing.T, u *authUsecase, repo *fakeUserRepo, mailer *fakeMailer) string
+		wantErr      bool
+		wantSignedIn bool
+		wantSetup    bool
+	}{
+		{
+			"new account",
+			func(t *testing.T, u *authUsecase, repo *fakeUserRepo, mailer *fakeMailer) string {
+				register(t, u, email)
+				return mailer.last(email)
+			},
+			false, true, false,
+		},
+		{
+			"new admin where two-factor is required",
+			func(t *testing.T, u *authUsecase, repo *fakeUserRepo, mailer *fakeMailer) string {
+				user := repo.users[register(t, u, email)]
+				user.Role = domain.RoleAdmin
+				repo.users[user.ID] = user
+				repo.configs["two_factor_required"] = "true"
+				return mailer.last(email)
+			},
+			false, false, true,
+		},
+		{
+			"verified account asks for a code",
+			func(t *testing.T, u *authUsecase, repo *fakeUserRepo, mailer *fakeMailer) string {
+				register(t, u, email)
+				if err := verify(u, email, mailer.last(email), domain.PurposeRegister); err != nil {
+					t.Fatal(err)
+				}
+				if err := u.RequestOTP(context.Background(), email, domain.PurposeRegister); err == nil {
+					t.Fatal("a registration code was sent to a verified account")
+				}
+				return mailer.last(email)
+			},
+			true, false, false,
+		},
+		{
+			"two-factor account holding a code",
+			func(t *testing.T, u *authUsecase, repo *fakeUserRepo, mailer *fakeMailer) string {
+				enroll(t, u.twoFactor.(*twoFactorUsecase), repo, domain.RoleUser)
+				user := repo.users[1]
+				// A code left from before, however it was obtained
+				code, err := u.issueOTP(context.Background(), &user, domain.PurposeRegister, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return code
+			},
+			true, false, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, mailer, _, sessionRepo := newTestAuth(t)
+			code := tt.setup(t, u, repo, mailer)
+			opened := len(sessionRepo.sessions)
+
+			resp, err := u.VerifyOTP(context.Background(), &domain.VerifyOTPRequest{Email: email, OTP: code, Purpose: domain.PurposeRegister})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			signedIn := len(sessionRepo.sessions) > opened
+			if signedIn != tt.wantSignedIn || (resp != nil && (resp.Token != "") != tt.wantSignedIn) {
+				t.Fatalf("signed in = %v, want %v", signedIn, tt.wantSignedIn)
+			}
+			if tt.wantSetup && (resp.TwoFactor == nil || !resp.TwoFactor.SetupRequired) {
+				t.Fatalf("response = %+v, want a two-factor setup challenge", resp)
+			}
+		})
+	}
+}
+
+func register(t *testing.T, u *authUsecase, email string) uint {
+	t.Helper()
+	resp, err := u.Register(context.Background(), &domain.RegisterRequest{Email: email, Password: "rahasia123", Name: "Siswa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.User.ID
+}
+
+func TestResetPasswordSignsOutEverywhere(t *testing.T) {
+	u, repo, mailer, _, sessions := newTestAuth(t)
+	ctx := context.Background()
+	const email = "siswa@contoh.com"
+	signUp(t, u, repo, email)
+	for i := 0; i < 2; i++ {
+		if _, err := u.sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := u.RequestOTP(ctx, email, domain.PurposeResetPassword); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: mailer.last(email), Purpose: domain.PurposeResetPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.ResetPassword(ctx, &domain.ResetPasswordRequest{Token: "wrong", NewPassword: "baru12345"}); !errors.Is(err, domain.ErrInvalidVerificationToken) {
+		t.Fatalf("reset with an unknown token: err = %v, want ErrInvalidVerificationToken", err)
+	}
+	if err := u.ResetPassword(ctx, &domain.ResetPasswordRequest{Token: verified.Token, NewPassword: "baru12345"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, revoked := range sessions.revoked(1) {
+		if !revoked {
+			t.Errorf("session %d still open after the password was reset", id)
+		}
+	}
+	if user, _ := repo.GetByID(ctx, 1); user.TokenVersion != 1 {
+		t.Fatalf("token version = %d after the reset, want access tokens revoked", user.TokenVersion)
+	}
+	// The token is used up
+	if err := u.ResetPassword(ctx, &domain.ResetPasswordRequest{Token: verified.Token, NewPassword: "lagi12345"}); !errors.Is(err, domain.ErrInvalidVerificationToken) {
+		t.Fatalf("second reset with the same token: err = %v, want ErrInvalidVerificationToken", err)
+	}
+}
+
+// signUp registers a verified member who signs in with rahasia123.
+func signUp(t *testing.T, u *authUsecase, repo *fakeUserRepo, email string) *domain.User {
+	t.Helper()
+	ctx := context.Background()
+	resp, err := u.Register(ctx, &domain.RegisterRequest{Email: email, Password: "rahasia123", Name: "Siswa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := repo.GetByID(ctx, resp.User.ID)
+	user.IsVerified = true
+	repo.Update(ctx, user)
+	return user
+}
+
+func TestLoginLockout(t *testing.T) {
+	const ip, otherIP = "203.0.113.7", "198.51.100.1"
+
+	tests := []struct {
+		name     string
+		configs  map[string]string
+		failures []domain.LoginRequest
+		login    domain.LoginRequest
+		wantErr  error
+	}{
+		{
+			name:     "below the threshold",
+			failures: repeat(4, domain.LoginRequest{Email: "siswa@contoh.com", Password: "salah"}),
+			login:    domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123"},
+		},
+		{
+			name:     "account locked",
+			failures: repeat(5, domain.LoginRequest{Email: "siswa@contoh.com", Password: "salah"}),
+			login:    domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123"},
+			wantErr:  domain.ErrTooManyAttempts,
+		},
+		{
+			name:     "account locked whatever the address",
+			failures: repeat(5, domain.LoginRequest{Email: "siswa@contoh.com", Password: "salah"}),
+			login:    domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123", Client: domain.ClientInfo{IP: otherIP}},
+			wantErr:  domain.ErrTooManyAttempts,
+		},
+		{
+			name:     "address locked by unknown emails",
+			configs:  map[string]string{"login_ip_max_attempts": "3"},
+			failures: repeat(3, domain.LoginRequest{Email: "tidak-ada@contoh.com", Password: "salah"}),
+			login:    domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123"},
+			wantErr:  domain.ErrTooManyAttempts,
+		},
+		{
+			name:     "other addresses still let in",
+			configs:  map[string]string{"login_ip_max_attempts": "3"},
+			failures: repeat(3, domain.LoginRequest{Email: "tidak-ada@contoh.com", Password: "salah"}),
+			login:    domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123", Client: domain.ClientInfo{IP: otherIP}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, _, _, _ := newTestAuth(t)
+			ctx := context.Background()
+			user := signUp(t, u, repo, "siswa@contoh.com")
+			for key, val := range tt.configs {
+				repo.configs[key] = val
+			}
+
+			for _, req := range tt.failures {
+				if req.Client.IP == "" {
+					req.Client.IP = ip
+				}
+				if _, err := u.Login(ctx, &req); err == nil {
+					t.Fatalf("login as %s with a wrong password succeeded", req.Email)
+				}
+			}
+			if tt.login.Client.IP == "" {
+				tt.login.Client.IP = ip
+			}
+			_, err := u.Login(ctx, &tt.login)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			// Signing in clears the failures
+			if user, _ = repo.GetByID(ctx, user.ID); tt.wantErr == nil && user.FailedLogins != 0 {
+				t.Fatalf("%d failures kept after signing in", user.FailedLogins)
+			}
+		})
+	}
+}
+
+func repeat(n int, req domain.LoginRequest) []domain.LoginRequest {
+	reqs := make([]domain.LoginRequest, n)
+	for i := range reqs {
+		reqs[i] = req
+	}
+	return reqs
+}
+
+func TestVerifyOTPWrongGuesses(t *testing.T) {
+	tests := []struct {
+		name      string
+		wrong     int
+		wantSpent bool
+	}{
+		{"a few wrong guesses", 4, false},
+		{"too many wrong guesses", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _, mailer, _, _ := newTestAuth(t)
+			ctx := context.Background()
+			const email = "siswa@contoh.com"
+			if _, err := u.Register(ctx, &domain.RegisterRequest{Email: email, Password: "rahasia123", Name: "Siswa"}); err != nil {
+				t.Fatal(err)
+			}
+			otp := mailer.last(email)
+
+			for i := 0; i < tt.wrong; i++ {
+				if _, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: "000000x", Purpose: "register"}); err == nil {
+					t.Fatal("a wrong code was accepted")
+				}
+			}
+			verifications := u.verifications.(*fakeVerificationRepo)
+			if spent := len(verifications.unused(1)) == 0; spent != tt.wantSpent {
+				t.Fatalf("code thrown away = %v after %d wrong guesses, want %v", spent, tt.wrong, tt.wantSpent)
+			}
+
+			_, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: otp, Purpose: "register"})
+			if (err == nil) == tt.wantSpent {
+				t.Fatalf("right code after %d wrong guesses: err = %v", tt.wrong, err)
+			}
+		})
+	}
+}
+
+func TestVerifyOTPCodes(t *testing.T) {
+	const email = "siswa@contoh.com"
+
+	tests := []struct {
+		name    string
+		verify  func(t *testing.T, u *authUsecase, mailer *fakeMailer) error
+		wantErr bool
+	}{
+		{"code for its purpose", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			return verify(u, email, mailer.last(email), domain.PurposeResetPassword)
+		}, false},
+		{"code for another purpose", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			return verify(u, email, mailer.last(email), domain.PurposeChangeEmail)
+		}, true},
+		{"code used twice", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			code := mailer.last(email)
+			if err := verify(u, email, code, domain.PurposeResetPassword); err != nil {
+				t.Fatal(err)
+			}
+			return verify(u, email, code, domain.PurposeResetPassword)
+		}, true},
+		{"code replaced by a newer one", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			code := mailer.last(email)
+			if err := u.RequestOTP(context.Background(), email, domain.PurposeResetPassword); err != nil {
+				t.Fatal(err)
+			}
+			return verify(u, email, code, domain.PurposeResetPassword)
+		}, true},
+		{"expired code", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			for _, token := range u.verifications.(*fakeVerificationRepo).tokens {
+				token.ExpiresAt = time.Now().Add(-time.Second)
+			}
+			return verify(u, email, mailer.last(email), domain.PurposeResetPassword)
+		}, true},
+		{"unknown purpose", func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+			return verify(u, email, mailer.last(email), "login")
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, mailer, _, _ := newTestAuth(t)
+			signUp(t, u, repo, email)
+			if err := u.RequestOTP(context.Background(), email, domain.PurposeResetPassword); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.verify(t, u, mailer); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOTPHashIsKeyed(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"same key", "0123456789abcdef0123456789abcdef", false},
+		{"key changed", "fedcba9876543210fedcba9876543210", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, mailer, _, _ := newTestAuth(t)
+			ctx := context.Background()
+			const email = "siswa@contoh.com"
+			if _, err := u.Register(ctx, &domain.RegisterRequest{Email: email, Password: "rahasia123", Name: "Siswa"}); err != nil {
+				t.Fatal(err)
+			}
+			user, _ := repo.GetByEmail(ctx, email)
+			code := mailer.last(email)
+
+			// A copy of the table alone does not give the code away
+			token, err := u.verifications.GetLatest(ctx, user.ID, domain.PurposeRegister)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.TokenHash == hashToken(fmt.Sprintf("%d:%s:%s", user.ID, domain.PurposeRegister, code)) {
+				t.Fatal("stored hash is not keyed")
+			}
+
+			u.otpKey = []byte(tt.key)
+			if err := verify(u, email, code, domain.PurposeRegister); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func verify(u *authUsecase, email, code, purpose string) error {
+	_, err := u.VerifyOTP(context.Background(), &domain.VerifyOTPRequest{Email: email, OTP: code, Purpose: purpose})
+	return err
+}
+
+func TestChangeEmailConfirmsNewAddress(t *testing.T) {
+	u, repo, mailer, _, _ := newTestAuth(t)
+	ctx := context.Background()
+	const oldEmail, newEmail = "siswa@contoh.com", "siswa.baru@contoh.com"
+	signUp(t, u, repo, oldEmail)
+	signUp(t, u, repo, "guru@contoh.com")
+
+	// Only a token from a verified change_email code is accepted
+	if err := u.ChangeEmail(ctx, &domain.ChangeEmailRequest{Token: "wrong", NewEmail: newEmail}); !errors.Is(err, domain.ErrInvalidVerificationToken) {
+		t.Fatalf("change with an unknown token: err = %v, want ErrInvalidVerificationToken", err)
+	}
+	if err := u.RequestOTP(ctx, oldEmail, domain.PurposeChangeEmail); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: oldEmail, OTP: mailer.last(oldEmail), Purpose: domain.PurposeChangeEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.ChangeEmail(ctx, &domain.ChangeEmailRequest{Token: verified.Token, NewEmail: "guru@contoh.com"}); err == nil {
+		t.Fatal("changed to an address another member uses")
+	}
+	if err := u.ChangeEmail(ctx, &domain.ChangeEmailRequest{Token: verified.Token, NewEmail: newEmail}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.ChangeEmail(ctx, &domain.ChangeEmailRequest{Token: verified.Token, NewEmail: newEmail}); !errors.Is(err, domain.ErrInvalidVerificationToken) {
+		t.Fatalf("second change with the same token: err = %v, want ErrInvalidVerificationToken", err)
+	}
+
+	// Nothing changes until the new address sends its code back
+	if user, _ := repo.GetByID(ctx, 1); user.Email != oldEmail {
+		t.Fatalf("email = %s before confirmation, want %s", user.Email, oldEmail)
+	}
+	code := mailer.last(newEmail)
+	if code == "" {
+		t.Fatal("no code mailed to the new address")
+	}
+	if err := u.ConfirmEmail(ctx, &domain.ConfirmEmailRequest{Email: newEmail, OTP: "000000x"}); err == nil {
+		t.Fatal("confirmed with a wrong code")
+	}
+	if err := u.ConfirmEmail(ctx, &domain.ConfirmEmailRequest{Email: "lain@contoh.com", OTP: code}); err == nil {
+		t.Fatal("confirmed an address the code was not sent to")
+	}
+	if err := u.ConfirmEmail(ctx, &domain.ConfirmEmailRequest{Email: newEmail, OTP: code}); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := repo.GetByID(ctx, 1); user.Email != newEmail {
+		t.Fatalf("email = %s after confirmation, want %s", user.Email, newEmail)
+	}
+	if err := u.ConfirmEmail(ctx, &domain.ConfirmEmailRequest{Email: newEmail, OTP: code}); err == nil {
+		t.Fatal("confirmation code accepted twice")
+	}
+}
+
+func TestConfirmEmailSignsOutEverywhere(t *testing.T) {
+	const oldEmail, newEmail = "siswa@contoh.com", "siswa.baru@contoh.com"
+	tests := []struct {
+		name string
+		// confirm returns the code to confirm with
+		confirm     func(t *testing.T, u *authUsecase, repo *fakeUserRepo, code string) string
+		wantErr     bool
+		wantEmail   string
+		wantVersion int
+	}{
+		{"confirmed", func(t *testing.T, _ *authUsecase, _ *fakeUserRepo, code string) string { return code }, false, newEmail, 1},
+		{"wrong code", func(*testing.T, *authUsecase, *fakeUserRepo, string) string { return "000000" }, true, oldEmail, 0},
+		{"address taken meanwhile", func(t *testing.T, u *authUsecase, repo *fakeUserRepo, code string) string {
+			signUp(t, u, repo, newEmail)
+			return code
+		}, true, oldEmail, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, mailer, _, sessions := newTestAuth(t)
+			ctx := context.Background()
+			signUp(t, u, repo, oldEmail)
+			for i := 0; i < 2; i++ {
+				if _, err := u.sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := u.RequestOTP(ctx, oldEmail, domain.PurposeChangeEmail); err != nil {
+				t.Fatal(err)
+			}
+			verified, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: oldEmail, OTP: mailer.last(oldEmail), Purpose: domain.PurposeChangeEmail})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := u.ChangeEmail(ctx, &domain.ChangeEmailRequest{Token: verified.Token, NewEmail: newEmail}); err != nil {
+				t.Fatal(err)
+			}
+
+			code := tt.confirm(t, u, repo, mailer.last(newEmail))
+			err = u.ConfirmEmail(ctx, &domain.ConfirmEmailRequest{Email: newEmail, OTP: code})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			user, _ := repo.GetByID(ctx, 1)
+			if user.Email != tt.wantEmail || user.TokenVersion != tt.wantVersion {
+				t.Fatalf("email %s, token version %d; want %s, %d", user.Email, user.TokenVersion, tt.wantEmail, tt.wantVersion)
+			}
+			if len(sessions.revoked(1)) != 2 {
+				t.Fatalf("sessions = %v, want the 2 opened", sessions.revoked(1))
+			}
+			for id, revoked := range sessions.revoked(1) {
+				if revoked != (tt.wantVersion == 1) {
+					t.Errorf("session %d revoked = %v", id, revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyOnAccountChanges(t *testing.T) {
+	const email = "siswa@contoh.com"
+
+	tests := []struct {
+		name      string
+		configs   map[string]string
+		run       func(t *testing.T, u *authUsecase, mailer *fakeMailer) error
+		wantField string
+		wantErr   error
+	}{
+		{
+			name: "register with a short password",
+			run: func(t *testing.T, u *authUsecase, _ *fakeMailer) error {
+				_, err := u.Register(context.Background(), &domain.RegisterRequest{Email: "baru@contoh.com", Password: "pendek", Name: "Baru"})
+				return err
+			},
+			wantField: "password",
+		},
+		{
+			name: "reset to the current password",
+			run: func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+				return resetPassword(t, u, mailer, email, "rahasia123")
+			},
+			wantField: "new_password",
+		},
+		{
+			name:    "reset missing a required class",
+			configs: map[string]string{"password_required_classes": "digit"},
+			run: func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+				return resetPassword(t, u, mailer, email, "tanpa-angka")
+			},
+			wantField: "new_password",
+		},
+		{
+			name: "reset to a new password",
+			run: func(t *testing.T, u *authUsecase, mailer *fakeMailer) error {
+				return resetPassword(t, u, mailer, email, "baru12345")
+			},
+		},
+		{
+			name:    "login with an expired password",
+			configs: map[string]string{"password_max_age_days": "90"},
+			run: func(t *testing.T, u *authUsecase, _ *fakeMailer) error {
+				repo := u.userRepo.(*fakeUserRepo)
+				user, _ := repo.GetByID(context.Background(), 1)
+				changed := time.Now().Add(-91 * 24 * time.Hour)
+				user.PasswordChangedAt = &changed
+				repo.Update(context.Background(), user)
+				_, err := u.Login(context.Background(), &domain.LoginRequest{Email: email, Password: "rahasia123"})
+				return err
+			},
+			wantErr: domain.ErrPasswordExpired,
+		},
+		{
+			name:    "login within the maximum age",
+			configs: map[string]string{"password_max_age_days": "90"},
+			run: func(t *testing.T, u *authUsecase, _ *fakeMailer) error {
+				_, err := u.Login(context.Background(), &domain.LoginRequest{Email: email, Password: "rahasia123"})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, repo, mailer, _, _ := newTestAuth(t)
+			signUp(t, u, repo, email)
+			for key, val := range tt.configs {
+				repo.configs[key] = val
+			}
+
+			err := tt.run(t, u, mailer)
+			if tt.wantField == "" {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			var invalid *utils.ValidationError
+			if !errors.As(err, &invalid) || len(invalid.Fields) == 0 || invalid.Fields[0].Field != tt.wantField {
+				t.Fatalf("err = %v, want a validation error on %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func resetPassword(t *testing.T, u *authUsecase, mailer *fakeMailer, email, password string) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := u.RequestOTP(ctx, email, domain.PurposeResetPassword); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := u.VerifyOTP(ctx, &domain.VerifyOTPRequest{Email: email, OTP: mailer.last(email), Purpose: domain.PurposeResetPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.ResetPassword(ctx, &domain.ResetPasswordRequest{Token: verified.Token, NewPassword: password})
+}
@@ -0,0 +1,583 @@
+package usecase
+
+import (
+	"context"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/client"
+	"pushtaka/pkg/events"
+	"pushtaka/services/identity/internal/domain"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// In-memory stand-ins for the repositories, enough for the usecases under
+// test. Methods a test does not need are left to the embedded interface and
+// panic if called.
+
+type fakeUserRepo struct {
+	domain.UserRepository
+	mu      sync.Mutex
+	configs map[string]string
+	users   map[uint]domain.User
+}
+
+func newFakeUserRepo() *fakeUserRepo {
+	return &fakeUserRepo{configs: make(map[string]string), users: make(map[uint]domain.User)}
+}
+
+func (r *fakeUserRepo) GetConfig(ctx context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	val, ok := r.configs[key]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return val, nil
+}
+
+func (r *fakeUserRepo) GetAllConfigs(ctx context.Context) ([]domain.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var configs []domain.Config
+	for key, val := range r.configs {
+		configs = append(configs, domain.Config{Key: key, Value: val})
+	}
+	return configs, nil
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.ID = uint(len(r.users) + 1)
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUserRepo) GetByID(ctx context.Context, id uint) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (r *fakeUserRepo) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	user, err := r.GetByEmailUnscoped(ctx, email)
+	if err != nil || user.DeletedAt.Valid {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepo) GetByEmailUnscoped(ctx context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) Update(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUserRepo) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return gorm.ErrRecordNotFound
+	}
+	user.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.users[id] = user
+	return nil
+}
+
+func (r *fakeUserRepo) DeletePermanent(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+func (r *fakeUserRepo) DeleteBatch(ctx context.Context, ids []uint, permanent bool) error {
+	for _, id := range ids {
+		var err error
+		if permanent {
+			err = r.DeletePermanent(ctx, id)
+		} else {
+			err = r.Delete(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type inTxKey struct{}
+
+// fakeTransactor marks the context so fakes can tell whether they were
+// called inside a transaction. It does not roll anything back.
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, inTxKey{}, true))
+}
+
+type publishedEvent struct {
+	Type      string
+	UserID    uint
+	Permanent bool
+	InTx      bool
+}
+
+// fakePublisher records the events it was asked to queue.
+type fakePublisher struct {
+	mu     sync.Mutex
+	events []publishedEvent
+}
+
+func (p *fakePublisher) publish(ctx context.Context, event publishedEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	event.InTx, _ = ctx.Value(inTxKey{}).(bool)
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *fakePublisher) PublishUserRegistered(ctx context.Context, user *domain.User) error {
+	return p.publish(ctx, publishedEvent{Type: events.TypeUserRegistered, UserID: user.ID})
+}
+
+func (p *fakePublisher) PublishUserDeleted(ctx context.Context, userID uint, permanent bool) error {
+	return p.publish(ctx, publishedEvent{Type: events.TypeUserDeleted, UserID: userID, Permanent: permanent})
+}
+
+// fakeMailer keeps the last code or token mailed to each address.
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+func (m *fakeMailer) SendOTP(to string, name string, otp string) error {
+	return m.send(to, otp)
+}
+
+func (m *fakeMailer) SendResetPassword(to string, token string) error {
+	return m.send(to, token)
+}
+
+func (m *fakeMailer) send(to, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent == nil {
+		m.sent = make(map[string]string)
+	}
+	m.sent[to] = secret
+	return nil
+}
+
+func (m *fakeMailer) last(to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sent[to]
+}
+
+// fakeObligations answers the pre-delete check from a map of what each
+// member owes. Setting err makes the transaction service unreachable.
+type fakeObligations struct {
+	owed map[uint]client.Obligations
+	err  error
+}
+
+func (f *fakeObligations) UserObligations(ctx context.Context, userID uint) (*client.Obligations, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	o := f.owed[userID]
+	return &o, nil
+}
+
+type fakeSessionRepo struct {
+	domain.SessionRepository
+	sessions map[uint]*domain.Session
+	retired  map[string]uint // Session ID by retired token hash
+	nextID   uint
+}
+
+func newFakeSessionRepo() *fakeSessionRepo {
+	return &fakeSessionRepo{sessions: make(map[uint]*domain.Session), retired: make(map[string]uint)}
+}
+
+func (r *fakeSessionRepo) Create(ctx context.Context, session *domain.Session) error {
+	r.nextID++
+	session.ID = r.nextID
+	stored := *session
+	r.sessions[session.ID] = &stored
+	return nil
+}
+
+func (r *fakeSessionRepo) GetByID(ctx context.Context, id uint) (*domain.Session, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *session
+	return &found, nil
+}
+
+func (r *fakeSessionRepo) GetByTokenHash(ctx context.Context, hash string) (*domain.Session, error) {
+	for _, session := range r.sessions {
+		if session.TokenHash == hash {
+			found := *session
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeSessionRepo) GetByRetiredHash(ctx context.Context, hash string) (*domain.Session, error) {
+	id, ok := r.retired[hash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.GetByID(ctx, id)
+}
+
+func (r *fakeSessionRepo) Rotate(ctx context.Context, session *domain.Session, oldHash string) error {
+	stored, ok := r.sessions[session.ID]
+	if !ok || stored.TokenHash != oldHash || stored.RevokedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	*stored = *session
+	r.retired[oldHash] = session.ID
+	return nil
+}
+
+func (r *fakeSessionRepo) Revoke(ctx context.Context, id uint) error {
+	now := time.Now()
+	r.sessions[id].RevokedAt = &now
+	return nil
+}
+
+func (r *fakeSessionRepo) RevokeAllByUserID(ctx context.Context, userID uint, exceptID uint) error {
+	now := time.Now()
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.ID != exceptID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// revoked reports which of the user's sessions are revoked, by session ID.
+func (r *fakeSessionRepo) revoked(userID uint) map[uint]bool {
+	revoked := make(map[uint]bool)
+	for _, session := range r.sessions {
+		if session.UserID == userID {
+			revoked[session.ID] = session.RevokedAt != nil
+		}
+	}
+	return revoked
+}
+
+func (r *fakeUserRepo) BumpTokenVersion(ctx context.Context, ids []uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if user, ok := r.users[id]; ok {
+			user.TokenVersion++
+			r.users[id] = user
+		}
+	}
+	return nil
+}
+
+// newTestSigner signs with an in-memory key that ParseToken also trusts for
+// the length of the test.
+func newTestSigner(t *testing.T) *auth.Signer {
+	t.Helper()
+	signer, err := auth.NewSigner("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.UseKeys(signer)
+	t.Cleanup(func() { auth.UseKeys(nil) })
+	return signer
+}
+
+type recoveryCode struct {
+	hash string
+	used bool
+}
+
+// fakeTwoFactorRepo claims TOTP steps on the users in users, as the real
+// repository does on the users table.
+type fakeTwoFactorRepo struct {
+	users      *fakeUserRepo
+	challenges map[uint]*domain.LoginChallenge
+	nextID     uint
+	recovery   map[uint][]recoveryCode
+}
+
+func newFakeTwoFactorRepo(users *fakeUserRepo) *fakeTwoFactorRepo {
+	return &fakeTwoFactorRepo{
+		users:      users,
+		challenges: make(map[uint]*domain.LoginChallenge),
+		recovery:   make(map[uint][]recoveryCode),
+	}
+}
+
+func (r *fakeTwoFactorRepo) CreateChallenge(ctx context.Context, challenge *domain.LoginChallenge) error {
+	r.nextID++
+	challenge.ID = r.nextID
+	stored := *challenge
+	r.challenges[challenge.ID] = &stored
+	return nil
+}
+
+func (r *fakeTwoFactorRepo) GetChallengeByTokenHash(ctx context.Context, hash string) (*domain.LoginChallenge, error) {
+	for _, challenge := range r.challenges {
+		if challenge.TokenHash == hash {
+			found := *challenge
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeTwoFactorRepo) DeleteChallenge(ctx context.Context, id uint) error {
+	if _, ok := r.challenges[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.challenges, id)
+	return nil
+}
+
+func (r *fakeTwoFactorRepo) RecordChallengeFailure(ctx context.Context, id uint) (int, error) {
+	challenge, ok := r.challenges[id]
+	if !ok {
+		return 0, gorm.ErrRecordNotFound
+	}
+	challenge.Attempts++
+	return challenge.Attempts, nil
+}
+
+func (r *fakeTwoFactorRepo) ClaimTOTPStep(ctx context.Context, userID uint, step int64) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	user, ok := r.users.users[userID]
+	if !ok || user.TOTPLastStep >= step {
+		return gorm.ErrRecordNotFound
+	}
+	user.TOTPLastStep = step
+	r.users.users[userID] = user
+	return nil
+}
+
+func (r *fakeTwoFactorRepo) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	codes := make([]recoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, recoveryCode{hash: hash})
+	}
+	r.recovery[userID] = codes
+	return nil
+}
+
+func (r *fakeTwoFactorRepo) UseRecoveryCode(ctx context.Context, userID uint, hash string) error {
+	for i, code := range r.recovery[userID] {
+		if code.hash == hash && !code.used {
+			r.recovery[userID][i].used = true
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *fakeTwoFactorRepo) DeleteRecoveryCodes(ctx context.Context, userID uint) error {
+	delete(r.recovery, userID)
+	return nil
+}
+
+type fakeAuditRepo struct {
+	mu      sync.Mutex
+	entries []domain.AuditLog
+}
+
+func (r *fakeAuditRepo) Record(ctx context.Context, entry *domain.AuditLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *fakeAuditRepo) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []string
+	for _, entry := range r.entries {
+		events = append(events, entry.Event)
+	}
+	return events
+}
+
+// fakeVerificationRepo keeps every token ever issued; replaced ones are
+// dropped as the real repository deletes them.
+type fakeVerificationRepo struct {
+	domain.VerificationRepository
+	tokens []*domain.VerificationToken
+	nextID uint
+}
+
+func (r *fakeVerificationRepo) Issue(ctx context.Context, token *domain.VerificationToken) error {
+	kept := r.tokens[:0]
+	for _, t := range r.tokens {
+		if t.UserID != token.UserID || t.Purpose != token.Purpose {
+			kept = append(kept, t)
+		}
+	}
+	r.nextID++
+	token.ID = r.nextID
+	stored := *token
+	r.tokens = append(kept, &stored)
+	return nil
+}
+
+func (r *fakeVerificationRepo) find(match func(t *domain.VerificationToken) bool) (*domain.VerificationToken, error) {
+	for i := len(r.tokens) - 1; i >= 0; i-- {
+		if t := r.tokens[i]; t.UsedAt == nil && match(t) {
+			found := *t
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeVerificationRepo) GetLatest(ctx context.Context, userID uint, purpose string) (*domain.VerificationToken, error) {
+	return r.find(func(t *domain.VerificationToken) bool { return t.UserID == userID && t.Purpose == purpose })
+}
+
+func (r *fakeVerificationRepo) GetByHash(ctx context.Context, purpose string, hash string) (*domain.VerificationToken, error) {
+	return r.find(func(t *domain.VerificationToken) bool { return t.Purpose == purpose && t.TokenHash == hash })
+}
+
+func (r *fakeVerificationRepo) GetLatestByNewEmail(ctx context.Context, purpose string, email string) (*domain.VerificationToken, error) {
+	return r.find(func(t *domain.VerificationToken) bool { return t.Purpose == purpose && t.NewEmail == email })
+}
+
+func (r *fakeVerificationRepo) RecordFailure(ctx context.Context, id uint) (int, error) {
+	for _, t := range r.tokens {
+		if t.ID == id {
+			t.Attempts++
+			return t.Attempts, nil
+		}
+	}
+	return 0, gorm.ErrRecordNotFound
+}
+
+func (r *fakeVerificationRepo) Consume(ctx context.Context, id uint) error {
+	for _, t := range r.tokens {
+		if t.ID == id && t.UsedAt == nil {
+			now := time.Now()
+			t.UsedAt = &now
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// unused lists the purposes of the user's tokens still waiting to be used.
+func (r *fakeVerificationRepo) unused(userID uint) []string {
+	var purposes []string
+	for _, t := range r.tokens {
+		if t.UserID == userID && t.UsedAt == nil {
+			purposes = append(purposes, t.Purpose)
+		}
+	}
+	return purposes
+}
+
+// fakeOIDCProvider answers every code exchange with claims, or err.
+type fakeOIDCProvider struct {
+	cfg    domain.OIDCProviderConfig
+	claims domain.ExternalClaims
+	err    error
+	nonce  string // Sent with the last authorization URL
+}
+
+func (p *fakeOIDCProvider) Config() *domain.OIDCProviderConfig {
+	return &p.cfg
+}
+
+func (p *fakeOIDCProvider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	p.nonce = nonce
+	return p.cfg.Issuer + "/authorize?state=" + state, nil
+}
+
+func (p *fakeOIDCProvider) Exchange(ctx context.Context, code, redirectURI, verifier string) (*domain.ExternalClaims, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	claims := p.claims
+	if claims.Nonce == "" {
+		claims.Nonce = p.nonce
+	}
+	return &claims, nil
+}
+
+type fakeIdentityRepo struct {
+	logins     map[string]*domain.OIDCLogin
+	identities []domain.ExternalIdentity
+}
+
+func newFakeIdentityRepo() *fakeIdentityRepo {
+	return &fakeIdentityRepo{logins: make(map[string]*domain.OIDCLogin)}
+}
+
+func (r *fakeIdentityRepo) CreateLogin(ctx context.Context, login *domain.OIDCLogin) error {
+	r.logins[login.StateHash] = login
+	return nil
+}
+
+func (r *fakeIdentityRepo) TakeLogin(ctx context.Context, stateHash string) (*domain.OIDCLogin, error) {
+	login, ok := r.logins[stateHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.logins, stateHash)
+	return login, nil
+}
+
+func (r *fakeIdentityRepo) Get(ctx context.Context, provider, subject string) (*domain.ExternalIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentityRepo) Create(ctx context.Context, identity *domain.ExternalIdentity) error {
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeIdentityRepo) Touch(ctx context.Context, id uint, email string) error {
+	return nil
+}
@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"pushtaka/services/identity/internal/domain"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// failureWindow is how long failures are remembered after the last one.
+	failureWindow = 24 * time.Hour
+	lockoutBase   = time.Minute
+	lockoutMax    = time.Hour
+)
+
+// Defaults for the login_max_attempts, login_ip_max_attempts and
+// otp_max_attempts settings. The IP limit is higher because a school's
+// students may all sign in from one address.
+const (
+	defaultAccountAttempts = 5
+	defaultIPAttempts      = 50
+	defaultCodeAttempts    = 5
+)
+
+type loginGuard struct {
+	userRepo     domain.UserRepository
+	throttleRepo domain.ThrottleRepository
+	auditRepo    domain.AuditRepository
+}
+
+func NewLoginGuard(userRepo domain.UserRepository, throttleRepo domain.ThrottleRepository, auditRepo domain.AuditRepository) domain.LoginGuard {
+	return &loginGuard{
+		userRepo:     userRepo,
+		throttleRepo: throttleRepo,
+		auditRepo:    auditRepo,
+	}
+}
+
+func (g *loginGuard) Check(ctx context.Context, user *domain.User, ip string) error {
+	now := time.Now()
+	if user != nil && user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return domain.ErrTooManyAttempts
+	}
+	if ip == "" {
+		return nil
+	}
+
+	throttle, err := g.throttleRepo.GetIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+		return domain.ErrTooManyAttempts
+	}
+	return nil
+}
+
+func (g *loginGuard) Fail(ctx context.Context, user *domain.User, ip string) error {
+	if user != nil {
+		failures, err := g.throttleRepo.RecordAccountFailure(ctx, user.ID, failureWindow)
+		if err != nil {
+			return err
+		}
+		user.FailedLogins = failures
+		if threshold := g.setting(ctx, "login_max_attempts", defaultAccountAttempts); failures >= threshold {
+			until := time.Now().Add(lockoutFor(failures, threshold))
+			if err := g.throttleRepo.LockAccount(ctx, user.ID, until); err != nil {
+				return err
+			}
+			user.LockedUntil = &until
+			err := g.audit(ctx, &domain.AuditLog{
+				Event:  domain.AuditAccountLocked,
+				UserID: &user.ID,
+				IP:     ip,
+				Detail: fmt.Sprintf("%d failed attempts, locked until %s", failures, until.Format(time.RFC3339)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	failures, err := g.throttleRepo.RecordIPFailure(ctx, ip, failureWindow)
+	if err != nil {
+		return err
+	}
+	if threshold := g.setting(ctx, "login_ip_max_attempts", defaultIPAttempts); failures >= threshold {
+		until := time.Now().Add(lockoutFor(failures, threshold))
+		if err := g.throttleRepo.LockIP(ctx, ip, until); err != nil {
+			return err
+		}
+		return g.audit(ctx, &domain.AuditLog{
+			Event:  domain.AuditIPLocked,
+			IP:     ip,
+			Detail: fmt.Sprintf("%d failed attempts, locked until %s", failures, until.Format(time.RFC3339)),
+		})
+	}
+	return nil
+}
+
+func (g *loginGuard) FailCode(ctx context.Context, user *domain.User, ip string, attempts int, event string) (bool, error) {
+	if err := g.Fail(ctx, user, ip); err != nil {
+		return false, err
+	}
+	if attempts < g.setting(ctx, "otp_max_attempts", defaultCodeAttempts) {
+		return false, nil
+	}
+	err := g.audit(ctx, &domain.AuditLog{
+		Event:  event,
+		UserID: &user.ID,
+		IP:     ip,
+		Detail: fmt.Sprintf("%d wrong codes", attempts),
+	})
+	return true, err
+}
+
+func (g *loginGuard) Succeed(ctx context.Context, user *domain.User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return g.throttleRepo.ResetAccount(ctx, user.ID)
+}
+
+func (g *loginGuard) audit(ctx context.Context, entry *domain.AuditLog) error {
+	log.Printf("AUDIT %s user=%v ip=%s: %s", entry.Event, derefID(entry.UserID), entry.IP, entry.Detail)
+	return g.auditRepo.Record(ctx, entry)
+}
+
+func (g *loginGuard) setting(ctx context.Context, key string, fallback int) int {
+	if val, err := g.userRepo.GetConfig(ctx, key); err == nil {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// lockoutFor doubles the lockout with every failure past the threshold:
+// 1, 2, 4 ... minutes, up to an hour.
+func lockoutFor(failures int, threshold int) time.Duration {
+	d := lockoutBase << min(failures-threshold, 6)
+	return min(d, lockoutMax)
+}
+
+func derefID(id *uint) uint {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
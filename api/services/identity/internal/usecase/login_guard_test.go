@@ -0,0 +1,243 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/services/identity/internal/domain"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeThrottleRepo keeps account failures in its own maps and, like the
+// real repository, on the user's row when the user is in users.
+type fakeThrottleRepo struct {
+	users           *fakeUserRepo
+	accountFailures map[uint]int
+	accountLocks    map[uint]time.Time
+	ips             map[string]*domain.IPThrottle
+}
+
+func newFakeThrottleRepo(users *fakeUserRepo) *fakeThrottleRepo {
+	return &fakeThrottleRepo{
+		users:           users,
+		accountFailures: make(map[uint]int),
+		accountLocks:    make(map[uint]time.Time),
+		ips:             make(map[string]*domain.IPThrottle),
+	}
+}
+
+func (r *fakeThrottleRepo) updateUser(userID uint, fn func(user *domain.User)) {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	if user, ok := r.users.users[userID]; ok {
+		fn(&user)
+		r.users.users[userID] = user
+	}
+}
+
+func (r *fakeThrottleRepo) RecordAccountFailure(ctx context.Context, userID uint, window time.Duration) (int, error) {
+	r.accountFailures[userID]++
+	failures := r.accountFailures[userID]
+	r.updateUser(userID, func(user *domain.User) { user.FailedLogins = failures })
+	return failures, nil
+}
+
+func (r *fakeThrottleRepo) LockAccount(ctx context.Context, userID uint, until time.Time) error {
+	r.accountLocks[userID] = until
+	r.updateUser(userID, func(user *domain.User) { user.LockedUntil = &until })
+	return nil
+}
+
+func (r *fakeThrottleRepo) ResetAccount(ctx context.Context, userID uint) error {
+	delete(r.accountFailures, userID)
+	delete(r.accountLocks, userID)
+	r.updateUser(userID, func(user *domain.User) { user.FailedLogins, user.LockedUntil = 0, nil })
+	return nil
+}
+
+func (r *fakeThrottleRepo) GetIP(ctx context.Context, ip string) (*domain.IPThrottle, error) {
+	throttle, ok := r.ips[ip]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return throttle, nil
+}
+
+func (r *fakeThrottleRepo) RecordIPFailure(ctx context.Context, ip string, window time.Duration) (int, error) {
+	throttle, ok := r.ips[ip]
+	if !ok {
+		throttle = &domain.IPThrottle{IP: ip}
+		r.ips[ip] = throttle
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = time.Now()
+	return throttle.Failures, nil
+}
+
+func (r *fakeThrottleRepo) LockIP(ctx context.Context, ip string, until time.Time) error {
+	r.ips[ip].LockedUntil = &until
+	return nil
+}
+
+func TestLockoutFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 8 * time.Minute},
+		{10, 32 * time.Minute},
+		{11, time.Hour}, // 64 minutes, capped
+		{50, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := lockoutFor(tt.failures, 5); got != tt.want {
+			t.Errorf("lockoutFor(%d, 5) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func newTestGuard() (*loginGuard, *fakeUserRepo, *fakeThrottleRepo, *fakeAuditRepo) {
+	users := newFakeUserRepo()
+	throttles := newFakeThrottleRepo(users)
+	audits := &fakeAuditRepo{}
+	return NewLoginGuard(users, throttles, audits).(*loginGuard), users, throttles, audits
+}
+
+// lockedFor is how long from now the lock lasts, to the second.
+func lockedFor(until *time.Time) time.Duration {
+	if until == nil {
+		return 0
+	}
+	return time.Until(*until).Round(time.Second)
+}
+
+func TestLoginGuardLocksAccountWithBackoff(t *testing.T) {
+	guard, _, _, audits := newTestGuard()
+	ctx := context.Background()
+	user := &domain.User{ID: 1}
+
+	for i := 1; i < defaultAccountAttempts; i++ {
+		if err := guard.Fail(ctx, user, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := guard.Check(ctx, user, ""); err != nil {
+			t.Fatalf("locked after %d failures: %v", i, err)
+		}
+	}
+
+	// Each failure from the threshold on doubles the lockout
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		if err := guard.Fail(ctx, user, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got := lockedFor(user.LockedUntil); got != want {
+			t.Fatalf("after %d failures locked for %v, want %v", user.FailedLogins, got, want)
+		}
+		if err := guard.Check(ctx, user, ""); !errors.Is(err, domain.ErrTooManyAttempts) {
+			t.Fatalf("Check while locked = %v, want ErrTooManyAttempts", err)
+		}
+	}
+	if got := audits.events(); len(got) != 3 || got[0] != domain.AuditAccountLocked {
+		t.Fatalf("audited %v, want 3 account locks", got)
+	}
+
+	// Once the lock has passed the user may try again
+	past := time.Now().Add(-time.Second)
+	user.LockedUntil = &past
+	if err := guard.Check(ctx, user, ""); err != nil {
+		t.Fatalf("Check after the lock = %v", err)
+	}
+}
+
+func TestLoginGuardThresholdSetting(t *testing.T) {
+	guard, users, _, _ := newTestGuard()
+	ctx := context.Background()
+	users.configs["login_max_attempts"] = "2"
+	user := &domain.User{ID: 1}
+
+	guard.Fail(ctx, user, "")
+	if user.LockedUntil != nil {
+		t.Fatal("locked after 1 failure with login_max_attempts 2")
+	}
+	guard.Fail(ctx, user, "")
+	if got := lockedFor(user.LockedUntil); got != time.Minute {
+		t.Fatalf("after 2 failures locked for %v, want 1m", got)
+	}
+
+	// Nonsense falls back to the default
+	users.configs["login_max_attempts"] = "zero"
+	if got := guard.setting(ctx, "login_max_attempts", defaultAccountAttempts); got != defaultAccountAttempts {
+		t.Fatalf("setting with an invalid value = %d, want %d", got, defaultAccountAttempts)
+	}
+}
+
+func TestLoginGuardLocksIP(t *testing.T) {
+	guard, users, throttles, audits := newTestGuard()
+	ctx := context.Background()
+	users.configs["login_ip_max_attempts"] = "3"
+	const ip = "203.0.113.7"
+
+	// Unknown emails count against the address too
+	for i := 0; i < 3; i++ {
+		if err := guard.Fail(ctx, nil, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lockedFor(throttles.ips[ip].LockedUntil); got != time.Minute {
+		t.Fatalf("IP locked for %v, want 1m", got)
+	}
+	if err := guard.Check(ctx, &domain.User{ID: 2}, ip); !errors.Is(err, domain.ErrTooManyAttempts) {
+		t.Fatalf("Check from the locked IP = %v, want ErrTooManyAttempts", err)
+	}
+	if err := guard.Check(ctx, &domain.User{ID: 2}, "198.51.100.1"); err != nil {
+		t.Fatalf("Check from another IP = %v", err)
+	}
+	if !slices.Contains(audits.events(), domain.AuditIPLocked) {
+		t.Fatalf("audited %v, want an IP lock", audits.events())
+	}
+}
+
+func TestLoginGuardFailCode(t *testing.T) {
+	guard, _, throttles, audits := newTestGuard()
+	ctx := context.Background()
+	user := &domain.User{ID: 1}
+
+	for attempts := 1; attempts < defaultCodeAttempts; attempts++ {
+		spent, err := guard.FailCode(ctx, user, "", attempts, domain.AuditOTPInvalidated)
+		if err != nil || spent {
+			t.Fatalf("FailCode(%d) = %v, %v; want not spent", attempts, spent, err)
+		}
+	}
+	spent, err := guard.FailCode(ctx, user, "", defaultCodeAttempts, domain.AuditOTPInvalidated)
+	if err != nil || !spent {
+		t.Fatalf("FailCode(%d) = %v, %v; want spent", defaultCodeAttempts, spent, err)
+	}
+	if !slices.Contains(audits.events(), domain.AuditOTPInvalidated) {
+		t.Fatalf("audited %v, want the code thrown away", audits.events())
+	}
+	// Wrong codes count towards the account lockout as well
+	if throttles.accountFailures[user.ID] != defaultCodeAttempts {
+		t.Fatalf("account failures = %d, want %d", throttles.accountFailures[user.ID], defaultCodeAttempts)
+	}
+}
+
+func TestLoginGuardSucceedResets(t *testing.T) {
+	guard, _, throttles, _ := newTestGuard()
+	ctx := context.Background()
+	user := &domain.User{ID: 1}
+
+	guard.Fail(ctx, user, "")
+	if err := guard.Succeed(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := throttles.accountFailures[user.ID]; ok {
+		t.Fatal("failures kept after a successful sign-in")
+	}
+}
@@ -0,0 +1,348 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute
+	// providerTimeout is added to the usual timeout for the round trips to
+	// the provider: discovery, token exchange and signing keys.
+	providerTimeout = 10 * time.Second
+)
+
+type oidcUsecase struct {
+	providers      map[string]domain.OIDCProvider
+	userRepo       domain.UserRepository
+	identities     domain.ExternalIdentityRepository
+	twoFactor      domain.TwoFactorUsecase
+	publisher      domain.UserPublisher
+	transactor     database.Transactor
+	contextTimeout time.Duration
+}
+
+func NewOIDCUsecase(providers map[string]domain.OIDCProvider, userRepo domain.UserRepository, identities domain.ExternalIdentityRepository, twoFactor domain.TwoFactorUsecase, publisher domain.UserPublisher, transactor database.Transactor, timeout time.Duration) domain.OIDCUsecase {
+	return &oidcUsecase{
+		providers:      providers,
+		userRepo:       userRepo,
+		identities:     identities,
+		twoFactor:      twoFactor,
+		publisher:      publisher,
+		transactor:     transactor,
+		contextTimeout: timeout,
+	}
+}
+
+func (u *oidcUsecase) Providers() []string {
+	names := make([]string, 0, len(u.providers))
+	for name := range u.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (u *oidcUsecase) Start(c context.Context, name string, req *domain.OIDCStartRequest) (*domain.OIDCStartResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout+providerTimeout)
+	defer cancel()
+	return u.start(ctx, name, req, nil)
+}
+
+func (u *oidcUsecase) StartLink(c context.Context, userID uint, name string, req *domain.OIDCStartRequest) (*domain.OIDCStartResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout+providerTimeout)
+	defer cancel()
+	return u.start(ctx, name, req, &userID)
+}
+
+// start sends the user to the provider, to sign in or, with linkUserID, to
+// link the provider account to that user.
+func (u *oidcUsecase) start(ctx context.Context, name string, req *domain.OIDCStartRequest, linkUserID *uint) (*domain.OIDCStartResponse, error) {
+	provider, ok := u.providers[name]
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+	cfg := provider.Config()
+	redirectURI := cfg.RedirectURIs[0]
+	if req.RedirectURI != "" {
+		if !slices.Contains(cfg.RedirectURIs, req.RedirectURI) {
+			return nil, domain.ErrInvalidRedirectURI
+		}
+		redirectURI = req.RedirectURI
+	}
+
+	// 32 random bytes each; base64url fits the PKCE verifier alphabet
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, redirectURI, state, nonce, pkceChallenge(verifier))
+	if err != nil {
+		return nil, err
+	}
+	err = u.identities.CreateLogin(ctx, &domain.OIDCLogin{
+		StateHash:    stateHash,
+		Provider:     name,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.OIDCStartResponse{
+		AuthorizationURL: authURL,
+		State:            state,
+		ExpiresIn:        int(oidcLoginTTL.Seconds()),
+	}, nil
+}
+
+func (u *oidcUsecase) Callback(c context.Context, name string, req *domain.OIDCCallbackRequest) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout+providerTimeout)
+	defer cancel()
+
+	claims, err := u.exchange(ctx, name, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := u.linkedUser(ctx, name, u.providers[name].Config(), claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts with two-factor authentication still need their second factor
+	return u.twoFactor.SignIn(ctx, user, req.Client)
+}
+
+func (u *oidcUsecase) Link(c context.Context, userID uint, name string, req *domain.OIDCCallbackRequest) (*domain.ExternalIdentity, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout+providerTimeout)
+	defer cancel()
+
+	claims, err := u.exchange(ctx, name, req, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := u.identities.Get(ctx, name, claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, domain.ErrExternalIdentityLinked
+		}
+		return identity, u.identities.Touch(ctx, identity.ID, claims.Email)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Not provisioned: the user keeps their role whatever their groups are
+	identity = &domain.ExternalIdentity{
+		UserID:      userID,
+		Provider:    name,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: time.Now(),
+	}
+	if err := u.identities.Create(ctx, identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// exchange takes the sign-in started for state and redeems code for the
+// provider's verified claims. linkUserID must match the user the sign-in was
+// started for, nil for a plain sign-in.
+func (u *oidcUsecase) exchange(ctx context.Context, name string, req *domain.OIDCCallbackRequest, linkUserID *uint) (*domain.ExternalClaims, error) {
+	provider, ok := u.providers[name]
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+	if req.State == "" || req.Code == "" {
+		return nil, domain.ErrInvalidOIDCState
+	}
+
+	login, err := u.identities.TakeLogin(ctx, hashToken(req.State))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	if login.Provider != name || time.Now().After(login.ExpiresAt) {
+		return nil, domain.ErrInvalidOIDCState
+	}
+	// A link must finish as the user who started it, and a sign-in must not
+	// finish a link
+	if (login.LinkUserID == nil) != (linkUserID == nil) || (linkUserID != nil && *login.LinkUserID != *linkUserID) {
+		return nil, domain.ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Exchange(ctx, req.Code, login.RedirectURI, login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC sign-in with %s failed: %v", name, err)
+		return nil, domain.ErrExternalLoginFailed
+	}
+	// The ID token must answer this sign-in, not one replayed from another
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(login.Nonce)) != 1 {
+		log.Printf("OIDC sign-in with %s failed: nonce mismatch", name)
+		return nil, domain.ErrExternalLoginFailed
+	}
+	return claims, nil
+}
+
+// linkedUser returns the user linked to the provider account. On the first
+// sign-in it creates a user for the verified email, or takes over an account
+// with that email nobody can sign in to. A local account in use is never
+// linked by email alone: its owner links it with StartLink and Link.
+func (u *oidcUsecase) linkedUser(ctx context.Context, name string, cfg *domain.OIDCProviderConfig, claims *domain.ExternalClaims) (*domain.User, error) {
+	identity, err := u.identities.Get(ctx, name, claims.Subject)
+	if err == nil {
+		user, err := u.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The linked account has been deleted
+				return nil, domain.ErrExternalLoginFailed
+			}
+			return nil, err
+		}
+		if err := u.identities.Touch(ctx, identity.ID, claims.Email); err != nil {
+			return nil, err
+		}
+		if !identity.Provisioned {
+			return user, nil
+		}
+		return user, u.syncRole(ctx, user, cfg, claims.Groups)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// First sign-in with this provider account
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, domain.ErrExternalEmailNeeded
+	}
+
+	var user *domain.User
+	err = u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		existing, _ := u.userRepo.GetByEmailUnscoped(ctx, claims.Email)
+
+		switch {
+		case existing == nil:
+			user = &domain.User{
+				Email:      claims.Email,
+				Name:       displayName(claims),
+				Role:       roleForGroups(cfg.RoleMap, claims.Groups),
+				IsVerified: true, // The provider vouches for the address
+			}
+			if err := u.userRepo.Create(ctx, user); err != nil {
+				return err
+			}
+		case existing.DeletedAt.Valid || !existing.IsVerified:
+			// A deleted account, or a registration never verified: whoever
+			// set its password may not own the address, so it goes
+			user = existing
+			user.DeletedAt = gorm.DeletedAt{}
+			user.Password = ""
+			user.IsVerified = true
+			if existing.DeletedAt.Valid {
+				user.Role = roleForGroups(cfg.RoleMap, claims.Groups)
+			}
+			if user.Name == "" {
+				user.Name = displayName(claims)
+			}
+			if err := u.userRepo.Update(ctx, user); err != nil {
+				return err
+			}
+		default:
+			return domain.ErrExternalAccountExists
+		}
+
+		err := u.identities.Create(ctx, &domain.ExternalIdentity{
+			UserID:      user.ID,
+			Provider:    name,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			Provisioned: true,
+			LastLoginAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		return u.publisher.PublishUserRegistered(ctx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, u.syncRole(ctx, user, cfg, claims.Groups)
+}
+
+// syncRole gives the user the role their groups map to, when the provider
+// maps groups at all.
+func (u *oidcUsecase) syncRole(ctx context.Context, user *domain.User, cfg *domain.OIDCProviderConfig, groups []string) error {
+	if len(cfg.RoleMap) == 0 {
+		return nil
+	}
+	role := roleForGroups(cfg.RoleMap, groups)
+	if role == user.Role {
+		return nil
+	}
+
+	// Tokens issued under the old role stop working
+	user.Role = role
+	user.TokenVersion++
+	if err := u.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	auth.ForgetTokenVersion(user.ID)
+	return nil
+}
+
+// roleForGroups picks, among the roles the groups map to, the one with the
+// most permissions. Without a match the user is a member.
+func roleForGroups(roleMap map[string]string, groups []string) string {
+	best := domain.RoleUser
+	for _, group := range groups {
+		if role, ok := roleMap[group]; ok && len(auth.PermissionsFor(role)) > len(auth.PermissionsFor(best)) {
+			best = role
+		}
+	}
+	return best
+}
+
+func displayName(claims *domain.ExternalClaims) string {
+	if claims.Name != "" {
+		return claims.Name
+	}
+	name, _, _ := strings.Cut(claims.Email, "@")
+	return name
+}
+
+// pkceChallenge is the S256 code challenge for verifier (RFC 7636).
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
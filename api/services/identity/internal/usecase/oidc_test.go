@@ -0,0 +1,322 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/events"
+	"pushtaka/services/identity/internal/domain"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTestOIDC(t *testing.T) (*oidcUsecase, *fakeOIDCProvider, *fakeUserRepo, *fakeIdentityRepo, *fakePublisher) {
+	t.Helper()
+	twoFactor, users, _, _ := newTestTwoFactor(t)
+	provider := &fakeOIDCProvider{
+		cfg: domain.OIDCProviderConfig{
+			Name:         "school",
+			Issuer:       "https://idp.test",
+			RedirectURIs: []string{"https://app.test/callback", "pushtaka://callback"},
+			RoleMap:      map[string]string{"librarians": auth.RoleLibrarian},
+		},
+		claims: domain.ExternalClaims{Subject: "s-1", Email: "siswa@sekolah.sch.id", EmailVerified: true, Name: "Siswa"},
+	}
+	identities := newFakeIdentityRepo()
+	publisher := &fakePublisher{}
+	u := NewOIDCUsecase(map[string]domain.OIDCProvider{"school": provider}, users, identities, twoFactor, publisher, fakeTransactor{}, time.Second).(*oidcUsecase)
+	return u, provider, users, identities, publisher
+}
+
+func TestOIDCStart(t *testing.T) {
+	tests := []struct {
+		name         string
+		provider     string
+		redirectURI  string
+		wantErr      error
+		wantRedirect string
+	}{
+		{"default redirect", "school", "", nil, "https://app.test/callback"},
+		{"registered redirect", "school", "pushtaka://callback", nil, "pushtaka://callback"},
+		{"unregistered redirect", "school", "https://evil.test/callback", domain.ErrInvalidRedirectURI, ""},
+		{"unknown provider", "other", "", domain.ErrUnknownProvider, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _, _, identities, _ := newTestOIDC(t)
+
+			res, err := u.Start(context.Background(), tt.provider, &domain.OIDCStartRequest{RedirectURI: tt.redirectURI})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(identities.logins) != 0 {
+					t.Fatal("a sign-in was stored")
+				}
+				return
+			}
+			login, ok := identities.logins[hashToken(res.State)]
+			if !ok {
+				t.Fatal("sign-in not stored under the state hash")
+			}
+			if login.RedirectURI != tt.wantRedirect || login.CodeVerifier == "" || login.Nonce == "" {
+				t.Fatalf("login = %+v", login)
+			}
+		})
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, provider *fakeOIDCProvider, users *fakeUserRepo, identities *fakeIdentityRepo)
+		state   func(state string) string
+		wantErr error
+	}{
+		{"new account", nil, nil, nil},
+		{"missing state", nil, func(string) string { return "" }, domain.ErrInvalidOIDCState},
+		{"unknown state", nil, func(string) string { return "forged" }, domain.ErrInvalidOIDCState},
+		{
+			"expired state",
+			func(t *testing.T, _ *fakeOIDCProvider, _ *fakeUserRepo, identities *fakeIdentityRepo) {
+				for _, login := range identities.logins {
+					login.ExpiresAt = time.Now().Add(-time.Second)
+				}
+			},
+			nil, domain.ErrInvalidOIDCState,
+		},
+		{
+			"state from another provider",
+			func(t *testing.T, _ *fakeOIDCProvider, _ *fakeUserRepo, identities *fakeIdentityRepo) {
+				for _, login := range identities.logins {
+					login.Provider = "other"
+				}
+			},
+			nil, domain.ErrInvalidOIDCState,
+		},
+		{
+			"exchange fails",
+			func(t *testing.T, provider *fakeOIDCProvider, _ *fakeUserRepo, _ *fakeIdentityRepo) {
+				provider.err = errors.New("invalid_grant")
+			},
+			nil, domain.ErrExternalLoginFailed,
+		},
+		{
+			"nonce mismatch",
+			func(t *testing.T, provider *fakeOIDCProvider, _ *fakeUserRepo, _ *fakeIdentityRepo) {
+				provider.claims.Nonce = "nonce-of-another-sign-in"
+			},
+			nil, domain.ErrExternalLoginFailed,
+		},
+		{
+			"email not verified",
+			func(t *testing.T, provider *fakeOIDCProvider, _ *fakeUserRepo, _ *fakeIdentityRepo) {
+				provider.claims.EmailVerified = false
+			},
+			nil, domain.ErrExternalEmailNeeded,
+		},
+		{
+			"linked account deleted",
+			func(t *testing.T, _ *fakeOIDCProvider, users *fakeUserRepo, identities *fakeIdentityRepo) {
+				user := &domain.User{Email: "siswa@sekolah.sch.id", IsVerified: true}
+				users.Create(context.Background(), user)
+				identities.Create(context.Background(), &domain.ExternalIdentity{UserID: user.ID, Provider: "school", Subject: "s-1"})
+				users.Delete(context.Background(), user.ID)
+			},
+			nil, domain.ErrExternalLoginFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, provider, users, identities, publisher := newTestOIDC(t)
+			ctx := context.Background()
+
+			start, err := u.Start(ctx, "school", &domain.OIDCStartRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, provider, users, identities)
+			}
+			state := start.State
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+
+			res, err := u.Callback(ctx, "school", &domain.OIDCCallbackRequest{State: state, Code: "code"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(publisher.events) != 0 {
+					t.Fatalf("events published: %+v", publisher.events)
+				}
+				return
+			}
+			if res.Token == "" {
+				t.Fatal("no access token")
+			}
+			user, err := users.GetByID(ctx, res.User.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !user.IsVerified || user.Password != "" || user.Role != auth.RoleMember || user.Name != "Siswa" {
+				t.Fatalf("user = %+v", user)
+			}
+			if len(publisher.events) != 1 || publisher.events[0].Type != events.TypeUserRegistered || !publisher.events[0].InTx {
+				t.Fatalf("events = %+v, want user.registered in the transaction", publisher.events)
+			}
+		})
+	}
+}
+
+func TestOIDCFirstSignInWithExistingEmail(t *testing.T) {
+	tests := []struct {
+		name         string
+		existing     domain.User
+		wantErr      error
+		wantPassword string
+		wantRole     string
+	}{
+		{"account in use", domain.User{Password: "hash", Role: auth.RoleAdmin, IsVerified: true}, domain.ErrExternalAccountExists, "hash", auth.RoleAdmin},
+		{"unverified registration", domain.User{Password: "hash", Role: auth.RoleMember}, nil, "", auth.RoleMember},
+		{"deleted account", domain.User{Password: "hash", Role: auth.RoleAdmin, IsVerified: true, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}, nil, "", auth.RoleMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _, users, identities, publisher := newTestOIDC(t)
+			ctx := context.Background()
+			existing := tt.existing
+			existing.Email = "siswa@sekolah.sch.id"
+			users.Create(ctx, &existing)
+
+			start, err := u.Start(ctx, "school", &domain.OIDCStartRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = u.Callback(ctx, "school", &domain.OIDCCallbackRequest{State: start.State, Code: "code"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			user := users.users[existing.ID]
+			if user.Password != tt.wantPassword || user.Role != tt.wantRole || len(users.users) != 1 {
+				t.Fatalf("user = %+v, want password %q and role %q", user, tt.wantPassword, tt.wantRole)
+			}
+			if err != nil {
+				if len(identities.identities) != 0 || len(publisher.events) != 0 {
+					t.Fatalf("identities = %+v, events = %+v", identities.identities, publisher.events)
+				}
+				return
+			}
+			if !user.IsVerified || user.DeletedAt.Valid {
+				t.Fatalf("user = %+v, want verified and restored", user)
+			}
+			if len(identities.identities) != 1 || !identities.identities[0].Provisioned {
+				t.Fatalf("identities = %+v, want one provisioned", identities.identities)
+			}
+			if len(publisher.events) != 1 {
+				t.Fatalf("events = %+v", publisher.events)
+			}
+		})
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	const owner, other = 1, 2
+	tests := []struct {
+		name    string
+		setup   func(identities *fakeIdentityRepo)
+		startAs uint // 0 starts a plain sign-in
+		linkAs  uint
+		wantErr error
+	}{
+		{"owner links", nil, owner, owner, nil},
+		{"already linked to the owner", func(identities *fakeIdentityRepo) {
+			identities.Create(context.Background(), &domain.ExternalIdentity{UserID: owner, Provider: "school", Subject: "s-1"})
+		}, owner, owner, nil},
+		{"linked to someone else", func(identities *fakeIdentityRepo) {
+			identities.Create(context.Background(), &domain.ExternalIdentity{UserID: other, Provider: "school", Subject: "s-1"})
+		}, owner, owner, domain.ErrExternalIdentityLinked},
+		{"finished by another user", nil, owner, other, domain.ErrInvalidOIDCState},
+		{"sign-in state", nil, 0, owner, domain.ErrInvalidOIDCState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _, _, identities, _ := newTestOIDC(t)
+			ctx := context.Background()
+			if tt.setup != nil {
+				tt.setup(identities)
+			}
+			before := len(identities.identities)
+
+			var start *domain.OIDCStartResponse
+			var err error
+			if tt.startAs != 0 {
+				start, err = u.StartLink(ctx, tt.startAs, "school", &domain.OIDCStartRequest{})
+			} else {
+				start, err = u.Start(ctx, "school", &domain.OIDCStartRequest{})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, err := u.Link(ctx, tt.linkAs, "school", &domain.OIDCCallbackRequest{State: start.State, Code: "code"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(identities.identities) != before {
+					t.Fatalf("identities = %+v", identities.identities)
+				}
+				return
+			}
+			if identity.UserID != owner || identity.Provisioned || len(identities.identities) != 1 {
+				t.Fatalf("identity = %+v, want one linked to %d, not provisioned", identity, owner)
+			}
+		})
+	}
+
+	// A link state does not sign anyone in
+	u, _, _, _, _ := newTestOIDC(t)
+	start, err := u.StartLink(context.Background(), owner, "school", &domain.OIDCStartRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Callback(context.Background(), "school", &domain.OIDCCallbackRequest{State: start.State, Code: "code"}); !errors.Is(err, domain.ErrInvalidOIDCState) {
+		t.Fatalf("callback with a link state: got %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCRoleFollowsGroupsOnlyWhenProvisioned(t *testing.T) {
+	tests := []struct {
+		name        string
+		provisioned bool
+		wantRole    string
+	}{
+		{"provisioned", true, auth.RoleLibrarian},
+		{"linked by the owner", false, auth.RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, provider, users, identities, _ := newTestOIDC(t)
+			ctx := context.Background()
+			user := &domain.User{Email: "kepala@sekolah.sch.id", Role: auth.RoleAdmin, IsVerified: true}
+			users.Create(ctx, user)
+			identities.Create(ctx, &domain.ExternalIdentity{UserID: user.ID, Provider: "school", Subject: "s-1", Provisioned: tt.provisioned})
+			provider.claims.Groups = []string{"librarians"}
+
+			start, err := u.Start(ctx, "school", &domain.OIDCStartRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := u.Callback(ctx, "school", &domain.OIDCCallbackRequest{State: start.State, Code: "code"}); err != nil {
+				t.Fatalf("callback: %v", err)
+			}
+			if got := users.users[user.ID].Role; got != tt.wantRole {
+				t.Fatalf("role = %q, want %q", got, tt.wantRole)
+			}
+		})
+	}
+}
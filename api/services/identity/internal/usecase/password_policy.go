@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Defaults for the password_* settings. The hash cost is kept moderate
+// because a change compares against every remembered hash within the
+// request timeout.
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordHistory   = 3
+	defaultPasswordHashCost  = 11
+	maxPasswordHashCost      = 14
+	maxPasswordBytes         = 72 // bcrypt ignores the rest
+)
+
+var passwordClasses = map[string]struct {
+	name  string
+	match func(rune) bool
+}{
+	"lower":  {"a lowercase letter", unicode.IsLower},
+	"upper":  {"an uppercase letter", unicode.IsUpper},
+	"digit":  {"a digit", unicode.IsDigit},
+	"symbol": {"a symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }},
+}
+
+type passwordSettings struct {
+	minLength     int
+	classes       []string
+	history       int // Passwords remembered, the current one included
+	maxAge        time.Duration
+	checkBreached bool
+	hashCost      int
+}
+
+type passwordPolicy struct {
+	userRepo    domain.UserRepository
+	historyRepo domain.PasswordHistoryRepository
+	breached    domain.BreachedPasswords
+}
+
+// NewPasswordPolicy builds the policy. breached may be nil when no list is
+// shipped, which skips that check.
+func NewPasswordPolicy(userRepo domain.UserRepository, historyRepo domain.PasswordHistoryRepository, breached domain.BreachedPasswords) domain.PasswordPolicy {
+	return &passwordPolicy{
+		userRepo:    userRepo,
+		historyRepo: historyRepo,
+		breached:    breached,
+	}
+}
+
+func (p *passwordPolicy) Set(ctx context.Context, user *domain.User, password string) error {
+	s := p.settings(ctx)
+
+	// Cheap checks first, all reported at once
+	invalid := &utils.ValidationError{}
+	switch {
+	case password == "":
+		invalid.Add("password", "required", "password is required")
+	case utf8.RuneCountInString(password) < s.minLength:
+		invalid.Add("password", "too_short", fmt.Sprintf("must be at least %d characters", s.minLength))
+	case len(password) > maxPasswordBytes:
+		invalid.Add("password", "too_long", fmt.Sprintf("must be at most %d bytes", maxPasswordBytes))
+	}
+	for _, class := range s.classes {
+		if !strings.ContainsFunc(password, passwordClasses[class].match) {
+			invalid.Add("password", "missing_"+class, "must contain "+passwordClasses[class].name)
+		}
+	}
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+
+	if s.checkBreached && p.breached != nil {
+		breached, err := p.isBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			invalid.Add("password", "breached", "appears in a known data breach, choose another")
+			return invalid
+		}
+	}
+
+	// Unverified accounts are still being registered, their earlier
+	// passwords are not worth remembering
+	remember := user.ID != 0 && user.IsVerified && s.history > 0
+	if remember {
+		reused, err := p.reused(ctx, user, password, s.history)
+		if err != nil {
+			return err
+		}
+		if reused {
+			invalid.Add("password", "reused", fmt.Sprintf("must differ from your last %d passwords", s.history))
+			return invalid
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.hashCost)
+	if err != nil {
+		return err
+	}
+	if remember && s.history > 1 && user.Password != "" {
+		if err := p.historyRepo.Add(ctx, user.ID, user.Password, s.history-1); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	user.Password = string(hash)
+	user.PasswordChangedAt = &now
+	return nil
+}
+
+func (p *passwordPolicy) Expired(ctx context.Context, user *domain.User) bool {
+	if user.PasswordChangedAt == nil {
+		return false
+	}
+	s := p.settings(ctx)
+	return s.maxAge > 0 && time.Since(*user.PasswordChangedAt) > s.maxAge
+}
+
+// reused compares password with the current one and the history, history
+// passwords in all.
+func (p *passwordPolicy) reused(ctx context.Context, user *domain.User, password string, history int) (bool, error) {
+	hashes := []string{user.Password}
+	if history > 1 {
+		earlier, err := p.historyRepo.Recent(ctx, user.ID, history-1)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, earlier...)
+	}
+	for _, hash := range hashes {
+		if hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isBreached looks the password up by the first five characters of its
+// SHA-1 only, the k-anonymity range query of Have I Been Pwned.
+func (p *passwordPolicy) isBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := p.breached.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// settings reads password_min_length, password_required_classes (a comma
+// separated list of lower, upper, digit and symbol), password_history,
+// password_max_age_days (0 for no expiry), password_check_breached and
+// password_hash_cost.
+func (p *passwordPolicy) settings(ctx context.Context) passwordSettings {
+	s := passwordSettings{
+		minLength:     defaultPasswordMinLength,
+		history:       defaultPasswordHistory,
+		checkBreached: true,
+		hashCost:      defaultPasswordHashCost,
+	}
+	configs, err := p.userRepo.GetAllConfigs(ctx)
+	if err != nil {
+		return s
+	}
+	for _, config := range configs {
+		n, numErr := strconv.Atoi(config.Value)
+		switch config.Key {
+		case "password_min_length":
+			if numErr == nil && n > 0 {
+				s.minLength = n
+			}
+		case "password_required_classes":
+			for _, class := range strings.Split(config.Value, ",") {
+				if class = strings.TrimSpace(class); passwordClasses[class].match != nil {
+					s.classes = append(s.classes, class)
+				}
+			}
+		case "password_history":
+			if numErr == nil && n >= 0 {
+				s.history = n
+			}
+		case "password_max_age_days":
+			if numErr == nil && n > 0 {
+				s.maxAge = time.Duration(n) * 24 * time.Hour
+			}
+		case "password_check_breached":
+			if b, err := strconv.ParseBool(config.Value); err == nil {
+				s.checkBreached = b
+			}
+		case "password_hash_cost":
+			if numErr == nil && n >= bcrypt.DefaultCost {
+				s.hashCost = min(n, maxPasswordHashCost)
+			}
+		}
+	}
+	return s
+}
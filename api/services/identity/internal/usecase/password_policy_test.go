@@ -0,0 +1,212 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/identity/internal/domain"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakePasswordHistory struct {
+	hashes map[uint][]string // Newest first
+}
+
+func newFakePasswordHistory() *fakePasswordHistory {
+	return &fakePasswordHistory{hashes: make(map[uint][]string)}
+}
+
+func (r *fakePasswordHistory) Add(ctx context.Context, userID uint, hash string, keep int) error {
+	hashes := append([]string{hash}, r.hashes[userID]...)
+	r.hashes[userID] = hashes[:min(len(hashes), keep)]
+	return nil
+}
+
+func (r *fakePasswordHistory) Recent(ctx context.Context, userID uint, limit int) ([]string, error) {
+	hashes := r.hashes[userID]
+	return hashes[:min(len(hashes), limit)], nil
+}
+
+// fakeBreached holds whole passwords and answers range queries on their
+// SHA-1 like the real list.
+type fakeBreached map[string][]string
+
+func newFakeBreached(passwords ...string) fakeBreached {
+	list := make(fakeBreached)
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		list[hash[:5]] = append(list[hash[:5]], hash[5:])
+	}
+	return list
+}
+
+func (b fakeBreached) Range(prefix string) ([]string, error) {
+	return b[prefix], nil
+}
+
+func newTestPolicy(breached domain.BreachedPasswords) (*passwordPolicy, *fakeUserRepo, *fakePasswordHistory) {
+	users := newFakeUserRepo()
+	users.configs["password_hash_cost"] = "10" // The lowest allowed, to keep the tests quick
+	history := newFakePasswordHistory()
+	return NewPasswordPolicy(users, history, breached).(*passwordPolicy), users, history
+}
+
+// fieldCodes lists the codes of a *utils.ValidationError, failing on any
+// other error.
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var invalid *utils.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	var codes []string
+	for _, field := range invalid.Fields {
+		codes = append(codes, field.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyHistory(t *testing.T) {
+	policy, _, history := newTestPolicy(nil)
+	ctx := context.Background()
+	user := &domain.User{ID: 1, IsVerified: true}
+
+	for _, password := range []string{"first-pass", "second-pass", "third-pass"} {
+		if err := policy.Set(ctx, user, password); err != nil {
+			t.Fatalf("Set(%s): %v", password, err)
+		}
+	}
+	if got := len(history.hashes[user.ID]); got != defaultPasswordHistory-1 {
+		t.Fatalf("remembered %d earlier passwords, want %d", got, defaultPasswordHistory-1)
+	}
+
+	// The current password and the two before it are off limits
+	for _, password := range []string{"third-pass", "second-pass", "first-pass"} {
+		err := policy.Set(ctx, user, password)
+		if codes := fieldCodes(t, err); len(codes) != 1 || codes[0] != "reused" {
+			t.Fatalf("Set(%s) = %v, want reused", password, err)
+		}
+	}
+
+	// One more change and the oldest falls out of the history
+	if err := policy.Set(ctx, user, "fourth-pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Set(ctx, user, "first-pass"); err != nil {
+		t.Fatalf("Set(first-pass) four changes later: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("first-pass")) != nil {
+		t.Fatal("user.Password is not the new hash")
+	}
+}
+
+func TestPasswordPolicyHistorySetting(t *testing.T) {
+	policy, users, _ := newTestPolicy(nil)
+	ctx := context.Background()
+	users.configs["password_history"] = "0"
+	user := &domain.User{ID: 1, IsVerified: true}
+
+	for i := 0; i < 2; i++ {
+		if err := policy.Set(ctx, user, "same-pass"); err != nil {
+			t.Fatalf("Set with password_history 0: %v", err)
+		}
+	}
+
+	// Nor is anything remembered while an account is still being registered
+	policy, _, history := newTestPolicy(nil)
+	user = &domain.User{ID: 2}
+	for i := 0; i < 2; i++ {
+		if err := policy.Set(ctx, user, "same-pass"); err != nil {
+			t.Fatalf("Set on an unverified account: %v", err)
+		}
+	}
+	if len(history.hashes[user.ID]) != 0 {
+		t.Fatal("history kept for an unverified account")
+	}
+}
+
+func TestPasswordPolicyExpired(t *testing.T) {
+	policy, users, _ := newTestPolicy(nil)
+	ctx := context.Background()
+	daysAgo := func(days int) *domain.User {
+		changed := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		return &domain.User{PasswordChangedAt: &changed}
+	}
+
+	if policy.Expired(ctx, daysAgo(3650)) {
+		t.Fatal("expired with no password_max_age_days")
+	}
+
+	users.configs["password_max_age_days"] = "90"
+	if policy.Expired(ctx, daysAgo(89)) {
+		t.Fatal("expired after 89 of 90 days")
+	}
+	if !policy.Expired(ctx, daysAgo(91)) {
+		t.Fatal("not expired after 91 of 90 days")
+	}
+	// Accounts from before the policy have no date and are left alone
+	if policy.Expired(ctx, &domain.User{}) {
+		t.Fatal("expired without a PasswordChangedAt")
+	}
+
+	user := &domain.User{ID: 1, IsVerified: true}
+	if err := policy.Set(ctx, user, "fresh-pass"); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Expired(ctx, user) {
+		t.Fatal("a password just set has expired")
+	}
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	policy, users, _ := newTestPolicy(newFakeBreached("password123", "letmein!"))
+	ctx := context.Background()
+	user := &domain.User{ID: 1, IsVerified: true}
+
+	err := policy.Set(ctx, user, "password123")
+	if codes := fieldCodes(t, err); len(codes) != 1 || codes[0] != "breached" {
+		t.Fatalf("Set(password123) = %v, want breached", err)
+	}
+	if err := policy.Set(ctx, user, "not-in-the-list"); err != nil {
+		t.Fatal(err)
+	}
+
+	users.configs["password_check_breached"] = "false"
+	if err := policy.Set(ctx, user, "password123"); err != nil {
+		t.Fatalf("Set with password_check_breached off: %v", err)
+	}
+}
+
+func TestPasswordPolicyRules(t *testing.T) {
+	policy, users, _ := newTestPolicy(nil)
+	ctx := context.Background()
+	users.configs["password_required_classes"] = "upper, digit, unknown"
+
+	tests := []struct {
+		password string
+		codes    []string
+	}{
+		{"", []string{"required", "missing_upper", "missing_digit"}},
+		{"Ab1", []string{"too_short"}},
+		{"abcdefgh", []string{"missing_upper", "missing_digit"}},
+		{"Abcdefg1", nil},
+		{"Kata-Sandi-1", nil},
+		{strings.Repeat("A1", 37), []string{"too_long"}},
+	}
+	for _, tt := range tests {
+		err := policy.Set(ctx, &domain.User{}, tt.password)
+		if got := fieldCodes(t, err); strings.Join(got, ",") != strings.Join(tt.codes, ",") {
+			t.Errorf("Set(%q) codes = %v, want %v", tt.password, got, tt.codes)
+		}
+	}
+}
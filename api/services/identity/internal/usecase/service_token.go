@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"strings"
+	"time"
+)
+
+const serviceTokenTTL = 5 * time.Minute
+
+type serviceTokenUsecase struct {
+	signer  *auth.Signer
+	clients map[string]string
+}
+
+// NewServiceTokenUsecase issues service tokens to the clients in the
+// SERVICE_CLIENTS format, "id:secret,id:secret".
+func NewServiceTokenUsecase(signer *auth.Signer, clients string) domain.ServiceTokenUsecase {
+	registered := make(map[string]string)
+	for _, pair := range strings.Split(clients, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && id != "" && secret != "" {
+			registered[id] = secret
+		}
+	}
+	return &serviceTokenUsecase{signer: signer, clients: registered}
+}
+
+func (u *serviceTokenUsecase) Issue(ctx context.Context, req *domain.ServiceTokenRequest) (*domain.ServiceTokenResponse, error) {
+	secret, ok := u.clients[req.ClientID]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(req.ClientSecret)) != 1 {
+		return nil, domain.ErrInvalidClient
+	}
+
+	token, err := u.signer.GenerateToken(0, req.ClientID, auth.RoleService, serviceTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ServiceTokenResponse{Token: token, ExpiresIn: int(serviceTokenTTL.Seconds())}, nil
+}
@@ -0,0 +1,48 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"testing"
+)
+
+func TestServiceTokenIssue(t *testing.T) {
+	signer := newTestSigner(t)
+	u := NewServiceTokenUsecase(signer, "book:rahasia-buku, transaction:rahasia-transaksi,broken,:no-id,no-secret:")
+
+	tests := []struct {
+		name    string
+		req     domain.ServiceTokenRequest
+		wantErr error
+	}{
+		{"registered client", domain.ServiceTokenRequest{ClientID: "book", ClientSecret: "rahasia-buku"}, nil},
+		{"spaces around an entry", domain.ServiceTokenRequest{ClientID: "transaction", ClientSecret: "rahasia-transaksi"}, nil},
+		{"wrong secret", domain.ServiceTokenRequest{ClientID: "book", ClientSecret: "rahasia-transaksi"}, domain.ErrInvalidClient},
+		{"unknown client", domain.ServiceTokenRequest{ClientID: "reporting", ClientSecret: "rahasia-buku"}, domain.ErrInvalidClient},
+		{"entry without a secret", domain.ServiceTokenRequest{ClientID: "no-secret", ClientSecret: ""}, domain.ErrInvalidClient},
+		{"entry without an id", domain.ServiceTokenRequest{ClientID: "", ClientSecret: "no-id"}, domain.ErrInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := u.Issue(context.Background(), &tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if resp.ExpiresIn != 300 {
+				t.Fatalf("expires in %d, want 300", resp.ExpiresIn)
+			}
+			claims, err := auth.ParseToken(resp.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Role != auth.RoleService || claims.Email != tt.req.ClientID {
+				t.Fatalf("claims = %v, want a service token for %s", claims, tt.req.ClientID)
+			}
+		})
+	}
+}
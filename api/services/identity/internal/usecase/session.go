@@ -0,0 +1,234 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type sessionUsecase struct {
+	sessionRepo    domain.SessionRepository
+	userRepo       domain.UserRepository
+	contextTimeout time.Duration
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	signer         *auth.Signer
+}
+
+func NewSessionUsecase(sessionRepo domain.SessionRepository, userRepo domain.UserRepository, signer *auth.Signer, timeout time.Duration) domain.SessionUsecase {
+	accessMinutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_MINUTES"))
+	if err != nil || accessMinutes <= 0 {
+		accessMinutes = 15 // Default fallback
+	}
+	refreshDays, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_DAYS"))
+	if err != nil || refreshDays <= 0 {
+		refreshDays = 30 // Default fallback
+	}
+
+	return &sessionUsecase{
+		sessionRepo:    sessionRepo,
+		userRepo:       userRepo,
+		contextTimeout: timeout,
+		accessTTL:      time.Duration(accessMinutes) * time.Minute,
+		refreshTTL:     time.Duration(refreshDays) * 24 * time.Hour,
+		signer:         signer,
+	}
+}
+
+func (u *sessionUsecase) Open(c context.Context, user *domain.User, client domain.ClientInfo) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	refreshToken, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &domain.Session{
+		UserID:     user.ID,
+		TokenHash:  hash,
+		DeviceName: client.DeviceName,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		ExpiresAt:  now.Add(u.refreshLifetime(ctx)),
+		LastUsedAt: now,
+	}
+	if err := u.sessionRepo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	return u.respond(ctx, user, session, refreshToken)
+}
+
+// Refresh trades a refresh token for a new access and refresh token pair.
+// Each refresh token works once: presenting any token the session has
+// already rotated past means it leaked, so the whole session is revoked.
+func (u *sessionUsecase) Refresh(c context.Context, refreshToken string, client domain.ClientInfo) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	hash := hashToken(refreshToken)
+	session, err := u.sessionRepo.GetByTokenHash(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if reused, _ := u.sessionRepo.GetByRetiredHash(ctx, hash); reused != nil && reused.RevokedAt == nil {
+			if err := u.sessionRepo.Revoke(ctx, reused.ID); err != nil {
+				return nil, err
+			}
+			return nil, domain.ErrRefreshTokenReused
+		}
+		return nil, domain.ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+
+	user, err := u.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.sessionRepo.Revoke(ctx, session.ID)
+			return nil, domain.ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	session.TokenHash = newHash
+	session.ExpiresAt = now.Add(u.refreshLifetime(ctx))
+	session.LastUsedAt = now
+	if client.UserAgent != "" {
+		session.UserAgent = client.UserAgent
+	}
+	if client.IP != "" {
+		session.IP = client.IP
+	}
+
+	if err := u.sessionRepo.Rotate(ctx, session, hash); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Redeemed by a concurrent request
+			return nil, domain.ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	return u.respond(ctx, user, session, newToken)
+}
+
+// Logout ends the session the refresh token belongs to. Unknown tokens are
+// ignored so logging out twice is harmless.
+func (u *sessionUsecase) Logout(c context.Context, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	session, err := u.sessionRepo.GetByTokenHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return u.sessionRepo.Revoke(ctx, session.ID)
+}
+
+func (u *sessionUsecase) ListSessions(c context.Context, userID uint, currentID uint) ([]domain.Session, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	sessions, err := u.sessionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+func (u *sessionUsecase) RevokeSession(c context.Context, userID uint, sessionID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	session, err := u.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil || session.UserID != userID {
+		return domain.ErrSessionNotFound
+	}
+	return u.sessionRepo.Revoke(ctx, session.ID)
+}
+
+func (u *sessionUsecase) RevokeOtherSessions(c context.Context, userID uint, currentID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+	return u.sessionRepo.RevokeAllByUserID(ctx, userID, currentID)
+}
+
+func (u *sessionUsecase) RevokeAll(c context.Context, userID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+	return u.sessionRepo.RevokeAllByUserID(ctx, userID, 0)
+}
+
+func (u *sessionUsecase) respond(ctx context.Context, user *domain.User, session *domain.Session, refreshToken string) (*domain.AuthResponse, error) {
+	accessTTL := u.accessLifetime(ctx)
+	token, err := u.signer.GenerateSessionToken(user.ID, user.Email, user.Role, session.ID, user.TokenVersion, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTTL.Seconds()),
+		User:         *user,
+	}, nil
+}
+
+// accessLifetime and refreshLifetime read the access_token_minutes and
+// refresh_token_days settings, falling back to the environment defaults.
+func (u *sessionUsecase) accessLifetime(ctx context.Context) time.Duration {
+	if val, err := u.userRepo.GetConfig(ctx, "access_token_minutes"); err == nil {
+		if minutes, err := strconv.Atoi(val); err == nil && minutes > 0 {
+			return time.Duration(minutes) * time.Minute
+		}
+	}
+	return u.accessTTL
+}
+
+func (u *sessionUsecase) refreshLifetime(ctx context.Context) time.Duration {
+	if val, err := u.userRepo.GetConfig(ctx, "refresh_token_days"); err == nil {
+		if days, err := strconv.Atoi(val); err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return u.refreshTTL
+}
+
+// newOpaqueToken returns a random token for the client and the hash that
+// is stored in its place.
+func newOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
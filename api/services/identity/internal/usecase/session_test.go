@@ -0,0 +1,169 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/services/identity/internal/domain"
+	"testing"
+	"time"
+)
+
+func newTestSessions(t *testing.T) (*sessionUsecase, *fakeSessionRepo, *fakeUserRepo) {
+	t.Helper()
+	sessions := newFakeSessionRepo()
+	users := newFakeUserRepo()
+	users.users[1] = domain.User{ID: 1, Email: "siswa@contoh.com", Role: domain.RoleUser}
+	return NewSessionUsecase(sessions, users, newTestSigner(t), time.Second).(*sessionUsecase), sessions, users
+}
+
+func TestSessionRefreshRotates(t *testing.T) {
+	sessions, repo, _ := newTestSessions(t)
+	ctx := context.Background()
+
+	opened, err := sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{DeviceName: "Laptop", IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := opened.RefreshToken
+	for i := 0; i < 3; i++ {
+		refreshed, err := sessions.Refresh(ctx, token, domain.ClientInfo{IP: "198.51.100.1"})
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i+1, err)
+		}
+		if refreshed.RefreshToken == token || refreshed.Token == "" {
+			t.Fatalf("refresh %d did not hand out a new pair", i+1)
+		}
+		token = refreshed.RefreshToken
+	}
+
+	session := repo.sessions[1]
+	if len(repo.sessions) != 1 || session.TokenHash != hashToken(token) {
+		t.Fatal("refreshing did not rotate the one session")
+	}
+	if session.IP != "198.51.100.1" || session.DeviceName != "Laptop" {
+		t.Fatalf("session is from %s on %q, want the refreshing address and the original device", session.IP, session.DeviceName)
+	}
+}
+
+func TestSessionRefreshReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		rotations int
+		replay    int // Generation replayed, 0 being the token Open handed out
+	}{
+		{"previous token", 1, 0},
+		{"token two rotations back", 2, 0},
+		{"token one rotation back after two", 2, 1},
+		{"first token after many rotations", 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, repo, _ := newTestSessions(t)
+			opened, err := sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens := []string{opened.RefreshToken}
+			for i := 0; i < tt.rotations; i++ {
+				rotated, err := sessions.Refresh(ctx, tokens[i], domain.ClientInfo{})
+				if err != nil {
+					t.Fatalf("refresh %d: %v", i+1, err)
+				}
+				tokens = append(tokens, rotated.RefreshToken)
+			}
+			current := tokens[tt.rotations]
+
+			// A rotated token turning up again means it was copied
+			if _, err := sessions.Refresh(ctx, tokens[tt.replay], domain.ClientInfo{}); !errors.Is(err, domain.ErrRefreshTokenReused) {
+				t.Fatalf("reusing a rotated token = %v, want ErrRefreshTokenReused", err)
+			}
+			if repo.sessions[1].RevokedAt == nil {
+				t.Fatal("session not revoked after reuse")
+			}
+
+			// Neither the thief nor the owner can carry on
+			if _, err := sessions.Refresh(ctx, current, domain.ClientInfo{}); !errors.Is(err, domain.ErrInvalidRefreshToken) {
+				t.Fatalf("current token after reuse = %v, want ErrInvalidRefreshToken", err)
+			}
+			if _, err := sessions.Refresh(ctx, tokens[tt.replay], domain.ClientInfo{}); !errors.Is(err, domain.ErrInvalidRefreshToken) {
+				t.Fatalf("rotated token once revoked = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+}
+
+func TestSessionRefreshRefused(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		spoil func(*fakeSessionRepo, *fakeUserRepo)
+		token func(string) string
+	}{
+		{"never issued", nil, func(string) string { return "never-issued" }},
+		{"expired", func(r *fakeSessionRepo, _ *fakeUserRepo) {
+			r.sessions[1].ExpiresAt = time.Now().Add(-time.Minute)
+		}, nil},
+		{"logged out", func(r *fakeSessionRepo, _ *fakeUserRepo) {
+			r.Revoke(ctx, 1)
+		}, nil},
+		{"member deleted", func(_ *fakeSessionRepo, u *fakeUserRepo) {
+			delete(u.users, 1)
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, repo, users := newTestSessions(t)
+			opened, err := sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.spoil != nil {
+				tt.spoil(repo, users)
+			}
+			token := opened.RefreshToken
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			if _, err := sessions.Refresh(ctx, token, domain.ClientInfo{}); !errors.Is(err, domain.ErrInvalidRefreshToken) {
+				t.Fatalf("refresh = %v, want ErrInvalidRefreshToken", err)
+			}
+			if tt.name == "member deleted" && repo.sessions[1].RevokedAt == nil {
+				t.Fatal("session of a deleted member left open")
+			}
+		})
+	}
+}
+
+func TestSessionManagement(t *testing.T) {
+	sessions, repo, _ := newTestSessions(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := sessions.Open(ctx, &domain.User{ID: 1}, domain.ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo.Create(ctx, &domain.Session{UserID: 2, TokenHash: "other"})
+
+	if err := sessions.RevokeSession(ctx, 1, 4); !errors.Is(err, domain.ErrSessionNotFound) {
+		t.Fatalf("revoking another member's session = %v, want ErrSessionNotFound", err)
+	}
+	if err := sessions.RevokeSession(ctx, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.RevokeOtherSessions(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]bool{1: false, 2: true, 3: true}
+	for id, revoked := range repo.revoked(1) {
+		if revoked != want[id] {
+			t.Errorf("session %d revoked = %v, want %v", id, revoked, want[id])
+		}
+	}
+	if repo.sessions[4].RevokedAt != nil {
+		t.Fatal("another member's session was revoked")
+	}
+}
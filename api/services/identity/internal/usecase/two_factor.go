@@ -0,0 +1,397 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/database"
+	"pushtaka/services/identity/internal/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Pushtaka" // Shown next to the account in authenticator apps
+)
+
+type twoFactorUsecase struct {
+	userRepo       domain.UserRepository
+	twoFactorRepo  domain.TwoFactorRepository
+	sessions       domain.SessionUsecase
+	guard          domain.LoginGuard
+	transactor     database.Transactor
+	contextTimeout time.Duration
+}
+
+func NewTwoFactorUsecase(userRepo domain.UserRepository, twoFactorRepo domain.TwoFactorRepository, sessions domain.SessionUsecase, guard domain.LoginGuard, transactor database.Transactor, timeout time.Duration) domain.TwoFactorUsecase {
+	return &twoFactorUsecase{
+		userRepo:       userRepo,
+		twoFactorRepo:  twoFactorRepo,
+		sessions:       sessions,
+		guard:          guard,
+		transactor:     transactor,
+		contextTimeout: timeout,
+	}
+}
+
+func (u *twoFactorUsecase) SignIn(c context.Context, user *domain.User, client domain.ClientInfo) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	if !user.TOTPEnabled && !u.required(ctx, user) {
+		if err := u.guard.Succeed(ctx, user); err != nil {
+			return nil, err
+		}
+		return u.sessions.Open(ctx, user, client)
+	}
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	challenge := &domain.LoginChallenge{
+		UserID:     user.ID,
+		TokenHash:  hash,
+		DeviceName: client.DeviceName,
+		ExpiresAt:  time.Now().Add(challengeTTL),
+	}
+	if err := u.twoFactorRepo.CreateChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResponse{
+		User: *user,
+		TwoFactor: &domain.TwoFactorChallenge{
+			ChallengeToken: token,
+			ExpiresIn:      int(challengeTTL.Seconds()),
+			SetupRequired:  !user.TOTPEnabled,
+		},
+	}, nil
+}
+
+func (u *twoFactorUsecase) SetupChallenge(c context.Context, challengeToken string) (*domain.TwoFactorSetup, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	_, user, err := u.challenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain.ErrTwoFactorAlreadyEnabled
+	}
+	return u.newSecret(ctx, user)
+}
+
+// CompleteLogin checks the code and opens the session the password login
+// was held back from. A user enrolling during login confirms the new secret
+// with the code and gets their recovery codes in the response.
+func (u *twoFactorUsecase) CompleteLogin(c context.Context, req *domain.TwoFactorLoginRequest) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	challenge, user, err := u.challenge(ctx, req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+	ip := req.Client.IP
+	if err := u.guard.Check(ctx, user, ip); err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	if user.TOTPEnabled {
+		err = u.verifyCode(ctx, user, req.Code, true)
+	} else if user.TOTPSecret == "" {
+		return nil, domain.ErrTwoFactorNotSetUp
+	} else {
+		recoveryCodes, err = u.enable(ctx, user, req.Code)
+	}
+	if errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+		return nil, u.challengeFailed(ctx, user, challenge, ip)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.twoFactorRepo.DeleteChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	if err := u.guard.Succeed(ctx, user); err != nil {
+		return nil, err
+	}
+
+	client := req.Client
+	client.DeviceName = challenge.DeviceName
+	res, err := u.sessions.Open(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+	res.RecoveryCodes = recoveryCodes
+	return res, nil
+}
+
+func (u *twoFactorUsecase) Setup(c context.Context, userID uint) (*domain.TwoFactorSetup, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain.ErrTwoFactorAlreadyEnabled
+	}
+	return u.newSecret(ctx, user)
+}
+
+func (u *twoFactorUsecase) Enable(c context.Context, userID uint, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain.ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, domain.ErrTwoFactorNotSetUp
+	}
+	return u.enable(ctx, user, code)
+}
+
+func (u *twoFactorUsecase) Disable(c context.Context, userID uint, code string) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return domain.ErrTwoFactorNotSetUp
+	}
+	if u.required(ctx, user) {
+		return domain.ErrTwoFactorRequired
+	}
+	if err := u.checkCode(ctx, user, code, true); err != nil {
+		return err
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	return u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		return u.twoFactorRepo.DeleteRecoveryCodes(ctx, user.ID)
+	})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not. It
+// takes a TOTP code only, so a leaked recovery code cannot mint new ones.
+func (u *twoFactorUsecase) RegenerateRecoveryCodes(c context.Context, userID uint, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, domain.ErrTwoFactorNotSetUp
+	}
+	if err := u.checkCode(ctx, user, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.twoFactorRepo.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// required reports whether the account may not sign in without 2FA: admins
+// once the two_factor_required setting is on.
+func (u *twoFactorUsecase) required(ctx context.Context, user *domain.User) bool {
+	if user.Role != domain.RoleAdmin {
+		return false
+	}
+	val, err := u.userRepo.GetConfig(ctx, "two_factor_required")
+	if err != nil {
+		return false
+	}
+	required, _ := strconv.ParseBool(val)
+	return required
+}
+
+func (u *twoFactorUsecase) challenge(ctx context.Context, token string) (*domain.LoginChallenge, *domain.User, error) {
+	challenge, err := u.twoFactorRepo.GetChallengeByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, domain.ErrInvalidChallenge
+		}
+		return nil, nil, err
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, nil, domain.ErrInvalidChallenge
+	}
+
+	user, err := u.userRepo.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, domain.ErrInvalidChallenge
+		}
+		return nil, nil, err
+	}
+	return challenge, user, nil
+}
+
+func (u *twoFactorUsecase) newSecret(ctx context.Context, user *domain.User) (*domain.TwoFactorSetup, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = secret
+	if err := u.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return &domain.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, totpIssuer, user.Email),
+	}, nil
+}
+
+// enable confirms the pending secret with a code from the app and issues the
+// first set of recovery codes.
+func (u *twoFactorUsecase) enable(ctx context.Context, user *domain.User, code string) ([]string, error) {
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, domain.ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	err = u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		return u.twoFactorRepo.ReplaceRecoveryCodes(ctx, user.ID, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// challengeFailed counts a wrong code against the challenge, throwing it away
+// after too many so the password has to be entered again.
+func (u *twoFactorUsecase) challengeFailed(ctx context.Context, user *domain.User, challenge *domain.LoginChallenge, ip string) error {
+	attempts, err := u.twoFactorRepo.RecordChallengeFailure(ctx, challenge.ID)
+	if err != nil {
+		return err
+	}
+	spent, err := u.guard.FailCode(ctx, user, ip, attempts, domain.AuditChallengeInvalidated)
+	if err != nil {
+		return err
+	}
+	if spent {
+		if err := u.twoFactorRepo.DeleteChallenge(ctx, challenge.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	return domain.ErrInvalidTwoFactorCode
+}
+
+// checkCode is verifyCode for a signed-in user, with wrong codes counting
+// towards the account lockout.
+func (u *twoFactorUsecase) checkCode(ctx context.Context, user *domain.User, code string, allowRecovery bool) error {
+	if err := u.guard.Check(ctx, user, ""); err != nil {
+		return err
+	}
+	err := u.verifyCode(ctx, user, code, allowRecovery)
+	if errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+		if err := u.guard.Fail(ctx, user, ""); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// verifyCode accepts a TOTP code once, or, with allowRecovery, an unused
+// recovery code, which is then spent.
+func (u *twoFactorUsecase) verifyCode(ctx context.Context, user *domain.User, code string, allowRecovery bool) error {
+	code = normalizeCode(code)
+
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		if err := u.twoFactorRepo.ClaimTOTPStep(ctx, user.ID, step); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return domain.ErrInvalidTwoFactorCode
+			}
+			return err
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	if !allowRecovery {
+		return domain.ErrInvalidTwoFactorCode
+	}
+	if err := u.twoFactorRepo.UseRecoveryCode(ctx, user.ID, hashToken(code)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return domain.ErrInvalidTwoFactorCode
+		}
+		return err
+	}
+	return nil
+}
+
+// normalizeCode drops the separators people type or paste, so "123 456" and
+// "ABCDE-FGHIJ" match.
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// newRecoveryCodes returns codes like "k7d2m-x4qpa" for the user and their
+// hashes for storage.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryAlphabet[b[j]%byte(len(recoveryAlphabet))]
+		}
+		code := string(b[:5]) + "-" + string(b[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeCode(code)))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,386 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"strings"
+	"testing"
+	"time"
+)
+
+// totpAt is the code an authenticator app shows for secret at t.
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func newTestTwoFactor(t *testing.T) (*twoFactorUsecase, *fakeUserRepo, *fakeTwoFactorRepo, *fakeSessionRepo) {
+	t.Helper()
+	users := newFakeUserRepo()
+	sessionRepo := newFakeSessionRepo()
+	twoFactorRepo := newFakeTwoFactorRepo(users)
+	sessions := NewSessionUsecase(sessionRepo, users, newTestSigner(t), time.Second)
+	guard := NewLoginGuard(users, newFakeThrottleRepo(users), &fakeAuditRepo{})
+	u := NewTwoFactorUsecase(users, twoFactorRepo, sessions, guard, fakeTransactor{}, time.Second).(*twoFactorUsecase)
+	return u, users, twoFactorRepo, sessionRepo
+}
+
+// enroll gives user 1 an enabled authenticator and returns its secret and
+// recovery codes.
+func enroll(t *testing.T, u *twoFactorUsecase, users *fakeUserRepo, role string) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	users.users[1] = domain.User{ID: 1, Email: "siswa@contoh.com", Role: role, IsVerified: true}
+	setup, err := u.Setup(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Confirm with the previous period's code so the current one is still
+	// unused for the test
+	codes, err := u.Enable(ctx, 1, totpAt(t, setup.Secret, time.Now().Add(-30*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return setup.Secret, codes
+}
+
+func TestTwoFactorSignIn(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		enrolled   bool
+		required   string
+		wantTokens bool
+		wantSetup  bool
+	}{
+		{"no two-factor", domain.RoleUser, false, "", true, false},
+		{"enrolled", domain.RoleUser, true, "", false, false},
+		{"admin, not required", domain.RoleAdmin, false, "false", true, false},
+		{"admin, required", domain.RoleAdmin, false, "true", false, true},
+		{"member, required for admins only", domain.RoleUser, false, "true", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, users, twoFactorRepo, sessionRepo := newTestTwoFactor(t)
+			users.users[1] = domain.User{ID: 1, Role: tt.role, IsVerified: true}
+			if tt.enrolled {
+				enroll(t, u, users, tt.role)
+			}
+			if tt.required != "" {
+				users.configs["two_factor_required"] = tt.required
+			}
+			user, _ := users.GetByID(context.Background(), 1)
+
+			resp, err := u.SignIn(context.Background(), user, domain.ClientInfo{DeviceName: "Laptop"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotTokens := resp.Token != ""; gotTokens != tt.wantTokens || len(sessionRepo.sessions) != map[bool]int{true: 1}[tt.wantTokens] {
+				t.Fatalf("signed in = %v with %d sessions, want %v", gotTokens, len(sessionRepo.sessions), tt.wantTokens)
+			}
+			if tt.wantTokens {
+				return
+			}
+			if resp.TwoFactor == nil || resp.TwoFactor.ChallengeToken == "" || resp.TwoFactor.SetupRequired != tt.wantSetup {
+				t.Fatalf("challenge = %+v, want one with setup required %v", resp.TwoFactor, tt.wantSetup)
+			}
+			if len(twoFactorRepo.challenges) != 1 {
+				t.Fatalf("%d challenges stored, want 1", len(twoFactorRepo.challenges))
+			}
+		})
+	}
+}
+
+func TestTwoFactorCompleteLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    func(secret string, recovery []string) string
+		prepare func(u *twoFactorUsecase, twoFactorRepo *fakeTwoFactorRepo)
+		token   string
+		wantErr error
+	}{
+		{
+			name: "current code",
+			code: func(secret string, _ []string) string { return totpAt(t, secret, time.Now()) },
+		},
+		{
+			name: "code typed with a space",
+			code: func(secret string, _ []string) string {
+				code := totpAt(t, secret, time.Now())
+				return code[:3] + " " + code[3:]
+			},
+		},
+		{
+			name:    "code already used",
+			code:    func(secret string, _ []string) string { return totpAt(t, secret, time.Now().Add(-30*time.Second)) },
+			wantErr: domain.ErrInvalidTwoFactorCode,
+		},
+		{
+			name: "recovery code",
+			code: func(_ string, recovery []string) string { return recovery[0] },
+		},
+		{
+			name: "recovery code in upper case",
+			code: func(_ string, recovery []string) string { return "  " + strings.ToUpper(recovery[1]) },
+		},
+		{
+			name:    "wrong code",
+			code:    func(string, []string) string { return "000000x" },
+			wantErr: domain.ErrInvalidTwoFactorCode,
+		},
+		{
+			name: "expired challenge",
+			code: func(secret string, _ []string) string { return totpAt(t, secret, time.Now()) },
+			prepare: func(u *twoFactorUsecase, twoFactorRepo *fakeTwoFactorRepo) {
+				for _, challenge := range twoFactorRepo.challenges {
+					challenge.ExpiresAt = time.Now().Add(-time.Second)
+				}
+			},
+			wantErr: domain.ErrInvalidChallenge,
+		},
+		{
+			name:    "unknown challenge",
+			code:    func(secret string, _ []string) string { return totpAt(t, secret, time.Now()) },
+			token:   "not-a-challenge",
+			wantErr: domain.ErrInvalidChallenge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, users, twoFactorRepo, sessionRepo := newTestTwoFactor(t)
+			ctx := context.Background()
+			secret, recovery := enroll(t, u, users, domain.RoleUser)
+			user, _ := users.GetByID(ctx, 1)
+			challenge, err := u.SignIn(ctx, user, domain.ClientInfo{DeviceName: "Laptop"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(u, twoFactorRepo)
+			}
+			token := challenge.TwoFactor.ChallengeToken
+			if tt.token != "" {
+				token = tt.token
+			}
+
+			resp, err := u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: tt.code(secret, recovery)})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(sessionRepo.sessions) != 0 {
+					t.Fatal("a session was opened")
+				}
+				return
+			}
+			if resp.Token == "" || resp.RefreshToken == "" || resp.RecoveryCodes != nil {
+				t.Fatalf("response = %+v, want tokens only", resp)
+			}
+			if len(sessionRepo.sessions) != 1 || sessionRepo.sessions[1].DeviceName != "Laptop" {
+				t.Fatalf("sessions = %+v, want one for the device that signed in", sessionRepo.sessions)
+			}
+			// The challenge is spent
+			if _, err := u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: tt.code(secret, recovery)}); !errors.Is(err, domain.ErrInvalidChallenge) {
+				t.Fatalf("second use of the challenge: err = %v, want ErrInvalidChallenge", err)
+			}
+		})
+	}
+}
+
+func TestTwoFactorRequiredSetupDuringLogin(t *testing.T) {
+	u, users, _, sessionRepo := newTestTwoFactor(t)
+	ctx := context.Background()
+	users.users[1] = domain.User{ID: 1, Email: "admin@contoh.com", Role: domain.RoleAdmin, IsVerified: true}
+	users.configs["two_factor_required"] = "true"
+	user, _ := users.GetByID(ctx, 1)
+
+	challenge, err := u.SignIn(ctx, user, domain.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := challenge.TwoFactor.ChallengeToken
+	if _, err := u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: "123456"}); !errors.Is(err, domain.ErrTwoFactorNotSetUp) {
+		t.Fatalf("code before setup: err = %v, want ErrTwoFactorNotSetUp", err)
+	}
+
+	setup, err := u.SetupChallenge(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: totpAt(t, setup.Secret, time.Now())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || len(resp.RecoveryCodes) != recoveryCodeCount || len(sessionRepo.sessions) != 1 {
+		t.Fatalf("response = %+v, want tokens and %d recovery codes", resp, recoveryCodeCount)
+	}
+	if user, _ := users.GetByID(ctx, 1); !user.TOTPEnabled {
+		t.Fatal("two-factor not enabled after setup during login")
+	}
+}
+
+func TestTwoFactorDisable(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		required string
+		code     func(secret string, recovery []string) string
+		wantErr  error
+	}{
+		{"current code", domain.RoleUser, "", func(secret string, _ []string) string { return totpAt(t, secret, time.Now()) }, nil},
+		{"recovery code", domain.RoleUser, "", func(_ string, recovery []string) string { return recovery[0] }, nil},
+		{"wrong code", domain.RoleUser, "", func(string, []string) string { return "000000" }, domain.ErrInvalidTwoFactorCode},
+		{"required for the role", domain.RoleAdmin, "true", func(secret string, _ []string) string { return totpAt(t, secret, time.Now()) }, domain.ErrTwoFactorRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, users, twoFactorRepo, _ := newTestTwoFactor(t)
+			ctx := context.Background()
+			secret, recovery := enroll(t, u, users, tt.role)
+			if tt.required != "" {
+				users.configs["two_factor_required"] = tt.required
+			}
+
+			err := u.Disable(ctx, 1, tt.code(secret, recovery))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			user, _ := users.GetByID(ctx, 1)
+			disabled := !user.TOTPEnabled && user.TOTPSecret == "" && twoFactorRepo.recovery[1] == nil
+			if disabled != (tt.wantErr == nil) {
+				t.Fatalf("disabled = %v, want %v", disabled, tt.wantErr == nil)
+			}
+		})
+	}
+
+	u, users, _, _ := newTestTwoFactor(t)
+	users.users[1] = domain.User{ID: 1}
+	if err := u.Disable(context.Background(), 1, "000000"); !errors.Is(err, domain.ErrTwoFactorNotSetUp) {
+		t.Fatalf("disable without 2FA: err = %v, want ErrTwoFactorNotSetUp", err)
+	}
+}
+
+func TestTwoFactorEnable(t *testing.T) {
+	u, users, _, _ := newTestTwoFactor(t)
+	ctx := context.Background()
+	users.users[1] = domain.User{ID: 1, Email: "siswa@contoh.com"}
+
+	if _, err := u.Enable(ctx, 1, "123456"); !errors.Is(err, domain.ErrTwoFactorNotSetUp) {
+		t.Fatalf("enable before setup: err = %v, want ErrTwoFactorNotSetUp", err)
+	}
+	setup, err := u.Setup(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := users.GetByID(ctx, 1); user.TOTPEnabled || user.TOTPSecret != setup.Secret {
+		t.Fatal("setup enabled two-factor before the secret was confirmed")
+	}
+	if _, err := u.Enable(ctx, 1, "000000"); !errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+		t.Fatalf("wrong code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	if _, err := u.Enable(ctx, 1, totpAt(t, setup.Secret, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Setup(ctx, 1); !errors.Is(err, domain.ErrTwoFactorAlreadyEnabled) {
+		t.Fatalf("setup once enabled: err = %v, want ErrTwoFactorAlreadyEnabled", err)
+	}
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	u, users, twoFactorRepo, _ := newTestTwoFactor(t)
+	ctx := context.Background()
+	secret, recovery := enroll(t, u, users, domain.RoleUser)
+
+	// A recovery code cannot mint new ones
+	if _, err := u.RegenerateRecoveryCodes(ctx, 1, recovery[0]); !errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+		t.Fatalf("with a recovery code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	codes, err := u.RegenerateRecoveryCodes(ctx, 1, totpAt(t, secret, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(twoFactorRepo.recovery[1]) != recoveryCodeCount {
+		t.Fatalf("got %d codes, stored %d, want %d", len(codes), len(twoFactorRepo.recovery[1]), recoveryCodeCount)
+	}
+	if err := u.verifyCode(ctx, &domain.User{ID: 1}, recovery[1], true); !errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+		t.Fatalf("old recovery code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
+
+func TestLoginWithTwoFactorReturnsChallenge(t *testing.T) {
+	u, repo, _, _, sessions := newTestAuth(t)
+	ctx := context.Background()
+	if _, err := u.Register(ctx, &domain.RegisterRequest{Email: "siswa@contoh.com", Password: "rahasia123", Name: "Siswa"}); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := repo.GetByID(ctx, 1)
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.IsVerified, user.TOTPEnabled, user.TOTPSecret = true, true, secret
+	repo.Update(ctx, user)
+
+	resp, err := u.Login(ctx, &domain.LoginRequest{Email: "siswa@contoh.com", Password: "rahasia123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token != "" || resp.RefreshToken != "" || resp.TwoFactor == nil || len(sessions.sessions) != 0 {
+		t.Fatalf("login = %+v, want a challenge and no session", resp)
+	}
+}
+
+func TestTwoFactorChallengeWrongCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		wrong     int
+		wantSpent bool
+	}{
+		{"a few wrong codes", 4, false},
+		{"too many wrong codes", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, users, twoFactorRepo, sessionRepo := newTestTwoFactor(t)
+			ctx := context.Background()
+			secret, _ := enroll(t, u, users, domain.RoleUser)
+			user, _ := users.GetByID(ctx, 1)
+			challenge, err := u.SignIn(ctx, user, domain.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := challenge.TwoFactor.ChallengeToken
+
+			for i := 0; i < tt.wrong; i++ {
+				if _, err := u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: "000000x"}); !errors.Is(err, domain.ErrInvalidTwoFactorCode) {
+					t.Fatalf("wrong code %d: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+				}
+			}
+			if spent := len(twoFactorRepo.challenges) == 0; spent != tt.wantSpent {
+				t.Fatalf("challenge thrown away = %v after %d wrong codes, want %v", spent, tt.wrong, tt.wantSpent)
+			}
+
+			_, err = u.CompleteLogin(ctx, &domain.TwoFactorLoginRequest{ChallengeToken: token, Code: totpAt(t, secret, time.Now())})
+			if (err == nil) == tt.wantSpent || (len(sessionRepo.sessions) == 1) == tt.wantSpent {
+				t.Fatalf("right code after %d wrong ones: err = %v, %d sessions", tt.wrong, err, len(sessionRepo.sessions))
+			}
+		})
+	}
+}
@@ -3,21 +3,33 @@ package usecase
 import (
 	"context"
 	"errors"
+	"fmt"
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/database"
 	"pushtaka/services/identity/internal/domain"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type userUsecase struct {
 	userRepo       domain.UserRepository
+	publisher      domain.UserPublisher
+	obligations    domain.ObligationChecker
+	transactor     database.Transactor
+	auditRepo      domain.AuditRepository
+	passwords      domain.PasswordPolicy
 	contextTimeout time.Duration
 }
 
-func NewUserUsecase(userRepo domain.UserRepository, timeout time.Duration) domain.UserUsecase {
+func NewUserUsecase(userRepo domain.UserRepository, publisher domain.UserPublisher, obligations domain.ObligationChecker, transactor database.Transactor, auditRepo domain.AuditRepository, passwords domain.PasswordPolicy, timeout time.Duration) domain.UserUsecase {
 	return &userUsecase{
 		userRepo:       userRepo,
+		publisher:      publisher,
+		obligations:    obligations,
+		transactor:     transactor,
+		auditRepo:      auditRepo,
+		passwords:      passwords,
 		contextTimeout: timeout,
 	}
 }
@@ -45,39 +57,44 @@ func (u *userUsecase) CreateUser(c context.Context, req *domain.RegisterRequest)
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	existingUser, err := u.userRepo.GetByEmailUnscoped(ctx, req.Email)
+	existingUser, _ := u.userRepo.GetByEmailUnscoped(ctx, req.Email)
 	if existingUser != nil {
 		if existingUser.DeletedAt.Valid {
 			// Restore deleted user
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-			if err != nil {
+			if err := u.passwords.Set(ctx, existingUser, req.Password); err != nil {
 				return err
 			}
 			existingUser.DeletedAt = gorm.DeletedAt{}
 			existingUser.Name = req.Name
-			existingUser.Password = string(hashedPassword)
 			existingUser.Role = domain.RoleUser
 			existingUser.IsVerified = true
 
-			return u.userRepo.Update(ctx, existingUser)
+			return u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+				if err := u.userRepo.Update(ctx, existingUser); err != nil {
+					return err
+				}
+				return u.publisher.PublishUserRegistered(ctx, existingUser)
+			})
 		}
 		return errors.New("email already exists")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-
 	newUser := &domain.User{
 		Email:      req.Email,
-		Password:   string(hashedPassword),
 		Name:       req.Name,
 		Role:       domain.RoleUser, // Default to user, admin can update role later if needed
 		IsVerified: true,            // Admin created users are auto-verified
 	}
+	if err := u.passwords.Set(ctx, newUser, req.Password); err != nil {
+		return err
+	}
 
-	return u.userRepo.Create(ctx, newUser)
+	return u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.userRepo.Create(ctx, newUser); err != nil {
+			return err
+		}
+		return u.publisher.PublishUserRegistered(ctx, newUser)
+	})
 }
 
 func (u *userUsecase) UpdateUser(c context.Context, id uint, req *domain.UpdateUserRequest) error {
@@ -101,34 +118,118 @@ func (u *userUsecase) UpdateUser(c context.Context, id uint, req *domain.UpdateU
 		user.Email = req.Email
 	}
 	if req.Role != "" {
-		// Validate role?
-		if req.Role != domain.RoleUser && req.Role != domain.RoleAdmin {
+		if !auth.ValidRole(req.Role) {
 			return errors.New("invalid role")
 		}
+		if req.Role != user.Role {
+			// Tokens issued under the old role stop working
+			user.TokenVersion++
+		}
 		user.Role = req.Role
 	}
 	if req.IsVerified != nil {
 		user.IsVerified = *req.IsVerified
 	}
+	unlock := req.Unlock && (user.FailedLogins > 0 || user.LockedUntil != nil)
+	if unlock {
+		user.FailedLogins = 0
+		user.LastFailedLoginAt = nil
+		user.LockedUntil = nil
+	}
 
-	return u.userRepo.Update(ctx, user)
+	if err := u.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	auth.ForgetTokenVersion(user.ID)
+
+	if unlock {
+		return u.auditRepo.Record(ctx, &domain.AuditLog{
+			Event:   domain.AuditAccountUnlocked,
+			UserID:  &user.ID,
+			ActorID: &req.ActorID,
+			Detail:  "unlocked by admin",
+		})
+	}
+	return nil
 }
 
 func (u *userUsecase) DeleteUser(c context.Context, id uint, permanent bool) error {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	if permanent {
-		return u.userRepo.DeletePermanent(ctx, id)
+	if err := u.ensureDeletable(ctx, id); err != nil {
+		return err
 	}
 
-	return u.userRepo.Delete(ctx, id)
+	err := u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Revoke outstanding tokens, including if the account is restored later
+		if err := u.userRepo.BumpTokenVersion(ctx, []uint{id}); err != nil {
+			return err
+		}
+
+		var err error
+		if permanent {
+			err = u.userRepo.DeletePermanent(ctx, id)
+		} else {
+			err = u.userRepo.Delete(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		return u.publisher.PublishUserDeleted(ctx, id, permanent)
+	})
+	if err != nil {
+		return err
+	}
+	auth.ForgetTokenVersion(id)
+	return nil
 }
 
 func (u *userUsecase) DeleteUsers(ctx context.Context, ids []uint, permanent bool) error {
 	ctx, cancel := context.WithTimeout(ctx, u.contextTimeout)
 	defer cancel()
-	return u.userRepo.DeleteBatch(ctx, ids, permanent)
+
+	// All or nothing: refuse the batch if any member still owes something
+	for _, id := range ids {
+		if err := u.ensureDeletable(ctx, id); err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+	}
+
+	err := u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.userRepo.BumpTokenVersion(ctx, ids); err != nil {
+			return err
+		}
+		if err := u.userRepo.DeleteBatch(ctx, ids, permanent); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := u.publisher.PublishUserDeleted(ctx, id, permanent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		auth.ForgetTokenVersion(id)
+	}
+	return nil
+}
+
+// ensureDeletable asks the transaction service whether the member still has
+// open loans or unpaid fines. It fails closed when the service cannot answer.
+func (u *userUsecase) ensureDeletable(ctx context.Context, id uint) error {
+	obligations, err := u.obligations.UserObligations(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot verify loans and fines: %v", err)
+	}
+	if obligations.OpenLoans > 0 || obligations.UnpaidFines > 0 {
+		return domain.ErrUserHasObligations
+	}
+	return nil
 }
 
 func (u *userUsecase) GetProfile(c context.Context, id uint) (*domain.User, error) {
@@ -147,13 +248,9 @@ func (u *userUsecase) UpdateProfile(c context.Context, id uint, req *domain.Upda
 	if req.Name != "" {
 		user.Name = req.Name
 	}
-	if req.Email != "" {
-		// Check if email taken by another user
-		existingUser, _ := u.userRepo.GetByEmail(ctx, req.Email)
-		if existingUser != nil && existingUser.ID != user.ID {
-			return errors.New("email already used by another user")
-		}
-		user.Email = req.Email
+	if req.Email != "" && req.Email != user.Email {
+		// The new address has to prove itself first
+		return domain.ErrEmailChangeUnconfirmed
 	}
 
 	return u.userRepo.Update(ctx, user)
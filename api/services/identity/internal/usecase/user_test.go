@@ -0,0 +1,259 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/client"
+	"pushtaka/pkg/events"
+	"pushtaka/services/identity/internal/domain"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestUserChangesPublishEvents(t *testing.T) {
+	ctx := context.Background()
+	req := &domain.RegisterRequest{Email: "siswa@contoh.com", Password: "rahasia123", Name: "Siswa"}
+
+	tests := []struct {
+		name    string
+		setup   func(*fakeUserRepo)
+		change  func(domain.UserUsecase) error
+		wantErr string
+		want    []publishedEvent
+	}{
+		{"admin creates a member", nil, func(u domain.UserUsecase) error {
+			return u.CreateUser(ctx, req)
+		}, "", []publishedEvent{{events.TypeUserRegistered, 2, false, true}}},
+		{"admin restores a deleted member", func(r *fakeUserRepo) {
+			r.Create(ctx, &domain.User{Email: req.Email, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}})
+		}, func(u domain.UserUsecase) error {
+			return u.CreateUser(ctx, req)
+		}, "", []publishedEvent{{events.TypeUserRegistered, 2, false, true}}},
+		{"email taken", func(r *fakeUserRepo) {
+			r.Create(ctx, &domain.User{Email: req.Email})
+		}, func(u domain.UserUsecase) error {
+			return u.CreateUser(ctx, req)
+		}, "email already exists", nil},
+		{"soft delete", nil, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, false)
+		}, "", []publishedEvent{{events.TypeUserDeleted, 1, false, true}}},
+		{"permanent delete", nil, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, true)
+		}, "", []publishedEvent{{events.TypeUserDeleted, 1, true, true}}},
+		{"delete an unknown member", nil, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 42, false)
+		}, gorm.ErrRecordNotFound.Error(), nil},
+		{"batch delete", func(r *fakeUserRepo) {
+			r.Create(ctx, &domain.User{Email: "guru@contoh.com"})
+		}, func(u domain.UserUsecase) error {
+			return u.DeleteUsers(ctx, []uint{1, 2}, false)
+		}, "", []publishedEvent{{events.TypeUserDeleted, 1, false, true}, {events.TypeUserDeleted, 2, false, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			repo.Create(ctx, &domain.User{Email: "admin@contoh.com", Role: domain.RoleAdmin})
+			if tt.setup != nil {
+				tt.setup(repo)
+			}
+			publisher := &fakePublisher{}
+			u := NewUserUsecase(repo, publisher, &fakeObligations{}, fakeTransactor{}, &fakeAuditRepo{}, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			err := tt.change(u)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if !slices.Equal(publisher.events, tt.want) {
+				t.Fatalf("published %+v, want %+v", publisher.events, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteIsGatedByObligations(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		obligations *fakeObligations
+		delete      func(domain.UserUsecase) error
+		wantErr     string
+	}{
+		{"member with an open loan", &fakeObligations{owed: map[uint]client.Obligations{1: {OpenLoans: 1}}}, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, false)
+		}, domain.ErrUserHasObligations.Error()},
+		{"member with an unpaid fine", &fakeObligations{owed: map[uint]client.Obligations{1: {UnpaidFines: 1, FineAmount: 2000}}}, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, true)
+		}, domain.ErrUserHasObligations.Error()},
+		{"transaction service unreachable", &fakeObligations{err: errors.New("connection refused")}, func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, false)
+		}, "cannot verify loans and fines: connection refused"},
+		{"one member in the batch owes", &fakeObligations{owed: map[uint]client.Obligations{2: {OpenLoans: 2}}}, func(u domain.UserUsecase) error {
+			return u.DeleteUsers(ctx, []uint{1, 2}, false)
+		}, "user 2: " + domain.ErrUserHasObligations.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			repo.Create(ctx, &domain.User{Email: "siswa@contoh.com"})
+			repo.Create(ctx, &domain.User{Email: "guru@contoh.com"})
+			publisher := &fakePublisher{}
+			u := NewUserUsecase(repo, publisher, tt.obligations, fakeTransactor{}, &fakeAuditRepo{}, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			err := tt.delete(u)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			for _, id := range []uint{1, 2} {
+				if _, err := repo.GetByID(ctx, id); err != nil {
+					t.Errorf("user %d was deleted: %v", id, err)
+				}
+			}
+			if len(publisher.events) != 0 {
+				t.Errorf("published %+v, want nothing", publisher.events)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		role        string
+		wantErr     bool
+		want        string
+		wantVersion int
+	}{
+		{domain.RoleLibrarian, false, domain.RoleLibrarian, 1},
+		{domain.RoleCataloguer, false, domain.RoleCataloguer, 1},
+		{domain.RoleAdmin, false, domain.RoleAdmin, 1},
+		{domain.RoleUser, false, domain.RoleUser, 0},
+		{"", false, domain.RoleUser, 0},
+		{"superuser", true, domain.RoleUser, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			repo.Create(ctx, &domain.User{Email: "siswa@contoh.com", Role: domain.RoleUser})
+			u := NewUserUsecase(repo, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, &fakeAuditRepo{}, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			err := u.UpdateUser(ctx, 1, &domain.UpdateUserRequest{Role: tt.role})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			// Tokens issued under the old role stop working
+			if user, _ := repo.GetByID(ctx, 1); user.Role != tt.want || user.TokenVersion != tt.wantVersion {
+				t.Fatalf("role = %q at token version %d, want %q at %d", user.Role, user.TokenVersion, tt.want, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDeleteRevokesTokens(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		delete func(domain.UserUsecase) error
+		want   map[uint]int
+	}{
+		{"soft delete", func(u domain.UserUsecase) error {
+			return u.DeleteUser(ctx, 1, false)
+		}, map[uint]int{1: 1, 2: 0}},
+		{"batch delete", func(u domain.UserUsecase) error {
+			return u.DeleteUsers(ctx, []uint{1, 2}, false)
+		}, map[uint]int{1: 1, 2: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			repo.Create(ctx, &domain.User{Email: "siswa@contoh.com"})
+			repo.Create(ctx, &domain.User{Email: "guru@contoh.com"})
+			u := NewUserUsecase(repo, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, &fakeAuditRepo{}, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			if err := tt.delete(u); err != nil {
+				t.Fatal(err)
+			}
+			// Kept on the soft-deleted row so a restored account starts over
+			for id, want := range tt.want {
+				if got := repo.users[id].TokenVersion; got != want {
+					t.Errorf("user %d token version = %d, want %d", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateUserUnlock(t *testing.T) {
+	ctx := context.Background()
+	until := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		user        domain.User
+		unlock      bool
+		wantLocked  bool
+		wantAudited bool
+	}{
+		{"locked account unlocked", domain.User{FailedLogins: 7, LockedUntil: &until}, true, false, true},
+		{"failures cleared", domain.User{FailedLogins: 3}, true, false, true},
+		{"nothing to unlock", domain.User{}, true, false, false},
+		{"other changes leave the lock", domain.User{FailedLogins: 7, LockedUntil: &until}, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			tt.user.Email = "siswa@contoh.com"
+			repo.Create(ctx, &tt.user)
+			audits := &fakeAuditRepo{}
+			u := NewUserUsecase(repo, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, audits, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			if err := u.UpdateUser(ctx, 1, &domain.UpdateUserRequest{Name: "Siswa", Unlock: tt.unlock, ActorID: 9}); err != nil {
+				t.Fatal(err)
+			}
+			user, _ := repo.GetByID(ctx, 1)
+			if locked := user.LockedUntil != nil || user.FailedLogins != 0; locked != tt.wantLocked {
+				t.Fatalf("locked = %v, want %v", locked, tt.wantLocked)
+			}
+			if audited := len(audits.entries) == 1; audited != tt.wantAudited {
+				t.Fatalf("audit log = %+v, want an entry %v", audits.entries, tt.wantAudited)
+			}
+			if tt.wantAudited && (audits.entries[0].Event != domain.AuditAccountUnlocked || *audits.entries[0].ActorID != 9) {
+				t.Fatalf("audited %+v, want the unlock by admin 9", audits.entries[0])
+			}
+		})
+	}
+}
+
+func TestUpdateProfileEmail(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		req     domain.UpdateProfileRequest
+		want    domain.User
+		wantErr error
+	}{
+		{"name only", domain.UpdateProfileRequest{Name: "Siswa Baru"}, domain.User{Name: "Siswa Baru", Email: "siswa@contoh.com"}, nil},
+		{"same email", domain.UpdateProfileRequest{Name: "Siswa Baru", Email: "siswa@contoh.com"}, domain.User{Name: "Siswa Baru", Email: "siswa@contoh.com"}, nil},
+		{"new email", domain.UpdateProfileRequest{Email: "baru@contoh.com"}, domain.User{Name: "Siswa", Email: "siswa@contoh.com"}, domain.ErrEmailChangeUnconfirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepo()
+			repo.Create(ctx, &domain.User{Name: "Siswa", Email: "siswa@contoh.com"})
+			u := NewUserUsecase(repo, &fakePublisher{}, &fakeObligations{}, fakeTransactor{}, &fakeAuditRepo{}, NewPasswordPolicy(repo, newFakePasswordHistory(), nil), time.Second)
+
+			if err := u.UpdateProfile(ctx, 1, &tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if user, _ := repo.GetByID(ctx, 1); user.Name != tt.want.Name || user.Email != tt.want.Email {
+				t.Fatalf("profile = %s <%s>, want %s <%s>", user.Name, user.Email, tt.want.Name, tt.want.Email)
+			}
+		})
+	}
+}
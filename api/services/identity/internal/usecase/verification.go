@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"pushtaka/pkg/auth"
+	"pushtaka/services/identity/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	otpTTL         = 5 * time.Minute
+	actionTokenTTL = 15 * time.Minute
+)
+
+// issueOTP replaces the user's code for purpose with a fresh one and returns
+// it for mailing. newEmail is the address a PurposeConfirmEmail code confirms.
+func (u *authUsecase) issueOTP(ctx context.Context, user *domain.User, purpose string, newEmail string) (string, error) {
+	otp := auth.GenerateOTP()
+	err := u.verifications.Issue(ctx, &domain.VerificationToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: u.otpHash(user.ID, purpose, otp),
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(otpTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return otp, nil
+}
+
+// checkOTP uses up token if code matches it. Wrong codes count towards the
+// lockout and, past otp_max_attempts, throw the token away.
+func (u *authUsecase) checkOTP(ctx context.Context, user *domain.User, token *domain.VerificationToken, code string, ip string) error {
+	if subtle.ConstantTimeCompare([]byte(token.TokenHash), []byte(u.otpHash(user.ID, token.Purpose, code))) != 1 {
+		return u.otpFailed(ctx, user, token, ip, errors.New("invalid otp"))
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return errors.New("otp expired")
+	}
+	if err := u.verifications.Consume(ctx, token.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid otp")
+		}
+		return err
+	}
+	return nil
+}
+
+// otpFailed counts a wrong OTP and throws the OTP away once it has been
+// guessed at too often. token is nil when the user holds no code at all. It
+// returns cause for the caller to report.
+func (u *authUsecase) otpFailed(ctx context.Context, user *domain.User, token *domain.VerificationToken, ip string, cause error) error {
+	attempts := 0
+	if token != nil {
+		var err error
+		if attempts, err = u.verifications.RecordFailure(ctx, token.ID); err != nil {
+			return err
+		}
+	}
+	spent, err := u.guard.FailCode(ctx, user, ip, attempts, domain.AuditOTPInvalidated)
+	if err != nil {
+		return err
+	}
+	if spent {
+		if err := u.verifications.Consume(ctx, token.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	return cause
+}
+
+// issueActionToken returns a single-use token for the request that follows
+// a verified OTP, such as setting a new password.
+func (u *authUsecase) issueActionToken(ctx context.Context, userID uint, purpose string) (string, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	err = u.verifications.Issue(ctx, &domain.VerificationToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(actionTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// findActionToken looks up an unused, unexpired token for purpose. The
+// caller uses it up with consumeActionToken once the request checks out.
+func (u *authUsecase) findActionToken(ctx context.Context, purpose string, token string) (*domain.VerificationToken, error) {
+	found, err := u.verifications.GetByHash(ctx, purpose, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+	if time.Now().After(found.ExpiresAt) {
+		return nil, domain.ErrInvalidVerificationToken
+	}
+	return found, nil
+}
+
+func (u *authUsecase) consumeActionToken(ctx context.Context, token *domain.VerificationToken) error {
+	if err := u.verifications.Consume(ctx, token.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return domain.ErrInvalidVerificationToken
+		}
+		return err
+	}
+	return nil
+}
+
+// otpHash binds a code to its user and purpose, so the same six digits
+// issued elsewhere never match. A million codes are quickly tried against a
+// plain hash, so it is keyed with the server's OTP key: without it, a copy
+// of the table gives nothing away.
+func (u *authUsecase) otpHash(userID uint, purpose string, otp string) string {
+	mac := hmac.New(sha256.New, u.otpKey)
+	fmt.Fprintf(mac, "%d:%s:%s", userID, purpose, otp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"pushtaka/pkg/auth"
 	"pushtaka/pkg/database"
 	"pushtaka/pkg/messaging"
+	"pushtaka/pkg/messaging/httpapi"
 	"pushtaka/services/transaction/internal/domain"
 	"pushtaka/services/transaction/internal/handler"
 	"pushtaka/services/transaction/internal/repository"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	amqp "github.com/rabbitmq/amqp091-go"
 	_ "gorm.io/driver/postgres"
 	_ "gorm.io/gorm"
 )
@@ -34,28 +38,33 @@ func main() {
 	}
 
 	// Auto Migrate
-	db.AutoMigrate(&domain.Transaction{})
+	db.AutoMigrate(&domain.Transaction{}, &domain.Loan{}, &domain.Hold{}, &messaging.OutboxMessage{}, &messaging.DeadLetter{}, &messaging.ProcessedMessage{})
 
-	// RabbitMQ
-	conn, ch, err := messaging.ConnectRabbitMQ(os.Getenv("RABBITMQ_URL"))
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
-	}
-	defer conn.Close()
-	defer ch.Close()
-
-	// Queue Declare
-	_, err = ch.QueueDeclare(
-		"stock_updates", // name
-		true,            // durable
-		false,           // delete when unused
-		false,           // exclusive
-		false,           // no-wait
-		nil,             // arguments
-	)
-	if err != nil {
-		log.Fatalf("Failed to declare queue: %v", err)
-	}
+	// Reject tokens revoked by a password reset, role change or deletion
+	auth.UseTokenVersions(auth.NewTokenVersionCache(db, 10*time.Second))
+
+	// Verify tokens against the identity service's published keys
+	auth.UseKeys(auth.NewJWKSCache(os.Getenv("JWKS_URL"), 10*time.Minute))
+
+	// RabbitMQ, re-established automatically if the broker restarts
+	mq := messaging.NewConnectionManager(os.Getenv("RABBITMQ_URL"))
+	mq.DeclareTopology(func(ch *amqp.Channel) error {
+		for _, name := range []string{"stock_updates", "stock_reservations", "stock_reservation_results"} {
+			_, err := ch.QueueDeclare(
+				name,  // name
+				true,  // durable
+				false, // delete when unused
+				false, // exclusive
+				false, // no-wait
+				nil,   // arguments
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	mq.DeclareTopology(messaging.DeclareEventExchange)
 
 	// App
 	app := fiber.New()
@@ -64,16 +73,56 @@ func main() {
 	// Init Layers
 	timeoutContext := time.Duration(2) * time.Second
 	txRepo := repository.NewPostgresTransactionRepo(db)
-	txUsecase := usecase.NewTransactionUsecase(txRepo, timeoutContext, ch)
+	loanRepo := repository.NewPostgresLoanRepo(db)
+	holdRepo := repository.NewPostgresHoldRepo(db)
+	holdUsecase := usecase.NewHoldUsecase(holdRepo, loanRepo, txRepo, timeoutContext)
+	outbox := messaging.NewOutbox(db, "transaction")
+	txUsecase := usecase.NewTransactionUsecase(txRepo, loanRepo, holdUsecase, timeoutContext, database.NewTransactor(db), outbox)
+	deadLetters := messaging.NewDeadLetterStore(db, "transaction", outbox)
+
+	// Migrate legacy borrow/return rows to loans and fine statuses
+	if err := loanRepo.BackfillFromTransactions(context.Background()); err != nil {
+		log.Printf("Failed to backfill loans: %v", err)
+	}
+	if err := txRepo.BackfillFineStatus(context.Background()); err != nil {
+		log.Printf("Failed to backfill fine statuses: %v", err)
+	}
+
+	// Start Consumers
+	consumer := msgConsumer.NewConsumer(txUsecase, messaging.NewLedger(db))
+	mq.Consume("book_deleted_queue", consumer.Start)
+	mq.Consume("stock_reservation_results", consumer.StartReservationResults)
+	mq.Consume("transaction.user_events", consumer.StartUserEvents)
+
+	// Archive messages that exhausted their retries
+	mq.Consume("dead_letters", func(ch *amqp.Channel) error {
+		return deadLetters.Archive(ch, "book_deleted_queue", "stock_reservation_results", "transaction.user_events")
+	})
+
+	mq.Start(context.Background())
+
+	// Relay outbox messages to RabbitMQ
+	go messaging.NewOutboxRelay(db, mq, "transaction").Run(context.Background())
 
-	// Start Consumer
-	consumer := msgConsumer.NewConsumer(txUsecase)
+	// Overdue Loans, Hold Expiry & Promotion
 	go func() {
-		consumer.Start(conn)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := txUsecase.MarkOverdueLoans(context.Background()); err != nil {
+				log.Printf("Failed to mark overdue loans: %v", err)
+			}
+			if err := holdUsecase.ProcessHolds(context.Background()); err != nil {
+				log.Printf("Failed to process holds: %v", err)
+			}
+		}
 	}()
 
 	// Init Handler
+	httpapi.NewHealthHandler(app, "/transactions", mq)
 	handler.NewTransactionHandler(app, txUsecase)
+	handler.NewHoldHandler(app, holdUsecase)
+	httpapi.NewDeadLetterHandler(app, "/transactions", deadLetters)
 
 	log.Fatal(app.Listen(":3000"))
 }
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	HoldStatusWaiting   = "waiting"   // In the queue, no copy assigned yet
+	HoldStatusReady     = "ready"     // A returned copy is set aside for pickup
+	HoldStatusFulfilled = "fulfilled" // Member borrowed the held copy
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired" // Not picked up before ExpiresAt
+)
+
+type Hold struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	UserID    uint           `gorm:"not null;index" json:"user_id"`
+	User      *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	BookID    uint           `gorm:"not null;index:idx_hold_book_status" json:"book_id"`
+	Book      *Book          `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	Status    string         `gorm:"not null;default:'waiting';index:idx_hold_book_status" json:"status"`
+	Position  int            `gorm:"-" json:"position,omitempty"` // Queue position, computed on read
+	ReadyAt   *time.Time     `json:"ready_at"`
+	ExpiresAt *time.Time     `json:"expires_at"` // Pickup deadline once ready
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+type HoldRepository interface {
+	Create(ctx context.Context, hold *Hold) error
+	Update(ctx context.Context, hold *Hold) error
+	GetByUserID(ctx context.Context, userID uint) ([]Hold, error)
+	GetActiveByUserAndBook(ctx context.Context, userID uint, bookID uint) (*Hold, error)
+	GetNextWaiting(ctx context.Context, bookID uint) (*Hold, error)
+	CountAhead(ctx context.Context, hold *Hold) (int64, error)
+	CountByStatus(ctx context.Context, bookID uint, status string) (int64, error)
+	GetExpiredReady(ctx context.Context, now time.Time) ([]Hold, error)
+	GetWaitingBookIDs(ctx context.Context) ([]uint, error)
+}
+
+type HoldUsecase interface {
+	PlaceHold(ctx context.Context, userID uint, bookID uint) (*Hold, error)
+	CancelHold(ctx context.Context, userID uint, bookID uint) error
+	MyHolds(ctx context.Context, userID uint) ([]Hold, error)
+	GetQueuePosition(ctx context.Context, userID uint, bookID uint) (*Hold, error)
+
+	// Circulation hooks
+	CheckAvailability(ctx context.Context, userID uint, bookID uint) error
+	HasWaitingHolds(ctx context.Context, bookID uint) (bool, error)
+	FulfillHold(ctx context.Context, userID uint, bookID uint) error
+	PromoteNext(ctx context.Context, bookID uint) error
+	ProcessHolds(ctx context.Context) error
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LoanState string
+
+const (
+	LoanRequested       LoanState = "requested" // Waiting for the book service to reserve stock
+	LoanRejected        LoanState = "rejected"  // Stock reservation refused
+	LoanActive          LoanState = "active"
+	LoanOverdue         LoanState = "overdue"
+	LoanReturned        LoanState = "returned"
+	LoanLost            LoanState = "lost"
+	LoanClaimedReturned LoanState = "claimed_returned" // Member says it is back, staff has not confirmed
+)
+
+// OpenLoanStates are the states in which the member still holds the copy.
+var OpenLoanStates = []LoanState{LoanRequested, LoanActive, LoanOverdue, LoanClaimedReturned}
+
+var loanTransitions = map[LoanState][]LoanState{
+	LoanRequested:       {LoanActive, LoanRejected},
+	LoanActive:          {LoanOverdue, LoanReturned, LoanLost, LoanClaimedReturned},
+	LoanOverdue:         {LoanReturned, LoanLost, LoanClaimedReturned},
+	LoanClaimedReturned: {LoanReturned, LoanLost},
+}
+
+var ErrInvalidTransition = errors.New("invalid loan transition")
+
+// TransitionError is returned when a loan is asked to move to a state that
+// is not reachable from its current one.
+type TransitionError struct {
+	From LoanState
+	To   LoanState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("loan cannot go from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+type Loan struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	UserID       uint           `gorm:"not null;index:idx_loan_user_state" json:"user_id"`
+	User         *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	BookID       uint           `gorm:"not null;index:idx_loan_book_state" json:"book_id"`
+	Book         *Book          `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	CopyID       uint           `gorm:"index" json:"copy_id,omitempty"` // Physical copy lent out; zero for loans made before copies were tracked
+	Barcode      string         `json:"barcode,omitempty"`
+	State        LoanState      `gorm:"type:varchar(32);not null;default:'requested';index:idx_loan_user_state;index:idx_loan_book_state" json:"state"`
+	DueDate      *time.Time     `json:"due_date"`
+	ReturnedAt   *time.Time     `json:"returned_at"`
+	RenewCount   int            `gorm:"default:0" json:"renew_count"`
+	RejectReason string         `json:"reject_reason,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (l *Loan) CanTransition(to LoanState) bool {
+	for _, s := range loanTransitions[l.State] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the loan to the given state or returns a *TransitionError.
+func (l *Loan) Transition(to LoanState) error {
+	if !l.CanTransition(to) {
+		return &TransitionError{From: l.State, To: to}
+	}
+	l.State = to
+	return nil
+}
+
+// LegacyStatus is the value mirrored into the borrow transaction's Status
+// field for clients that still read loan state from the history rows.
+func (l *Loan) LegacyStatus() string {
+	switch l.State {
+	case LoanRequested:
+		return "pending"
+	case LoanActive, LoanOverdue:
+		return "active"
+	default:
+		return string(l.State)
+	}
+}
+
+type LoanRepository interface {
+	Create(ctx context.Context, loan *Loan) error
+	Update(ctx context.Context, loan *Loan) error
+	GetByID(ctx context.Context, id uint) (*Loan, error)
+	GetByUserID(ctx context.Context, userID uint) ([]Loan, error)
+	GetOpenLoan(ctx context.Context, userID uint, bookID uint) (*Loan, error)
+	GetOpenLoanByCopy(ctx context.Context, copyID uint) (*Loan, error)
+	CountOpenLoans(ctx context.Context, userID uint) (int64, error)
+	CountOpenLoansByBook(ctx context.Context, bookID uint) (int64, error)
+	GetPastDue(ctx context.Context, now time.Time) ([]Loan, error)
+	BackfillFromTransactions(ctx context.Context) error
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+var allLoanStates = []LoanState{LoanRequested, LoanRejected, LoanActive, LoanOverdue, LoanReturned, LoanLost, LoanClaimedReturned}
+
+// legalTransitions is the loan lifecycle written out pair by pair, so a
+// change to loanTransitions has to be made here as well.
+var legalTransitions = map[[2]LoanState]bool{
+	{LoanRequested, LoanActive}:         true,
+	{LoanRequested, LoanRejected}:       true,
+	{LoanActive, LoanOverdue}:           true,
+	{LoanActive, LoanReturned}:          true,
+	{LoanActive, LoanLost}:              true,
+	{LoanActive, LoanClaimedReturned}:   true,
+	{LoanOverdue, LoanReturned}:         true,
+	{LoanOverdue, LoanLost}:             true,
+	{LoanOverdue, LoanClaimedReturned}:  true,
+	{LoanClaimedReturned, LoanReturned}: true,
+	{LoanClaimedReturned, LoanLost}:     true,
+}
+
+func TestLoanTransition(t *testing.T) {
+	for _, from := range allLoanStates {
+		for _, to := range allLoanStates {
+			legal := legalTransitions[[2]LoanState{from, to}]
+			loan := &Loan{State: from}
+
+			if got := loan.CanTransition(to); got != legal {
+				t.Errorf("CanTransition(%s -> %s) = %v, want %v", from, to, got, legal)
+			}
+
+			err := loan.Transition(to)
+			if legal {
+				if err != nil {
+					t.Errorf("Transition(%s -> %s): %v", from, to, err)
+				}
+				if loan.State != to {
+					t.Errorf("Transition(%s -> %s) left the loan %s", from, to, loan.State)
+				}
+				continue
+			}
+
+			if !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("Transition(%s -> %s) = %v, want ErrInvalidTransition", from, to, err)
+				continue
+			}
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) || transitionErr.From != from || transitionErr.To != to {
+				t.Errorf("Transition(%s -> %s) = %#v, want a TransitionError naming both states", from, to, err)
+			}
+			if loan.State != from {
+				t.Errorf("refused Transition(%s -> %s) still moved the loan to %s", from, to, loan.State)
+			}
+		}
+	}
+}
+
+func TestLoanFinalStates(t *testing.T) {
+	for _, state := range []LoanState{LoanRejected, LoanReturned, LoanLost} {
+		for _, to := range allLoanStates {
+			if (&Loan{State: state}).CanTransition(to) {
+				t.Errorf("%s is final but can go to %s", state, to)
+			}
+		}
+	}
+}
+
+func TestLoanLegacyStatus(t *testing.T) {
+	want := map[LoanState]string{
+		LoanRequested:       "pending",
+		LoanRejected:        "rejected",
+		LoanActive:          "active",
+		LoanOverdue:         "active",
+		LoanReturned:        "returned",
+		LoanLost:            "lost",
+		LoanClaimedReturned: "claimed_returned",
+	}
+	for _, state := range allLoanStates {
+		if got := (&Loan{State: state}).LegacyStatus(); got != want[state] {
+			t.Errorf("LegacyStatus(%s) = %q, want %q", state, got, want[state])
+		}
+	}
+}
@@ -2,6 +2,8 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"pushtaka/pkg/events"
 	"time"
 
 	"gorm.io/gorm"
@@ -33,17 +35,33 @@ type User struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// BookCopy represents a physical copy from the book service, read by the
+// circulation desk to resolve scanned barcodes
+type BookCopy struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	BookID    uint           `json:"book_id"`
+	Barcode   string         `json:"barcode"`
+	Status    string         `json:"status"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+const CopyAvailable = "available"
+
 type Transaction struct {
 	ID         uint           `gorm:"primaryKey" json:"id"`
 	UserID     uint           `gorm:"not null" json:"user_id"`
 	User       *User          `gorm:"foreignKey:UserID" json:"user,omitempty"` // Preloaded user details
 	BookID     uint           `gorm:"not null" json:"book_id"`
 	Book       *Book          `gorm:"foreignKey:BookID" json:"book,omitempty"` // Preloaded book details
+	LoanID     *uint          `gorm:"index" json:"loan_id"`
+	Loan       *Loan          `gorm:"foreignKey:LoanID" json:"loan,omitempty"` // Authoritative loan state
+	StaffID    *uint          `gorm:"index" json:"staff_id,omitempty"` // Staff member who served it at the circulation desk
 	Action     string         `gorm:"not null" json:"action"` // "borrow" or "return"
-	Status     string         `gorm:"default:'pending'" json:"status"`
+	Status     string         `gorm:"default:'pending'" json:"status"` // Legacy mirror, see Loan.LegacyStatus
 	DueDate    *time.Time     `json:"due_date"`
 	ReturnDate *time.Time     `json:"return_date"`
 	Fine       int            `json:"fine"`
+	FineStatus string         `gorm:"index" json:"fine_status"` // Tracked separately from the loan state
 	PaidAt     *time.Time     `json:"paid_at"` // Timestamp when fine was paid
 	PaymentMethod string      `json:"payment_method"` // "qris" or "manual"
 	PaymentProof  string      `json:"payment_proof"`  // URL or base64 for manual transfer
@@ -52,6 +70,13 @@ type Transaction struct {
 	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+const (
+	FineStatusNone                = ""
+	FineStatusUnpaid              = "unpaid"
+	FineStatusPendingVerification = "pending_verification"
+	FineStatusPaid                = "paid"
+)
+
 type Config struct {
 	ID          uint   `gorm:"primaryKey" json:"id"`
 	Key         string `gorm:"uniqueIndex;not null" json:"key"`
@@ -62,12 +87,55 @@ type Config struct {
 }
 
 type Settings struct {
-	BorrowDuration     int    `json:"borrow_duration"`
-	BorrowDurationUnit string `json:"borrow_duration_unit"` // "minute", "hour", "day"
-	FineAmount         int    `json:"fine_amount"`
-	FineUnit           string `json:"fine_unit"`           // "minute", "hour", "day", "month"
-	FineDuration       int    `json:"fine_duration"`       // e.g., per 2 (minutes)
-	MaxBorrowLimit     int    `json:"max_borrow_limit"`
+	BorrowDuration         int    `json:"borrow_duration"`
+	BorrowDurationUnit     string `json:"borrow_duration_unit"` // "minute", "hour", "day"
+	FineAmount             int    `json:"fine_amount"`
+	FineUnit               string `json:"fine_unit"`     // "minute", "hour", "day", "month"
+	FineDuration           int    `json:"fine_duration"` // e.g., per 2 (minutes)
+	MaxBorrowLimit         int    `json:"max_borrow_limit"`
+	MaxRenewals            *int   `json:"max_renewals"` // Use pointer to distinguish between 0 and missing
+	HoldPickupDuration     int    `json:"hold_pickup_duration"`
+	HoldPickupDurationUnit string `json:"hold_pickup_duration_unit"` // "minute", "hour", "day"
+}
+
+var ErrOutstandingObligations = errors.New("open loans or unpaid fines remain")
+
+// Obligations counts the open loans and unpaid fines of a member or a book.
+// Neither can be deleted while any remain.
+type Obligations struct {
+	OpenLoans   int64 `json:"open_loans"`
+	UnpaidFines int   `json:"unpaid_fines"`
+	FineAmount  int   `json:"fine_amount"`
+}
+
+func (o *Obligations) Outstanding() bool {
+	return o.OpenLoans > 0 || o.UnpaidFines > 0
+}
+
+var (
+	ErrMemberNotFound  = errors.New("member not found")
+	ErrUnknownBarcode  = errors.New("unknown barcode")
+	ErrCopyUnavailable = errors.New("copy is not available for loan")
+	ErrCopyNotOnLoan   = errors.New("copy is not on loan")
+)
+
+// DeskCheckoutRequest is sent by staff scanning a member card and the items
+// the member wants to take out. Member is the member's ID or email.
+type DeskCheckoutRequest struct {
+	Member   string   `json:"member"`
+	Barcodes []string `json:"barcodes"`
+}
+
+type DeskCheckinRequest struct {
+	Barcode string `json:"barcode"`
+}
+
+// CheckoutResult reports what happened to one scanned item. Loan is set when
+// the loan was opened; it stays requested until the book service confirms.
+type CheckoutResult struct {
+	Barcode string `json:"barcode"`
+	Loan    *Loan  `json:"loan,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 type TransactionRepository interface {
@@ -75,20 +143,38 @@ type TransactionRepository interface {
 	Update(ctx context.Context, transaction *Transaction) error
 	GetByUserID(ctx context.Context, userID uint) ([]Transaction, error)
 	GetByID(ctx context.Context, id uint) (*Transaction, error)
-	CountActiveBorrows(ctx context.Context, userID uint) (int64, error)
-	GetActiveBorrow(ctx context.Context, userID uint, bookID uint) (*Transaction, error)
+	GetBorrowByLoanID(ctx context.Context, loanID uint) (*Transaction, error)
 	GetUnpaidFines(ctx context.Context, userID uint) ([]Transaction, error)
+	GetUnpaidFinesByBook(ctx context.Context, bookID uint) ([]Transaction, error)
 	GetAll(ctx context.Context) ([]Transaction, error)
+	GetBook(ctx context.Context, bookID uint) (*Book, error)
+	GetCopyByBarcode(ctx context.Context, barcode string) (*BookCopy, error)
+	FindMember(ctx context.Context, identifier string) (*User, error)
 	
 	// Config
 	GetConfig(ctx context.Context, key string) (string, error)
 	UpdateConfig(ctx context.Context, key string, value string) error
 	DeleteByBookID(ctx context.Context, bookID uint) error
+	DeleteByUserID(ctx context.Context, userID uint) error
+	BackfillFineStatus(ctx context.Context) error
 }
 
 type TransactionUsecase interface {
-	BorrowBook(ctx context.Context, userID uint, bookID uint) error
+	BorrowBook(ctx context.Context, userID uint, bookID uint) (*Loan, error)
+	CompleteReservation(ctx context.Context, result events.StockReservationCompleted) error
 	ReturnBook(ctx context.Context, userID uint, bookID uint) error
+	RenewBook(ctx context.Context, userID uint, transactionID uint) (*Loan, error)
+
+	// Circulation desk, staff acting for a member
+	CheckoutCopies(ctx context.Context, staffID uint, member string, barcodes []string) ([]CheckoutResult, error)
+	CheckinCopy(ctx context.Context, staffID uint, barcode string) (*Loan, error)
+
+	// Loans
+	MyLoans(ctx context.Context, userID uint) ([]Loan, error)
+	ClaimReturned(ctx context.Context, userID uint, loanID uint) error
+	ConfirmReturn(ctx context.Context, loanID uint) error
+	MarkLost(ctx context.Context, loanID uint) error
+	MarkOverdueLoans(ctx context.Context) error
 
 	History(ctx context.Context, userID uint) ([]Transaction, error)
 	GetAllHistory(ctx context.Context) ([]Transaction, error)
@@ -106,4 +192,9 @@ type TransactionUsecase interface {
 	// Test/Debug helpers
 	MakeLate(ctx context.Context, userID uint, transactionID uint, daysLate int) error
 	DeleteByBookID(ctx context.Context, bookID uint) error
+	GetBookObligations(ctx context.Context, bookID uint) (*Obligations, error)
+
+	// Member lifecycle
+	GetUserObligations(ctx context.Context, userID uint) (*Obligations, error)
+	DeleteByUserID(ctx context.Context, userID uint) error
 }
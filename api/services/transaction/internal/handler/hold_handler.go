@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"pushtaka/pkg/auth"
+	"pushtaka/pkg/utils"
+	"pushtaka/services/transaction/internal/domain"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HoldHandler struct {
+	holdUsecase domain.HoldUsecase
+}
+
+// NewHoldHandler must be registered after NewTransactionHandler so the JWT
+// middleware applied there also covers these routes.
+func NewHoldHandler(app *fiber.App, holdUsecase domain.HoldUsecase) {
+	handler := &HoldHandler{
+		holdUsecase: holdUsecase,
+	}
+
+	app.Get("/transactions/holds", handler.MyHolds)
+	app.Get("/transactions/holds/:book_id", handler.GetQueuePosition)
+	app.Post("/transactions/holds/:book_id", handler.PlaceHold)
+	app.Delete("/transactions/holds/:book_id", handler.CancelHold)
+}
+
+func (h *HoldHandler) MyHolds(c *fiber.Ctx) error {
+	userID := auth.GetUserID(c)
+	holds, err := h.holdUsecase.MyHolds(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("holds retrieved", holds))
+}
+
+func (h *HoldHandler) GetQueuePosition(c *fiber.Ctx) error {
+	bookID, err := strconv.Atoi(c.Params("book_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
+	}
+	userID := auth.GetUserID(c)
+
+	hold, err := h.holdUsecase.GetQueuePosition(c.Context(), userID, uint(bookID))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("hold retrieved", hold))
+}
+
+func (h *HoldHandler) PlaceHold(c *fiber.Ctx) error {
+	bookID, err := strconv.Atoi(c.Params("book_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
+	}
+	userID := auth.GetUserID(c)
+
+	hold, err := h.holdUsecase.PlaceHold(c.Context(), userID, uint(bookID))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
+	}
+	return c.Status(fiber.StatusCreated).JSON(utils.Success("hold placed successfully", hold))
+}
+
+func (h *HoldHandler) CancelHold(c *fiber.Ctx) error {
+	bookID, err := strconv.Atoi(c.Params("book_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
+	}
+	userID := auth.GetUserID(c)
+
+	if err := h.holdUsecase.CancelHold(c.Context(), userID, uint(bookID)); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("hold cancelled successfully", nil))
+}
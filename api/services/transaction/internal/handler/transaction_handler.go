@@ -1,8 +1,9 @@
 package handler
 
 import (
-	"os"
+	"errors"
 	"pushtaka/pkg/auth"
+	"pushtaka/pkg/middleware"
 	"pushtaka/pkg/utils"
 	"pushtaka/services/transaction/internal/domain"
 	"strconv"
@@ -23,23 +24,38 @@ func NewTransactionHandler(app *fiber.App, txUsecase domain.TransactionUsecase)
 	app.Post("/transactions/callback", handler.CallbackFine)
 
 	// Protected Routes
-	app.Use(auth.Middleware(os.Getenv("JWT_SECRET")))
+	app.Use(middleware.RequireAuth())
 	app.Post("/transactions/borrow/:id", handler.Borrow)
 	app.Post("/transactions/return/:id", handler.Return)
+	app.Post("/transactions/renew/:id", handler.Renew)
+
+	// Circulation desk, staff scanning a member card and item barcodes
+	app.Post("/transactions/desk/checkout", middleware.RequirePermission(auth.PermLoansCirculate), handler.DeskCheckout)
+	app.Post("/transactions/desk/checkin", middleware.RequirePermission(auth.PermLoansCirculate), handler.DeskCheckin)
+
+	// Loans
+	app.Get("/transactions/loans", handler.MyLoans)
+	app.Post("/transactions/loans/:id/claim-returned", handler.ClaimReturned)
+	app.Post("/transactions/loans/:id/confirm-return", middleware.RequirePermission(auth.PermLoansOverride), handler.ConfirmReturn)
+	app.Post("/transactions/loans/:id/lost", middleware.RequirePermission(auth.PermLoansOverride), handler.MarkLost)
 	// app.Post("/transactions/pay-fine/:id", handler.PayFine) // Override below
 	app.Get("/transactions/history", handler.History)
-	app.Get("/transactions", handler.GetAllTransactions)
+	app.Get("/transactions", middleware.RequirePermission(auth.PermLoansRead), handler.GetAllTransactions)
 	
 	// Settings
 	app.Get("/transactions/settings", handler.GetSettings)
-	app.Post("/transactions/settings", handler.UpdateSettings)
+	app.Post("/transactions/settings", middleware.RequirePermission(auth.PermSettingsManage), handler.UpdateSettings)
 
 	// Fine Management
 	app.Get("/transactions/fines", handler.GetMyFines)
 	app.Post("/transactions/pay-fine/:id", handler.PayFine)
-	app.Post("/transactions/verify/:id", handler.VerifyFine)
+	app.Post("/transactions/verify/:id", middleware.RequirePermission(auth.PermFinesManage), handler.VerifyFine)
 	// app.Post("/transactions/callback", handler.CallbackFine) // Moved up
 
+	// Pre-delete checks, asked by the identity and book services
+	app.Get("/transactions/admin/users/:id/obligations", middleware.RequirePermission(auth.PermLoansRead), handler.GetUserObligations)
+	app.Get("/transactions/admin/books/:id/obligations", middleware.RequirePermission(auth.PermLoansRead), handler.GetBookObligations)
+
 	// Test/Debug helpers
 	app.Post("/transactions/test/make-late/:id", handler.MakeLate)
 }
@@ -53,11 +69,6 @@ func (h *TransactionHandler) GetSettings(c *fiber.Ctx) error {
 }
 
 func (h *TransactionHandler) UpdateSettings(c *fiber.Ctx) error {
-	role := auth.GetUserRole(c)
-	if role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(utils.Error("access denied: admins only"))
-	}
-
 	var settings domain.Settings
 	if err := c.BodyParser(&settings); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid request body"))
@@ -78,11 +89,12 @@ func (h *TransactionHandler) Borrow(c *fiber.Ctx) error {
 	}
 	userID := auth.GetUserID(c)
 
-	if err := h.txUsecase.BorrowBook(c.Context(), userID, uint(bookID)); err != nil {
+	loan, err := h.txUsecase.BorrowBook(c.Context(), userID, uint(bookID))
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
 	}
 
-	return c.JSON(utils.Success("book borrowed successfully", nil))
+	return c.Status(fiber.StatusAccepted).JSON(utils.Success("borrow request received, awaiting stock confirmation", loan))
 }
 
 func (h *TransactionHandler) Return(c *fiber.Ctx) error {
@@ -94,12 +106,123 @@ func (h *TransactionHandler) Return(c *fiber.Ctx) error {
 	userID := auth.GetUserID(c)
 
 	if err := h.txUsecase.ReturnBook(c.Context(), userID, uint(bookID)); err != nil {
+		return c.Status(loanErrorStatus(err, fiber.StatusInternalServerError)).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("book returned successfully", nil))
+}
+
+func (h *TransactionHandler) DeskCheckout(c *fiber.Ctx) error {
+	var req domain.DeskCheckoutRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid request body"))
+	}
+	if req.Member == "" || len(req.Barcodes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("member and barcodes are required"))
+	}
+
+	results, err := h.txUsecase.CheckoutCopies(c.Context(), auth.GetUserID(c), req.Member, req.Barcodes)
+	if err != nil {
+		if errors.Is(err, domain.ErrMemberNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("checkout processed", results))
+}
+
+func (h *TransactionHandler) DeskCheckin(c *fiber.Ctx) error {
+	var req domain.DeskCheckinRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid request body"))
+	}
+	if req.Barcode == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("barcode is required"))
+	}
+
+	loan, err := h.txUsecase.CheckinCopy(c.Context(), auth.GetUserID(c), req.Barcode)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrUnknownBarcode):
+			return c.Status(fiber.StatusNotFound).JSON(utils.Error(err.Error()))
+		case errors.Is(err, domain.ErrCopyNotOnLoan):
+			return c.Status(fiber.StatusConflict).JSON(utils.Error(err.Error()))
+		}
+		return c.Status(loanErrorStatus(err, fiber.StatusInternalServerError)).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("book returned successfully", loan))
+}
+
+func (h *TransactionHandler) Renew(c *fiber.Ctx) error {
+	param := c.Params("id")
+	transactionID, err := strconv.Atoi(param)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid transaction id"))
+	}
+	userID := auth.GetUserID(c)
+
+	loan, err := h.txUsecase.RenewBook(c.Context(), userID, uint(transactionID))
+	if err != nil {
+		return c.Status(loanErrorStatus(err, fiber.StatusBadRequest)).JSON(utils.Error(err.Error()))
+	}
+
+	return c.JSON(utils.Success("loan renewed successfully", loan))
+}
+
+func (h *TransactionHandler) MyLoans(c *fiber.Ctx) error {
+	userID := auth.GetUserID(c)
+	loans, err := h.txUsecase.MyLoans(c.Context(), userID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
 	}
+	return c.JSON(utils.Success("loans retrieved", loans))
+}
+
+func (h *TransactionHandler) ClaimReturned(c *fiber.Ctx) error {
+	loanID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid loan id"))
+	}
+	userID := auth.GetUserID(c)
+
+	if err := h.txUsecase.ClaimReturned(c.Context(), userID, uint(loanID)); err != nil {
+		return c.Status(loanErrorStatus(err, fiber.StatusBadRequest)).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("return claim recorded, awaiting confirmation", nil))
+}
+
+func (h *TransactionHandler) ConfirmReturn(c *fiber.Ctx) error {
+	loanID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid loan id"))
+	}
 
+	if err := h.txUsecase.ConfirmReturn(c.Context(), uint(loanID)); err != nil {
+		return c.Status(loanErrorStatus(err, fiber.StatusBadRequest)).JSON(utils.Error(err.Error()))
+	}
 	return c.JSON(utils.Success("book returned successfully", nil))
 }
 
+func (h *TransactionHandler) MarkLost(c *fiber.Ctx) error {
+	loanID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid loan id"))
+	}
+
+	if err := h.txUsecase.MarkLost(c.Context(), uint(loanID)); err != nil {
+		return c.Status(loanErrorStatus(err, fiber.StatusBadRequest)).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("loan marked as lost", nil))
+}
+
+// loanErrorStatus maps rejected loan state transitions to 409 Conflict.
+func loanErrorStatus(err error, fallback int) int {
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		return fiber.StatusConflict
+	}
+	return fallback
+}
+
 func (h *TransactionHandler) History(c *fiber.Ctx) error {
 	userID := auth.GetUserID(c)
 	history, err := h.txUsecase.History(c.Context(), userID)
@@ -110,11 +233,6 @@ func (h *TransactionHandler) History(c *fiber.Ctx) error {
 }
 
 func (h *TransactionHandler) GetAllTransactions(c *fiber.Ctx) error {
-	role := auth.GetUserRole(c)
-	if role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(utils.Error("access denied: admins only"))
-	}
-
 	history, err := h.txUsecase.GetAllHistory(c.Context())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
@@ -124,6 +242,32 @@ func (h *TransactionHandler) GetAllTransactions(c *fiber.Ctx) error {
 
 
 
+func (h *TransactionHandler) GetUserObligations(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid user id"))
+	}
+
+	obligations, err := h.txUsecase.GetUserObligations(c.Context(), uint(id))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("user obligations retrieved", obligations))
+}
+
+func (h *TransactionHandler) GetBookObligations(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid book id"))
+	}
+
+	obligations, err := h.txUsecase.GetBookObligations(c.Context(), uint(id))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.Error(err.Error()))
+	}
+	return c.JSON(utils.Success("book obligations retrieved", obligations))
+}
+
 func (h *TransactionHandler) GetMyFines(c *fiber.Ctx) error {
 	userID := auth.GetUserID(c)
 	fines, err := h.txUsecase.GetMyFines(c.Context(), userID)
@@ -168,15 +312,10 @@ func (h *TransactionHandler) PayFine(c *fiber.Ctx) error {
 }
 
 type VerifyFineRequest struct {
-	Action string `json:"action"` // "approve" or "reject"
+	Action string `json:"action"` // "approve", "reject" or "waive"
 }
 
 func (h *TransactionHandler) VerifyFine(c *fiber.Ctx) error {
-	role := auth.GetUserRole(c)
-	if role != "admin" {
-		return c.Status(fiber.StatusForbidden).JSON(utils.Error("access denied: admins only"))
-	}
-
 	param := c.Params("id")
 	transactionID, err := strconv.Atoi(param)
 	if err != nil {
@@ -188,6 +327,10 @@ func (h *TransactionHandler) VerifyFine(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error("invalid request body"))
 	}
 
+	if req.Action == "waive" && !auth.HasPermission(c, auth.PermFinesWaive) {
+		return c.Status(fiber.StatusForbidden).JSON(utils.Error("Insufficient permissions"))
+	}
+
 	if err := h.txUsecase.VerifyFine(c.Context(), uint(transactionID), req.Action); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.Error(err.Error()))
 	}
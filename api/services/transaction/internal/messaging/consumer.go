@@ -2,8 +2,11 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
 	"pushtaka/services/transaction/internal/domain"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -11,75 +14,115 @@ import (
 
 type Consumer struct {
 	txUsecase domain.TransactionUsecase
+	ledger    *pkgMessaging.Ledger
 }
 
-func NewConsumer(txUsecase domain.TransactionUsecase) *Consumer {
-	return &Consumer{txUsecase: txUsecase}
+func NewConsumer(txUsecase domain.TransactionUsecase, ledger *pkgMessaging.Ledger) *Consumer {
+	return &Consumer{txUsecase: txUsecase, ledger: ledger}
 }
 
-func (c *Consumer) Start(conn *amqp.Connection) {
-	ch, err := conn.Channel()
+// Start consumes BookDeleted events. It blocks until ch is closed.
+func (c *Consumer) Start(ch *amqp.Channel) error {
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, "book_deleted_queue", pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
+	}
+
+	log.Println("Waiting for book deleted messages...")
+
+	return pkgMessaging.Consume(ch, "book_deleted_queue", pkgMessaging.DefaultRetryPolicy, c.ledger.Wrap("transaction:book_deleted_queue", func(ctx context.Context, d amqp.Delivery) error {
+		return c.bookDeleted(ctx, d.Body)
+	}))
+}
+
+// bookDeleted soft-deletes the records of the book in body. A book with
+// loans or fines still open needs an admin to settle, so it is parked in the
+// dead-letter queue rather than retried.
+func (c *Consumer) bookDeleted(ctx context.Context, body []byte) error {
+	event, _, err := events.Decode[events.BookDeleted](body)
 	if err != nil {
-		log.Printf("Failed to open channel: %v", err)
-		return
+		return pkgMessaging.Permanent(fmt.Errorf("error decoding JSON: %v", err))
+	}
+	if event.BookID == 0 {
+		return pkgMessaging.Permanent(errors.New("invalid event format: missing book_id"))
+	}
+	bookID := event.BookID
+
+	log.Printf("Received BookDeleted event: %d", bookID)
+
+	// Process Cascading Soft Delete
+	err = c.txUsecase.DeleteByBookID(ctx, bookID)
+	if errors.Is(err, domain.ErrOutstandingObligations) {
+		return pkgMessaging.Permanent(fmt.Errorf("book %d: %w", bookID, err))
 	}
-	defer ch.Close()
-
-	q, err := ch.QueueDeclare(
-		"book_deleted_queue", // name
-		true,                 // durable
-		false,                // delete when unused
-		false,                // exclusive
-		false,                // no-wait
-		nil,                  // arguments
-	)
 	if err != nil {
-		log.Printf("Failed to declare queue: %v", err)
-		return
+		return fmt.Errorf("failed to delete transactions for book %d: %v", bookID, err)
 	}
+	log.Printf("Successfully soft-deleted transactions for book %d", bookID)
+	return nil
+}
+
+// StartUserEvents consumes user.deleted from the events exchange and cleans up
+// the member's loans, fines and holds.
+func (c *Consumer) StartUserEvents(ch *amqp.Channel) error {
+	const queue = "transaction.user_events"
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, queue, pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
+	}
+	if err := pkgMessaging.BindEvents(ch, queue, events.TypeUserDeleted); err != nil {
+		return err
+	}
+
+	log.Println("Waiting for user events...")
 
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+	return pkgMessaging.Consume(ch, queue, pkgMessaging.DefaultRetryPolicy, c.ledger.Wrap("transaction:"+queue, func(ctx context.Context, d amqp.Delivery) error {
+		return c.userDeleted(ctx, d.Body)
+	}))
+}
+
+// userDeleted cleans up after the member in body. As with books, open loans
+// or fines park the event in the dead-letter queue.
+func (c *Consumer) userDeleted(ctx context.Context, body []byte) error {
+	event, _, err := events.Decode[events.UserDeleted](body)
 	if err != nil {
-		log.Printf("Failed to register consumer: %v", err)
-		return
+		return pkgMessaging.Permanent(fmt.Errorf("error decoding JSON: %v", err))
+	}
+	if event.UserID == 0 {
+		return pkgMessaging.Permanent(errors.New("invalid event format: missing user_id"))
 	}
 
-	log.Println("Waiting for book deleted messages...")
+	log.Printf("Received UserDeleted event: %d", event.UserID)
+
+	err = c.txUsecase.DeleteByUserID(ctx, event.UserID)
+	if errors.Is(err, domain.ErrOutstandingObligations) {
+		return pkgMessaging.Permanent(fmt.Errorf("user %d: %w", event.UserID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete records for user %d: %v", event.UserID, err)
+	}
+	log.Printf("Successfully soft-deleted records for user %d", event.UserID)
+	return nil
+}
+
+// StartReservationResults consumes the book service's answers to stock
+// reservation requests and completes the matching borrow.
+func (c *Consumer) StartReservationResults(ch *amqp.Channel) error {
+	if err := pkgMessaging.DeclareQueueWithRetry(ch, "stock_reservation_results", pkgMessaging.DefaultRetryPolicy); err != nil {
+		return err
+	}
 
-	forever := make(chan bool)
-
-	go func() {
-		for d := range msgs {
-			var event map[string]uint
-			if err := json.Unmarshal(d.Body, &event); err != nil {
-				log.Printf("Error decoding JSON: %v", err)
-				continue
-			}
-
-			bookID, ok := event["book_id"]
-			if !ok {
-				log.Printf("Invalid event format: missing book_id")
-				continue
-			}
-
-			log.Printf("Received BookDeleted event: %d", bookID)
-
-			// Process Cascading Soft Delete
-			if err := c.txUsecase.DeleteByBookID(context.Background(), bookID); err != nil {
-				log.Printf("Failed to delete transactions for book %d: %v", bookID, err)
-			} else {
-				log.Printf("Successfully soft-deleted transactions for book %d", bookID)
-			}
+	log.Println("Waiting for stock reservation results...")
+
+	return pkgMessaging.Consume(ch, "stock_reservation_results", pkgMessaging.DefaultRetryPolicy, c.ledger.Wrap("transaction:stock_reservation_results", func(ctx context.Context, d amqp.Delivery) error {
+		result, _, err := events.Decode[events.StockReservationCompleted](d.Body)
+		if err != nil {
+			return pkgMessaging.Permanent(fmt.Errorf("error decoding JSON: %v", err))
 		}
-	}()
 
-	<-forever
+		log.Printf("Received stock reservation result: %+v", result)
+
+		if err := c.txUsecase.CompleteReservation(ctx, result); err != nil {
+			return fmt.Errorf("failed to complete reservation for loan %d: %v", result.LoanID, err)
+		}
+		return nil
+	}))
 }
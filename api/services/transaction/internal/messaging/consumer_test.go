@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"pushtaka/pkg/events"
+	pkgMessaging "pushtaka/pkg/messaging"
+	"pushtaka/services/transaction/internal/domain"
+	"testing"
+)
+
+// fakeTxUsecase records the cleanups asked of it and fails them with err.
+type fakeTxUsecase struct {
+	domain.TransactionUsecase
+	err     error
+	deleted []uint
+}
+
+func (u *fakeTxUsecase) DeleteByBookID(ctx context.Context, bookID uint) error {
+	u.deleted = append(u.deleted, bookID)
+	return u.err
+}
+
+func (u *fakeTxUsecase) DeleteByUserID(ctx context.Context, userID uint) error {
+	u.deleted = append(u.deleted, userID)
+	return u.err
+}
+
+func marshal(t *testing.T, payload events.Payload) []byte {
+	t.Helper()
+	body, err := events.Marshal("test", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestDeletedEvents(t *testing.T) {
+	errDown := errors.New("database unavailable")
+
+	tests := []struct {
+		name          string
+		handle        func(c *Consumer, ctx context.Context, body []byte) error
+		payload       events.Payload
+		body          []byte // Sent instead of payload when set
+		usecaseErr    error
+		wantErr       error
+		wantPermanent bool
+		wantDeleted   bool
+	}{
+		{"book deleted", (*Consumer).bookDeleted, events.BookDeleted{BookID: 7}, nil, nil, nil, false, true},
+		{"book still owed", (*Consumer).bookDeleted, events.BookDeleted{BookID: 7}, nil, domain.ErrOutstandingObligations, domain.ErrOutstandingObligations, true, true},
+		{"book cleanup fails", (*Consumer).bookDeleted, events.BookDeleted{BookID: 7}, nil, errDown, nil, false, true},
+		{"book without id", (*Consumer).bookDeleted, events.BookDeleted{}, nil, nil, nil, true, false},
+		{"book event garbled", (*Consumer).bookDeleted, nil, []byte("{"), nil, nil, true, false},
+		{"user deleted", (*Consumer).userDeleted, events.UserDeleted{UserID: 7}, nil, nil, nil, false, true},
+		{"user still owes", (*Consumer).userDeleted, events.UserDeleted{UserID: 7}, nil, domain.ErrOutstandingObligations, domain.ErrOutstandingObligations, true, true},
+		{"user cleanup fails", (*Consumer).userDeleted, events.UserDeleted{UserID: 7}, nil, errDown, nil, false, true},
+		{"user without id", (*Consumer).userDeleted, events.UserDeleted{}, nil, nil, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txUsecase := &fakeTxUsecase{err: tt.usecaseErr}
+			body := tt.body
+			if body == nil {
+				body = marshal(t, tt.payload)
+			}
+
+			err := tt.handle(NewConsumer(txUsecase, nil), context.Background(), body)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.usecaseErr != nil || tt.wantPermanent) {
+				t.Fatalf("err = %v", err)
+			}
+			if pkgMessaging.IsPermanent(err) != tt.wantPermanent {
+				t.Fatalf("permanent = %v, want %v", pkgMessaging.IsPermanent(err), tt.wantPermanent)
+			}
+			if deleted := len(txUsecase.deleted) == 1 && txUsecase.deleted[0] == 7; deleted != tt.wantDeleted {
+				t.Fatalf("cleaned up %v", txUsecase.deleted)
+			}
+		})
+	}
+}
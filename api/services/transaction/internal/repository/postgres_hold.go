@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/transaction/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type postgresHoldRepo struct {
+	db *gorm.DB
+}
+
+func NewPostgresHoldRepo(db *gorm.DB) domain.HoldRepository {
+	return &postgresHoldRepo{db}
+}
+
+func (p *postgresHoldRepo) Create(ctx context.Context, hold *domain.Hold) error {
+	return database.Conn(ctx, p.db).Create(hold).Error
+}
+
+func (p *postgresHoldRepo) Update(ctx context.Context, hold *domain.Hold) error {
+	return database.Conn(ctx, p.db).Save(hold).Error
+}
+
+func (p *postgresHoldRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Hold, error) {
+	var holds []domain.Hold
+	err := database.Conn(ctx, p.db).
+		Preload("Book").
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&holds).Error
+	return holds, err
+}
+
+func (p *postgresHoldRepo) GetActiveByUserAndBook(ctx context.Context, userID uint, bookID uint) (*domain.Hold, error) {
+	var hold domain.Hold
+	err := database.Conn(ctx, p.db).
+		Where("user_id = ? AND book_id = ? AND status IN ?", userID, bookID, []string{domain.HoldStatusWaiting, domain.HoldStatusReady}).
+		First(&hold).Error
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (p *postgresHoldRepo) GetNextWaiting(ctx context.Context, bookID uint) (*domain.Hold, error) {
+	var hold domain.Hold
+	err := database.Conn(ctx, p.db).
+		Where("book_id = ? AND status = ?", bookID, domain.HoldStatusWaiting).
+		Order("created_at asc, id asc").
+		First(&hold).Error
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// CountAhead counts the waiting holds queued before the given one (FIFO).
+func (p *postgresHoldRepo) CountAhead(ctx context.Context, hold *domain.Hold) (int64, error) {
+	var count int64
+	err := database.Conn(ctx, p.db).Model(&domain.Hold{}).
+		Where("book_id = ? AND status = ?", hold.BookID, domain.HoldStatusWaiting).
+		Where("(created_at < ? OR (created_at = ? AND id < ?))", hold.CreatedAt, hold.CreatedAt, hold.ID).
+		Count(&count).Error
+	return count, err
+}
+
+func (p *postgresHoldRepo) CountByStatus(ctx context.Context, bookID uint, status string) (int64, error) {
+	var count int64
+	err := database.Conn(ctx, p.db).Model(&domain.Hold{}).
+		Where("book_id = ? AND status = ?", bookID, status).
+		Count(&count).Error
+	return count, err
+}
+
+func (p *postgresHoldRepo) GetExpiredReady(ctx context.Context, now time.Time) ([]domain.Hold, error) {
+	var holds []domain.Hold
+	err := database.Conn(ctx, p.db).
+		Where("status = ? AND expires_at < ?", domain.HoldStatusReady, now).
+		Find(&holds).Error
+	return holds, err
+}
+
+func (p *postgresHoldRepo) GetWaitingBookIDs(ctx context.Context) ([]uint, error) {
+	var bookIDs []uint
+	err := database.Conn(ctx, p.db).Model(&domain.Hold{}).
+		Where("status = ?", domain.HoldStatusWaiting).
+		Distinct().
+		Pluck("book_id", &bookIDs).Error
+	return bookIDs, err
+}
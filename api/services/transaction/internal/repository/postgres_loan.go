@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"pushtaka/pkg/database"
+	"pushtaka/services/transaction/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type postgresLoanRepo struct {
+	db *gorm.DB
+}
+
+func NewPostgresLoanRepo(db *gorm.DB) domain.LoanRepository {
+	return &postgresLoanRepo{db}
+}
+
+func (p *postgresLoanRepo) Create(ctx context.Context, loan *domain.Loan) error {
+	return database.Conn(ctx, p.db).Create(loan).Error
+}
+
+func (p *postgresLoanRepo) Update(ctx context.Context, loan *domain.Loan) error {
+	return database.Conn(ctx, p.db).Save(loan).Error
+}
+
+func (p *postgresLoanRepo) GetByID(ctx context.Context, id uint) (*domain.Loan, error) {
+	var loan domain.Loan
+	err := database.Conn(ctx, p.db).First(&loan, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+func (p *postgresLoanRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Loan, error) {
+	var loans []domain.Loan
+	err := database.Conn(ctx, p.db).
+		Preload("Book").
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&loans).Error
+	return loans, err
+}
+
+func (p *postgresLoanRepo) GetOpenLoan(ctx context.Context, userID uint, bookID uint) (*domain.Loan, error) {
+	var loan domain.Loan
+	err := database.Conn(ctx, p.db).
+		Where("user_id = ? AND book_id = ? AND state IN ?", userID, bookID, domain.OpenLoanStates).
+		Order("created_at desc").
+		First(&loan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+func (p *postgresLoanRepo) GetOpenLoanByCopy(ctx context.Context, copyID uint) (*domain.Loan, error) {
+	var loan domain.Loan
+	err := database.Conn(ctx, p.db).
+		Where("copy_id = ? AND state IN ?", copyID, domain.OpenLoanStates).
+		Order("created_at desc").
+		First(&loan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+func (p *postgresLoanRepo) CountOpenLoans(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := database.Conn(ctx, p.db).Model(&domain.Loan{}).
+		Where("user_id = ? AND state IN ?", userID, domain.OpenLoanStates).
+		Count(&count).Error
+	return count, err
+}
+
+func (p *postgresLoanRepo) CountOpenLoansByBook(ctx context.Context, bookID uint) (int64, error) {
+	var count int64
+	err := database.Conn(ctx, p.db).Model(&domain.Loan{}).
+		Where("book_id = ? AND state IN ?", bookID, domain.OpenLoanStates).
+		Count(&count).Error
+	return count, err
+}
+
+func (p *postgresLoanRepo) GetPastDue(ctx context.Context, now time.Time) ([]domain.Loan, error) {
+	var loans []domain.Loan
+	err := database.Conn(ctx, p.db).
+		Where("state = ? AND due_date < ?", domain.LoanActive, now).
+		Find(&loans).Error
+	return loans, err
+}
+
+// BackfillFromTransactions creates a loan for every legacy borrow row that
+// predates the loans table and links the row to it.
+func (p *postgresLoanRepo) BackfillFromTransactions(ctx context.Context) error {
+	var borrows []domain.Transaction
+	err := database.Conn(ctx, p.db).
+		Where("action = 'borrow' AND loan_id IS NULL").
+		Find(&borrows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, borrow := range borrows {
+		state := domain.LoanActive
+		if borrow.Status == "returned" {
+			state = domain.LoanReturned
+		}
+		loan := &domain.Loan{
+			UserID:    borrow.UserID,
+			BookID:    borrow.BookID,
+			State:     state,
+			DueDate:   borrow.DueDate,
+			CreatedAt: borrow.CreatedAt,
+		}
+
+		err := database.Conn(ctx, p.db).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(loan).Error; err != nil {
+				return err
+			}
+			return tx.Model(&domain.Transaction{}).Where("id = ?", borrow.ID).UpdateColumn("loan_id", loan.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"pushtaka/pkg/database"
 	"pushtaka/services/transaction/internal/domain"
+	"strconv"
 
 	"gorm.io/gorm"
 )
@@ -16,17 +18,18 @@ func NewPostgresTransactionRepo(db *gorm.DB) domain.TransactionRepository {
 }
 
 func (p *postgresTransactionRepo) Create(ctx context.Context, transaction *domain.Transaction) error {
-	return p.db.WithContext(ctx).Create(transaction).Error
+	return database.Conn(ctx, p.db).Create(transaction).Error
 }
 
 func (p *postgresTransactionRepo) Update(ctx context.Context, transaction *domain.Transaction) error {
-	return p.db.WithContext(ctx).Save(transaction).Error
+	return database.Conn(ctx, p.db).Save(transaction).Error
 }
 
 func (p *postgresTransactionRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Transaction, error) {
 	var transactions []domain.Transaction
-	err := p.db.WithContext(ctx).
+	err := database.Conn(ctx, p.db).
 		Preload("Book").
+		Preload("Loan").
 		Where("user_id = ?", userID).
 		Order("created_at desc").
 		Find(&transactions).Error
@@ -35,7 +38,7 @@ func (p *postgresTransactionRepo) GetByUserID(ctx context.Context, userID uint)
 
 func (p *postgresTransactionRepo) GetByID(ctx context.Context, id uint) (*domain.Transaction, error) {
 	var transaction domain.Transaction
-	err := p.db.WithContext(ctx).First(&transaction, id).Error
+	err := database.Conn(ctx, p.db).First(&transaction, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -44,62 +47,71 @@ func (p *postgresTransactionRepo) GetByID(ctx context.Context, id uint) (*domain
 
 func (p *postgresTransactionRepo) GetUnpaidFines(ctx context.Context, userID uint) ([]domain.Transaction, error) {
 	var transactions []domain.Transaction
-	err := p.db.WithContext(ctx).
-		Where("user_id = ? AND fine > 0 AND paid_at IS NULL", userID).
+	err := database.Conn(ctx, p.db).
+		Where("user_id = ? AND fine > 0 AND fine_status IN ?", userID, []string{domain.FineStatusUnpaid, domain.FineStatusPendingVerification}).
 		Order("created_at desc").
 		Find(&transactions).Error
 	return transactions, err
 }
 
+func (p *postgresTransactionRepo) GetUnpaidFinesByBook(ctx context.Context, bookID uint) ([]domain.Transaction, error) {
+	var transactions []domain.Transaction
+	err := database.Conn(ctx, p.db).
+		Where("book_id = ? AND fine > 0 AND fine_status IN ?", bookID, []string{domain.FineStatusUnpaid, domain.FineStatusPendingVerification}).
+		Find(&transactions).Error
+	return transactions, err
+}
+
 func (p *postgresTransactionRepo) GetAll(ctx context.Context) ([]domain.Transaction, error) {
 	var transactions []domain.Transaction
-	err := p.db.WithContext(ctx).
+	err := database.Conn(ctx, p.db).
 		Preload("Book").
 		Preload("User").
+		Preload("Loan").
 		Order("created_at desc").
 		Find(&transactions).Error
 	return transactions, err
 }
 
-func (p *postgresTransactionRepo) CountActiveBorrows(ctx context.Context, userID uint) (int64, error) {
-	var count int64
-	// Simplification: Count 'borrow' minus 'return' for specific book?
-	// For "Active Borrows count":
-	// We might need a better query or strategy. 
-	// Simple approach: Count 'borrow' transactions where there is no corresponding 'return' for the same book/user.
-	// This is complex in SQL.
-	// Alternative: Add 'status' field to transaction or a separate 'Borrow' table.
-	// Based on "Transaction" being an event log, reconstructing state is hard.
-	// Let's assume 'Status' field in Transaction handles it ? 
-	// Re-reading logic: "Create transaction". If I borrow, I create a record.
-	// If I return, I create another record?
-	// The prompt implies "Transaction Service" handles process.
-	// Let's stick to simple logic: 
-	// Find all borrows for user. Find all returns for user. Count difference?
-	// But we need to limit to 3 books.
-	// Let's assume we count (Borrow - Return).
-	
-	// Better query:
-	// SELECT count(*) FROM transactions t1 WHERE action = 'borrow' AND user_id = ? AND NOT EXISTS (SELECT 1 FROM transactions t2 WHERE t2.book_id = t1.book_id AND t2.user_id = t1.user_id AND t2.action = 'return' AND t2.created_at > t1.created_at)
-	
-	err := p.db.WithContext(ctx).Model(&domain.Transaction{}).
-		Where("user_id = ? AND action = 'borrow'", userID).
-		Where("NOT EXISTS (SELECT 1 FROM transactions t2 WHERE t2.book_id = transactions.book_id AND t2.user_id = transactions.user_id AND t2.action = 'return' AND t2.created_at > transactions.created_at)").
-		Count(&count).Error
-	
-	return count, err
+func (p *postgresTransactionRepo) GetBook(ctx context.Context, bookID uint) (*domain.Book, error) {
+	var book domain.Book
+	err := database.Conn(ctx, p.db).First(&book, bookID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
 }
 
-func (p *postgresTransactionRepo) GetActiveBorrow(ctx context.Context, userID uint, bookID uint) (*domain.Transaction, error) {
+func (p *postgresTransactionRepo) GetCopyByBarcode(ctx context.Context, barcode string) (*domain.BookCopy, error) {
+	var bookCopy domain.BookCopy
+	err := database.Conn(ctx, p.db).Where("barcode = ?", barcode).First(&bookCopy).Error
+	if err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+// FindMember looks a member up by the ID printed on their card or by email.
+func (p *postgresTransactionRepo) FindMember(ctx context.Context, identifier string) (*domain.User, error) {
+	query := database.Conn(ctx, p.db)
+	if id, err := strconv.ParseUint(identifier, 10, 64); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("LOWER(email) = LOWER(?)", identifier)
+	}
+
+	var user domain.User
+	if err := query.First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (p *postgresTransactionRepo) GetBorrowByLoanID(ctx context.Context, loanID uint) (*domain.Transaction, error) {
 	var transaction domain.Transaction
-	// Find the latest borrow for this book/user that hasn't been returned
-	// Logic: Find 'borrow' action. Ensure no 'return' exists after it.
-	err := p.db.WithContext(ctx).
-		Where("user_id = ? AND book_id = ? AND action = 'borrow'", userID, bookID).
-		Where("NOT EXISTS (SELECT 1 FROM transactions t2 WHERE t2.book_id = transactions.book_id AND t2.user_id = transactions.user_id AND t2.action = 'return' AND t2.created_at > transactions.created_at)").
-		Order("created_at desc").
+	err := database.Conn(ctx, p.db).
+		Where("loan_id = ? AND action = 'borrow'", loanID).
 		First(&transaction).Error
-		
 	if err != nil {
 		return nil, err
 	}
@@ -108,18 +120,18 @@ func (p *postgresTransactionRepo) GetActiveBorrow(ctx context.Context, userID ui
 
 func (p *postgresTransactionRepo) GetConfig(ctx context.Context, key string) (string, error) {
 	var config domain.Config
-	err := p.db.WithContext(ctx).Where("key = ?", key).First(&config).Error
+	err := database.Conn(ctx, p.db).Where("key = ?", key).First(&config).Error
 	return config.Value, err
 }
 
 func (p *postgresTransactionRepo) UpdateConfig(ctx context.Context, key string, value string) error {
 	var config domain.Config
 	// Check if exists
-	err := p.db.WithContext(ctx).Where("key = ?", key).First(&config).Error
+	err := database.Conn(ctx, p.db).Where("key = ?", key).First(&config).Error
 	if err == nil {
 		// Update
 		config.Value = value
-		return p.db.WithContext(ctx).Save(&config).Error
+		return database.Conn(ctx, p.db).Save(&config).Error
 	}
 	
 	// Create new
@@ -129,12 +141,30 @@ func (p *postgresTransactionRepo) UpdateConfig(ctx context.Context, key string,
 		Type: "int", // Default type
 		IsVisible: true,
 	}
-	return p.db.WithContext(ctx).Create(&newConfig).Error
+	return database.Conn(ctx, p.db).Create(&newConfig).Error
 }
 func (p *postgresTransactionRepo) DeleteByBookID(ctx context.Context, bookID uint) error {
-	result := p.db.WithContext(ctx).Where("book_id = ?", bookID).Delete(&domain.Transaction{})
-	if result.Error != nil {
-		return result.Error
+	for _, model := range []interface{}{&domain.Transaction{}, &domain.Loan{}, &domain.Hold{}} {
+		if err := database.Conn(ctx, p.db).Where("book_id = ?", bookID).Delete(model).Error; err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// DeleteByUserID soft-deletes a member's history, loans and holds.
+func (p *postgresTransactionRepo) DeleteByUserID(ctx context.Context, userID uint) error {
+	for _, model := range []interface{}{&domain.Transaction{}, &domain.Loan{}, &domain.Hold{}} {
+		if err := database.Conn(ctx, p.db).Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackfillFineStatus derives fine_status for fines recorded before the column existed.
+func (p *postgresTransactionRepo) BackfillFineStatus(ctx context.Context) error {
+	return database.Conn(ctx, p.db).Model(&domain.Transaction{}).
+		Where("fine > 0 AND (fine_status IS NULL OR fine_status = '')").
+		UpdateColumn("fine_status", gorm.Expr("CASE WHEN paid_at IS NULL THEN ? ELSE ? END", domain.FineStatusUnpaid, domain.FineStatusPaid)).Error
+}
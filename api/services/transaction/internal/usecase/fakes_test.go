@@ -0,0 +1,382 @@
+package usecase
+
+import (
+	"context"
+	"pushtaka/pkg/events"
+	"pushtaka/services/transaction/internal/domain"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// In-memory stand-ins for the repositories, enough for the usecases under
+// test. Methods a test does not need are left to the embedded interface and
+// panic if called.
+
+type fakeTxRepo struct {
+	domain.TransactionRepository
+	configs      map[string]string
+	books        map[uint]domain.Book
+	transactions []domain.Transaction
+	copies       []domain.BookCopy
+	members      []domain.User
+}
+
+func newFakeTxRepo() *fakeTxRepo {
+	return &fakeTxRepo{configs: make(map[string]string), books: make(map[uint]domain.Book)}
+}
+
+func (r *fakeTxRepo) GetConfig(ctx context.Context, key string) (string, error) {
+	val, ok := r.configs[key]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return val, nil
+}
+
+func (r *fakeTxRepo) UpdateConfig(ctx context.Context, key string, value string) error {
+	r.configs[key] = value
+	return nil
+}
+
+func (r *fakeTxRepo) GetBook(ctx context.Context, bookID uint) (*domain.Book, error) {
+	book, ok := r.books[bookID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &book, nil
+}
+
+func (r *fakeTxRepo) Create(ctx context.Context, tx *domain.Transaction) error {
+	tx.ID = uint(len(r.transactions) + 1)
+	r.transactions = append(r.transactions, *tx)
+	return nil
+}
+
+func (r *fakeTxRepo) Update(ctx context.Context, tx *domain.Transaction) error {
+	r.transactions[tx.ID-1] = *tx
+	return nil
+}
+
+func (r *fakeTxRepo) GetByID(ctx context.Context, id uint) (*domain.Transaction, error) {
+	if id == 0 || int(id) > len(r.transactions) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	tx := r.transactions[id-1]
+	return &tx, nil
+}
+
+func (r *fakeTxRepo) GetBorrowByLoanID(ctx context.Context, loanID uint) (*domain.Transaction, error) {
+	for _, tx := range r.transactions {
+		if tx.LoanID != nil && *tx.LoanID == loanID && tx.Action == "borrow" {
+			return &tx, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeTxRepo) GetUnpaidFines(ctx context.Context, userID uint) ([]domain.Transaction, error) {
+	var fines []domain.Transaction
+	for _, tx := range r.transactions {
+		if tx.UserID == userID && tx.Fine > 0 && (tx.FineStatus == domain.FineStatusUnpaid || tx.FineStatus == domain.FineStatusPendingVerification) {
+			fines = append(fines, tx)
+		}
+	}
+	return fines, nil
+}
+
+type fakeLoanRepo struct {
+	domain.LoanRepository
+	loans []domain.Loan
+}
+
+func (r *fakeLoanRepo) Create(ctx context.Context, loan *domain.Loan) error {
+	loan.ID = uint(len(r.loans) + 1)
+	r.loans = append(r.loans, *loan)
+	return nil
+}
+
+func (r *fakeLoanRepo) Update(ctx context.Context, loan *domain.Loan) error {
+	r.loans[loan.ID-1] = *loan
+	return nil
+}
+
+func (r *fakeLoanRepo) GetByID(ctx context.Context, id uint) (*domain.Loan, error) {
+	if id == 0 || int(id) > len(r.loans) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	loan := r.loans[id-1]
+	return &loan, nil
+}
+
+func (r *fakeLoanRepo) GetOpenLoan(ctx context.Context, userID uint, bookID uint) (*domain.Loan, error) {
+	for _, loan := range r.loans {
+		if loan.UserID == userID && loan.BookID == bookID && slices.Contains(domain.OpenLoanStates, loan.State) {
+			return &loan, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeLoanRepo) CountOpenLoans(ctx context.Context, userID uint) (int64, error) {
+	var n int64
+	for _, loan := range r.loans {
+		if loan.UserID == userID && slices.Contains(domain.OpenLoanStates, loan.State) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeLoanRepo) GetPastDue(ctx context.Context, now time.Time) ([]domain.Loan, error) {
+	var loans []domain.Loan
+	for _, loan := range r.loans {
+		if loan.State == domain.LoanActive && loan.DueDate != nil && loan.DueDate.Before(now) {
+			loans = append(loans, loan)
+		}
+	}
+	return loans, nil
+}
+
+// fakeHoldRepo keeps holds in creation order, which stands in for
+// created_at.
+type fakeHoldRepo struct {
+	holds []domain.Hold
+}
+
+func (r *fakeHoldRepo) Create(ctx context.Context, hold *domain.Hold) error {
+	hold.ID = uint(len(r.holds) + 1)
+	hold.CreatedAt = time.Now()
+	r.holds = append(r.holds, *hold)
+	return nil
+}
+
+func (r *fakeHoldRepo) Update(ctx context.Context, hold *domain.Hold) error {
+	r.holds[hold.ID-1] = *hold
+	return nil
+}
+
+func (r *fakeHoldRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Hold, error) {
+	var holds []domain.Hold
+	for _, hold := range r.holds {
+		if hold.UserID == userID {
+			holds = append(holds, hold)
+		}
+	}
+	return holds, nil
+}
+
+func (r *fakeHoldRepo) GetActiveByUserAndBook(ctx context.Context, userID uint, bookID uint) (*domain.Hold, error) {
+	for _, hold := range r.holds {
+		if hold.UserID == userID && hold.BookID == bookID && (hold.Status == domain.HoldStatusWaiting || hold.Status == domain.HoldStatusReady) {
+			return &hold, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeHoldRepo) GetNextWaiting(ctx context.Context, bookID uint) (*domain.Hold, error) {
+	for _, hold := range r.holds {
+		if hold.BookID == bookID && hold.Status == domain.HoldStatusWaiting {
+			return &hold, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeHoldRepo) CountAhead(ctx context.Context, hold *domain.Hold) (int64, error) {
+	var n int64
+	for _, other := range r.holds {
+		if other.BookID == hold.BookID && other.Status == domain.HoldStatusWaiting && other.ID < hold.ID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeHoldRepo) CountByStatus(ctx context.Context, bookID uint, status string) (int64, error) {
+	var n int64
+	for _, hold := range r.holds {
+		if hold.BookID == bookID && hold.Status == status {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeHoldRepo) GetExpiredReady(ctx context.Context, now time.Time) ([]domain.Hold, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var holds []domain.Hold
+	for _, hold := range r.holds {
+		if hold.Status == domain.HoldStatusReady && hold.ExpiresAt != nil && hold.ExpiresAt.Before(now) {
+			holds = append(holds, hold)
+		}
+	}
+	return holds, nil
+}
+
+func (r *fakeHoldRepo) GetWaitingBookIDs(ctx context.Context) ([]uint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, hold := range r.holds {
+		if hold.Status == domain.HoldStatusWaiting && !seen[hold.BookID] {
+			seen[hold.BookID] = true
+			ids = append(ids, hold.BookID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// statuses lists the hold statuses in creation order.
+func (r *fakeHoldRepo) statuses() []string {
+	var statuses []string
+	for _, hold := range r.holds {
+		statuses = append(statuses, hold.Status)
+	}
+	return statuses
+}
+
+type inTxKey struct{}
+
+// fakeTransactor marks the context so fakes can tell whether they were
+// called inside a transaction. It does not roll anything back.
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, inTxKey{}, true))
+}
+
+func inTx(ctx context.Context) bool {
+	v, _ := ctx.Value(inTxKey{}).(bool)
+	return v
+}
+
+type queuedMessage struct {
+	Exchange      string
+	RoutingKey    string
+	Type          string
+	ReplyTo       string
+	CorrelationID string
+	Payload       events.Payload
+	InTx          bool
+}
+
+// fakeOutbox records queued messages in order. Setting err makes every
+// enqueue fail.
+type fakeOutbox struct {
+	messages []queuedMessage
+	err      error
+}
+
+func (o *fakeOutbox) Enqueue(ctx context.Context, routingKey string, payload events.Payload) error {
+	return o.EnqueueWithReply(ctx, routingKey, "", "", payload)
+}
+
+func (o *fakeOutbox) EnqueueWithReply(ctx context.Context, routingKey, replyTo, correlationID string, payload events.Payload) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, queuedMessage{
+		RoutingKey:    routingKey,
+		Type:          payload.EventType(),
+		ReplyTo:       replyTo,
+		CorrelationID: correlationID,
+		Payload:       payload,
+		InTx:          inTx(ctx),
+	})
+	return nil
+}
+
+func (o *fakeOutbox) Publish(ctx context.Context, payload events.Payload) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, queuedMessage{
+		Exchange:   events.Exchange,
+		RoutingKey: payload.EventType(),
+		Type:       payload.EventType(),
+		Payload:    payload,
+		InTx:       inTx(ctx),
+	})
+	return nil
+}
+
+// types lists the types of the queued messages in order.
+func (o *fakeOutbox) types() []string {
+	var types []string
+	for _, msg := range o.messages {
+		types = append(types, msg.Type)
+	}
+	return types
+}
+
+func (r *fakeTxRepo) DeleteByUserID(ctx context.Context, userID uint) error {
+	r.transactions = slices.DeleteFunc(r.transactions, func(tx domain.Transaction) bool {
+		return tx.UserID == userID
+	})
+	return nil
+}
+
+func (r *fakeTxRepo) GetUnpaidFinesByBook(ctx context.Context, bookID uint) ([]domain.Transaction, error) {
+	var fines []domain.Transaction
+	for _, tx := range r.transactions {
+		if tx.BookID == bookID && tx.Fine > 0 && (tx.FineStatus == domain.FineStatusUnpaid || tx.FineStatus == domain.FineStatusPendingVerification) {
+			fines = append(fines, tx)
+		}
+	}
+	return fines, nil
+}
+
+func (r *fakeTxRepo) DeleteByBookID(ctx context.Context, bookID uint) error {
+	r.transactions = slices.DeleteFunc(r.transactions, func(tx domain.Transaction) bool {
+		return tx.BookID == bookID
+	})
+	return nil
+}
+
+func (r *fakeLoanRepo) CountOpenLoansByBook(ctx context.Context, bookID uint) (int64, error) {
+	var n int64
+	for _, loan := range r.loans {
+		if loan.BookID == bookID && slices.Contains(domain.OpenLoanStates, loan.State) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeTxRepo) GetCopyByBarcode(ctx context.Context, barcode string) (*domain.BookCopy, error) {
+	for _, bookCopy := range r.copies {
+		if bookCopy.Barcode == barcode {
+			return &bookCopy, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeTxRepo) FindMember(ctx context.Context, identifier string) (*domain.User, error) {
+	for _, user := range r.members {
+		if strconv.FormatUint(uint64(user.ID), 10) == identifier || strings.EqualFold(user.Email, identifier) {
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeLoanRepo) GetOpenLoanByCopy(ctx context.Context, copyID uint) (*domain.Loan, error) {
+	for _, loan := range r.loans {
+		if loan.CopyID == copyID && slices.Contains(domain.OpenLoanStates, loan.State) {
+			return &loan, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
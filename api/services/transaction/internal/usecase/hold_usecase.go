@@ -0,0 +1,285 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pushtaka/services/transaction/internal/domain"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type holdUsecase struct {
+	holdRepo       domain.HoldRepository
+	loanRepo       domain.LoanRepository
+	txRepo         domain.TransactionRepository
+	contextTimeout time.Duration
+}
+
+func NewHoldUsecase(holdRepo domain.HoldRepository, loanRepo domain.LoanRepository, txRepo domain.TransactionRepository, timeout time.Duration) domain.HoldUsecase {
+	return &holdUsecase{
+		holdRepo:       holdRepo,
+		loanRepo:       loanRepo,
+		txRepo:         txRepo,
+		contextTimeout: timeout,
+	}
+}
+
+func (u *holdUsecase) PlaceHold(c context.Context, userID uint, bookID uint) (*domain.Hold, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	// 1. Check if book exists
+	book, err := u.txRepo.GetBook(ctx, bookID)
+	if err != nil {
+		return nil, errors.New("book not found")
+	}
+
+	// 2. Same rules as borrowing: no unpaid fines, no copy already in hand
+	unpaidFines, err := u.txRepo.GetUnpaidFines(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(unpaidFines) > 0 {
+		return nil, errors.New("you have unpaid fines, please pay them first")
+	}
+	if openLoan, _ := u.loanRepo.GetOpenLoan(ctx, userID, bookID); openLoan != nil {
+		return nil, errors.New("you have already borrowed this book")
+	}
+
+	// 3. One hold per member per book
+	if existing, _ := u.holdRepo.GetActiveByUserAndBook(ctx, userID, bookID); existing != nil {
+		return nil, errors.New("you already have a hold on this book")
+	}
+
+	// 4. Only queue when no copy is free for walk-in borrowing
+	waiting, err := u.holdRepo.CountByStatus(ctx, bookID, domain.HoldStatusWaiting)
+	if err != nil {
+		return nil, err
+	}
+	ready, err := u.holdRepo.CountByStatus(ctx, bookID, domain.HoldStatusReady)
+	if err != nil {
+		return nil, err
+	}
+	if waiting == 0 && int64(book.Stock)-ready > 0 {
+		return nil, errors.New("book is available, borrow it directly")
+	}
+
+	// 5. Join the queue
+	hold := &domain.Hold{
+		UserID: userID,
+		BookID: bookID,
+		Status: domain.HoldStatusWaiting,
+	}
+	if err := u.holdRepo.Create(ctx, hold); err != nil {
+		return nil, err
+	}
+
+	if err := u.fillPosition(ctx, hold); err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (u *holdUsecase) CancelHold(c context.Context, userID uint, bookID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	hold, err := u.holdRepo.GetActiveByUserAndBook(ctx, userID, bookID)
+	if err != nil {
+		return errors.New("hold not found")
+	}
+
+	wasReady := hold.Status == domain.HoldStatusReady
+	hold.Status = domain.HoldStatusCancelled
+	if err := u.holdRepo.Update(ctx, hold); err != nil {
+		return err
+	}
+
+	// The copy set aside for this member goes to the next in line
+	if wasReady {
+		return u.PromoteNext(ctx, bookID)
+	}
+	return nil
+}
+
+func (u *holdUsecase) MyHolds(c context.Context, userID uint) ([]domain.Hold, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	holds, err := u.holdRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range holds {
+		if err := u.fillPosition(ctx, &holds[i]); err != nil {
+			return nil, err
+		}
+	}
+	return holds, nil
+}
+
+func (u *holdUsecase) GetQueuePosition(c context.Context, userID uint, bookID uint) (*domain.Hold, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	hold, err := u.holdRepo.GetActiveByUserAndBook(ctx, userID, bookID)
+	if err != nil {
+		return nil, errors.New("hold not found")
+	}
+
+	if err := u.fillPosition(ctx, hold); err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// CheckAvailability reports whether userID may borrow bookID right now.
+// Copies set aside for other members' ready holds are not available.
+func (u *holdUsecase) CheckAvailability(c context.Context, userID uint, bookID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	book, err := u.txRepo.GetBook(ctx, bookID)
+	if err != nil {
+		return errors.New("book not found")
+	}
+
+	reserved, err := u.holdRepo.CountByStatus(ctx, bookID, domain.HoldStatusReady)
+	if err != nil {
+		return err
+	}
+	if hold, _ := u.holdRepo.GetActiveByUserAndBook(ctx, userID, bookID); hold != nil && hold.Status == domain.HoldStatusReady {
+		reserved--
+	}
+
+	if int64(book.Stock)-reserved <= 0 {
+		return errors.New("book is out of stock, place a hold to join the queue")
+	}
+	return nil
+}
+
+func (u *holdUsecase) HasWaitingHolds(c context.Context, bookID uint) (bool, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	waiting, err := u.holdRepo.CountByStatus(ctx, bookID, domain.HoldStatusWaiting)
+	if err != nil {
+		return false, err
+	}
+	return waiting > 0, nil
+}
+
+func (u *holdUsecase) FulfillHold(c context.Context, userID uint, bookID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	hold, err := u.holdRepo.GetActiveByUserAndBook(ctx, userID, bookID)
+	if err != nil {
+		return nil // Borrowed without a hold
+	}
+
+	hold.Status = domain.HoldStatusFulfilled
+	return u.holdRepo.Update(ctx, hold)
+}
+
+// PromoteNext marks the oldest waiting hold for bookID as ready for pickup.
+func (u *holdUsecase) PromoteNext(c context.Context, bookID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	hold, err := u.holdRepo.GetNextWaiting(ctx, bookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Nobody waiting, copy goes back to the shelf
+		}
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(u.pickupWindow(ctx))
+	hold.Status = domain.HoldStatusReady
+	hold.ReadyAt = &now
+	hold.ExpiresAt = &expiresAt
+	if err := u.holdRepo.Update(ctx, hold); err != nil {
+		return err
+	}
+
+	log.Printf("Hold %d for book %d is ready for pickup by user %d", hold.ID, bookID, hold.UserID)
+	return nil
+}
+
+// ProcessHolds expires uncollected holds and promotes waiting ones whenever
+// a copy is free. It is meant to be run periodically.
+func (u *holdUsecase) ProcessHolds(c context.Context) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	// 1. Expire ready holds past their pickup deadline
+	expired, err := u.holdRepo.GetExpiredReady(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		hold := &expired[i]
+		hold.Status = domain.HoldStatusExpired
+		if err := u.holdRepo.Update(ctx, hold); err != nil {
+			return err
+		}
+		log.Printf("Hold %d for book %d expired", hold.ID, hold.BookID)
+
+		if err := u.PromoteNext(ctx, hold.BookID); err != nil {
+			return err
+		}
+	}
+
+	// 2. Promote waiting holds for books that have free copies (e.g. restocked)
+	bookIDs, err := u.holdRepo.GetWaitingBookIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bookID := range bookIDs {
+		book, err := u.txRepo.GetBook(ctx, bookID)
+		if err != nil {
+			continue
+		}
+		ready, err := u.holdRepo.CountByStatus(ctx, bookID, domain.HoldStatusReady)
+		if err != nil {
+			return err
+		}
+		for free := int64(book.Stock) - ready; free > 0; free-- {
+			if err := u.PromoteNext(ctx, bookID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (u *holdUsecase) fillPosition(ctx context.Context, hold *domain.Hold) error {
+	if hold.Status != domain.HoldStatusWaiting {
+		hold.Position = 0
+		return nil
+	}
+	ahead, err := u.holdRepo.CountAhead(ctx, hold)
+	if err != nil {
+		return err
+	}
+	hold.Position = int(ahead) + 1
+	return nil
+}
+
+func (u *holdUsecase) pickupWindow(ctx context.Context) time.Duration {
+	durationStr, _ := u.txRepo.GetConfig(ctx, "hold_pickup_duration")
+	unit, _ := u.txRepo.GetConfig(ctx, "hold_pickup_duration_unit")
+
+	duration := 3 // Default
+	if d, err := strconv.Atoi(durationStr); err == nil && d > 0 {
+		duration = d
+	}
+	return durationFromUnit(duration, unit)
+}
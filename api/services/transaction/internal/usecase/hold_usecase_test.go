@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"pushtaka/services/transaction/internal/domain"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testBookID = 7
+
+func newTestHolds(stock int) (*holdUsecase, *fakeHoldRepo, *fakeTxRepo) {
+	holds := &fakeHoldRepo{}
+	txs := newFakeTxRepo()
+	txs.books[testBookID] = domain.Book{ID: testBookID, Title: "Laskar Pelangi", Stock: stock}
+	return NewHoldUsecase(holds, &fakeLoanRepo{}, txs, time.Second).(*holdUsecase), holds, txs
+}
+
+func TestPlaceHold(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		stock   int
+		setup   func(*holdUsecase, *fakeTxRepo)
+		bookID  uint
+		wantErr string
+	}{
+		{"unknown book", 0, nil, 99, "book not found"},
+		{"copies on the shelf", 1, nil, testBookID, "book is available, borrow it directly"},
+		{"unpaid fine", 0, func(_ *holdUsecase, txs *fakeTxRepo) {
+			txs.transactions = append(txs.transactions, domain.Transaction{ID: 1, UserID: 1, BookID: 3, Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+		}, testBookID, "you have unpaid fines, please pay them first"},
+		{"already borrowed", 0, func(u *holdUsecase, _ *fakeTxRepo) {
+			u.loanRepo.Create(ctx, &domain.Loan{UserID: 1, BookID: testBookID, State: domain.LoanActive})
+		}, testBookID, "you have already borrowed this book"},
+		{"second hold", 0, func(u *holdUsecase, _ *fakeTxRepo) {
+			u.PlaceHold(ctx, 1, testBookID)
+		}, testBookID, "you already have a hold on this book"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holds, _, txs := newTestHolds(tt.stock)
+			if tt.setup != nil {
+				tt.setup(holds, txs)
+			}
+			if _, err := holds.PlaceHold(ctx, 1, tt.bookID); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("PlaceHold = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHoldQueueOrder(t *testing.T) {
+	holds, _, _ := newTestHolds(0)
+	ctx := context.Background()
+
+	for userID := uint(1); userID <= 3; userID++ {
+		hold, err := holds.PlaceHold(ctx, userID, testBookID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hold.Position != int(userID) || hold.Status != domain.HoldStatusWaiting {
+			t.Fatalf("user %d joined at %d (%s), want %d waiting", userID, hold.Position, hold.Status, userID)
+		}
+	}
+
+	// Leaving the queue moves everyone behind up
+	if err := holds.CancelHold(ctx, 1, testBookID); err != nil {
+		t.Fatal(err)
+	}
+	hold, err := holds.GetQueuePosition(ctx, 3, testBookID)
+	if err != nil || hold.Position != 2 {
+		t.Fatalf("GetQueuePosition after a cancel = %+v, %v; want position 2", hold, err)
+	}
+	if _, err := holds.GetQueuePosition(ctx, 1, testBookID); err == nil {
+		t.Fatal("cancelled hold still has a position")
+	}
+}
+
+func TestHoldPromotion(t *testing.T) {
+	holds, repo, txs := newTestHolds(0)
+	ctx := context.Background()
+	txs.configs["hold_pickup_duration"] = "2"
+	txs.configs["hold_pickup_duration_unit"] = "hour"
+
+	holds.PlaceHold(ctx, 1, testBookID)
+	holds.PlaceHold(ctx, 2, testBookID)
+
+	// A returned copy goes to the first in line, for the pickup window
+	txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+	if err := holds.PromoteNext(ctx, testBookID); err != nil {
+		t.Fatal(err)
+	}
+	ready := repo.holds[0]
+	if ready.Status != domain.HoldStatusReady || ready.ExpiresAt == nil || time.Until(*ready.ExpiresAt).Round(time.Minute) != 2*time.Hour {
+		t.Fatalf("first hold = %s until %v, want ready for 2h", ready.Status, ready.ExpiresAt)
+	}
+
+	// The copy is set aside: only its holder may borrow it
+	if err := holds.CheckAvailability(ctx, 2, testBookID); err == nil {
+		t.Fatal("member 2 may borrow the copy held for member 1")
+	}
+	if err := holds.CheckAvailability(ctx, 1, testBookID); err != nil {
+		t.Fatalf("holder may not borrow the held copy: %v", err)
+	}
+
+	// Cancelling a ready hold passes the copy on, and borrowing it closes
+	// the hold
+	if err := holds.CancelHold(ctx, 1, testBookID); err != nil {
+		t.Fatal(err)
+	}
+	if err := holds.FulfillHold(ctx, 2, testBookID); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.statuses(); !slices.Equal(got, []string{domain.HoldStatusCancelled, domain.HoldStatusFulfilled}) {
+		t.Fatalf("holds = %v, want the cancelled copy borrowed by member 2", got)
+	}
+
+	// Nobody waiting is not an error
+	if err := holds.PromoteNext(ctx, testBookID); err != nil {
+		t.Fatalf("PromoteNext with an empty queue = %v", err)
+	}
+}
+
+func TestProcessHolds(t *testing.T) {
+	holds, repo, txs := newTestHolds(0)
+	ctx := context.Background()
+
+	for userID := uint(1); userID <= 4; userID++ {
+		holds.PlaceHold(ctx, userID, testBookID)
+	}
+
+	// Member 1 let the pickup window pass
+	past := time.Now().Add(-time.Minute)
+	repo.holds[0].Status = domain.HoldStatusReady
+	repo.holds[0].ExpiresAt = &past
+	txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+	if err := holds.ProcessHolds(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{domain.HoldStatusExpired, domain.HoldStatusReady, domain.HoldStatusWaiting, domain.HoldStatusWaiting}
+	if got := repo.statuses(); !slices.Equal(got, want) {
+		t.Fatalf("after an expiry holds = %v, want %v", got, want)
+	}
+
+	// A restock frees copies for everyone still waiting
+	txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 5}
+	if err := holds.ProcessHolds(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{domain.HoldStatusExpired, domain.HoldStatusReady, domain.HoldStatusReady, domain.HoldStatusReady}
+	if got := repo.statuses(); !slices.Equal(got, want) {
+		t.Fatalf("after a restock holds = %v, want %v", got, want)
+	}
+}
+
+func TestProcessHoldsTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr error
+		want    string
+	}{
+		{"within the timeout", time.Second, nil, domain.HoldStatusExpired},
+		{"past the timeout", time.Nanosecond, context.DeadlineExceeded, domain.HoldStatusReady},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holds, repo, _ := newTestHolds(0)
+			ctx := context.Background()
+			holds.PlaceHold(ctx, 1, testBookID)
+			past := time.Now().Add(-time.Minute)
+			repo.holds[0].Status = domain.HoldStatusReady
+			repo.holds[0].ExpiresAt = &past
+
+			// The caller's context has no deadline of its own
+			holds.contextTimeout = tt.timeout
+			if err := holds.ProcessHolds(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ProcessHolds = %v, want %v", err, tt.wantErr)
+			}
+			if got := repo.holds[0].Status; got != tt.want {
+				t.Fatalf("hold status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,63 +2,68 @@ package usecase
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"log"
+	"pushtaka/pkg/database"
+	"pushtaka/pkg/events"
+	"pushtaka/pkg/messaging"
 	"pushtaka/services/transaction/internal/domain"
 	"strconv"
+	"strings"
 	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
 )
 
 type transactionUsecase struct {
 	txRepo         domain.TransactionRepository
+	loanRepo       domain.LoanRepository
+	holdUsecase    domain.HoldUsecase
 	contextTimeout time.Duration
-	amqpChannel    *amqp.Channel
+	transactor     database.Transactor
+	outbox         messaging.OutboxWriter
 }
 
-type StockUpdateMessage struct {
-	BookID   uint   `json:"book_id"`
-	Action   string `json:"action"` // "borrow" or "return"
-	Quantity int    `json:"quantity"`
-}
-
-func NewTransactionUsecase(txRepo domain.TransactionRepository, timeout time.Duration, ch *amqp.Channel) domain.TransactionUsecase {
+func NewTransactionUsecase(txRepo domain.TransactionRepository, loanRepo domain.LoanRepository, holdUsecase domain.HoldUsecase, timeout time.Duration, transactor database.Transactor, outbox messaging.OutboxWriter) domain.TransactionUsecase {
 	return &transactionUsecase{
 		txRepo:         txRepo,
+		loanRepo:       loanRepo,
+		holdUsecase:    holdUsecase,
 		contextTimeout: timeout,
-		amqpChannel:    ch,
+		transactor:     transactor,
+		outbox:         outbox,
 	}
 }
 
-func (u *transactionUsecase) BorrowBook(c context.Context, userID uint, bookID uint) error {
+func (u *transactionUsecase) BorrowBook(c context.Context, userID uint, bookID uint) (*domain.Loan, error) {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
+	return u.borrow(ctx, userID, bookID, nil, nil)
+}
 
+// borrow opens a loan for the member. bookCopy pins the physical copy to
+// lend, otherwise the book service picks one; staffID records who served the
+// member at the desk.
+func (u *transactionUsecase) borrow(ctx context.Context, userID uint, bookID uint, bookCopy *domain.BookCopy, staffID *uint) (*domain.Loan, error) {
 	// 1. Check if user has unpaid fines
 	unpaidFines, err := u.txRepo.GetUnpaidFines(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(unpaidFines) > 0 {
-		totalFine := 0
-		for _, tx := range unpaidFines {
-			totalFine += tx.Fine
-		}
-		return errors.New("you have unpaid fines, please pay them first")
+		return nil, errors.New("you have unpaid fines, please pay them first")
 	}
 
 	// 2. Check if user already borrowed this book
-	activeBorrow, _ := u.txRepo.GetActiveBorrow(ctx, userID, bookID)
-	if activeBorrow != nil {
-		return errors.New("you have already borrowed this book")
+	openLoan, _ := u.loanRepo.GetOpenLoan(ctx, userID, bookID)
+	if openLoan != nil {
+		return nil, errors.New("you have already borrowed this book")
 	}
 
 	// 3. Check max borrows
-	count, err := u.txRepo.CountActiveBorrows(ctx, userID)
+	count, err := u.loanRepo.CountOpenLoans(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
 	limitStr, err := u.txRepo.GetConfig(ctx, "max_borrow_limit")
@@ -71,159 +76,553 @@ func (u *transactionUsecase) BorrowBook(c context.Context, userID uint, bookID u
 	}
 
 	if int(count) >= limit {
-		return errors.New("limit reached: max " + strconv.Itoa(limit) + " books borrowed")
+		return nil, errors.New("limit reached: max " + strconv.Itoa(limit) + " books borrowed")
 	}
 
-	// 4. Calculate Due Date
+	// 4. Check stock, minus copies set aside for other members' holds
+	if err := u.holdUsecase.CheckAvailability(ctx, userID, bookID); err != nil {
+		return nil, err
+	}
+
+	// 5. Calculate Due Date
+	dueDate := time.Now().Add(u.borrowDuration(ctx))
+
+	// 6. Open the loan as requested until the book service reserves a copy.
+	// The loan, its history row and the reservation request commit together.
+	loan := &domain.Loan{
+		UserID:  userID,
+		BookID:  bookID,
+		State:   domain.LoanRequested,
+		DueDate: &dueDate,
+	}
+	if bookCopy != nil {
+		loan.CopyID = bookCopy.ID
+		loan.Barcode = bookCopy.Barcode
+	}
+	err = u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.loanRepo.Create(ctx, loan); err != nil {
+			return err
+		}
+
+		// 7. Record the borrow in the history log
+		tx := &domain.Transaction{
+			UserID:  userID,
+			BookID:  bookID,
+			LoanID:  &loan.ID,
+			StaffID: staffID,
+			Action:  "borrow",
+			Status:  loan.LegacyStatus(),
+			DueDate: &dueDate,
+		}
+		if err := u.txRepo.Create(ctx, tx); err != nil {
+			return err
+		}
+
+		// 8. Ask the book service to reserve stock. The reply is handled by
+		// CompleteReservation.
+		req := events.StockReservationRequested{LoanID: loan.ID, BookID: bookID, CopyID: loan.CopyID, Quantity: 1}
+		return u.publishReservation(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// CompleteReservation finishes the borrow saga once the book service has
+// answered a stock reservation request.
+func (u *transactionUsecase) CompleteReservation(c context.Context, result events.StockReservationCompleted) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	loan, err := u.loanRepo.GetByID(ctx, result.LoanID)
+	if err != nil {
+		return err
+	}
+
+	// Replies can be redelivered; only a requested loan is waiting for one
+	if loan.State != domain.LoanRequested {
+		return nil
+	}
+
+	if !result.Approved {
+		reason := result.Reason
+		if reason == "" {
+			reason = "book is out of stock"
+		}
+		return u.transitionLoan(ctx, loan, domain.LoanRejected, func(l *domain.Loan) {
+			l.RejectReason = reason
+		})
+	}
+
+	err = u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := u.transitionLoan(ctx, loan, domain.LoanActive, func(l *domain.Loan) {
+			l.CopyID = result.CopyID
+			l.Barcode = result.Barcode
+		})
+		if err != nil {
+			return err
+		}
+		return u.outbox.Publish(ctx, events.LoanBorrowed{
+			LoanID:  loan.ID,
+			UserID:  loan.UserID,
+			BookID:  loan.BookID,
+			CopyID:  loan.CopyID,
+			DueDate: loan.DueDate,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// Close the member's hold, if they had one
+	if err := u.holdUsecase.FulfillHold(ctx, loan.UserID, loan.BookID); err != nil {
+		log.Printf("Failed to fulfill hold for user %d book %d: %v", loan.UserID, loan.BookID, err)
+	}
+	return nil
+}
+
+func (u *transactionUsecase) RenewBook(c context.Context, userID uint, transactionID uint) (*domain.Loan, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	// 1. Get transaction
+	tx, err := u.txRepo.GetByID(ctx, transactionID)
+	if err != nil {
+		return nil, errors.New("transaction not found")
+	}
+
+	// 2. Verify ownership
+	if tx.UserID != userID {
+		return nil, errors.New("unauthorized: this transaction does not belong to you")
+	}
+
+	// 3. Only active, not yet overdue loans can be renewed
+	if tx.Action != "borrow" || tx.LoanID == nil {
+		return nil, errors.New("only active loans can be renewed")
+	}
+	loan, err := u.loanRepo.GetByID(ctx, *tx.LoanID)
+	if err != nil {
+		return nil, errors.New("loan not found")
+	}
+	if loan.State == domain.LoanActive && loan.DueDate != nil && time.Now().After(*loan.DueDate) {
+		// Record it now rather than wait for the next sweep
+		if err := u.markOverdue(ctx, loan); err != nil {
+			return nil, err
+		}
+	}
+	if loan.State == domain.LoanOverdue {
+		return nil, errors.New("loan is overdue, please return the book")
+	}
+	if loan.State != domain.LoanActive {
+		return nil, errors.New("only active loans can be renewed")
+	}
+	if loan.DueDate == nil {
+		return nil, errors.New("loan has no due date to extend")
+	}
+
+	// 4. Check renewal limit
+	maxRenewals := 2 // Default
+	if val, err := u.txRepo.GetConfig(ctx, "max_renewals"); err == nil {
+		if i, err := strconv.Atoi(val); err == nil && i >= 0 {
+			maxRenewals = i
+		}
+	}
+	if loan.RenewCount >= maxRenewals {
+		return nil, errors.New("renewal limit reached: max " + strconv.Itoa(maxRenewals) + " renewals")
+	}
+
+	// 5. Check if user has unpaid fines
+	unpaidFines, err := u.txRepo.GetUnpaidFines(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(unpaidFines) > 0 {
+		return nil, errors.New("you have unpaid fines, please pay them first")
+	}
+
+	// 6. Other members are queueing for this book
+	hasHolds, err := u.holdUsecase.HasWaitingHolds(ctx, loan.BookID)
+	if err != nil {
+		return nil, err
+	}
+	if hasHolds {
+		return nil, errors.New("book has pending holds and cannot be renewed")
+	}
+
+	// 7. Extend Due Date
+	newDueDate := loan.DueDate.Add(u.borrowDuration(ctx))
+	loan.DueDate = &newDueDate
+	loan.RenewCount++
+
+	if err := u.loanRepo.Update(ctx, loan); err != nil {
+		return nil, err
+	}
+	if err := u.syncBorrowRow(ctx, loan); err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// borrowDuration returns the configured loan period.
+func (u *transactionUsecase) borrowDuration(ctx context.Context) time.Duration {
 	durationStr, _ := u.txRepo.GetConfig(ctx, "borrow_duration")
 	unit, _ := u.txRepo.GetConfig(ctx, "borrow_duration_unit")
-	
+
 	duration := 7 // Default
 	if d, err := strconv.Atoi(durationStr); err == nil && d > 0 {
 		duration = d
 	}
-	
-	var addTime time.Duration
+	return durationFromUnit(duration, unit)
+}
+
+// durationFromUnit converts a configured amount and unit ("minute", "hour",
+// "day") into a time.Duration, defaulting to days.
+func durationFromUnit(amount int, unit string) time.Duration {
 	switch unit {
 	case "minute":
-		addTime = time.Duration(duration) * time.Minute
+		return time.Duration(amount) * time.Minute
 	case "hour":
-		addTime = time.Duration(duration) * time.Hour
-	case "day":
-		addTime = time.Duration(duration) * 24 * time.Hour
+		return time.Duration(amount) * time.Hour
 	default:
-		addTime = time.Duration(duration) * 24 * time.Hour // Default to days
+		return time.Duration(amount) * 24 * time.Hour
 	}
-	
-	dueDate := time.Now().Add(addTime)
+}
 
-	// 5. Create Transaction
-	tx := &domain.Transaction{
-		UserID:  userID,
-		BookID:  bookID,
-		Action:  "borrow",
-		Status:  "active",
-		DueDate: &dueDate,
+func (u *transactionUsecase) ReturnBook(c context.Context, userID uint, bookID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	loan, err := u.loanRepo.GetOpenLoan(ctx, userID, bookID)
+	if err != nil {
+		return errors.New("active borrow record not found")
 	}
-	if err := u.txRepo.Create(ctx, tx); err != nil {
+	return u.returnLoan(ctx, loan, nil)
+}
+
+// returnLoan closes the loan, charges any late fine and puts the copy back
+// on the shelf. staffID is set when the return was taken at the desk.
+func (u *transactionUsecase) returnLoan(ctx context.Context, loan *domain.Loan, staffID *uint) error {
+	now := time.Now()
+
+	// 1. Calculate Fine
+	fine := u.calculateFine(ctx, loan.DueDate, now)
+
+	err := u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// 2. Close the loan
+		if err := u.transitionLoan(ctx, loan, domain.LoanReturned, func(l *domain.Loan) {
+			l.ReturnedAt = &now
+		}); err != nil {
+			return err
+		}
+
+		// 3. Create Return Transaction
+		fineStatus := domain.FineStatusNone
+		if fine > 0 {
+			fineStatus = domain.FineStatusUnpaid
+		}
+		tx := &domain.Transaction{
+			UserID:     loan.UserID,
+			BookID:     loan.BookID,
+			LoanID:     &loan.ID,
+			StaffID:    staffID,
+			Action:     "return",
+			Status:     "completed",
+			ReturnDate: &now,
+			Fine:       fine,
+			FineStatus: fineStatus,
+		}
+		if err := u.txRepo.Create(ctx, tx); err != nil {
+			return err
+		}
+
+		// 4. Publish Event (Increase Stock)
+		msg := events.StockUpdated{BookID: loan.BookID, CopyID: loan.CopyID, Action: "return", Quantity: 1}
+		if err := u.publishEvent(ctx, msg); err != nil {
+			return err
+		}
+
+		// 5. Announce the return and any fine it incurred
+		err := u.outbox.Publish(ctx, events.LoanReturned{
+			LoanID:     loan.ID,
+			UserID:     loan.UserID,
+			BookID:     loan.BookID,
+			CopyID:     loan.CopyID,
+			ReturnedAt: now,
+			Late:       fine > 0,
+		})
+		if err != nil || fine <= 0 {
+			return err
+		}
+		return u.outbox.Publish(ctx, events.FineAssessed{
+			TransactionID: tx.ID,
+			LoanID:        loan.ID,
+			UserID:        loan.UserID,
+			BookID:        loan.BookID,
+			Amount:        fine,
+		})
+	})
+	if err != nil {
 		return err
 	}
 
-	// 6. Publish Event (Decrease Stock)
-	msg := StockUpdateMessage{BookID: bookID, Action: "borrow", Quantity: -1}
-	u.publishEvent(msg)
+	// 6. Set the returned copy aside for the next member in the hold queue
+	if err := u.holdUsecase.PromoteNext(ctx, loan.BookID); err != nil {
+		log.Printf("Failed to promote hold for book %d: %v", loan.BookID, err)
+	}
 
 	return nil
 }
 
-func (u *transactionUsecase) ReturnBook(c context.Context, userID uint, bookID uint) error {
+// CheckoutCopies lends scanned copies to a member on a staff member's
+// behalf. Each barcode is handled on its own so one bad item does not hold
+// up the rest; the returned results follow the order of the barcodes.
+func (u *transactionUsecase) CheckoutCopies(c context.Context, staffID uint, member string, barcodes []string) ([]domain.CheckoutResult, error) {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 
-	// 1. Get Active Borrow to check Due Date
-	activeBorrow, err := u.txRepo.GetActiveBorrow(ctx, userID, bookID)
+	user, err := u.txRepo.FindMember(ctx, strings.TrimSpace(member))
 	if err != nil {
-		return errors.New("active borrow record not found")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrMemberNotFound
+		}
+		return nil, err
 	}
 
-	// 2. Calculate Fine
-	fine := 0
-	now := time.Now()
-	
-	if activeBorrow.DueDate != nil && now.After(*activeBorrow.DueDate) {
-		// Get Fine Config
-		amountStr, _ := u.txRepo.GetConfig(ctx, "fine_amount")
-		unit, _ := u.txRepo.GetConfig(ctx, "fine_unit")
-		durationStr, _ := u.txRepo.GetConfig(ctx, "fine_duration")
-		
-		fineAmount := 1000 // Default
-		if f, err := strconv.Atoi(amountStr); err == nil && f > 0 {
-			fineAmount = f
+	results := make([]domain.CheckoutResult, 0, len(barcodes))
+	for _, barcode := range barcodes {
+		barcode = strings.TrimSpace(barcode)
+		result := domain.CheckoutResult{Barcode: barcode}
+
+		loan, err := u.checkoutCopy(ctx, user.ID, barcode, staffID)
+		if err != nil {
+			result.Error = err.Error()
 		} else {
-			// Backward compatibility: try fine_per_day
-			if oldFine, err := u.txRepo.GetConfig(ctx, "fine_per_day"); err == nil {
-				if f, err := strconv.Atoi(oldFine); err == nil && f > 0 {
-					fineAmount = f
-				}
+			result.Loan = loan
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (u *transactionUsecase) checkoutCopy(ctx context.Context, userID uint, barcode string, staffID uint) (*domain.Loan, error) {
+	bookCopy, err := u.txRepo.GetCopyByBarcode(ctx, barcode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrUnknownBarcode
+		}
+		return nil, err
+	}
+	if bookCopy.Status != domain.CopyAvailable {
+		return nil, domain.ErrCopyUnavailable
+	}
+	return u.borrow(ctx, userID, bookCopy.BookID, bookCopy, &staffID)
+}
+
+// CheckinCopy takes back a scanned copy and closes whichever loan it is out
+// on, so staff do not need to know the borrower.
+func (u *transactionUsecase) CheckinCopy(c context.Context, staffID uint, barcode string) (*domain.Loan, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	bookCopy, err := u.txRepo.GetCopyByBarcode(ctx, strings.TrimSpace(barcode))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrUnknownBarcode
+		}
+		return nil, err
+	}
+
+	loan, err := u.loanRepo.GetOpenLoanByCopy(ctx, bookCopy.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrCopyNotOnLoan
+		}
+		return nil, err
+	}
+
+	if err := u.returnLoan(ctx, loan, &staffID); err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// calculateFine returns the late fine owed for a loan due at dueDate and returned at now.
+func (u *transactionUsecase) calculateFine(ctx context.Context, dueDate *time.Time, now time.Time) int {
+	if dueDate == nil || !now.After(*dueDate) {
+		return 0
+	}
+
+	// Get Fine Config
+	amountStr, _ := u.txRepo.GetConfig(ctx, "fine_amount")
+	unit, _ := u.txRepo.GetConfig(ctx, "fine_unit")
+	durationStr, _ := u.txRepo.GetConfig(ctx, "fine_duration")
+	
+	fineAmount := 1000 // Default
+	if f, err := strconv.Atoi(amountStr); err == nil && f > 0 {
+		fineAmount = f
+	} else {
+		// Backward compatibility: try fine_per_day
+		if oldFine, err := u.txRepo.GetConfig(ctx, "fine_per_day"); err == nil {
+			if f, err := strconv.Atoi(oldFine); err == nil && f > 0 {
+				fineAmount = f
 			}
 		}
-		
-		fineDuration := 1 // Default
-		if d, err := strconv.Atoi(durationStr); err == nil && d > 0 {
-			fineDuration = d
-		}
-
-		if unit == "" {
-			unit = "day" // Default
-		}
-		
-		// Calculate time late
-		diff := now.Sub(*activeBorrow.DueDate)
-		var totalMinutes float64
-		
-		switch unit {
-		case "minute":
-			totalMinutes = diff.Minutes()
-		case "hour":
-			totalMinutes = diff.Hours() * 60
-		case "day":
-			totalMinutes = diff.Hours() * 60 * 24
-		case "month":
-			totalMinutes = diff.Hours() * 60 * 24 * 30
-		default:
-			totalMinutes = diff.Hours() * 60 * 24
-		}
-		
-		// Convert fine duration to minutes for calculation
-		var durationInMinutes int
-		switch unit {
-		case "minute":
-			durationInMinutes = fineDuration
-		case "hour":
-			durationInMinutes = fineDuration * 60
-		case "day":
-			durationInMinutes = fineDuration * 60 * 24
-		case "month":
-			durationInMinutes = fineDuration * 60 * 24 * 30
-		default:
-			durationInMinutes = fineDuration * 60 * 24
-		}
-
-		// Calculate units late (rounding up)
-		unitsLate := int(totalMinutes / float64(durationInMinutes))
-		if int(totalMinutes) % durationInMinutes > 0 || unitsLate == 0 {
-			unitsLate++
-		}
-		
-		fine = unitsLate * fineAmount
 	}
 	
-	// 3. Update borrow status to returned
-	activeBorrow.Status = "returned"
-	if err := u.txRepo.Update(ctx, activeBorrow); err != nil {
-		return err
+	fineDuration := 1 // Default
+	if d, err := strconv.Atoi(durationStr); err == nil && d > 0 {
+		fineDuration = d
 	}
 
-	// 4. Create Return Transaction
-	tx := &domain.Transaction{
-		UserID:     userID,
-		BookID:     bookID,
-		Action:     "return",
-		Status:     "completed",
-		ReturnDate: &now,
-		Fine:       fine,
+	if unit == "" {
+		unit = "day" // Default
 	}
-	if err := u.txRepo.Create(ctx, tx); err != nil {
+	
+	// Calculate time late
+	diff := now.Sub(*dueDate)
+	var totalMinutes float64
+	
+	switch unit {
+	case "minute":
+		totalMinutes = diff.Minutes()
+	case "hour":
+		totalMinutes = diff.Hours() * 60
+	case "day":
+		totalMinutes = diff.Hours() * 60 * 24
+	case "month":
+		totalMinutes = diff.Hours() * 60 * 24 * 30
+	default:
+		totalMinutes = diff.Hours() * 60 * 24
+	}
+	
+	// Convert fine duration to minutes for calculation
+	var durationInMinutes int
+	switch unit {
+	case "minute":
+		durationInMinutes = fineDuration
+	case "hour":
+		durationInMinutes = fineDuration * 60
+	case "day":
+		durationInMinutes = fineDuration * 60 * 24
+	case "month":
+		durationInMinutes = fineDuration * 60 * 24 * 30
+	default:
+		durationInMinutes = fineDuration * 60 * 24
+	}
+
+	// Calculate units late (rounding up)
+	unitsLate := int(totalMinutes / float64(durationInMinutes))
+	if int(totalMinutes) % durationInMinutes > 0 || unitsLate == 0 {
+		unitsLate++
+	}
+	
+	return unitsLate * fineAmount
+}
+
+// transitionLoan applies a state change, persists it and mirrors it onto the
+// borrow history row. Optional mutators run after a successful transition.
+func (u *transactionUsecase) transitionLoan(ctx context.Context, loan *domain.Loan, to domain.LoanState, mutators ...func(*domain.Loan)) error {
+	if err := loan.Transition(to); err != nil {
+		return err
+	}
+	for _, m := range mutators {
+		m(loan)
+	}
+	if err := u.loanRepo.Update(ctx, loan); err != nil {
+		return err
+	}
+	return u.syncBorrowRow(ctx, loan)
+}
+
+// syncBorrowRow keeps the legacy status and due date on the borrow row in step with the loan.
+func (u *transactionUsecase) syncBorrowRow(ctx context.Context, loan *domain.Loan) error {
+	borrow, err := u.txRepo.GetBorrowByLoanID(ctx, loan.ID)
+	if err != nil {
 		return err
 	}
+	borrow.Status = loan.LegacyStatus()
+	borrow.DueDate = loan.DueDate
+	return u.txRepo.Update(ctx, borrow)
+}
 
-	// 5. Publish Event (Increase Stock)
-	msg := StockUpdateMessage{BookID: bookID, Action: "return", Quantity: 1}
-	u.publishEvent(msg)
+func (u *transactionUsecase) MyLoans(c context.Context, userID uint) ([]domain.Loan, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+	return u.loanRepo.GetByUserID(ctx, userID)
+}
 
+func (u *transactionUsecase) ClaimReturned(c context.Context, userID uint, loanID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	loan, err := u.loanRepo.GetByID(ctx, loanID)
+	if err != nil {
+		return errors.New("loan not found")
+	}
+	if loan.UserID != userID {
+		return errors.New("unauthorized: this loan does not belong to you")
+	}
+
+	return u.transitionLoan(ctx, loan, domain.LoanClaimedReturned)
+}
+
+func (u *transactionUsecase) ConfirmReturn(c context.Context, loanID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	loan, err := u.loanRepo.GetByID(ctx, loanID)
+	if err != nil {
+		return errors.New("loan not found")
+	}
+	return u.returnLoan(ctx, loan, nil)
+}
+
+func (u *transactionUsecase) MarkLost(c context.Context, loanID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	loan, err := u.loanRepo.GetByID(ctx, loanID)
+	if err != nil {
+		return errors.New("loan not found")
+	}
+	return u.transitionLoan(ctx, loan, domain.LoanLost)
+}
+
+// MarkOverdueLoans moves active loans past their due date to overdue. It is
+// meant to be run periodically.
+func (u *transactionUsecase) MarkOverdueLoans(c context.Context) error {
+	loans, err := u.loanRepo.GetPastDue(c, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range loans {
+		loan := &loans[i]
+		if err := u.markOverdue(c, loan); err != nil {
+			log.Printf("Failed to mark loan %d overdue: %v", loan.ID, err)
+		}
+	}
 	return nil
 }
 
+// markOverdue moves loan to overdue and queues loan.overdue in the same
+// transaction.
+func (u *transactionUsecase) markOverdue(c context.Context, loan *domain.Loan) error {
+	return u.transactor.WithinTransaction(c, func(ctx context.Context) error {
+		if err := u.transitionLoan(ctx, loan, domain.LoanOverdue); err != nil {
+			return err
+		}
+		return u.outbox.Publish(ctx, events.LoanOverdue{
+			LoanID:  loan.ID,
+			UserID:  loan.UserID,
+			BookID:  loan.BookID,
+			DueDate: loan.DueDate,
+		})
+	})
+}
+
 func (u *transactionUsecase) History(c context.Context, userID uint) ([]domain.Transaction, error) {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
@@ -257,10 +656,10 @@ func (u *transactionUsecase) PayFine(c context.Context, userID uint, transaction
 	}
 
 	// 4. Check if already paid or pending
-	if tx.PaidAt != nil {
+	if tx.FineStatus == domain.FineStatusPaid {
 		return "", errors.New("fine already paid")
 	}
-	if tx.Status == "pending_verification" {
+	if tx.FineStatus == domain.FineStatusPendingVerification {
 		return "", errors.New("payment is already pending verification")
 	}
 
@@ -272,7 +671,7 @@ func (u *transactionUsecase) PayFine(c context.Context, userID uint, transaction
 		// Mock/Dummy Logic - Auto Pay
 		now := time.Now()
 		tx.PaidAt = &now
-		tx.Status = "completed"
+		tx.FineStatus = domain.FineStatusPaid
 		tx.UpdatedAt = now
 		tx.PaymentMethod = "qris"
 		tx.PaymentProof = "auto-paid-dummy"
@@ -281,7 +680,7 @@ func (u *transactionUsecase) PayFine(c context.Context, userID uint, transaction
 		// Mock/Dummy Logic - Auto Pay for Manual as well
 		now := time.Now()
 		tx.PaidAt = &now
-		tx.Status = "completed"
+		tx.FineStatus = domain.FineStatusPaid
 		tx.UpdatedAt = now
 		tx.PaymentMethod = "manual"
 		if proof != "" {
@@ -318,17 +717,20 @@ func (u *transactionUsecase) VerifyFine(c context.Context, transactionID uint, a
 		return err
 	}
 	
+	if tx.Fine <= 0 {
+		return errors.New("no fine to verify for this transaction")
+	}
+	
 	if action == "approve" {
-		now := time.Now()
-		tx.PaidAt = &now
-		tx.Status = "completed"
-		tx.UpdatedAt = now
+		return u.markFinePaid(ctx, tx)
+	} else if action == "waive" {
+		// Settled without payment, e.g. a hardship waiver
+		tx.PaymentMethod = "waived"
+		tx.PaymentProof = ""
+		return u.markFinePaid(ctx, tx)
 	} else if action == "reject" {
-		tx.Status = "completed" // Or back to active? "active" means unpaid in our logic?
-		// Logic mismatch: In BorrowBook, we check "UnpaidFines" -> Fine > 0 AND PaidAt IS NULL.
-		// So checking status is critical.
-		// If rejected, we reset to let user try again?
-		tx.Status = "active" // Reset to allow re-payment
+		// Back to unpaid so the member can pay again
+		tx.FineStatus = domain.FineStatusUnpaid
 		tx.PaymentMethod = ""
 		tx.PaymentProof = ""
 	} else {
@@ -338,6 +740,30 @@ func (u *transactionUsecase) VerifyFine(c context.Context, transactionID uint, a
 	return u.txRepo.Update(ctx, tx)
 }
 
+// markFinePaid settles a fine and announces it. Settling an already paid fine
+// is a no-op so repeated callbacks do not publish twice.
+func (u *transactionUsecase) markFinePaid(ctx context.Context, tx *domain.Transaction) error {
+	if tx.FineStatus == domain.FineStatusPaid {
+		return nil
+	}
+
+	now := time.Now()
+	tx.PaidAt = &now
+	tx.FineStatus = domain.FineStatusPaid
+	tx.UpdatedAt = now
+	return u.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.txRepo.Update(ctx, tx); err != nil {
+			return err
+		}
+		return u.outbox.Publish(ctx, events.FinePaid{
+			TransactionID: tx.ID,
+			UserID:        tx.UserID,
+			Amount:        tx.Fine,
+			Method:        tx.PaymentMethod,
+		})
+	})
+}
+
 func (u *transactionUsecase) HandlePaymentCallback(c context.Context, orderID string, status string) error {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
@@ -350,11 +776,7 @@ func (u *transactionUsecase) HandlePaymentCallback(c context.Context, orderID st
 	}
 	
 	if status == "settled" || status == "capture" {
-		now := time.Now()
-		tx.PaidAt = &now
-		tx.Status = "completed"
-		tx.UpdatedAt = now
-		return u.txRepo.Update(ctx, tx)
+		return u.markFinePaid(ctx, tx)
 	}
 	
 	return nil
@@ -376,12 +798,16 @@ func (u *transactionUsecase) MakeLate(c context.Context, userID uint, transactio
 	}
 
 	// 3. Check if this is a borrow transaction
-	if tx.Action != "borrow" {
+	if tx.Action != "borrow" || tx.LoanID == nil {
 		return errors.New("only borrow transactions can be made late")
 	}
 
 	// 4. Check if already returned
-	if tx.Status == "returned" {
+	loan, err := u.loanRepo.GetByID(ctx, *tx.LoanID)
+	if err != nil {
+		return errors.New("loan not found")
+	}
+	if loan.State != domain.LoanActive && loan.State != domain.LoanOverdue {
 		return errors.New("book already returned")
 	}
 
@@ -390,49 +816,126 @@ func (u *transactionUsecase) MakeLate(c context.Context, userID uint, transactio
 		daysLate = 3 // Default 3 days late
 	}
 	newDueDate := time.Now().Add(-time.Duration(daysLate) * 24 * time.Hour)
-	tx.DueDate = &newDueDate
+	loan.DueDate = &newDueDate
 
-	if err := u.txRepo.Update(ctx, tx); err != nil {
+	if err := u.loanRepo.Update(ctx, loan); err != nil {
 		return err
 	}
 
-	return nil
+	return u.syncBorrowRow(ctx, loan)
 }
 
-func (u *transactionUsecase) publishEvent(msg StockUpdateMessage) {
-	body, _ := json.Marshal(msg)
-	err := u.amqpChannel.PublishWithContext(context.Background(),
-		"",            // exchange
-		"stock_updates", // routing key
-		false,         // mandatory
-		false,         // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
-	if err != nil {
-		log.Printf("Failed to publish message: %v", err)
-	}
+// publishEvent writes a stock update to the outbox. Call it inside the
+// transaction that makes the change it announces.
+func (u *transactionUsecase) publishEvent(ctx context.Context, msg events.StockUpdated) error {
+	return u.outbox.Enqueue(ctx, "stock_updates", msg)
 }
 
+func (u *transactionUsecase) publishReservation(ctx context.Context, req events.StockReservationRequested) error {
+	correlationID := strconv.FormatUint(uint64(req.LoanID), 10)
+	return u.outbox.EnqueueWithReply(ctx, "stock_reservations", "stock_reservation_results", correlationID, req)
+}
 
+// DeleteByBookID cleans up after a deleted book. As with members, the book
+// service checks first; records that still carry a debt are kept.
 func (u *transactionUsecase) DeleteByBookID(c context.Context, bookID uint) error {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
+
+	obligations, err := u.GetBookObligations(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if obligations.Outstanding() {
+		return domain.ErrOutstandingObligations
+	}
 	return u.txRepo.DeleteByBookID(ctx, bookID)
 }
 
+func (u *transactionUsecase) GetBookObligations(c context.Context, bookID uint) (*domain.Obligations, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	openLoans, err := u.loanRepo.CountOpenLoansByBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	fines, err := u.txRepo.GetUnpaidFinesByBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	return newObligations(openLoans, fines), nil
+}
+
+func (u *transactionUsecase) GetUserObligations(c context.Context, userID uint) (*domain.Obligations, error) {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	openLoans, err := u.loanRepo.CountOpenLoans(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	fines, err := u.txRepo.GetUnpaidFines(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newObligations(openLoans, fines), nil
+}
+
+func newObligations(openLoans int64, fines []domain.Transaction) *domain.Obligations {
+	obligations := &domain.Obligations{OpenLoans: openLoans, UnpaidFines: len(fines)}
+	for _, fine := range fines {
+		obligations.FineAmount += fine.Fine
+	}
+	return obligations
+}
+
+// DeleteByUserID cleans up after a deleted member. The identity service checks
+// obligations before deleting, so finding any here means a loan or fine was
+// created in between; the records are kept rather than losing what is owed.
+func (u *transactionUsecase) DeleteByUserID(c context.Context, userID uint) error {
+	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
+	defer cancel()
+
+	obligations, err := u.GetUserObligations(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if obligations.Outstanding() {
+		return domain.ErrOutstandingObligations
+	}
+
+	// Leave hold queues first so copies set aside for the member move on
+	holds, err := u.holdUsecase.MyHolds(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, hold := range holds {
+		if hold.Status != domain.HoldStatusWaiting && hold.Status != domain.HoldStatusReady {
+			continue
+		}
+		if err := u.holdUsecase.CancelHold(ctx, userID, hold.BookID); err != nil {
+			return err
+		}
+	}
+
+	return u.txRepo.DeleteByUserID(ctx, userID)
+}
+
 func (u *transactionUsecase) GetSettings(c context.Context) (*domain.Settings, error) {
 	ctx, cancel := context.WithTimeout(c, u.contextTimeout)
 	defer cancel()
 	
 	s := &domain.Settings{
-		BorrowDuration:     7,     // Defaults
-		BorrowDurationUnit: "day", // Defaults
-		FineAmount:         1000,
-		FineUnit:           "day",
-		FineDuration:       1,
-		MaxBorrowLimit:     3,
+		BorrowDuration:         7,     // Defaults
+		BorrowDurationUnit:     "day", // Defaults
+		FineAmount:             1000,
+		FineUnit:               "day",
+		FineDuration:           1,
+		MaxBorrowLimit:         3,
+		HoldPickupDuration:     3,
+		HoldPickupDurationUnit: "day",
 	}
 
 	if val, err := u.txRepo.GetConfig(ctx, "borrow_duration"); err == nil {
@@ -475,6 +978,23 @@ func (u *transactionUsecase) GetSettings(c context.Context) (*domain.Settings, e
 		}
 	}
 
+	maxRenewals := 2
+	if val, err := u.txRepo.GetConfig(ctx, "max_renewals"); err == nil {
+		if i, err := strconv.Atoi(val); err == nil && i >= 0 {
+			maxRenewals = i
+		}
+	}
+	s.MaxRenewals = &maxRenewals
+
+	if val, err := u.txRepo.GetConfig(ctx, "hold_pickup_duration"); err == nil {
+		if i, err := strconv.Atoi(val); err == nil && i > 0 {
+			s.HoldPickupDuration = i
+		}
+	}
+	if val, err := u.txRepo.GetConfig(ctx, "hold_pickup_duration_unit"); err == nil && val != "" {
+		s.HoldPickupDurationUnit = val
+	}
+
 	return s, nil
 }
 
@@ -512,6 +1032,21 @@ func (u *transactionUsecase) UpdateSettings(c context.Context, s *domain.Setting
 			return err
 		}
 	}
+	if s.MaxRenewals != nil && *s.MaxRenewals >= 0 {
+		if err := u.txRepo.UpdateConfig(ctx, "max_renewals", strconv.Itoa(*s.MaxRenewals)); err != nil {
+			return err
+		}
+	}
+	if s.HoldPickupDuration > 0 {
+		if err := u.txRepo.UpdateConfig(ctx, "hold_pickup_duration", strconv.Itoa(s.HoldPickupDuration)); err != nil {
+			return err
+		}
+	}
+	if s.HoldPickupDurationUnit != "" {
+		if err := u.txRepo.UpdateConfig(ctx, "hold_pickup_duration_unit", s.HoldPickupDurationUnit); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
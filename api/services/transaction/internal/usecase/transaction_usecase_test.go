@@ -0,0 +1,873 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"pushtaka/pkg/events"
+	"pushtaka/services/transaction/internal/domain"
+	"slices"
+	"testing"
+	"time"
+)
+
+// circulation is a transaction usecase over in-memory repositories.
+type circulation struct {
+	*transactionUsecase
+	txs    *fakeTxRepo
+	loans  *fakeLoanRepo
+	holds  *fakeHoldRepo
+	outbox *fakeOutbox
+}
+
+func newTestCirculation() *circulation {
+	c := &circulation{txs: newFakeTxRepo(), loans: &fakeLoanRepo{}, holds: &fakeHoldRepo{}, outbox: &fakeOutbox{}}
+	holds := NewHoldUsecase(c.holds, c.loans, c.txs, time.Second)
+	c.transactionUsecase = NewTransactionUsecase(c.txs, c.loans, holds, time.Second, fakeTransactor{}, c.outbox).(*transactionUsecase)
+	return c
+}
+
+// lend records an active loan of testBookID to user 1, due in dueIn, and its
+// borrow row. It returns the loan and borrow row IDs.
+func (c *circulation) lend(dueIn time.Duration) (uint, uint) {
+	ctx := context.Background()
+	due := time.Now().Add(dueIn)
+	loan := &domain.Loan{UserID: 1, BookID: testBookID, State: domain.LoanActive, DueDate: &due}
+	c.loans.Create(ctx, loan)
+	borrow := &domain.Transaction{UserID: 1, BookID: testBookID, LoanID: &loan.ID, Action: "borrow", Status: "active", DueDate: &due}
+	c.txs.Create(ctx, borrow)
+	return loan.ID, borrow.ID
+}
+
+func (c *circulation) loan(id uint) *domain.Loan {
+	return &c.loans.loans[id-1]
+}
+
+func TestRenewBookRefused(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		setup   func(*circulation) uint
+		wantErr string
+	}{
+		{"unknown loan", func(*circulation) uint { return 42 }, "transaction not found"},
+		{"someone else's loan", func(c *circulation) uint {
+			_, borrow := c.lend(time.Hour)
+			c.txs.transactions[borrow-1].UserID = 2
+			return borrow
+		}, "unauthorized: this transaction does not belong to you"},
+		{"returned loan", func(c *circulation) uint {
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanReturned
+			return borrow
+		}, "only active loans can be renewed"},
+		{"claimed returned", func(c *circulation) uint {
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanClaimedReturned
+			return borrow
+		}, "only active loans can be renewed"},
+		{"overdue", func(c *circulation) uint {
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanOverdue
+			return borrow
+		}, "loan is overdue, please return the book"},
+		{"past due, not yet marked", func(c *circulation) uint {
+			_, borrow := c.lend(-time.Hour)
+			return borrow
+		}, "loan is overdue, please return the book"},
+		{"no due date", func(c *circulation) uint {
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).DueDate = nil
+			return borrow
+		}, "loan has no due date to extend"},
+		{"default limit of 2", func(c *circulation) uint {
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).RenewCount = 2
+			return borrow
+		}, "renewal limit reached: max 2 renewals"},
+		{"renewals switched off", func(c *circulation) uint {
+			c.txs.configs["max_renewals"] = "0"
+			_, borrow := c.lend(time.Hour)
+			return borrow
+		}, "renewal limit reached: max 0 renewals"},
+		{"unpaid fine", func(c *circulation) uint {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: 3, Action: "return", Fine: 1000, FineStatus: domain.FineStatusUnpaid})
+			_, borrow := c.lend(time.Hour)
+			return borrow
+		}, "you have unpaid fines, please pay them first"},
+		{"others are waiting", func(c *circulation) uint {
+			c.holds.Create(ctx, &domain.Hold{UserID: 2, BookID: testBookID, Status: domain.HoldStatusWaiting})
+			_, borrow := c.lend(time.Hour)
+			return borrow
+		}, "book has pending holds and cannot be renewed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			id := tt.setup(c)
+			if _, err := c.RenewBook(ctx, 1, id); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("RenewBook = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenewBookMarksPastDueLoanOverdue(t *testing.T) {
+	errQueue := errors.New("outbox unavailable")
+
+	tests := []struct {
+		name       string
+		outboxErr  error
+		wantErr    error
+		wantQueued []string
+	}{
+		{"overdue announced", nil, nil, []string{events.TypeLoanOverdue}},
+		{"event not queued", errQueue, errQueue, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			c.outbox.err = tt.outboxErr
+			loan, borrow := c.lend(-time.Hour)
+
+			_, err := c.RenewBook(context.Background(), 1, borrow)
+			if err == nil {
+				t.Fatal("renewed a loan past its due date")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(c.outbox.types(), tt.wantQueued) {
+				t.Fatalf("queued %v, want %v", c.outbox.types(), tt.wantQueued)
+			}
+			if tt.wantQueued == nil {
+				return
+			}
+			if c.loan(loan).State != domain.LoanOverdue {
+				t.Fatalf("loan is %s, want overdue", c.loan(loan).State)
+			}
+			msg := c.outbox.messages[0]
+			if event := msg.Payload.(events.LoanOverdue); !msg.InTx || event.LoanID != loan || event.UserID != 1 {
+				t.Fatalf("queued %+v, want loan %d in the transaction", msg, loan)
+			}
+		})
+	}
+}
+
+func TestRenewBookExtendsFromDueDate(t *testing.T) {
+	c := newTestCirculation()
+	ctx := context.Background()
+	c.txs.configs["borrow_duration"] = "10"
+	c.txs.configs["max_renewals"] = "1"
+
+	loan, borrow := c.lend(2 * time.Hour)
+	due := *c.loan(loan).DueDate
+	renewed, err := c.RenewBook(ctx, 1, borrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := renewed.DueDate.Sub(due); got != 10*24*time.Hour {
+		t.Fatalf("renewal added %v, want 10 days on top of the old due date", got)
+	}
+	if stored := c.loan(loan); stored.RenewCount != 1 || !stored.DueDate.Equal(*renewed.DueDate) {
+		t.Fatalf("stored loan = %d renewals due %v, want the renewal saved", stored.RenewCount, stored.DueDate)
+	}
+	if row := c.txs.transactions[borrow-1]; !row.DueDate.Equal(*renewed.DueDate) {
+		t.Fatalf("borrow row due %v, want it kept in step with the loan", row.DueDate)
+	}
+
+	if _, err := c.RenewBook(ctx, 1, borrow); err == nil {
+		t.Fatal("renewed past max_renewals 1")
+	}
+}
+
+func TestMaxRenewalsSetting(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string // "" when never set
+		want   int
+	}{
+		{"default", "", 2},
+		{"negative", "-1", 2},
+		{"zero", "0", 0},
+		{"set", "4", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			if tt.stored != "" {
+				c.txs.configs["max_renewals"] = tt.stored
+			}
+			settings, err := c.GetSettings(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.MaxRenewals == nil || *settings.MaxRenewals != tt.want {
+				t.Fatalf("max_renewals = %v, want %d", settings.MaxRenewals, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSettingsKeepsOmittedFields(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{"max_renewals omitted", `{"borrow_duration":14}`, map[string]string{"borrow_duration": "14", "max_renewals": "1"}},
+		{"max_renewals set", `{"max_renewals":3}`, map[string]string{"borrow_duration": "7", "max_renewals": "3"}},
+		{"max_renewals set to 0", `{"max_renewals":0}`, map[string]string{"borrow_duration": "7", "max_renewals": "0"}},
+		{"max_renewals negative", `{"max_renewals":-1}`, map[string]string{"borrow_duration": "7", "max_renewals": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			c.txs.configs["borrow_duration"] = "7"
+			c.txs.configs["max_renewals"] = "1"
+
+			var settings domain.Settings
+			if err := json.Unmarshal([]byte(tt.body), &settings); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.UpdateSettings(context.Background(), &settings); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := c.txs.configs[key]; got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoanStateChanges(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		from       domain.LoanState
+		act        func(*circulation, uint) error
+		want       domain.LoanState
+		wantErr    error
+		wantLegacy string
+	}{
+		{"member claims a return", domain.LoanActive, func(c *circulation, id uint) error { return c.ClaimReturned(ctx, 1, id) }, domain.LoanClaimedReturned, nil, "claimed_returned"},
+		{"overdue member claims a return", domain.LoanOverdue, func(c *circulation, id uint) error { return c.ClaimReturned(ctx, 1, id) }, domain.LoanClaimedReturned, nil, "claimed_returned"},
+		{"claim twice", domain.LoanClaimedReturned, func(c *circulation, id uint) error { return c.ClaimReturned(ctx, 1, id) }, domain.LoanClaimedReturned, domain.ErrInvalidTransition, "active"},
+		{"claim after return", domain.LoanReturned, func(c *circulation, id uint) error { return c.ClaimReturned(ctx, 1, id) }, domain.LoanReturned, domain.ErrInvalidTransition, "active"},
+		{"staff marks lost", domain.LoanActive, func(c *circulation, id uint) error { return c.MarkLost(ctx, id) }, domain.LoanLost, nil, "lost"},
+		{"unconfirmed claim marked lost", domain.LoanClaimedReturned, func(c *circulation, id uint) error { return c.MarkLost(ctx, id) }, domain.LoanLost, nil, "lost"},
+		{"lost twice", domain.LoanLost, func(c *circulation, id uint) error { return c.MarkLost(ctx, id) }, domain.LoanLost, domain.ErrInvalidTransition, "active"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).State = tt.from
+
+			if err := tt.act(c, loan); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if got := c.loan(loan).State; got != tt.want {
+				t.Fatalf("loan is %s, want %s", got, tt.want)
+			}
+			if got := c.txs.transactions[borrow-1].Status; got != tt.wantLegacy {
+				t.Fatalf("borrow row status = %q, want %q", got, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+func TestClaimReturnedOnlyByBorrower(t *testing.T) {
+	c := newTestCirculation()
+	loan, _ := c.lend(time.Hour)
+
+	if err := c.ClaimReturned(context.Background(), 2, loan); err == nil {
+		t.Fatal("another member claimed the loan returned")
+	}
+	if err := c.ClaimReturned(context.Background(), 1, 42); err == nil {
+		t.Fatal("claimed an unknown loan")
+	}
+	if c.loan(loan).State != domain.LoanActive {
+		t.Fatalf("loan is %s, want active", c.loan(loan).State)
+	}
+}
+
+func TestMarkOverdueLoans(t *testing.T) {
+	c := newTestCirculation()
+	late, lateBorrow := c.lend(-time.Hour)
+	onTime, _ := c.lend(time.Hour)
+	claimed, _ := c.lend(-time.Hour)
+	c.loan(claimed).State = domain.LoanClaimedReturned
+
+	if err := c.MarkOverdueLoans(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[uint]domain.LoanState{late: domain.LoanOverdue, onTime: domain.LoanActive, claimed: domain.LoanClaimedReturned} {
+		if got := c.loan(id).State; got != want {
+			t.Errorf("loan %d is %s, want %s", id, got, want)
+		}
+	}
+	// Overdue loans still read as active to older clients
+	if got := c.txs.transactions[lateBorrow-1].Status; got != "active" {
+		t.Fatalf("overdue borrow row status = %q, want active", got)
+	}
+}
+
+func TestCompleteReservation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		result     events.StockReservationCompleted
+		want       domain.LoanState
+		wantReason string
+		wantCopy   uint
+		wantLegacy string
+		wantHold   string
+	}{
+		{"approved", events.StockReservationCompleted{Approved: true, CopyID: 7, Barcode: "BK-1-007"},
+			domain.LoanActive, "", 7, "active", domain.HoldStatusFulfilled},
+		{"approved before copies were tracked", events.StockReservationCompleted{Approved: true},
+			domain.LoanActive, "", 0, "active", domain.HoldStatusFulfilled},
+		{"out of stock", events.StockReservationCompleted{Reason: "book is out of stock"},
+			domain.LoanRejected, "book is out of stock", 0, "rejected", domain.HoldStatusReady},
+		{"copy taken", events.StockReservationCompleted{Reason: "copy is not available"},
+			domain.LoanRejected, "copy is not available", 0, "rejected", domain.HoldStatusReady},
+		{"refused without a reason", events.StockReservationCompleted{},
+			domain.LoanRejected, "book is out of stock", 0, "rejected", domain.HoldStatusReady},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			loan, borrow := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanRequested
+			c.holds.Create(ctx, &domain.Hold{UserID: 1, BookID: testBookID, Status: domain.HoldStatusReady})
+
+			result := tt.result
+			result.LoanID = loan
+			if err := c.CompleteReservation(ctx, result); err != nil {
+				t.Fatal(err)
+			}
+			got := c.loan(loan)
+			if got.State != tt.want || got.RejectReason != tt.wantReason {
+				t.Fatalf("loan is %s (%q), want %s (%q)", got.State, got.RejectReason, tt.want, tt.wantReason)
+			}
+			if got.CopyID != tt.wantCopy || got.Barcode != tt.result.Barcode {
+				t.Fatalf("loan lends copy %d (%q), want %d (%q)", got.CopyID, got.Barcode, tt.wantCopy, tt.result.Barcode)
+			}
+			if tt.want == domain.LoanActive {
+				if borrowed := c.outbox.messages[0].Payload.(events.LoanBorrowed); borrowed.CopyID != tt.wantCopy {
+					t.Fatalf("announced copy %d, want %d", borrowed.CopyID, tt.wantCopy)
+				}
+			}
+			if status := c.txs.transactions[borrow-1].Status; status != tt.wantLegacy {
+				t.Fatalf("borrow row status = %q, want %q", status, tt.wantLegacy)
+			}
+			// Only a loan that went through closes the member's hold
+			if status := c.holds.holds[0].Status; status != tt.wantHold {
+				t.Fatalf("hold is %s, want %s", status, tt.wantHold)
+			}
+		})
+	}
+}
+
+func TestCompleteReservationRedelivered(t *testing.T) {
+	c := newTestCirculation()
+	ctx := context.Background()
+	loan, _ := c.lend(time.Hour)
+	c.loan(loan).State = domain.LoanRequested
+
+	if err := c.CompleteReservation(ctx, events.StockReservationCompleted{LoanID: loan, Approved: true}); err != nil {
+		t.Fatal(err)
+	}
+	// A late refusal for the same loan must not undo the approval
+	if err := c.CompleteReservation(ctx, events.StockReservationCompleted{LoanID: loan, Reason: "book is out of stock"}); err != nil {
+		t.Fatalf("redelivered reply = %v", err)
+	}
+	if c.loan(loan).State != domain.LoanActive {
+		t.Fatalf("loan is %s after a redelivered reply, want active", c.loan(loan).State)
+	}
+	if err := c.CompleteReservation(ctx, events.StockReservationCompleted{LoanID: 42, Approved: true}); err == nil {
+		t.Fatal("completed a reservation for an unknown loan")
+	}
+}
+
+func TestBorrowBookRefused(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		setup   func(*circulation)
+		wantErr string
+	}{
+		{"unpaid fine", func(c *circulation) {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: 3, Action: "return", Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+		}, "you have unpaid fines, please pay them first"},
+		{"already borrowed", func(c *circulation) {
+			c.lend(time.Hour)
+		}, "you have already borrowed this book"},
+		{"borrow limit", func(c *circulation) {
+			c.txs.configs["max_borrow_limit"] = "1"
+			c.loans.Create(ctx, &domain.Loan{UserID: 1, BookID: 3, State: domain.LoanOverdue})
+		}, "limit reached: max 1 books borrowed"},
+		{"out of stock", func(c *circulation) {
+			c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 0}
+		}, "book is out of stock, place a hold to join the queue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+			tt.setup(c)
+			loans := len(c.loans.loans)
+
+			if _, err := c.BorrowBook(ctx, 1, testBookID); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("BorrowBook = %v, want %q", err, tt.wantErr)
+			}
+			if len(c.loans.loans) != loans || len(c.outbox.messages) != 0 {
+				t.Fatalf("refused borrow opened %d loans and queued %v", len(c.loans.loans)-loans, c.outbox.messages)
+			}
+		})
+	}
+}
+
+func TestBorrowBookRequestsReservation(t *testing.T) {
+	c := newTestCirculation()
+	ctx := context.Background()
+	c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+
+	loan, err := c.BorrowBook(ctx, 1, testBookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loan waits for the book service; nothing is lent yet
+	if loan.State != domain.LoanRequested {
+		t.Fatalf("loan is %s, want requested", loan.State)
+	}
+	borrow := c.txs.transactions[0]
+	if borrow.Action != "borrow" || borrow.LoanID == nil || *borrow.LoanID != loan.ID || borrow.Status != "pending" {
+		t.Fatalf("borrow row = %+v, want a pending row for loan %d", borrow, loan.ID)
+	}
+
+	want := queuedMessage{
+		RoutingKey:    "stock_reservations",
+		Type:          events.TypeStockReservationRequested,
+		ReplyTo:       "stock_reservation_results",
+		CorrelationID: "1",
+		Payload:       events.StockReservationRequested{LoanID: loan.ID, BookID: testBookID, Quantity: 1},
+		InTx:          true,
+	}
+	if len(c.outbox.messages) != 1 || c.outbox.messages[0] != want {
+		t.Fatalf("queued %+v, want the reservation request %+v", c.outbox.messages, want)
+	}
+}
+
+func TestBorrowBookOutboxFailure(t *testing.T) {
+	c := newTestCirculation()
+	c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+	c.outbox.err = errors.New("outbox unavailable")
+
+	if _, err := c.BorrowBook(context.Background(), 1, testBookID); !errors.Is(err, c.outbox.err) {
+		t.Fatalf("BorrowBook = %v, want the outbox error", err)
+	}
+}
+
+func TestReturnBook(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		configs        map[string]string
+		dueIn          time.Duration
+		wantFine       int
+		wantFineStatus string
+		wantEvents     []string
+	}{
+		{"on time", nil, time.Hour, 0, domain.FineStatusNone,
+			[]string{events.TypeStockUpdated, events.TypeLoanReturned}},
+		{"late, default fine", nil, -time.Hour, 1000, domain.FineStatusUnpaid,
+			[]string{events.TypeStockUpdated, events.TypeLoanReturned, events.TypeFineAssessed}},
+		{"late, hourly fine", map[string]string{"fine_amount": "500", "fine_unit": "hour"}, -3 * time.Hour, 1500, domain.FineStatusUnpaid,
+			[]string{events.TypeStockUpdated, events.TypeLoanReturned, events.TypeFineAssessed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			for key, val := range tt.configs {
+				c.txs.configs[key] = val
+			}
+			loan, borrow := c.lend(tt.dueIn)
+			c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+			c.holds.Create(ctx, &domain.Hold{UserID: 2, BookID: testBookID, Status: domain.HoldStatusWaiting})
+
+			if err := c.ReturnBook(ctx, 1, testBookID); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.loan(loan); got.State != domain.LoanReturned || got.ReturnedAt == nil {
+				t.Fatalf("loan is %s (returned at %v), want returned", got.State, got.ReturnedAt)
+			}
+			if status := c.txs.transactions[borrow-1].Status; status != "returned" {
+				t.Fatalf("borrow row status = %q, want returned", status)
+			}
+			ret := c.txs.transactions[len(c.txs.transactions)-1]
+			if ret.Action != "return" || ret.Fine != tt.wantFine || ret.FineStatus != tt.wantFineStatus {
+				t.Fatalf("return row = %s fine %d (%q), want fine %d (%q)", ret.Action, ret.Fine, ret.FineStatus, tt.wantFine, tt.wantFineStatus)
+			}
+
+			want := queuedMessage{RoutingKey: "stock_updates", Type: events.TypeStockUpdated, Payload: events.StockUpdated{BookID: testBookID, Action: "return", Quantity: 1}, InTx: true}
+			if got := c.outbox.types(); !slices.Equal(got, tt.wantEvents) || c.outbox.messages[0] != want {
+				t.Fatalf("queued %+v, want the stock update %+v followed by %v", c.outbox.messages, want, tt.wantEvents[1:])
+			}
+			returned := c.outbox.messages[1].Payload.(events.LoanReturned)
+			if returned.LoanID != loan || returned.Late != (tt.wantFine > 0) || !c.outbox.messages[1].InTx {
+				t.Fatalf("announced %+v, want loan %d returned late=%v in the return's transaction", returned, loan, tt.wantFine > 0)
+			}
+			if tt.wantFine > 0 {
+				fine := c.outbox.messages[2].Payload.(events.FineAssessed)
+				if fine.TransactionID != ret.ID || fine.Amount != tt.wantFine {
+					t.Fatalf("announced %+v, want fine %d on transaction %d", fine, tt.wantFine, ret.ID)
+				}
+			}
+			// The returned copy goes to the next member in line
+			if status := c.holds.holds[0].Status; status != domain.HoldStatusReady {
+				t.Fatalf("waiting hold is %s after a return, want ready", status)
+			}
+		})
+	}
+}
+
+func TestReturnBookRefused(t *testing.T) {
+	c := newTestCirculation()
+	ctx := context.Background()
+
+	if err := c.ReturnBook(ctx, 1, testBookID); err == nil || err.Error() != "active borrow record not found" {
+		t.Fatalf("ReturnBook without a loan = %v", err)
+	}
+
+	// A failed enqueue fails the return; nothing is promoted
+	c.lend(time.Hour)
+	c.outbox.err = errors.New("outbox unavailable")
+	c.holds.Create(ctx, &domain.Hold{UserID: 2, BookID: testBookID, Status: domain.HoldStatusWaiting})
+	if err := c.ReturnBook(ctx, 1, testBookID); !errors.Is(err, c.outbox.err) {
+		t.Fatalf("ReturnBook = %v, want the outbox error", err)
+	}
+	if status := c.holds.holds[0].Status; status != domain.HoldStatusWaiting {
+		t.Fatalf("hold is %s after a failed return, want waiting", status)
+	}
+}
+
+func TestConfirmReturn(t *testing.T) {
+	c := newTestCirculation()
+	ctx := context.Background()
+	loan, _ := c.lend(time.Hour)
+	c.loan(loan).State = domain.LoanClaimedReturned
+
+	if err := c.ConfirmReturn(ctx, loan); err != nil {
+		t.Fatal(err)
+	}
+	if c.loan(loan).State != domain.LoanReturned || c.outbox.messages[0].RoutingKey != "stock_updates" {
+		t.Fatalf("loan is %s with %v queued, want returned and restocked", c.loan(loan).State, c.outbox.types())
+	}
+	if err := c.ConfirmReturn(ctx, loan); !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Fatalf("confirming a return twice = %v, want ErrInvalidTransition", err)
+	}
+	if err := c.ConfirmReturn(ctx, 42); err == nil {
+		t.Fatal("confirmed the return of an unknown loan")
+	}
+}
+
+func TestDomainEventsAnnounced(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		change func(*circulation) error
+		want   []string
+	}{
+		{"reservation approved", func(c *circulation) error {
+			loan, _ := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanRequested
+			return c.CompleteReservation(ctx, events.StockReservationCompleted{LoanID: loan, Approved: true})
+		}, []string{events.TypeLoanBorrowed}},
+		{"reservation refused", func(c *circulation) error {
+			loan, _ := c.lend(time.Hour)
+			c.loan(loan).State = domain.LoanRequested
+			return c.CompleteReservation(ctx, events.StockReservationCompleted{LoanID: loan})
+		}, nil},
+		{"loan past due", func(c *circulation) error {
+			c.lend(-time.Hour)
+			c.lend(time.Hour)
+			return c.MarkOverdueLoans(ctx)
+		}, []string{events.TypeLoanOverdue}},
+		{"fine approved", func(c *circulation) error {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusPendingVerification, PaymentMethod: "manual"})
+			return c.VerifyFine(ctx, 1, "approve")
+		}, []string{events.TypeFinePaid}},
+		{"fine rejected", func(c *circulation) error {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusPendingVerification, PaymentMethod: "manual"})
+			return c.VerifyFine(ctx, 1, "reject")
+		}, nil},
+		{"payment callback repeated", func(c *circulation) error {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+			if err := c.HandlePaymentCallback(ctx, "1", "settled"); err != nil {
+				return err
+			}
+			return c.HandlePaymentCallback(ctx, "1", "settled")
+		}, []string{events.TypeFinePaid}},
+		{"payment callback pending", func(c *circulation) error {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+			return c.HandlePaymentCallback(ctx, "1", "pending")
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			if err := tt.change(c); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.outbox.types(); !slices.Equal(got, tt.want) {
+				t.Fatalf("announced %v, want %v", got, tt.want)
+			}
+			for _, msg := range c.outbox.messages {
+				if msg.Exchange != events.Exchange || !msg.InTx {
+					t.Fatalf("%s went to %q outside the change's transaction", msg.Type, msg.Exchange)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteByUserID(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		setup     func(*circulation)
+		wantErr   error
+		wantTxs   int
+		wantHolds []string
+	}{
+		{"open loan keeps the records", func(c *circulation) {
+			c.lend(time.Hour)
+		}, domain.ErrOutstandingObligations, 1, nil},
+		{"unpaid fine keeps the records", func(c *circulation) {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+		}, domain.ErrOutstandingObligations, 1, nil},
+		{"settled member is cleaned up", func(c *circulation) {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusPaid})
+			c.txs.Create(ctx, &domain.Transaction{UserID: 2, BookID: testBookID, Action: "borrow"})
+			c.holds.Create(ctx, &domain.Hold{UserID: 1, BookID: testBookID, Status: domain.HoldStatusWaiting})
+			c.holds.Create(ctx, &domain.Hold{UserID: 1, BookID: testBookID + 1, Status: domain.HoldStatusFulfilled})
+			c.holds.Create(ctx, &domain.Hold{UserID: 2, BookID: testBookID, Status: domain.HoldStatusWaiting})
+		}, nil, 1, []string{domain.HoldStatusCancelled, domain.HoldStatusFulfilled, domain.HoldStatusWaiting}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			tt.setup(c)
+
+			if err := c.DeleteByUserID(ctx, 1); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(c.txs.transactions) != tt.wantTxs {
+				t.Errorf("%d transactions left, want %d", len(c.txs.transactions), tt.wantTxs)
+			}
+			if got := c.holds.statuses(); !slices.Equal(got, tt.wantHolds) {
+				t.Errorf("hold statuses = %v, want %v", got, tt.wantHolds)
+			}
+		})
+	}
+}
+
+func TestGetUserObligations(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCirculation()
+	c.lend(time.Hour)
+	c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusUnpaid})
+	c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 500, FineStatus: domain.FineStatusPendingVerification})
+	c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: 9000, FineStatus: domain.FineStatusPaid})
+
+	got, err := c.GetUserObligations(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := domain.Obligations{OpenLoans: 1, UnpaidFines: 2, FineAmount: 2500}
+	if *got != want {
+		t.Fatalf("obligations = %+v, want %+v", *got, want)
+	}
+}
+
+func TestDeleteByBookID(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		setup   func(*circulation)
+		wantErr error
+		wantTxs int
+	}{
+		{"copy on loan keeps the records", func(c *circulation) {
+			c.lend(time.Hour)
+		}, domain.ErrOutstandingObligations, 1},
+		{"unpaid fine keeps the records", func(c *circulation) {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 2, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusPendingVerification})
+		}, domain.ErrOutstandingObligations, 1},
+		{"settled book is cleaned up", func(c *circulation) {
+			c.txs.Create(ctx, &domain.Transaction{UserID: 2, BookID: testBookID, Action: "return", Fine: 2000, FineStatus: domain.FineStatusPaid})
+			c.txs.Create(ctx, &domain.Transaction{UserID: 2, BookID: testBookID + 1, Action: "borrow"})
+		}, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			tt.setup(c)
+
+			if err := c.DeleteByBookID(ctx, testBookID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(c.txs.transactions) != tt.wantTxs {
+				t.Errorf("%d transactions left, want %d", len(c.txs.transactions), tt.wantTxs)
+			}
+		})
+	}
+}
+
+// newTestDesk returns a circulation desk where member 1 (siswa@contoh.com)
+// can borrow testBookID, which has one copy on the shelf and one in repair.
+func newTestDesk() *circulation {
+	c := newTestCirculation()
+	c.txs.books[testBookID] = domain.Book{ID: testBookID, Stock: 1}
+	c.txs.members = []domain.User{{ID: 1, Email: "siswa@contoh.com"}}
+	c.txs.copies = []domain.BookCopy{
+		{ID: 11, BookID: testBookID, Barcode: "BK-1-001", Status: domain.CopyAvailable},
+		{ID: 12, BookID: testBookID, Barcode: "BK-1-002", Status: "in_repair"},
+	}
+	return c
+}
+
+func TestCheckoutCopies(t *testing.T) {
+	ctx := context.Background()
+	c := newTestDesk()
+
+	results, err := c.CheckoutCopies(ctx, 99, " Siswa@Contoh.com ", []string{" BK-1-001", "BK-1-002", "BK-9-999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantErrors := []string{"", domain.ErrCopyUnavailable.Error(), domain.ErrUnknownBarcode.Error()}
+	for i, result := range results {
+		if result.Error != wantErrors[i] || (result.Loan != nil) != (wantErrors[i] == "") {
+			t.Errorf("result %d = %+v, want error %q", i, result, wantErrors[i])
+		}
+	}
+	if len(results) != 3 || results[0].Barcode != "BK-1-001" {
+		t.Fatalf("results = %+v, want one per barcode in scan order", results)
+	}
+
+	// The scanned copy is pinned to the loan and the desk is credited
+	loan := results[0].Loan
+	if loan.UserID != 1 || loan.CopyID != 11 || loan.Barcode != "BK-1-001" || loan.State != domain.LoanRequested {
+		t.Fatalf("loan = %+v, want a requested loan of copy 11 to member 1", loan)
+	}
+	if staff := c.txs.transactions[0].StaffID; staff == nil || *staff != 99 {
+		t.Fatalf("borrow row staff = %v, want 99", staff)
+	}
+	if req := c.outbox.messages[0].Payload.(events.StockReservationRequested); req.CopyID != 11 {
+		t.Fatalf("reservation asks for copy %d, want 11", req.CopyID)
+	}
+
+	if _, err := c.CheckoutCopies(ctx, 99, "guru@contoh.com", []string{"BK-1-001"}); !errors.Is(err, domain.ErrMemberNotFound) {
+		t.Fatalf("unknown member = %v, want %v", err, domain.ErrMemberNotFound)
+	}
+}
+
+func TestCheckinCopy(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		barcode string
+		wantErr error
+	}{
+		{"copy on loan", " BK-1-001 ", nil},
+		{"copy on the shelf", "BK-1-002", domain.ErrCopyNotOnLoan},
+		{"unknown barcode", "BK-9-999", domain.ErrUnknownBarcode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestDesk()
+			id, _ := c.lend(time.Hour)
+			c.loan(id).CopyID = 11
+
+			loan, err := c.CheckinCopy(ctx, 99, tt.barcode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if c.loan(id).State != domain.LoanActive {
+					t.Fatalf("loan is %s, want it still active", c.loan(id).State)
+				}
+				return
+			}
+			if loan.ID != id || c.loan(id).State != domain.LoanReturned {
+				t.Fatalf("loan %d is %s, want loan %d returned", loan.ID, c.loan(id).State, id)
+			}
+			ret := c.txs.transactions[len(c.txs.transactions)-1]
+			if ret.Action != "return" || ret.StaffID == nil || *ret.StaffID != 99 {
+				t.Fatalf("return row = %+v, want one taken by staff 99", ret)
+			}
+			if stock := c.outbox.messages[0].Payload.(events.StockUpdated); stock.CopyID != 11 {
+				t.Fatalf("stock update for copy %d, want 11", stock.CopyID)
+			}
+		})
+	}
+}
+
+func TestVerifyFine(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		fine       int
+		action     string
+		wantErr    string
+		wantStatus string
+		wantMethod string
+		wantPaid   bool
+	}{
+		{"approve", 2000, "approve", "", domain.FineStatusPaid, "manual", true},
+		{"waive", 2000, "waive", "", domain.FineStatusPaid, "waived", true},
+		{"reject", 2000, "reject", "", domain.FineStatusUnpaid, "", false},
+		{"unknown action", 2000, "forgive", "invalid action", domain.FineStatusPendingVerification, "manual", false},
+		{"nothing owed", 0, "waive", "no fine to verify for this transaction", domain.FineStatusPendingVerification, "manual", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCirculation()
+			c.txs.Create(ctx, &domain.Transaction{UserID: 1, BookID: testBookID, Action: "return", Fine: tt.fine, FineStatus: domain.FineStatusPendingVerification, PaymentMethod: "manual", PaymentProof: "bukti.jpg"})
+
+			err := c.VerifyFine(ctx, 1, tt.action)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			tx := c.txs.transactions[0]
+			if tx.FineStatus != tt.wantStatus || tx.PaymentMethod != tt.wantMethod {
+				t.Fatalf("fine is %s by %q, want %s by %q", tx.FineStatus, tx.PaymentMethod, tt.wantStatus, tt.wantMethod)
+			}
+			if !tt.wantPaid {
+				if len(c.outbox.messages) != 0 {
+					t.Fatalf("announced %v for an unsettled fine", c.outbox.types())
+				}
+				return
+			}
+			paid := c.outbox.messages[0].Payload.(events.FinePaid)
+			if paid.Amount != tt.fine || paid.Method != tt.wantMethod || tx.PaidAt == nil {
+				t.Fatalf("announced %+v (paid at %v), want %d settled by %q", paid, tx.PaidAt, tt.fine, tt.wantMethod)
+			}
+		})
+	}
+}